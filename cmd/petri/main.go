@@ -3,9 +3,14 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -16,14 +21,18 @@ import (
 	"github.com/boristopalov/petri/pkg/experiment"
 	"github.com/boristopalov/petri/pkg/messaging"
 	"github.com/boristopalov/petri/pkg/providers"
+	"github.com/boristopalov/petri/pkg/remote"
 	"github.com/spf13/cobra"
 )
 
 func main() {
 	rootCmd := &cobra.Command{
-		Use:   "petri",
-		Short: "Petri is a tool for running sandboxed AI-AI interaction experiments and for observing emergent cultural behaviors of LLMs.",
+		Use:               "petri",
+		Short:             "Petri is a tool for running sandboxed AI-AI interaction experiments and for observing emergent cultural behaviors of LLMs.",
+		PersistentPreRunE: configureLogging,
 	}
+	rootCmd.PersistentFlags().String("log-level", "info", "Log level (debug, info, warn, error); debug includes full model responses")
+	rootCmd.PersistentFlags().String("config", "", "YAML experiment config; its agents, model and environment replace the corresponding flags")
 
 	runCmd := &cobra.Command{
 		Use:   "run",
@@ -35,6 +44,10 @@ func main() {
 		Short: "Run a chat room experiment where agents converse with each other",
 		RunE:  runChatExperiment,
 	}
+	chatCmd.Flags().Bool("stream", false, "Print each agent's response as it is generated; concurrent responses may interleave")
+	chatCmd.Flags().String("transcript", "", "JSONL file to write every message the agents publish to")
+	chatCmd.Flags().Int("max-retries", 2, "Times an agent retries a failed model call before skipping its turn")
+	chatCmd.Flags().Duration("delivery-timeout", 0, "How long a message to an agent with a full inbox waits for space before it is dropped; 0 drops it immediately")
 
 	donorGameCmd := &cobra.Command{
 		Use:   "donor-game",
@@ -49,7 +62,71 @@ func main() {
 	donorGameCmd.Flags().Float64P("survivor-ratio", "s", 0.5, "Fraction of agents that survive to next generation")
 	donorGameCmd.Flags().Float64P("donation-multiplier", "m", 2.0, "Multiplier for donations (recipient gets this times what donor gives)")
 	donorGameCmd.Flags().Float64P("initial-balance", "b", 10.0, "Initial resource balance for each agent")
-	donorGameCmd.Flags().StringP("model", "l", "gpt-4", "LLM model to use (gpt-4 or gemini)")
+	donorGameCmd.Flags().StringP("model", "l", "gpt-4", "LLM model to use, e.g. gpt-4, gpt-4o, gemini, gemini-2.0-flash, ollama, ollama:<model>, or mock for a dry run without API calls")
+	donorGameCmd.Flags().Float64P("temperature", "t", 0, "Sampling temperature for every agent; the provider default is used when unset")
+	donorGameCmd.Flags().Float64("top-p", 0, "Nucleus sampling top-p for every agent; the provider default is used when unset")
+	donorGameCmd.Flags().Bool("json-output", false, "Ask the model for donations as JSON instead of parsing an ANSWER line")
+	donorGameCmd.Flags().StringSlice("fallback-model", nil, "LLM models to fall back to, in order, when the primary provider fails; accepts the same names as --model")
+	donorGameCmd.Flags().Int("max-calls", 0, "Stop the experiment gracefully after this many provider calls; 0 means no limit")
+	donorGameCmd.Flags().Duration("timeout", time.Hour, "Maximum duration of the whole experiment")
+	donorGameCmd.Flags().String("pairing", "random", "How agents are paired up each round: random or round-robin")
+	donorGameCmd.Flags().Int("concurrency", environment.DefaultConcurrency, "Maximum donor decisions and gossip calls made at once in a round; 0 means no limit")
+	donorGameCmd.Flags().Int("rate-limit", 0, "Maximum provider requests per minute; calls wait for the budget instead of hitting 429s. 0 means unlimited")
+	donorGameCmd.Flags().Duration("request-timeout", 0, "Maximum duration of a single provider request before it fails and the donation is skipped; 0 means no limit")
+	donorGameCmd.Flags().Int("max-retries", 3, "Times to retry a provider call that fails with a rate limit or server error")
+	donorGameCmd.Flags().String("record-calls", "", "JSONL file to record the exact messages sent in every provider call to")
+	donorGameCmd.Flags().Bool("punishment", false, "Let donors spend x units to take away 2x of the recipient's resources")
+	donorGameCmd.Flags().Bool("gossip", false, "After each round, have partners broadcast a reputation note about each other that later donors see")
+	donorGameCmd.Flags().Bool("summarize-memory", false, "Have agents summarize their oldest memories with the model when their memory is full instead of dropping them")
+	donorGameCmd.Flags().Int("parse-retries", 1, "Times to re-prompt an agent whose donation answer can't be parsed")
+	donorGameCmd.Flags().Int("precision", 2, "Decimal places used when displaying resource amounts in memories and stats")
+	donorGameCmd.Flags().Int64("seed", 0, "Seed for the random number generator; 0 picks a random seed, which is logged and recorded in the manifest")
+	donorGameCmd.Flags().Float64("history-noise", 0, "Probability that each donation in a recipient's history is corrupted before donors see it")
+	donorGameCmd.Flags().Int("history-token-limit", 0, "Maximum tokens of recipient history shown to donors, dropping the oldest interactions first; 0 means no limit")
+	donorGameCmd.Flags().String("history-noise-mode", "misreport", "How noisy donations are corrupted: misreport (flip the donated fraction) or omit")
+	donorGameCmd.Flags().Bool("seed-per-generation", false, "Reseed each generation from --seed and its generation number, so a generation's pairings don't depend on what earlier generations drew")
+	donorGameCmd.Flags().String("seed-strategies", "", "Strategies file from a previous run to seed generation 1 with")
+	donorGameCmd.Flags().Duration("round-timeout", 0, "Maximum duration of a single round before it is skipped; 0 means no limit")
+	donorGameCmd.Flags().Float64("max-failure-rate", -1, "Fraction of a round's donations that may fail before the round is abandoned, or every one of them; negative never abandons")
+	donorGameCmd.Flags().Int("round-retries", 0, "Times to retry an abandoned round before stopping the experiment")
+	donorGameCmd.Flags().Float64("collapse-threshold", experiment.DefaultCollapseThreshold, "Average donation fraction below which a generation is flagged as a cooperation collapse")
+	donorGameCmd.Flags().Int("advice-limit", 0, "Maximum number of top survivors whose strategies are shown to the next generation; 0 shows all")
+	donorGameCmd.Flags().Bool("shuffle-advice", false, "Show survivors' strategies in a random order and pick them at random under --advice-limit instead of by rank")
+	donorGameCmd.Flags().Int("min-viable-population", 0, "Start a generation with the agents that got a strategy when others fail, if at least this many did; 0 aborts on any failure")
+	donorGameCmd.Flags().Bool("agent-pool", false, "Recycle agents across generations instead of creating new ones")
+	donorGameCmd.Flags().Bool("elitism", false, "Carry survivors into the next generation with their strategies and memories; new agents only fill the remaining slots")
+	donorGameCmd.Flags().String("resume", "", "Checkpoint file saved before each generation; an existing checkpoint is resumed instead of starting at generation 1")
+	donorGameCmd.Flags().String("dump-strategies", "", "File to write the final generation's strategies to; a relative path is inside --output-dir")
+	donorGameCmd.Flags().String("parquet", "", "Parquet file to write every agent's donation and balance in every round to; needs a build with -tags parquet. A relative path is inside --output-dir")
+	donorGameCmd.Flags().Bool("progress", false, "Show the generation, round and estimated time left after every round")
+	donorGameCmd.Flags().String("output-dir", ".", "Directory to write the stats, report, manifest and other output files to; created if it doesn't exist")
+	donorGameCmd.Flags().String("format", string(experiment.FormatCSV), "Format of the per-generation statistics: csv, json (a report with each generation's survivors) or both")
+	donorGameCmd.Flags().String("round-stats", "", "CSV file to write the population's resources, Gini coefficient and donations to after every round; a relative path is inside --output-dir")
+	donorGameCmd.Flags().String("dump-lineage", "", "File to write which survivors each agent's strategy descended from to; .dot writes Graphviz, anything else JSON. A relative path is inside --output-dir")
+	donorGameCmd.Flags().String("round-log", "", "JSON file to write the donor and recipient of every pair in every round to; a relative path is inside --output-dir")
+	donorGameCmd.Flags().Float64("fitness-resources", experiment.DefaultFitnessWeights.Resources, "Survivor selection weight of final resources")
+	donorGameCmd.Flags().Float64("fitness-cooperation", experiment.DefaultFitnessWeights.Cooperation, "Survivor selection weight of cooperation rate")
+	donorGameCmd.Flags().Float64("fitness-inequality", experiment.DefaultFitnessWeights.Inequality, "Survivor selection penalty for deviating from the mean resources")
+	donorGameCmd.Flags().Bool("pareto", false, "Record each generation's Pareto front over wealth and cooperation and select survivors from the earliest fronts instead of by fitness weights")
+
+	prisonersDilemmaCmd := &cobra.Command{
+		Use:   "prisoners-dilemma",
+		Short: "Run an iterated Prisoner's Dilemma experiment in which strategies evolve across generations",
+		RunE:  runPrisonersDilemmaExperiment,
+	}
+	addGameFlags(prisonersDilemmaCmd)
+	prisonersDilemmaCmd.Flags().Float64("reward", environment.DefaultPayoffs.Reward, "Score of each player when both cooperate")
+	prisonersDilemmaCmd.Flags().Float64("sucker", environment.DefaultPayoffs.Sucker, "Score of a player who cooperates with a defector")
+	prisonersDilemmaCmd.Flags().Float64("temptation", environment.DefaultPayoffs.Temptation, "Score of a player who defects against a cooperator")
+	prisonersDilemmaCmd.Flags().Float64("punishment", environment.DefaultPayoffs.Punishment, "Score of each player when both defect")
+
+	ultimatumCmd := &cobra.Command{
+		Use:   "ultimatum",
+		Short: "Run an ultimatum game experiment to study the evolution of fairness",
+		RunE:  runUltimatumExperiment,
+	}
+	addGameFlags(ultimatumCmd)
+	ultimatumCmd.Flags().Float64("pot-size", 10, "Units the proposer of every pair splits with the responder")
 
 	for _, envFile := range []string{
 		".env",
@@ -61,16 +138,232 @@ func main() {
 		}
 	}
 
-	runCmd.AddCommand(chatCmd, donorGameCmd)
-	rootCmd.AddCommand(runCmd)
+	watchCmd := &cobra.Command{
+		Use:   "watch <stats-file>",
+		Short: "Show the latest generation's statistics of a running experiment as its stats file grows",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runWatch,
+	}
+	watchCmd.Flags().Duration("interval", time.Second, "How often to check the stats file for new rows")
+
+	serveCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve donor game experiments over gRPC so other services can create, step and observe them",
+		RunE:  runServe,
+	}
+	serveCmd.Flags().String("addr", "localhost:7070", "Address to listen for gRPC connections on")
+	serveCmd.Flags().String("output-root", ".", "Directory experiments write their output under; a client's output directory must be inside it")
+	serveCmd.Flags().Duration("session-ttl", remote.DefaultSessionTTL, "How long a finished experiment is kept before it is evicted")
+
+	runCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return runFromConfig(cmd, chatCmd, donorGameCmd)
+	}
+	runCmd.AddCommand(chatCmd, donorGameCmd, prisonersDilemmaCmd, ultimatumCmd)
+	rootCmd.AddCommand(runCmd, watchCmd, serveCmd)
 	rootCmd.Execute()
 }
 
+// logLevel and logOutput configure the default slog logger, which the log package writes through too
+var (
+	logLevel  slog.Level
+	logOutput io.Writer = os.Stderr
+)
+
+// setDefaultLogger makes a logger writing to logOutput at logLevel the default
+func setDefaultLogger() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(logOutput, &slog.HandlerOptions{Level: logLevel})))
+}
+
+// configureLogging sets the default slog logger to the level requested by the --log-level flag
+func configureLogging(cmd *cobra.Command, args []string) error {
+	levelName, _ := cmd.Flags().GetString("log-level")
+	if err := logLevel.UnmarshalText([]byte(levelName)); err != nil {
+		return fmt.Errorf("invalid log level %q: %v", levelName, err)
+	}
+	setDefaultLogger()
+	return nil
+}
+
+// applyLogConfig applies a config file's logging section. Its level is used unless --log-level
+// was given, and its path receives both the log and slog output.
+func applyLogConfig(cmd *cobra.Command, cfg config.LogConfig) error {
+	levelName, _ := cmd.Flags().GetString("log-level")
+	if cfg.Level != "" && !cmd.Flags().Changed("log-level") {
+		levelName = cfg.Level
+	}
+	if err := logLevel.UnmarshalText([]byte(levelName)); err != nil {
+		return fmt.Errorf("invalid log level %q: %v", levelName, err)
+	}
+	if cfg.Path != "" {
+		if err := os.MkdirAll(filepath.Dir(cfg.Path), 0755); err != nil {
+			return fmt.Errorf("failed to create log directory: %v", err)
+		}
+		// The file stays open for the rest of the process
+		file, err := os.OpenFile(cfg.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %v", err)
+		}
+		log.SetOutput(file)
+		logOutput = file
+	}
+	setDefaultLogger()
+	return nil
+}
+
+// runWatch redraws the latest row of a stats file whenever new rows are appended, until interrupted
+func runWatch(cmd *cobra.Command, args []string) error {
+	interval, _ := cmd.Flags().GetDuration("interval")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	path := args[0]
+	tailer := experiment.NewStatsTailer(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	fmt.Printf("Waiting for rows in %s...\n", path)
+	for {
+		rows, err := tailer.Poll()
+		if err != nil {
+			return err
+		}
+		if len(rows) > 0 {
+			fmt.Print("\033[H\033[2J") // clear the terminal
+			fmt.Printf("%s, updated %s\n\n", path, time.Now().Format(time.TimeOnly))
+			if err := experiment.RenderStatsRow(os.Stdout, rows[len(rows)-1]); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// runServe serves the remote experiment service until interrupted
+func runServe(cmd *cobra.Command, args []string) error {
+	addr, _ := cmd.Flags().GetString("addr")
+	outputRoot, _ := cmd.Flags().GetString("output-root")
+	sessionTTL, _ := cmd.Flags().GetDuration("session-ttl")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	server, err := remote.NewServer(ctx, func(ctx context.Context, model string) (agent.Client, string, error) {
+		return newProvider(ctx, model)
+	}, remote.WithOutputRoot(outputRoot), remote.WithSessionTTL(sessionTTL))
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+	go func() {
+		<-ctx.Done()
+		server.Stop()
+	}()
+	log.Printf("Serving experiments on %s", listener.Addr())
+	return server.Serve(listener)
+}
+
+// runFromConfig runs the experiment described by the --config file, picking the chat room or
+// donor game by the config's environment type
+func runFromConfig(cmd *cobra.Command, chatCmd, donorGameCmd *cobra.Command) error {
+	cfg, err := loadConfigFlag(cmd)
+	if err != nil {
+		return err
+	}
+	if cfg == nil {
+		return cmd.Help()
+	}
+	if cfg.Environment.Type == "donor_game" {
+		// The donor game is built from its flags rather than by environment.FromConfig, so that
+		// flags given on the command line and settings the environment doesn't know about, like
+		// groups, still apply
+		if err := applyDonorGameConfig(donorGameCmd, cfg); err != nil {
+			return err
+		}
+		return runDonorGameExperiment(donorGameCmd, nil)
+	}
+	env, err := environment.FromConfig(cfg.Environment)
+	if err != nil {
+		return fmt.Errorf("invalid environment in %s: %v", cfg.Name, err)
+	}
+	chatEnv, ok := env.(*environment.BaseEnvironment[*agent.LLMAgent, environment.BaseState])
+	if !ok {
+		return fmt.Errorf("environment type %q can't be run from the command line", cfg.Environment.Type)
+	}
+	return runChatRoom(chatCmd, cfg, chatEnv)
+}
+
+// loadConfigFlag loads the file given with --config, or returns nil if there isn't one
+func loadConfigFlag(cmd *cobra.Command) (*config.ExperimentConfig, error) {
+	path, _ := cmd.Flags().GetString("config")
+	if path == "" {
+		return nil, nil
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyLogConfig(cmd, cfg.Logging); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
 // runChatExperiment runs a simple chat room experiment where agents converse with each other
 func runChatExperiment(cmd *cobra.Command, args []string) error {
-	broker := messaging.NewBroker()
-	defer broker.Reset()
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	cfg := &config.ExperimentConfig{
+		Name:        "chat_room",
+		Duration:    15 * time.Second,
+		Steps:       5,
+		Agents:      []config.AgentConfig{{Model: "gpt-4", Count: 3}},
+		Environment: config.EnvConfig{Type: "chat_room"},
+	}
+	loaded, err := loadConfigFlag(cmd)
+	if err != nil {
+		return err
+	}
+	if loaded != nil {
+		if loaded.Environment.Type == "" {
+			loaded.Environment.Type = "chat_room"
+		}
+		cfg = loaded
+	}
+	env, err := environment.FromConfig(cfg.Environment)
+	if err != nil {
+		return fmt.Errorf("invalid environment in %s: %v", cfg.Name, err)
+	}
+	chatEnv, ok := env.(*environment.BaseEnvironment[*agent.LLMAgent, environment.BaseState])
+	if !ok {
+		return fmt.Errorf("config %s is for a %s environment, not a chat room", cfg.Name, cfg.Environment.Type)
+	}
+	return runChatRoom(cmd, cfg, chatEnv)
+}
+
+// runChatRoom runs a chat room in env with the agents in cfg. The environment's "topic" setting
+// chooses what they talk about.
+func runChatRoom(cmd *cobra.Command, cfg *config.ExperimentConfig, env *environment.BaseEnvironment[*agent.LLMAgent, environment.BaseState]) error {
+	deliveryTimeout, _ := cmd.Flags().GetDuration("delivery-timeout")
+	simpleBroker := messaging.NewBroker(messaging.WithOrderedDelivery(), messaging.WithBlockingDelivery(deliveryTimeout))
+	defer simpleBroker.Reset()
+	var broker messaging.Broker = simpleBroker
+	if transcriptPath, _ := cmd.Flags().GetString("transcript"); transcriptPath != "" {
+		transcriptFile, err := os.Create(transcriptPath)
+		if err != nil {
+			return fmt.Errorf("failed to create transcript file: %v", err)
+		}
+		defer transcriptFile.Close()
+		broker = messaging.NewRecordingBroker(simpleBroker, messaging.WithTranscript(transcriptFile))
+	}
+	duration := cfg.Duration
+	if duration <= 0 {
+		duration = 15 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
 	defer cancel()
 
 	// Handle graceful shutdown
@@ -81,51 +374,55 @@ func runChatExperiment(cmd *cobra.Command, args []string) error {
 		cancel()
 	}()
 
-	// Create experiment config
-	config := &config.ExperimentConfig{
-		Name:  "chat_room",
-		Steps: 10, // Run for 10 steps
+	topic := "artificial intelligence"
+	if t, ok := cfg.Environment.Config["topic"].(string); ok && t != "" {
+		topic = t
 	}
-	// Create base environment
-	env := environment.NewBaseEnvironment[*agent.LLMAgent, environment.BaseState](environment.BaseState{
-		Status:    "idle",
-		Step:      0,
-		Timestamp: time.Now(),
-	})
+	stream, _ := cmd.Flags().GetBool("stream")
+	maxRetries, _ := cmd.Flags().GetInt("max-retries")
 
-	openai, err := providers.OpenAi(ctx)
-	if err != nil {
-		return err
-	}
-	// Create 3 agents
-	const NUM_AGENTS = 3
-	for i := 0; i < NUM_AGENTS; i++ {
-		a, err := agent.NewLLMAgent(
-			ctx,
-			agent.WithMessageBroker(broker),
-			agent.WithTask("Have a friendly conversation about artificial intelligence with other agents."),
-			agent.WithProvider(openai),
-		)
+	for _, group := range cfg.Agents {
+		provider, modelID, err := newProvider(ctx, group.Model, providers.WithRateLimit(60))
 		if err != nil {
-			return fmt.Errorf("failed to create agent: %v", err)
+			return err
+		}
+		agentOpts := []agent.AgentOption{
+			agent.WithMessageBroker(broker),
+			agent.WithTask(fmt.Sprintf("Have a friendly conversation about %s with other agents.", topic)),
+			agent.WithProvider(provider),
+			agent.WithModel(agent.ModelInfo{Id: modelID, Config: group.Config}),
+			agent.WithPersona(group.Persona),
+			agent.WithMaxRetries(maxRetries),
 		}
-		log.Printf("Created %s", a.GetID())
+		if stream {
+			agentOpts = append(agentOpts, agent.WithStreamOutput(os.Stdout))
+		}
+
+		for i := 0; i < group.Count; i++ {
+			a, err := agent.NewLLMAgent(ctx, agentOpts...)
+			if err != nil {
+				return fmt.Errorf("failed to create agent: %v", err)
+			}
+			log.Printf("Created %s", a.GetID())
 
-		// Start message handler for each agent
-		a.StartMessageHandler(ctx)
+			// Start message handler for each agent
+			a.StartMessageHandler(ctx)
 
-		// Add agent to environment
-		if err := env.AddAgent(a); err != nil {
-			return fmt.Errorf("failed to add agent to environment: %v", err)
+			// Add agent to environment
+			if err := env.AddAgent(a); err != nil {
+				return fmt.Errorf("failed to add agent to environment: %v", err)
+			}
 		}
 	}
 
 	// Create and run experiment
-	exp := experiment.NewBaseExperiment(config, env)
+	exp := experiment.NewBaseExperiment(cfg, env)
 
-	// Run 5 steps
-	for i := 0; i < 5; i++ {
-		time.Sleep(1 * time.Second) // avoid rate limiting
+	steps := cfg.Steps
+	if steps == 0 {
+		steps = 5
+	}
+	for i := 0; i < steps; i++ {
 		if err := exp.Step(ctx); err != nil {
 			return fmt.Errorf("experiment failed: %v", err)
 		}
@@ -134,7 +431,84 @@ func runChatExperiment(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// donorGameEnvSettings maps environment config keys to donor game flags whose names don't
+// follow from the key
+var donorGameEnvSettings = map[string]string{
+	"rounds_per_gen": "rounds",
+}
+
+// donorGameAgentSettings maps agent config keys to the donor game flags they set
+var donorGameAgentSettings = map[string]string{
+	"temperature": "temperature",
+	"top_p":       "top-p",
+}
+
+// applyDonorGameConfig sets the donor game flags from an experiment config. The agents set the
+// population size and model, rounds_per_gen sets --rounds, and every other environment setting
+// sets the flag of the same name with dashes for underscores, e.g. donation_multiplier sets
+// --donation-multiplier. Flags given on the command line take precedence over the config.
+func applyDonorGameConfig(cmd *cobra.Command, cfg *config.ExperimentConfig) error {
+	model := cfg.Agents[0].Model
+	for _, group := range cfg.Agents[1:] {
+		if group.Model != model {
+			return fmt.Errorf("donor game configs support a single model, got %s and %s", model, group.Model)
+		}
+	}
+
+	settings := map[string]any{
+		"num-agents": cfg.NumAgents(),
+		"model":      model,
+	}
+	if cfg.Duration > 0 {
+		settings["timeout"] = cfg.Duration
+	}
+	for key, value := range cfg.Agents[0].Config {
+		switch {
+		case donorGameAgentSettings[key] != "":
+			settings[donorGameAgentSettings[key]] = value
+		case key == "response_format":
+			settings["json-output"] = value == providers.ResponseFormatJSON
+		default:
+			log.Printf("Ignoring agent setting %q, the donor game doesn't support it", key)
+		}
+	}
+	for key, value := range cfg.Environment.Config {
+		if name, ok := donorGameEnvSettings[key]; ok {
+			settings[name] = value
+			continue
+		}
+		settings[strings.ReplaceAll(key, "_", "-")] = value
+	}
+
+	for name, value := range settings {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil {
+			return fmt.Errorf("unknown donor game setting %q in %s", name, cfg.Name)
+		}
+		if flag.Changed {
+			continue
+		}
+		if err := cmd.Flags().Set(name, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("invalid donor game setting %s in %s: %v", name, cfg.Name, err)
+		}
+	}
+	return nil
+}
+
 func runDonorGameExperiment(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfigFlag(cmd)
+	if err != nil {
+		return err
+	}
+	if cfg != nil {
+		if cfg.Environment.Type != "" && cfg.Environment.Type != "donor_game" {
+			return fmt.Errorf("config %s is for a %s environment, not a donor game", cfg.Name, cfg.Environment.Type)
+		}
+		if err := applyDonorGameConfig(cmd, cfg); err != nil {
+			return err
+		}
+	}
+
 	// Get flag values
 	numGenerations, _ := cmd.Flags().GetInt("generations")
 	roundsPerGen, _ := cmd.Flags().GetInt("rounds")
@@ -143,15 +517,67 @@ func runDonorGameExperiment(cmd *cobra.Command, args []string) error {
 	donationMult, _ := cmd.Flags().GetFloat64("donation-multiplier")
 	initialBalance, _ := cmd.Flags().GetFloat64("initial-balance")
 	modelName, _ := cmd.Flags().GetString("model")
+	fallbackModels, _ := cmd.Flags().GetStringSlice("fallback-model")
+	recordCallsPath, _ := cmd.Flags().GetString("record-calls")
+	maxRetries, _ := cmd.Flags().GetInt("max-retries")
+	rateLimit, _ := cmd.Flags().GetInt("rate-limit")
+	concurrency, _ := cmd.Flags().GetInt("concurrency")
+	pairing, _ := cmd.Flags().GetString("pairing")
+	requestTimeout, _ := cmd.Flags().GetDuration("request-timeout")
+	maxCalls, _ := cmd.Flags().GetInt("max-calls")
+	parseRetries, _ := cmd.Flags().GetInt("parse-retries")
+	punishment, _ := cmd.Flags().GetBool("punishment")
+	gossip, _ := cmd.Flags().GetBool("gossip")
+	summarizeMemory, _ := cmd.Flags().GetBool("summarize-memory")
+	precision, _ := cmd.Flags().GetInt("precision")
+	seed, _ := cmd.Flags().GetInt64("seed")
+	seedPerGeneration, _ := cmd.Flags().GetBool("seed-per-generation")
+	seedStrategiesPath, _ := cmd.Flags().GetString("seed-strategies")
+	historyNoise, _ := cmd.Flags().GetFloat64("history-noise")
+	historyNoiseMode, _ := cmd.Flags().GetString("history-noise-mode")
+	historyTokenLimit, _ := cmd.Flags().GetInt("history-token-limit")
+	dumpStrategiesPath, _ := cmd.Flags().GetString("dump-strategies")
+	dumpLineagePath, _ := cmd.Flags().GetString("dump-lineage")
+	roundLogPath, _ := cmd.Flags().GetString("round-log")
+	resumePath, _ := cmd.Flags().GetString("resume")
+	parquetPath, _ := cmd.Flags().GetString("parquet")
+	roundStatsPath, _ := cmd.Flags().GetString("round-stats")
+	outputDir, _ := cmd.Flags().GetString("output-dir")
+	showProgress, _ := cmd.Flags().GetBool("progress")
+	formatName, _ := cmd.Flags().GetString("format")
+	format, err := experiment.ParseOutputFormat(formatName)
+	if err != nil {
+		return err
+	}
+	useAgentPool, _ := cmd.Flags().GetBool("agent-pool")
+	elitism, _ := cmd.Flags().GetBool("elitism")
+	roundTimeout, _ := cmd.Flags().GetDuration("round-timeout")
+	maxFailureRate, _ := cmd.Flags().GetFloat64("max-failure-rate")
+	roundRetries, _ := cmd.Flags().GetInt("round-retries")
+	minViablePopulation, _ := cmd.Flags().GetInt("min-viable-population")
+	adviceLimit, _ := cmd.Flags().GetInt("advice-limit")
+	shuffleAdvice, _ := cmd.Flags().GetBool("shuffle-advice")
+	collapseThreshold, _ := cmd.Flags().GetFloat64("collapse-threshold")
+	fitnessWeights := experiment.DefaultFitnessWeights
+	fitnessWeights.Resources, _ = cmd.Flags().GetFloat64("fitness-resources")
+	fitnessWeights.Cooperation, _ = cmd.Flags().GetFloat64("fitness-cooperation")
+	fitnessWeights.Inequality, _ = cmd.Flags().GetFloat64("fitness-inequality")
+	pareto, _ := cmd.Flags().GetBool("pareto")
+
+	timeout, _ := cmd.Flags().GetDuration("timeout")
 
 	// Setup context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
+	// Handle graceful shutdown: the first interrupt stops the experiment once the current round
+	// is done, and a second one cancels it immediately
+	sigChan := make(chan os.Signal, 2)
 	signal.Notify(sigChan, os.Interrupt)
+	stopRequested := make(chan struct{})
 	go func() {
+		<-sigChan
+		close(stopRequested)
 		<-sigChan
 		cancel()
 	}()
@@ -161,36 +587,116 @@ func runDonorGameExperiment(cmd *cobra.Command, args []string) error {
 	defer broker.Reset()
 
 	// Create LLM provider based on model flag
-	var llmProvider agent.Client
-	var err error
-	switch modelName {
-	case "gpt-4":
-		llmProvider, err = providers.OpenAi(ctx)
-	case "gemini":
-		llmProvider, err = providers.Gemini(ctx)
-	default:
-		return fmt.Errorf("unsupported model: %s", modelName)
+	providerOpts := []providers.ProviderOption{
+		providers.WithRateLimit(rateLimit),
+		providers.WithRequestTimeout(requestTimeout),
 	}
+	llmProvider, modelID, err := newProvider(ctx, modelName, providerOpts...)
 	if err != nil {
-		return fmt.Errorf("failed to create LLM provider: %v", err)
+		return err
+	}
+	if len(fallbackModels) > 0 {
+		chain := []providers.FallbackProvider{{Client: llmProvider, Model: modelID}}
+		for _, fallbackModel := range fallbackModels {
+			fallbackProvider, fallbackModelID, err := newProvider(ctx, fallbackModel, providerOpts...)
+			if err != nil {
+				return err
+			}
+			chain = append(chain, providers.FallbackProvider{Client: fallbackProvider, Model: fallbackModelID})
+		}
+		llmProvider = providers.NewFallbackClient(chain...)
+	}
+	usage := providers.NewUsageTracker()
+	llmProvider = usage.Wrap(llmProvider)
+	// The budget sits inside the retry wrapper so that retried calls count against it
+	var callBudget *providers.CallBudget
+	if maxCalls > 0 {
+		callBudget = providers.NewCallBudget(maxCalls)
+		llmProvider = callBudget.Wrap(llmProvider)
+	}
+	if maxRetries > 0 {
+		llmProvider = providers.NewRetryingClient(llmProvider, providers.WithMaxRetries(maxRetries))
+	}
+	if recordCallsPath != "" {
+		recordFile, err := os.Create(recordCallsPath)
+		if err != nil {
+			return fmt.Errorf("failed to create call record file: %v", err)
+		}
+		defer recordFile.Close()
+		llmProvider = providers.NewRecordingClient(llmProvider, recordFile)
 	}
 
 	// Create donor game environment
+	envOpts := []environment.DonorGameOption{
+		environment.WithSeed(seed),
+		environment.WithPrecision(precision),
+		environment.WithConcurrency(concurrency),
+		environment.WithMaxFailureRate(maxFailureRate),
+	}
+	if historyNoise > 0 {
+		var noise environment.HistoryNoise
+		switch historyNoiseMode {
+		case "misreport":
+			noise = environment.NoiseMisreport
+		case "omit":
+			noise = environment.NoiseOmit
+		default:
+			return fmt.Errorf("unsupported history noise mode: %s", historyNoiseMode)
+		}
+		envOpts = append(envOpts, environment.WithHistoryNoise(historyNoise, noise))
+	}
+	switch pairing {
+	case "random":
+	case "round-robin":
+		envOpts = append(envOpts, environment.WithPairingStrategy(environment.RoundRobinPairing{}))
+	default:
+		return fmt.Errorf("unsupported pairing strategy: %s", pairing)
+	}
+	if historyTokenLimit > 0 {
+		envOpts = append(envOpts, environment.WithHistoryTokenLimit(historyTokenLimit))
+	}
+	if punishment {
+		envOpts = append(envOpts, environment.WithPunishment())
+	}
+	if seedPerGeneration {
+		envOpts = append(envOpts, environment.WithGenerationSeeds())
+	}
+	if gossip {
+		envOpts = append(envOpts, environment.WithGossip())
+	}
 	env := environment.NewDonorGameEnvironment(
 		roundsPerGen,
 		donationMult,
 		initialBalance,
+		envOpts...,
 	)
 
 	// Create agent factory for generating new agents
+	modelConfig := make(map[string]any)
+	if cmd.Flags().Changed("temperature") {
+		modelConfig["temperature"], _ = cmd.Flags().GetFloat64("temperature")
+	}
+	if cmd.Flags().Changed("top-p") {
+		modelConfig["top_p"], _ = cmd.Flags().GetFloat64("top-p")
+	}
+	if jsonOutput, _ := cmd.Flags().GetBool("json-output"); jsonOutput {
+		modelConfig["response_format"] = providers.ResponseFormatJSON
+	}
+
+	agentOpts := []agent.AgentOption{
+		agent.WithProvider(llmProvider),
+		agent.WithModel(agent.ModelInfo{Id: modelID, Config: modelConfig}),
+		agent.WithMessageBroker(broker),
+		agent.WithParseRetries(parseRetries),
+	}
+	if punishment {
+		agentOpts = append(agentOpts, agent.WithPunishment())
+	}
+	if summarizeMemory {
+		agentOpts = append(agentOpts, agent.WithMemorySummarization())
+	}
 	agentFactory := func(ctx context.Context, id string, strategy string) (*agent.DonorGameAgent, error) {
-		return agent.NewDonorGameAgent(
-			ctx,
-			id,
-			strategy,
-			agent.WithProvider(llmProvider),
-			agent.WithMessageBroker(broker),
-		)
+		return agent.NewDonorGameAgent(ctx, id, strategy, agentOpts...)
 	}
 
 	// Create experiment config
@@ -199,6 +705,92 @@ func runDonorGameExperiment(cmd *cobra.Command, args []string) error {
 	// 	Steps: roundsPerGen,
 	// }
 
+	var opts []experiment.ExperimentOption
+	if seedStrategiesPath != "" {
+		strategies, err := experiment.LoadStrategies(seedStrategiesPath)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, experiment.WithSeedStrategies(strategies))
+	}
+	if dumpStrategiesPath != "" {
+		opts = append(opts, experiment.WithStrategyDump(dumpStrategiesPath))
+	}
+	opts = append(opts, experiment.WithUsageTracker(usage))
+	if slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		opts = append(opts, experiment.WithSubscriberCheck(broker))
+	}
+	if dumpLineagePath != "" {
+		opts = append(opts, experiment.WithLineageDump(dumpLineagePath))
+	}
+	if roundLogPath != "" {
+		opts = append(opts, experiment.WithRoundLog(roundLogPath))
+	}
+	if pareto {
+		objectives := []experiment.Objective{experiment.WealthObjective, experiment.CooperationObjective}
+		opts = append(opts,
+			experiment.WithObjectives(objectives...),
+			experiment.WithFitnessFunc(experiment.ParetoFitness(objectives...)),
+		)
+	} else if fitnessWeights != experiment.DefaultFitnessWeights {
+		opts = append(opts, experiment.WithFitnessFunc(experiment.CompositeFitness(fitnessWeights)))
+	}
+	if useAgentPool {
+		opts = append(opts, experiment.WithAgentPool())
+	}
+	if elitism {
+		opts = append(opts, experiment.WithElitism())
+	}
+	opts = append(opts, experiment.WithCollapseThreshold(collapseThreshold))
+	if callBudget != nil {
+		opts = append(opts, experiment.WithCallBudget(callBudget))
+	}
+	if adviceLimit > 0 {
+		opts = append(opts, experiment.WithAdviceLimit(adviceLimit))
+	}
+	if shuffleAdvice {
+		opts = append(opts, experiment.WithShuffledAdvice())
+	}
+	if roundTimeout > 0 {
+		opts = append(opts, experiment.WithRoundTimeout(roundTimeout))
+	}
+	if roundRetries > 0 {
+		opts = append(opts, experiment.WithRoundRetries(roundRetries))
+	}
+	if minViablePopulation > 0 {
+		opts = append(opts, experiment.WithMinViablePopulation(minViablePopulation))
+	}
+	if resumePath != "" {
+		opts = append(opts, experiment.WithCheckpoint(resumePath))
+	}
+	opts = append(opts, experiment.WithOutputDir(outputDir), experiment.WithOutputFormat(format))
+	if showProgress {
+		reporter := experiment.NewProgressReporter(os.Stderr)
+		// Logs on the same terminal are written above the progress line instead of into it
+		if logOutput == io.Writer(os.Stderr) {
+			logOutput = reporter.LogWriter(os.Stderr)
+			setDefaultLogger()
+		}
+		opts = append(opts, experiment.WithProgressReporter(reporter))
+	}
+	if roundStatsPath != "" {
+		opts = append(opts, experiment.WithRoundStats(roundStatsPath))
+	}
+	if parquetPath != "" {
+		// Like the experiment's other outputs, a relative path is written to the output directory
+		if !filepath.IsAbs(parquetPath) {
+			if err := os.MkdirAll(outputDir, 0755); err != nil {
+				return fmt.Errorf("failed to create output directory: %v", err)
+			}
+			parquetPath = filepath.Join(outputDir, parquetPath)
+		}
+		sink, err := experiment.NewParquetSink(parquetPath)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, experiment.WithStatsSink(sink))
+	}
+
 	// Create and run the generational experiment
 	experiment, err := experiment.NewDonorGameExperiment(
 		env,
@@ -207,10 +799,19 @@ func runDonorGameExperiment(cmd *cobra.Command, args []string) error {
 		numAgents,
 		numGenerations,
 		roundsPerGen,
+		opts...,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create experiment: %v", err)
 	}
+	go func() {
+		select {
+		case <-stopRequested:
+			log.Println("Interrupted, stopping after the current round; interrupt again to stop immediately")
+			experiment.Stop()
+		case <-ctx.Done():
+		}
+	}()
 
 	// Run the experiment
 	if err := experiment.Run(ctx); err != nil {
@@ -219,3 +820,161 @@ func runDonorGameExperiment(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+// addGameFlags adds the flags shared by the generational experiments of games other than the donor game
+func addGameFlags(cmd *cobra.Command) {
+	cmd.Flags().IntP("generations", "g", 3, "Number of generations to run")
+	cmd.Flags().IntP("rounds", "r", 3, "Number of rounds per generation")
+	cmd.Flags().IntP("num-agents", "n", 6, "Number of agents per generation")
+	cmd.Flags().Float64P("survivor-ratio", "s", 0.5, "Fraction of agents whose strategies are passed on to the next generation")
+	cmd.Flags().StringP("model", "l", "gpt-4", "LLM model to use, e.g. gpt-4, gpt-4o, gemini, gemini-2.0-flash, ollama, ollama:<model>, or mock for a dry run without API calls")
+	cmd.Flags().Int("max-retries", 3, "Times to retry a provider call that fails with a rate limit or server error")
+	cmd.Flags().Int("parse-retries", 1, "Times to re-prompt an agent whose answer can't be parsed")
+	cmd.Flags().Int64("seed", 0, "Seed for the random number generator; 0 picks a random seed")
+	cmd.Flags().Duration("timeout", time.Hour, "Maximum duration of the whole experiment")
+	cmd.Flags().String("output-dir", ".", "Directory to write the stats file to; created if it doesn't exist")
+	cmd.Flags().String("dump-strategies", "", "File to write the final generation's strategies to; a relative path is inside --output-dir")
+}
+
+// gameSetup is what every game experiment is built from, read from the flags added by addGameFlags
+type gameSetup struct {
+	numGenerations int
+	roundsPerGen   int
+	numAgents      int
+	survivorRatio  float64
+	seed           int64
+	agentOpts      []agent.AgentOption
+	opts           []experiment.GameOption
+}
+
+// newGameSetup creates the model client and options of a game experiment from cmd's flags. The
+// mock model answers mockResponse, which should hold both a strategy and a move of the game.
+func newGameSetup(ctx context.Context, cmd *cobra.Command, mockResponse string) (*gameSetup, error) {
+	setup := &gameSetup{}
+	setup.numGenerations, _ = cmd.Flags().GetInt("generations")
+	setup.roundsPerGen, _ = cmd.Flags().GetInt("rounds")
+	setup.numAgents, _ = cmd.Flags().GetInt("num-agents")
+	setup.survivorRatio, _ = cmd.Flags().GetFloat64("survivor-ratio")
+	setup.seed, _ = cmd.Flags().GetInt64("seed")
+	modelName, _ := cmd.Flags().GetString("model")
+	maxRetries, _ := cmd.Flags().GetInt("max-retries")
+	parseRetries, _ := cmd.Flags().GetInt("parse-retries")
+	outputDir, _ := cmd.Flags().GetString("output-dir")
+	dumpStrategiesPath, _ := cmd.Flags().GetString("dump-strategies")
+
+	llmProvider, modelID, err := newProvider(ctx, modelName)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := llmProvider.(*providers.MockClient); ok {
+		llmProvider = providers.NewMockClient(providers.WithMockResponse(mockResponse))
+	}
+	if maxRetries > 0 {
+		llmProvider = providers.NewRetryingClient(llmProvider, providers.WithMaxRetries(maxRetries))
+	}
+	setup.agentOpts = []agent.AgentOption{
+		agent.WithProvider(llmProvider),
+		agent.WithModel(agent.ModelInfo{Id: modelID}),
+		agent.WithParseRetries(parseRetries),
+	}
+
+	setup.opts = []experiment.GameOption{experiment.WithGameOutputDir(outputDir)}
+	if dumpStrategiesPath != "" {
+		setup.opts = append(setup.opts, experiment.WithGameStrategyDump(dumpStrategiesPath))
+	}
+	return setup, nil
+}
+
+// gameContext returns the context a game experiment runs in, which ends after cmd's timeout or
+// on an interrupt
+func gameContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// runPrisonersDilemmaExperiment runs an iterated Prisoner's Dilemma with generational evolution
+func runPrisonersDilemmaExperiment(cmd *cobra.Command, args []string) error {
+	ctx, cancel := gameContext(cmd)
+	defer cancel()
+
+	setup, err := newGameSetup(ctx, cmd, "My strategy will be to cooperate with every opponent.\nANSWER: COOPERATE")
+	if err != nil {
+		return err
+	}
+	var payoffs environment.PayoffMatrix
+	payoffs.Reward, _ = cmd.Flags().GetFloat64("reward")
+	payoffs.Sucker, _ = cmd.Flags().GetFloat64("sucker")
+	payoffs.Temptation, _ = cmd.Flags().GetFloat64("temptation")
+	payoffs.Punishment, _ = cmd.Flags().GetFloat64("punishment")
+
+	env := environment.NewPrisonersDilemmaEnvironment(payoffs, setup.roundsPerGen, environment.WithPDSeed(setup.seed))
+	log.Printf("Experiment seed: %d", env.Seed())
+	agentFactory := func(ctx context.Context, id string, strategy string) (*agent.PDAgent, error) {
+		return agent.NewPDAgent(ctx, id, strategy, setup.agentOpts...)
+	}
+	experiment, err := experiment.NewPrisonersDilemmaExperiment(env, agentFactory, setup.survivorRatio,
+		setup.numAgents, setup.numGenerations, setup.roundsPerGen, setup.opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create experiment: %v", err)
+	}
+	if err := experiment.Run(ctx); err != nil {
+		return fmt.Errorf("experiment failed: %v", err)
+	}
+	return nil
+}
+
+// runUltimatumExperiment runs the ultimatum game with generational evolution
+func runUltimatumExperiment(cmd *cobra.Command, args []string) error {
+	ctx, cancel := gameContext(cmd)
+	defer cancel()
+
+	setup, err := newGameSetup(ctx, cmd, "My strategy will be to split the pot evenly and accept fair offers.\nANSWER: 5\nANSWER: ACCEPT")
+	if err != nil {
+		return err
+	}
+	potSize, _ := cmd.Flags().GetFloat64("pot-size")
+
+	env := environment.NewUltimatumEnvironment(potSize, setup.roundsPerGen, environment.WithUltimatumSeed(setup.seed))
+	log.Printf("Experiment seed: %d", env.Seed())
+	agentFactory := func(ctx context.Context, id string, strategy string) (*agent.UltimatumAgent, error) {
+		return agent.NewUltimatumAgent(ctx, id, strategy, setup.agentOpts...)
+	}
+	experiment, err := experiment.NewUltimatumExperiment(env, agentFactory, setup.survivorRatio,
+		setup.numAgents, setup.numGenerations, setup.roundsPerGen, setup.opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create experiment: %v", err)
+	}
+	if err := experiment.Run(ctx); err != nil {
+		return fmt.Errorf("experiment failed: %v", err)
+	}
+	return nil
+}
+
+// newProvider creates the LLM provider for a model name or alias such as "gpt-4o" or "gemini",
+// and returns it with the canonical model ID to send to it
+func newProvider(ctx context.Context, modelName string, opts ...providers.ProviderOption) (agent.Client, string, error) {
+	spec, err := providers.ResolveModel(modelName)
+	if err != nil {
+		return nil, "", err
+	}
+	var provider agent.Client
+	switch spec.Provider {
+	case providers.ProviderOpenAI:
+		provider, err = providers.OpenAi(ctx, opts...)
+	case providers.ProviderGemini:
+		provider, err = providers.Gemini(ctx, opts...)
+	case providers.ProviderOllama:
+		provider, err = providers.Ollama(ctx, opts...)
+	case providers.ProviderMock:
+		provider = providers.NewMockClient()
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create LLM provider: %v", err)
+	}
+	return provider, spec.Model, nil
+}
@@ -2,13 +2,18 @@ package agent
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/boristopalov/petri/pkg/memory"
+	"github.com/boristopalov/petri/pkg/messaging"
+	"github.com/boristopalov/petri/pkg/providers"
 )
 
 const (
@@ -31,7 +36,25 @@ This is generation %d. It is now round %d. In this round, you have been paired w
 You currently have %.2f units of the valuable resource.
 How many units do you give up? Very briefly think step by step about how you apply your strategy in this situation and then provide your answer. Your answer should follow the string "ANSWER" like so: ANSWER:`
 
+	DONATION_RETRY_PROMPT_TEMPLATE = `Your previous response did not include your answer in the required format. Here was your response:
+
+%s
+
+Please restate how many units you give up. Your answer must follow the string "ANSWER" like so: ANSWER: 5`
+
+	// DONATION_JSON_INSTRUCTION is appended to donation prompts when the model is configured for JSON responses
+	DONATION_JSON_INSTRUCTION = `Instead of using the ANSWER format, respond only with a JSON object of the form {"reasoning": "<your brief step-by-step reasoning>", "donation": <units you give up>}.`
+
+	// DEFAULT_STRATEGY is assigned when the model fails to produce a parseable strategy
+	DEFAULT_STRATEGY = "to donate half of my resources in every round, regardless of the recipient's history."
+
 	PUNISHMENT_PROMPT = `You may also choose to punish the recipient by spending x units to take away 2x of their resources. Bear in mind that others may punish you too.`
+
+	// PUNISHMENT_INSTRUCTION follows PUNISHMENT_PROMPT in donation prompts and asks for the amount spent punishing
+	PUNISHMENT_INSTRUCTION = `If you decide to punish, add a line after your answer with the units you spend on punishment like so: PUNISH: 2`
+
+	// PUNISHMENT_JSON_INSTRUCTION replaces PUNISHMENT_INSTRUCTION when the model is configured for JSON responses
+	PUNISHMENT_JSON_INSTRUCTION = `If you decide to punish, also include "punish": <units you spend on punishment> in the JSON object.`
 )
 
 // DonorGameAgent represents an agent in the donor game
@@ -41,6 +64,40 @@ type DonorGameAgent struct {
 	memory   *memory.Memory
 	client   Client
 	model    ModelInfo
+	logger   *slog.Logger
+	// prompt templates used for strategy generation and donation decisions
+	strategyTemplate string
+	donationTemplate string
+	// parseRetries is how many times to re-prompt for an unparseable donation
+	parseRetries int
+	// fallbackStrategy is set when the agent was assigned DEFAULT_STRATEGY
+	fallbackStrategy bool
+	// punishment offers the agent the option to punish recipients
+	punishment bool
+	// retrievedMemories is how many memories relevant to the recipient are sent with donation
+	// prompts; 0 sends all of them
+	retrievedMemories int
+	// messageBroker is nil unless the agent was given one, in which case it is subscribed under its ID
+	messageBroker messaging.Broker
+	messageChan   chan messaging.Message
+	subscribed    bool
+	// donations are the donations the agent made and received, in the order they happened
+	donations   []DonationRecord
+	donationsMu sync.Mutex
+	// gossip is the gossip the agent has heard, oldest first and at most MAX_GOSSIP entries
+	gossip   []string
+	gossipMu sync.Mutex
+}
+
+// DonationRecord is a donation an agent made or received. An agent carried into a later
+// generation keeps its history, so records of several generations can be mixed.
+type DonationRecord struct {
+	Generation int     `json:"generation"` // generation the donation was made in
+	Round      int     `json:"round"`      // round of the generation the donation was made in, starting at 0
+	PartnerID  string  `json:"partner_id"` // the recipient if the agent donated, otherwise the donor
+	Amount     float64 `json:"amount"`     // units the donor gave up, before the multiplier
+	Pct        float64 `json:"pct"`        // fraction of the donor's resources given up, from 0 to 1
+	AsDonor    bool    `json:"as_donor"`   // whether the agent was the donor
 }
 
 // NewDonorGameAgent creates a new donor game agent
@@ -51,17 +108,69 @@ func NewDonorGameAgent(ctx context.Context, id string, strategy string, opts ...
 	}
 
 	params.AgentID = id
+	params.StrategyPromptTemplate = STRATEGY_PROMPT_TEMPLATE
+	params.DonationPromptTemplate = DONATION_PROMPT_TEMPLATE
 	for _, opt := range opts {
 		opt(params)
 	}
+	if err := withDefaultClient(ctx, params); err != nil {
+		return nil, err
+	}
 
-	return &DonorGameAgent{
-		id:       params.AgentID,
-		strategy: strategy,
-		memory:   memory.NewMemory(100),
-		client:   params.Client,
-		model:    params.Model,
-	}, nil
+	if err := ValidatePromptTemplate("strategy prompt", params.StrategyPromptTemplate, strategyTemplateVerbs); err != nil {
+		return nil, err
+	}
+	if err := ValidatePromptTemplate("donation prompt", params.DonationPromptTemplate, donationTemplateVerbs); err != nil {
+		return nil, err
+	}
+
+	mem := memory.NewMemory(100)
+	if params.SummarizeMemory {
+		mem = memory.NewSummarizingMemory(100, params.Client, params.Model.Id)
+	}
+	agent := &DonorGameAgent{
+		id:               params.AgentID,
+		strategy:         strategy,
+		memory:           mem,
+		client:           params.Client,
+		model:            params.Model,
+		logger:           params.Logger,
+		strategyTemplate: params.StrategyPromptTemplate,
+		donationTemplate: params.DonationPromptTemplate,
+		parseRetries:     params.ParseRetries,
+		punishment:       params.Punishment,
+		messageBroker:    params.MessageBroker,
+		messageChan:      make(chan messaging.Message, 100),
+	}
+	if params.Embedder != nil && params.RetrievedMemories > 0 {
+		agent.memory.SetEmbedder(params.Embedder)
+		agent.retrievedMemories = params.RetrievedMemories
+	}
+	if err := agent.subscribe(); err != nil {
+		return nil, err
+	}
+	return agent, nil
+}
+
+// subscribe subscribes the agent to its message broker under its current ID, if it has a broker
+func (a *DonorGameAgent) subscribe() error {
+	if a.messageBroker == nil {
+		return nil
+	}
+	if err := a.messageBroker.Subscribe(a.id, a.messageChan); err != nil {
+		return err
+	}
+	a.subscribed = true
+	return nil
+}
+
+// Unsubscribe removes the agent's subscription from its message broker, if it has one
+func (a *DonorGameAgent) Unsubscribe() error {
+	if !a.subscribed {
+		return nil
+	}
+	a.subscribed = false
+	return a.messageBroker.Unsubscribe(a.id)
 }
 
 // GetID returns the agent's ID
@@ -69,6 +178,11 @@ func (a *DonorGameAgent) GetID() string {
 	return a.id
 }
 
+// GetModel returns the model the agent runs on
+func (a *DonorGameAgent) GetModel() ModelInfo {
+	return a.model
+}
+
 // GetMemory returns the agent's memory
 func (a *DonorGameAgent) GetMemory() *memory.Memory {
 	return a.memory
@@ -79,9 +193,75 @@ func (a *DonorGameAgent) GetStrategy() string {
 	return a.strategy
 }
 
+// UsedFallbackStrategy reports whether the agent was assigned the default strategy
+// because the model never produced a parseable one
+func (a *DonorGameAgent) UsedFallbackStrategy() bool {
+	return a.fallbackStrategy
+}
+
+// Reset prepares the agent for reuse under a new ID, clearing its memory and replacing its strategy
+// If the agent has a message broker, it is resubscribed under the new ID.
+func (a *DonorGameAgent) Reset(id string, strategy string) {
+	if err := a.Unsubscribe(); err != nil {
+		a.logger.Warn("failed to unsubscribe agent", "agent", a.id, "error", err)
+	}
+	a.id = id
+	a.strategy = strategy
+	a.fallbackStrategy = false
+	if err := a.memory.Clear(); err != nil {
+		a.logger.Warn("failed to clear agent memory", "agent", id, "error", err)
+	}
+	a.donationsMu.Lock()
+	a.donations = nil
+	a.donationsMu.Unlock()
+	// Drop messages meant for the agent's previous identity
+	for len(a.messageChan) > 0 {
+		<-a.messageChan
+	}
+	if err := a.subscribe(); err != nil {
+		a.logger.Warn("failed to resubscribe agent", "agent", id, "error", err)
+	}
+}
+
+// RecordDonation adds a donation the agent made or received to its donation history
+func (a *DonorGameAgent) RecordDonation(record DonationRecord) {
+	a.donationsMu.Lock()
+	defer a.donationsMu.Unlock()
+	a.donations = append(a.donations, record)
+}
+
+// GetDonationHistory returns the donations the agent made and received, oldest first
+func (a *DonorGameAgent) GetDonationHistory() []DonationRecord {
+	a.donationsMu.Lock()
+	defer a.donationsMu.Unlock()
+	return slices.Clone(a.donations)
+}
+
+// DonationDecision is the outcome of a donation decision along with how it was reached
+type DonationDecision struct {
+	Amount      float64 // units donated, after clamping
+	RawResponse string  // final model response the amount was parsed from
+	Reasoning   string  // response text before the answer
+	Clamped     bool    // the requested amount was negative or exceeded the donor's resources
+	Parsed      bool    // an answer was found in the response
+	Punishment  float64 // units spent punishing the recipient, after clamping; 0 unless the agent was offered punishment
+	// FinishReason is why the model stopped generating the final response, if the provider reports it
+	FinishReason string
+}
+
 // MakeDonationDecision decides how much to donate based on the current situation
 func (a *DonorGameAgent) MakeDonationDecision(ctx context.Context, generation, round int, recipientID string, recipientResources float64, recipientHistory string, donorResources float64) (float64, error) {
-	prompt := fmt.Sprintf(DONATION_PROMPT_TEMPLATE,
+	decision, err := a.DecideDonation(ctx, generation, round, recipientID, recipientResources, recipientHistory, donorResources)
+	if err != nil {
+		return 0.0, err
+	}
+	return decision.Amount, nil
+}
+
+// DecideDonation is like MakeDonationDecision but returns the full decision record.
+// If no answer can be parsed, the returned decision holds the last response and an error.
+func (a *DonorGameAgent) DecideDonation(ctx context.Context, generation, round int, recipientID string, recipientResources float64, recipientHistory string, donorResources float64) (DonationDecision, error) {
+	prompt := fmt.Sprintf(a.donationTemplate,
 		a.id,
 		a.strategy,
 		generation,
@@ -92,37 +272,107 @@ func (a *DonorGameAgent) MakeDonationDecision(ctx context.Context, generation, r
 		donorResources,
 	)
 
-	response, err := a.client.Complete(ctx, a.model.Id, prompt, SYSTEM_PROMPT, a.memory.GetAllMessages())
+	jsonMode := a.model.Config["response_format"] == providers.ResponseFormatJSON
+	if a.punishment {
+		prompt += "\n\n" + PUNISHMENT_PROMPT
+		if !jsonMode {
+			prompt += " " + PUNISHMENT_INSTRUCTION
+		}
+	}
+	if jsonMode {
+		prompt += "\n\n" + DONATION_JSON_INSTRUCTION
+		if a.punishment {
+			prompt += " " + PUNISHMENT_JSON_INSTRUCTION
+		}
+		ctx = providers.WithResponseFormat(ctx, providers.ResponseFormatJSON)
+	}
+
+	ctx = providers.WithSampling(ctx, a.model.Sampling())
+	history := a.donationHistory(ctx, recipientID)
+	completion, err := providers.CompleteDetailed(ctx, a.client, a.model.Id, prompt, SYSTEM_PROMPT, history)
 	if err != nil {
-		return 0, fmt.Errorf("failed to generate response: %v", err)
+		return DonationDecision{}, fmt.Errorf("failed to generate response: %w", err)
+	}
+	response := completion.Text
+	a.logger.Debug("donation response", "agent", a.id, "response", response)
+
+	donationAmount, reasoning, err := parseDonation(response, jsonMode)
+	for retry := 0; err != nil && retry < a.parseRetries; retry++ {
+		a.logger.Warn("could not parse donation, retrying", "agent", a.id, "attempt", retry+1)
+		retryPrompt := fmt.Sprintf(DONATION_RETRY_PROMPT_TEMPLATE, response)
+		if jsonMode {
+			retryPrompt += "\n\n" + DONATION_JSON_INSTRUCTION
+		}
+		completion, err = providers.CompleteDetailed(ctx, a.client, a.model.Id, retryPrompt, SYSTEM_PROMPT, history)
+		if err != nil {
+			return DonationDecision{}, fmt.Errorf("failed to generate response on retry: %w", err)
+		}
+		response = completion.Text
+		a.logger.Debug("donation retry response", "agent", a.id, "response", response)
+		donationAmount, reasoning, err = parseDonation(response, jsonMode)
 	}
-	log.Printf("Donation Response for agent %s: %s", a.id, response)
 
-	donationAmount, err := parseDonationResponse(response)
+	decision := DonationDecision{
+		RawResponse:  response,
+		Reasoning:    reasoning,
+		FinishReason: completion.FinishReason,
+	}
 	if err != nil {
-		return 0.0, err
+		return decision, err
+	}
+	decision.Parsed = true
+
+	// A negative donation would take resources from the recipient, so it counts as donating nothing
+	if donationAmount < 0 {
+		donationAmount = 0
+		decision.Clamped = true
+	}
+	if available := max(donorResources, 0); donationAmount > available {
+		donationAmount = available
+		decision.Clamped = true
+	}
+	decision.Amount = donationAmount
+	if a.punishment {
+		// Punishment is paid out of what is left after the donation
+		decision.Punishment = min(parsePunishment(response, jsonMode), max(donorResources-donationAmount, 0))
 	}
-	if donationAmount > donorResources {
-		return donorResources, nil
+	a.logger.Info("donation decision", "agent", a.id, "recipient", recipientID, "amount", donationAmount, "punishment", decision.Punishment)
+	return decision, nil
+}
+
+// donationHistory returns the memories sent with a donation prompt: the ones most relevant to
+// the recipient if the agent retrieves memories, otherwise all of them. If retrieval fails, all
+// memories are sent.
+func (a *DonorGameAgent) donationHistory(ctx context.Context, recipientID string) []string {
+	if a.retrievedMemories == 0 {
+		return a.memory.GetAllMessages()
+	}
+	retrieved, err := a.memory.Retrieve(ctx, recipientID, a.retrievedMemories)
+	if err != nil {
+		a.logger.Warn("failed to retrieve memories, sending all of them", "agent", a.id, "error", err)
+		return a.memory.GetAllMessages()
 	}
-	return donationAmount, nil
+	return retrieved
 }
 
-// GenerateStrategy generates a new strategy for the agent at the start of a generation
+// GenerateStrategy generates a new strategy for the agent at the start of a generation.
+// If no strategy can be parsed even after a retry, the agent is assigned DEFAULT_STRATEGY.
 func (a *DonorGameAgent) GenerateStrategy(ctx context.Context, generation int, previousGenAdvice string) error {
 	var strategyPrompt string
 	if generation == 1 {
-		strategyPrompt = fmt.Sprintf(STRATEGY_PROMPT_TEMPLATE, a.id,
+		strategyPrompt = fmt.Sprintf(a.strategyTemplate, a.id,
 			"Based on the description of the game, create a strategy that you will follow in the game.")
 	} else {
-		strategyPrompt = fmt.Sprintf(STRATEGY_PROMPT_TEMPLATE, a.id,
+		strategyPrompt = fmt.Sprintf(a.strategyTemplate, a.id,
 			fmt.Sprintf("How would you approach the game?\nHere is the advice of the best-performing 50%% of the previous generation, along with their final scores:\n%s\nModify this advice to create your own strategy.", previousGenAdvice))
 	}
 
+	ctx = providers.WithSampling(ctx, a.model.Sampling())
 	response, err := a.client.Complete(ctx, a.model.Id, strategyPrompt, SYSTEM_PROMPT, []string{})
 	if err != nil {
 		return fmt.Errorf("failed to generate strategy: %v", err)
 	}
+	a.logger.Debug("strategy response", "agent", a.id, "response", response)
 
 	// Try to extract strategy
 	strategy := extractStrategy(response)
@@ -138,22 +388,27 @@ Please reformulate your strategy so that it starts with exactly "My strategy wil
 		if err != nil {
 			return fmt.Errorf("failed to generate strategy on retry: %v", err)
 		}
+		a.logger.Debug("strategy retry response", "agent", a.id, "response", response)
 
 		strategy = extractStrategy(response)
 		if strategy == "" {
-			return fmt.Errorf("no strategy found in response even after retry: %s", response)
+			a.logger.Warn("no strategy found in response even after retry, using default strategy", "agent", a.id)
+			a.strategy = DEFAULT_STRATEGY
+			a.fallbackStrategy = true
+			return nil
 		}
 	}
 
 	a.strategy = strategy
-	log.Printf("strategy for agent %s: %s", a.GetID(), a.strategy)
+	a.fallbackStrategy = false
+	a.logger.Info("generated strategy", "agent", a.id, "strategy", a.strategy)
 	return nil
 }
 
 // Helper function to parse donation amount from agent response
 func parseDonationResponse(response string) (float64, error) {
 	// Use regex to find "ANSWER: X" pattern
-	re := regexp.MustCompile(`ANSWER:\s*(\d*\.?\d+)`)
+	re := regexp.MustCompile(`ANSWER:\s*(-?\d*\.?\d+)`)
 	matches := re.FindStringSubmatch(response)
 
 	if len(matches) < 2 {
@@ -169,6 +424,58 @@ func parseDonationResponse(response string) (float64, error) {
 	return donation, nil
 }
 
+// donationJSON is the structured donation response requested in JSON mode
+type donationJSON struct {
+	Reasoning string   `json:"reasoning"`
+	Donation  *float64 `json:"donation"`
+	Punish    float64  `json:"punish"`
+}
+
+// punishmentPattern matches the PUNISH line a donor adds when it punishes the recipient
+var punishmentPattern = regexp.MustCompile(`PUNISH:\s*(\d*\.?\d+)`)
+
+// parsePunishment returns the units the response spends on punishment, or 0 if it doesn't punish.
+// In JSON mode the "punish" field is used if the response is a JSON object.
+func parsePunishment(response string, jsonMode bool) float64 {
+	if jsonMode {
+		var parsed donationJSON
+		if err := json.Unmarshal([]byte(strings.TrimSpace(response)), &parsed); err == nil {
+			return max(parsed.Punish, 0)
+		}
+	}
+	matches := punishmentPattern.FindStringSubmatch(response)
+	if len(matches) < 2 {
+		return 0
+	}
+	punishment, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0
+	}
+	return punishment
+}
+
+// parseDonation returns the donation amount and reasoning in response. In JSON mode the response
+// is first parsed as a donationJSON object, falling back to the ANSWER format if that fails.
+func parseDonation(response string, jsonMode bool) (float64, string, error) {
+	if jsonMode {
+		var parsed donationJSON
+		err := json.Unmarshal([]byte(strings.TrimSpace(response)), &parsed)
+		if err == nil && parsed.Donation != nil {
+			return *parsed.Donation, strings.TrimSpace(parsed.Reasoning), nil
+		}
+	}
+	amount, err := parseDonationResponse(response)
+	return amount, extractReasoning(response), err
+}
+
+// Helper function to extract the reasoning that precedes the answer in a response
+func extractReasoning(response string) string {
+	if i := strings.Index(response, "ANSWER:"); i >= 0 {
+		response = response[:i]
+	}
+	return strings.TrimSpace(response)
+}
+
 // Helper function to extract strategy from response
 func extractStrategy(response string) string {
 	lines := strings.Split(response, "\n")
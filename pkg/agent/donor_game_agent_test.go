@@ -0,0 +1,382 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/boristopalov/petri/pkg/providers"
+)
+
+// scriptedClient implements Client and returns its responses in order, repeating the last one
+type scriptedClient struct {
+	responses []string
+	prompts   []string
+	sampling  providers.Sampling // sampling parameters of the last call
+	format    string             // response format of the last call
+	history   []string           // history sent with the last call
+	system    string             // system prompt of the last call
+}
+
+func (c *scriptedClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	c.prompts = append(c.prompts, prompt)
+	c.sampling = providers.SamplingFromContext(ctx)
+	c.format = providers.ResponseFormatFromContext(ctx)
+	c.history = history
+	c.system = systemPrompt
+	response := c.responses[0]
+	if len(c.responses) > 1 {
+		c.responses = c.responses[1:]
+	}
+	return response, nil
+}
+
+func TestDonorGameAgent(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test full response is only logged at debug level", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+		client := &scriptedClient{responses: []string{"I will be generous this time. ANSWER: 4"}}
+
+		a, err := NewDonorGameAgent(ctx, "agent1", "donate half", WithProvider(client), WithLogger(logger))
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+
+		amount, err := a.MakeDonationDecision(ctx, 1, 1, "agent2", 10, "", 10)
+		if err != nil {
+			t.Fatalf("Failed to make donation decision: %v", err)
+		}
+		if amount != 4 {
+			t.Errorf("amount = %.2f, want 4.00", amount)
+		}
+
+		logs := buf.String()
+		if strings.Contains(logs, "I will be generous") {
+			t.Errorf("Full response logged at info level: %s", logs)
+		}
+		if !strings.Contains(logs, "agent=agent1") || !strings.Contains(logs, "amount=4") {
+			t.Errorf("Expected donation summary in logs, got: %s", logs)
+		}
+	})
+
+	t.Run("test default prompt templates are valid", func(t *testing.T) {
+		if err := ValidatePromptTemplate("strategy prompt", STRATEGY_PROMPT_TEMPLATE, strategyTemplateVerbs); err != nil {
+			t.Errorf("Default strategy template invalid: %v", err)
+		}
+		if err := ValidatePromptTemplate("donation prompt", DONATION_PROMPT_TEMPLATE, donationTemplateVerbs); err != nil {
+			t.Errorf("Default donation template invalid: %v", err)
+		}
+	})
+
+	t.Run("test template with a missing verb is rejected", func(t *testing.T) {
+		template := "Your name is %s. Instructions follow."
+		_, err := NewDonorGameAgent(ctx, "agent1", "", WithProvider(&scriptedClient{responses: []string{""}}),
+			WithStrategyPromptTemplate(template))
+		if err == nil {
+			t.Fatal("Expected an error for a template missing a verb")
+		}
+		want := "strategy prompt template: expected 2 format verbs (%s %s) but found 1 (%s)"
+		if err.Error() != want {
+			t.Errorf("error = %q, want %q", err.Error(), want)
+		}
+	})
+
+	t.Run("test template with a mismatched verb is rejected", func(t *testing.T) {
+		template := "Your name is %s. It is round %s."
+		err := ValidatePromptTemplate("custom", template, "sd")
+		if err == nil || !strings.Contains(err.Error(), "format verb 2 is %s but its argument is an integer") {
+			t.Errorf("Expected a verb type error, got %v", err)
+		}
+	})
+
+	t.Run("test star widths and precisions take their own arguments", func(t *testing.T) {
+		if err := ValidatePromptTemplate("custom", "%s has %*.*f resources", "sddf"); err != nil {
+			t.Errorf("Expected star width and precision to be accepted, got %v", err)
+		}
+		err := ValidatePromptTemplate("custom", "%s has %*f resources", "sf")
+		if err == nil || !strings.Contains(err.Error(), "expected 2 format verbs (%s %f) but found 3 (%s %* %f)") {
+			t.Errorf("Expected the star width to count as a verb, got %v", err)
+		}
+	})
+
+	t.Run("test recorded call contains the full conversation", func(t *testing.T) {
+		var buf bytes.Buffer
+		client := providers.NewRecordingClient(&scriptedClient{responses: []string{"ANSWER: 2"}}, &buf)
+		a, err := NewDonorGameAgent(ctx, "agent1", "donate half", WithProvider(client))
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+		memories := []string{"Round: I donated 50% to agent2", "Round: I received 4 from agent3"}
+		for _, m := range memories {
+			a.GetMemory().Store(m)
+		}
+
+		if _, err := a.MakeDonationDecision(ctx, 1, 2, "agent2", 10, "", 10); err != nil {
+			t.Fatalf("Failed to make donation decision: %v", err)
+		}
+
+		var record providers.CallRecord
+		if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+			t.Fatalf("Failed to decode call record: %v", err)
+		}
+		if len(record.Messages) != 4 {
+			t.Fatalf("Expected 4 messages, got %d: %+v", len(record.Messages), record.Messages)
+		}
+		if record.Messages[0].Role != "system" || record.Messages[0].Content != SYSTEM_PROMPT {
+			t.Errorf("First message should be the system prompt, got %+v", record.Messages[0])
+		}
+		for i, m := range memories {
+			if got := record.Messages[i+1]; got.Role != "assistant" || got.Content != m {
+				t.Errorf("Message %d = %+v, want assistant turn %q", i+1, got, m)
+			}
+		}
+		last := record.Messages[3]
+		if last.Role != "user" || !strings.Contains(last.Content, "How many units do you give up?") {
+			t.Errorf("Last message should be the donation prompt, got %+v", last)
+		}
+		if record.Response != "ANSWER: 2" {
+			t.Errorf("record.Response = %q, want %q", record.Response, "ANSWER: 2")
+		}
+	})
+
+	t.Run("test decision record captures an over-balance donation", func(t *testing.T) {
+		response := "They have been generous, so I will give everything. ANSWER: 15"
+		a, err := NewDonorGameAgent(ctx, "agent1", "donate half", WithProvider(&scriptedClient{responses: []string{response}}))
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+
+		decision, err := a.DecideDonation(ctx, 1, 1, "agent2", 10, "", 10)
+		if err != nil {
+			t.Fatalf("Failed to decide donation: %v", err)
+		}
+		if decision.RawResponse != response {
+			t.Errorf("RawResponse = %q, want %q", decision.RawResponse, response)
+		}
+		if decision.Reasoning != "They have been generous, so I will give everything." {
+			t.Errorf("Reasoning = %q", decision.Reasoning)
+		}
+		if !decision.Parsed || !decision.Clamped {
+			t.Errorf("decision = %+v, want Parsed and Clamped", decision)
+		}
+		if decision.Amount != 10 {
+			t.Errorf("Amount = %.2f, want 10.00", decision.Amount)
+		}
+	})
+
+	t.Run("test donation amounts are validated", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			response       string
+			donorResources float64
+			wantAmount     float64
+			wantClamped    bool
+		}{
+			{"negative donation", "ANSWER: -3", 10, 0, true},
+			{"zero donation", "ANSWER: 0", 10, 0, false},
+			{"donor with zero balance", "ANSWER: 4", 0, 0, true},
+		}
+		for _, tt := range tests {
+			a, err := NewDonorGameAgent(ctx, "agent1", "donate half", WithProvider(&scriptedClient{responses: []string{tt.response}}))
+			if err != nil {
+				t.Fatalf("Failed to create agent: %v", err)
+			}
+			decision, err := a.DecideDonation(ctx, 1, 1, "agent2", 10, "", tt.donorResources)
+			if err != nil {
+				t.Fatalf("%s: Failed to decide donation: %v", tt.name, err)
+			}
+			if decision.Amount != tt.wantAmount || decision.Clamped != tt.wantClamped {
+				t.Errorf("%s: Amount, Clamped = %.2f, %v, want %.2f, %v", tt.name, decision.Amount, decision.Clamped, tt.wantAmount, tt.wantClamped)
+			}
+		}
+	})
+
+	t.Run("test temperature from the model config is sent with each call", func(t *testing.T) {
+		client := &scriptedClient{responses: []string{"ANSWER: 1"}}
+		a, err := NewDonorGameAgent(ctx, "agent1", "donate half",
+			WithProvider(client),
+			WithModel(ModelInfo{Id: "gpt-4o-mini", Config: map[string]any{"temperature": 0.2}}),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+
+		if _, err := a.DecideDonation(ctx, 1, 1, "agent2", 10, "", 10); err != nil {
+			t.Fatalf("Failed to decide donation: %v", err)
+		}
+		if client.sampling.Temperature == nil || *client.sampling.Temperature != 0.2 {
+			t.Errorf("Temperature = %v, want 0.2", client.sampling.Temperature)
+		}
+		if client.sampling.TopP != nil {
+			t.Errorf("TopP = %v, want unset", *client.sampling.TopP)
+		}
+	})
+
+	t.Run("test json donations are parsed with a fallback to the answer format", func(t *testing.T) {
+		client := &scriptedClient{responses: []string{
+			`{"reasoning": "They donated last round.", "donation": 3.5}`,
+			"I will give two units. ANSWER: 2",
+		}}
+		a, err := NewDonorGameAgent(ctx, "agent1", "donate half",
+			WithProvider(client),
+			WithModel(ModelInfo{Id: "gpt-4o-mini", Config: map[string]any{"response_format": "json"}}),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+
+		decision, err := a.DecideDonation(ctx, 1, 1, "agent2", 10, "", 10)
+		if err != nil {
+			t.Fatalf("Failed to decide donation: %v", err)
+		}
+		if decision.Amount != 3.5 || decision.Reasoning != "They donated last round." {
+			t.Errorf("decision = %+v, want 3.5 with the JSON reasoning", decision)
+		}
+		if client.format != providers.ResponseFormatJSON {
+			t.Errorf("response format = %q, want %q", client.format, providers.ResponseFormatJSON)
+		}
+		if !strings.Contains(client.prompts[0], `"donation"`) {
+			t.Errorf("Expected JSON instructions in prompt:\n%s", client.prompts[0])
+		}
+
+		decision, err = a.DecideDonation(ctx, 1, 2, "agent2", 10, "", 6.5)
+		if err != nil {
+			t.Fatalf("Failed to decide donation: %v", err)
+		}
+		if decision.Amount != 2 || decision.Reasoning != "I will give two units." {
+			t.Errorf("decision = %+v, want 2 parsed from the ANSWER line", decision)
+		}
+	})
+
+	t.Run("test donation is only given up after the retry fails", func(t *testing.T) {
+		client := &scriptedClient{responses: []string{"I'll give a few units.", "Still thinking about it."}}
+		a, err := NewDonorGameAgent(ctx, "agent1", "donate half", WithProvider(client))
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+
+		decision, err := a.DecideDonation(ctx, 1, 1, "agent2", 10, "", 10)
+		if err == nil || decision.Parsed {
+			t.Fatalf("DecideDonation() = %+v, %v, want an unparsed decision and an error", decision, err)
+		}
+		if len(client.prompts) != 2 || !strings.Contains(client.prompts[1], "ANSWER: 5") {
+			t.Errorf("Expected one retry asking for the ANSWER format, got %q", client.prompts)
+		}
+		if decision.RawResponse != "Still thinking about it." {
+			t.Errorf("RawResponse = %q, want the retry's response", decision.RawResponse)
+		}
+
+		client = &scriptedClient{responses: []string{"I'll give a few units."}}
+		noRetry, err := NewDonorGameAgent(ctx, "agent3", "donate half", WithProvider(client), WithParseRetries(0))
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+		if _, err := noRetry.DecideDonation(ctx, 1, 1, "agent2", 10, "", 10); err == nil || len(client.prompts) != 1 {
+			t.Errorf("DecideDonation() error = %v after %d prompts, want an error without retrying", err, len(client.prompts))
+		}
+	})
+
+	t.Run("test punishment is offered and parsed from the response", func(t *testing.T) {
+		client := &scriptedClient{responses: []string{
+			"They kept everything last round. ANSWER: 2\nPUNISH: 3",
+			"They kept everything again. ANSWER: 4\nPUNISH: 9",
+		}}
+		a, err := NewDonorGameAgent(ctx, "agent1", "donate half", WithProvider(client), WithPunishment())
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+
+		decision, err := a.DecideDonation(ctx, 1, 1, "agent2", 10, "", 10)
+		if err != nil {
+			t.Fatalf("Failed to decide donation: %v", err)
+		}
+		if decision.Amount != 2 || decision.Punishment != 3 {
+			t.Errorf("decision = %+v, want a donation of 2 and punishment of 3", decision)
+		}
+		if !strings.Contains(client.prompts[0], PUNISHMENT_PROMPT) {
+			t.Errorf("Expected the punishment prompt in:\n%s", client.prompts[0])
+		}
+
+		// Punishment can't cost more than is left after the donation
+		decision, err = a.DecideDonation(ctx, 1, 2, "agent2", 10, "", 10)
+		if err != nil {
+			t.Fatalf("Failed to decide donation: %v", err)
+		}
+		if decision.Punishment != 6 {
+			t.Errorf("Punishment = %.2f, want it clamped to 6.00", decision.Punishment)
+		}
+
+		plain, err := NewDonorGameAgent(ctx, "agent3", "donate half", WithProvider(&scriptedClient{responses: []string{"ANSWER: 2\nPUNISH: 3"}}))
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+		if decision, err := plain.DecideDonation(ctx, 1, 1, "agent2", 10, "", 10); err != nil || decision.Punishment != 0 {
+			t.Errorf("decision = %+v, %v, want no punishment from an agent that wasn't offered it", decision, err)
+		}
+	})
+
+	t.Run("test donation prompt is sent the memories retrieved for the recipient", func(t *testing.T) {
+		client := &scriptedClient{responses: []string{"ANSWER: 2"}}
+		a, err := NewDonorGameAgent(ctx, "agent1", "donate half", WithProvider(client), WithMemoryRetrieval(mentionEmbedder{}, 1))
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+		for _, entry := range []string{"Round 1: agent2 donated 5 to me", "Round 2: I donated 3 to agent3"} {
+			if err := a.GetMemory().Store(entry); err != nil {
+				t.Fatalf("Failed to store memory: %v", err)
+			}
+		}
+
+		if _, err := a.DecideDonation(ctx, 1, 3, "agent2", 10, "", 10); err != nil {
+			t.Fatalf("Failed to decide donation: %v", err)
+		}
+		if len(client.history) != 1 || client.history[0] != "Round 1: agent2 donated 5 to me" {
+			t.Errorf("history = %q, want only the memory about agent2", client.history)
+		}
+	})
+
+	t.Run("test donation history is kept until the agent is reset", func(t *testing.T) {
+		a, err := NewDonorGameAgent(ctx, "agent1", "donate half", WithProvider(&scriptedClient{responses: []string{"ANSWER: 1"}}))
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+		a.RecordDonation(DonationRecord{Round: 0, PartnerID: "agent2", Amount: 3, Pct: 0.3, AsDonor: true})
+		a.RecordDonation(DonationRecord{Round: 1, PartnerID: "agent3", Amount: 2, Pct: 0.25})
+
+		history := a.GetDonationHistory()
+		if len(history) != 2 || history[0].PartnerID != "agent2" || history[1].AsDonor {
+			t.Fatalf("donation history = %+v, want both records in order", history)
+		}
+		history[0].Amount = 100
+		if a.GetDonationHistory()[0].Amount != 3 {
+			t.Error("Expected the returned history to be a copy")
+		}
+
+		a.Reset("agent4", "donate nothing")
+		if n := len(a.GetDonationHistory()); n != 0 {
+			t.Errorf("got %d donation records after reset, want 0", n)
+		}
+	})
+}
+
+// mentionEmbedder embeds a text as a vector with one dimension per agent it mentions, agent1 to agent3
+type mentionEmbedder struct{}
+
+func (mentionEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = make([]float32, 3)
+		for j := range vectors[i] {
+			if strings.Contains(text, fmt.Sprintf("agent%d", j+1)) {
+				vectors[i][j] = 1
+			}
+		}
+	}
+	return vectors, nil
+}
@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/boristopalov/petri/pkg/messaging"
+	"github.com/boristopalov/petri/pkg/providers"
+)
+
+const (
+	GOSSIP_PROMPT_TEMPLATE = `Your name is %s. You were just paired with %s in the donor game. The other players can't see what happened, but they will read what you say. Write one short sentence for them about %s's reputation, based on how they behaved. Respond with only that sentence.`
+
+	// GOSSIP_ENTRY_PREFIX starts every gossip entry an agent has heard
+	GOSSIP_ENTRY_PREFIX = "Gossip: "
+	// GOSSIP_ENTRY_TEMPLATE formats a gossip entry from the sender, subject and note
+	GOSSIP_ENTRY_TEMPLATE = GOSSIP_ENTRY_PREFIX + "%s said about %s: %s"
+
+	// MAX_GOSSIP is how many gossip entries an agent keeps; older ones are forgotten first
+	MAX_GOSSIP = 50
+)
+
+// ErrGossipUndelivered is returned by ShareGossip when a note was generated but didn't reach
+// every player, e.g. because an inbox was full
+var ErrGossipUndelivered = errors.New("gossip not delivered")
+
+// GossipNote is the content of a gossip message: a reputation note about a player
+type GossipNote struct {
+	About string
+	Note  string
+}
+
+// Gossip asks the model for a one-sentence reputation note about the agent's last partner
+func (a *DonorGameAgent) Gossip(ctx context.Context, partnerID string) (string, error) {
+	prompt := fmt.Sprintf(GOSSIP_PROMPT_TEMPLATE, a.id, partnerID, partnerID)
+	ctx = providers.WithSampling(ctx, a.model.Sampling())
+	response, err := a.client.Complete(ctx, a.model.Id, prompt, SYSTEM_PROMPT, a.memory.GetAllMessages())
+	if err != nil {
+		return "", fmt.Errorf("failed to generate gossip: %w", err)
+	}
+	a.logger.Debug("gossip response", "agent", a.id, "partner", partnerID, "response", response)
+
+	// Keep only the first line, so a verbose model can't flood the other players' memories
+	note, _, _ := strings.Cut(strings.TrimSpace(response), "\n")
+	note = strings.TrimSpace(note)
+	if note == "" {
+		return "", fmt.Errorf("empty gossip about %s", partnerID)
+	}
+	return note, nil
+}
+
+// ShareGossip generates a note about partnerID with Gossip and broadcasts it to the other
+// agents subscribed to the agent's message broker
+func (a *DonorGameAgent) ShareGossip(ctx context.Context, partnerID string) error {
+	if a.messageBroker == nil {
+		return errors.New("agent has no message broker to gossip through")
+	}
+	note, err := a.Gossip(ctx, partnerID)
+	if err != nil {
+		return err
+	}
+	err = a.messageBroker.Publish(messaging.Message{
+		From:      a.id,
+		Content:   GossipNote{About: partnerID, Note: note},
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("%w about %s: %w", ErrGossipUndelivered, partnerID, err)
+	}
+	return nil
+}
+
+// CollectGossip keeps the gossip the agent has received since it last collected and returns how
+// many notes there were. Other messages are discarded. Gossip is kept apart from the agent's
+// memory, so it never displaces the agent's own interactions, and only the newest MAX_GOSSIP
+// entries are kept.
+func (a *DonorGameAgent) CollectGossip() int {
+	a.gossipMu.Lock()
+	defer a.gossipMu.Unlock()
+	collected := 0
+	for {
+		select {
+		case msg := <-a.messageChan:
+			gossip, ok := msg.Content.(GossipNote)
+			if !ok {
+				continue
+			}
+			a.gossip = append(a.gossip, fmt.Sprintf(GOSSIP_ENTRY_TEMPLATE, msg.From, gossip.About, gossip.Note))
+			collected++
+		default:
+			if len(a.gossip) > MAX_GOSSIP {
+				a.gossip = slices.Delete(a.gossip, 0, len(a.gossip)-MAX_GOSSIP)
+			}
+			return collected
+		}
+	}
+}
+
+// HeardGossip returns the gossip entries the agent has kept, oldest first
+func (a *DonorGameAgent) HeardGossip() []string {
+	a.gossipMu.Lock()
+	defer a.gossipMu.Unlock()
+	return slices.Clone(a.gossip)
+}
+
+// HeardGossipAbout returns the gossip entries the agent has kept about agentID, oldest first
+func (a *DonorGameAgent) HeardGossipAbout(agentID string) []string {
+	var heard []string
+	for _, entry := range a.HeardGossip() {
+		if strings.Contains(entry, " said about "+agentID+": ") {
+			heard = append(heard, entry)
+		}
+	}
+	return heard
+}
+
+// InboxCapacity returns how many messages the agent can receive before collecting them
+func (a *DonorGameAgent) InboxCapacity() int {
+	return cap(a.messageChan)
+}
@@ -2,8 +2,11 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
 	"strings"
 	"time"
@@ -27,15 +30,43 @@ type ModelInfo struct {
 	Config map[string]any // model-specific configuration
 }
 
+// Sampling returns the sampling parameters set in the model's Config under "temperature" and "top_p"
+func (m ModelInfo) Sampling() providers.Sampling {
+	return providers.Sampling{
+		Temperature: configFloat(m.Config, "temperature"),
+		TopP:        configFloat(m.Config, "top_p"),
+	}
+}
+
+// configFloat returns config[key] as a float64, or nil if it's missing or not a number
+func configFloat(config map[string]any, key string) *float64 {
+	var f float64
+	switch v := config[key].(type) {
+	case float64:
+		f = v
+	case float32:
+		f = float64(v)
+	case int:
+		f = float64(v)
+	default:
+		return nil
+	}
+	return &f
+}
+
 type LLMAgent struct {
 	id            string
 	model         ModelInfo
 	task          string
+	systemPrompt  string // replaces the default system prompt built from the task; empty means the default
+	persona       string // describes the character the agent plays; empty means none
 	client        Client
 	memory        *memory.Memory
 	config        map[string]any
 	messageChan   chan messaging.Message
 	messageBroker messaging.Broker
+	streamOutput  io.Writer // where responses are printed as they are generated; nil disables streaming
+	tools         []providers.Tool
 }
 
 type Client interface {
@@ -50,6 +81,32 @@ type AgentParams struct {
 	MessageBroker messaging.Broker
 	Task          string
 	Client        Client
+	// SystemPrompt and Persona shape an LLM agent's system prompt
+	SystemPrompt string
+	Persona      string
+	Logger       *slog.Logger
+	// Prompt templates used by donor game agents; empty means the defaults
+	StrategyPromptTemplate string
+	DonationPromptTemplate string
+	// ParseRetries is how many times a donor game agent is re-prompted for an unparseable donation
+	ParseRetries int
+	// Punishment offers donor game agents the option to punish the recipient
+	Punishment bool
+	// StreamOutput receives an LLM agent's responses as they are generated
+	StreamOutput io.Writer
+	// Embedder and RetrievedMemories make donor game agents prompt with the memories most
+	// relevant to the recipient instead of all of them
+	Embedder          providers.Embedder
+	RetrievedMemories int
+	// SummarizeMemory makes donor game agents summarize old memories instead of dropping them
+	SummarizeMemory bool
+	// MaxRetries and RetryDelay control how an LLM agent retries a failed completion
+	MaxRetries int
+	RetryDelay time.Duration
+	// Tools are the tools an LLM agent's model can call
+	Tools []providers.Tool
+	// UniqueID makes an LLM agent whose ID is taken on its broker pick a free one
+	UniqueID bool
 }
 
 type AgentOption func(*AgentParams)
@@ -90,18 +147,128 @@ func WithTask(task string) AgentOption {
 	}
 }
 
+// WithSystemPrompt replaces the system prompt an LLM agent sends with every turn, which by
+// default introduces the agent and its task
+func WithSystemPrompt(prompt string) AgentOption {
+	return func(p *AgentParams) {
+		p.SystemPrompt = prompt
+	}
+}
+
+// WithPersona gives an LLM agent a character to play, such as "an optimist who thinks AI will
+// do more good than harm". It is added to the agent's system prompt.
+func WithPersona(persona string) AgentOption {
+	return func(p *AgentParams) {
+		p.Persona = persona
+	}
+}
+
+// WithMaxRetries sets how many times an LLM agent retries a completion that fails with a rate
+// limit or server error before its turn is given up on. The default is 2.
+func WithMaxRetries(n int) AgentOption {
+	return func(p *AgentParams) {
+		p.MaxRetries = n
+	}
+}
+
+// WithRetryDelay sets how long an LLM agent waits before retrying a failed completion, which
+// doubles on every further retry. The default is 1 second.
+func WithRetryDelay(d time.Duration) AgentOption {
+	return func(p *AgentParams) {
+		p.RetryDelay = d
+	}
+}
+
+// WithTools lets an LLM agent's model call tools while generating its turns. The agent's provider
+// must support tools; OpenAI does.
+func WithTools(tools ...providers.Tool) AgentOption {
+	return func(p *AgentParams) {
+		p.Tools = append(p.Tools, tools...)
+	}
+}
+
+// WithUniqueID makes NewLLMAgent recover when another agent on its broker already has its ID, by
+// adding the first free suffix -2, -3, ... to the ID instead of failing
+func WithUniqueID() AgentOption {
+	return func(p *AgentParams) {
+		p.UniqueID = true
+	}
+}
+
+// WithStreamOutput makes an LLM agent print its responses to w as they are generated, if its
+// provider supports streaming. Responses of agents running concurrently may interleave.
+func WithStreamOutput(w io.Writer) AgentOption {
+	return func(p *AgentParams) {
+		p.StreamOutput = w
+	}
+}
+
 func WithProvider(c Client) AgentOption {
 	return func(p *AgentParams) {
 		p.Client = c
 	}
 }
 
-func defaultOpenAiAgentParams(ctx context.Context) (*AgentParams, error) {
-	_client, err := providers.OpenAi(ctx)
-	if err != nil {
-		return nil, err
+// WithLogger sets the logger used for the agent's decision logs
+func WithLogger(l *slog.Logger) AgentOption {
+	return func(p *AgentParams) {
+		p.Logger = l
+	}
+}
+
+// WithStrategyPromptTemplate overrides the donor game strategy prompt template.
+// It must take the agent name and instructions as %s verbs.
+func WithStrategyPromptTemplate(template string) AgentOption {
+	return func(p *AgentParams) {
+		p.StrategyPromptTemplate = template
 	}
+}
+
+// WithDonationPromptTemplate overrides the donor game donation prompt template.
+// It must take the same format verbs as DONATION_PROMPT_TEMPLATE.
+func WithDonationPromptTemplate(template string) AgentOption {
+	return func(p *AgentParams) {
+		p.DonationPromptTemplate = template
+	}
+}
+
+// WithParseRetries sets how many times a donor game agent is re-prompted when its donation
+// answer can't be parsed before the donation is given up on
+func WithParseRetries(n int) AgentOption {
+	return func(p *AgentParams) {
+		p.ParseRetries = n
+	}
+}
+
+// WithPunishment offers a donor game agent the option to punish the recipient in its donation
+// prompt, by answering with a PUNISH line as well as its donation
+func WithPunishment() AgentOption {
+	return func(p *AgentParams) {
+		p.Punishment = true
+	}
+}
 
+// WithMemoryRetrieval makes a donor game agent send only the k memories most similar to the
+// recipient with its donation prompts, found by embedding its memories with embedder
+func WithMemoryRetrieval(embedder providers.Embedder, k int) AgentOption {
+	return func(p *AgentParams) {
+		p.Embedder = embedder
+		p.RetrievedMemories = k
+	}
+}
+
+// WithMemorySummarization makes a donor game agent's model summarize its oldest memories into a
+// single entry when its memory is full, instead of dropping them
+func WithMemorySummarization() AgentOption {
+	return func(p *AgentParams) {
+		p.SummarizeMemory = true
+	}
+}
+
+// defaultOpenAiAgentParams returns the default agent parameters. The OpenAI client is only
+// created by withDefaultClient once options have been applied, so agents given another
+// provider don't need an OpenAI API key.
+func defaultOpenAiAgentParams(ctx context.Context) (*AgentParams, error) {
 	return &AgentParams{
 		APIBaseUrl: "https://api.openai.com/v1/",
 		APIKey:     os.Getenv("OPENAI_API_KEY"),
@@ -109,11 +276,27 @@ func defaultOpenAiAgentParams(ctx context.Context) (*AgentParams, error) {
 			Id:     "gpt-4o-mini",
 			Config: make(map[string]any),
 		},
-		AgentID: "agent-" + uuid.New().String(),
-		Client:  _client,
+		AgentID:      "agent-" + uuid.New().String(),
+		Logger:       slog.Default(),
+		ParseRetries: 1,
+		MaxRetries:   2,
+		RetryDelay:   time.Second,
 	}, nil
 }
 
+// withDefaultClient sets params' client to an OpenAI client if no provider was given
+func withDefaultClient(ctx context.Context, params *AgentParams) error {
+	if params.Client != nil {
+		return nil
+	}
+	client, err := providers.OpenAi(ctx)
+	if err != nil {
+		return err
+	}
+	params.Client = client
+	return nil
+}
+
 // NewLLMAgent creates a new LLM agent
 func NewLLMAgent(ctx context.Context, opts ...AgentOption) (*LLMAgent, error) {
 	params, err := defaultOpenAiAgentParams(ctx)
@@ -124,27 +307,61 @@ func NewLLMAgent(ctx context.Context, opts ...AgentOption) (*LLMAgent, error) {
 	for _, opt := range opts {
 		opt(params)
 	}
+	if err := withDefaultClient(ctx, params); err != nil {
+		return nil, err
+	}
+
+	client := params.Client
+	if params.MaxRetries > 0 {
+		client = providers.NewRetryingClient(client,
+			providers.WithMaxRetries(params.MaxRetries), providers.WithBaseDelay(params.RetryDelay))
+	}
 
 	agent := &LLMAgent{
 		id:            params.AgentID,
 		task:          params.Task,
+		systemPrompt:  params.SystemPrompt,
+		persona:       params.Persona,
 		model:         params.Model,
-		client:        params.Client,
+		client:        client,
 		memory:        memory.NewMemory(100), // short term memory - start with capacity of 100 events
 		config:        make(map[string]any),
 		messageChan:   make(chan messaging.Message, 100), // Buffer 100 messages
 		messageBroker: params.MessageBroker,
+		streamOutput:  params.StreamOutput,
+		tools:         params.Tools,
 	}
 
-	// Subscribe to messages
-	if err := agent.messageBroker.Subscribe(agent.id, agent.messageChan); err != nil {
-		// Handle error appropriately
+	if err := agent.subscribe(params.UniqueID); err != nil {
 		return nil, err
 	}
 
 	return agent, nil
 }
 
+// subscribe subscribes the agent to its broker, if it has one. If unique is set and the agent's
+// ID is taken, the ID is suffixed with the first number that makes it free.
+func (a *LLMAgent) subscribe(unique bool) error {
+	if a.messageBroker == nil {
+		return nil
+	}
+	id := a.id
+	for n := 2; ; n++ {
+		err := a.messageBroker.Subscribe(id, a.messageChan)
+		if err == nil {
+			if id != a.id {
+				log.Printf("Agent ID %s is taken, using %s", a.id, id)
+				a.id = id
+			}
+			return nil
+		}
+		if !unique || !errors.Is(err, messaging.ErrAlreadySubscribed) {
+			return err
+		}
+		id = fmt.Sprintf("%s-%d", a.id, n)
+	}
+}
+
 func (a *LLMAgent) GetID() string {
 	return a.id
 }
@@ -157,8 +374,19 @@ func (a *LLMAgent) GetClient() Client {
 	return a.client
 }
 
+// Unsubscribe removes the agent's subscription from its message broker, if it has one
+func (a *LLMAgent) Unsubscribe() error {
+	if a.messageBroker == nil {
+		return nil
+	}
+	return a.messageBroker.Unsubscribe(a.id)
+}
+
 // Send implements messaging.Sender
 func (a *LLMAgent) Send(msg messaging.Message) error {
+	if a.messageBroker == nil {
+		return errors.New("agent has no message broker to send through")
+	}
 	msg.From = a.id
 	msg.Timestamp = time.Now()
 	log.Printf("[%s]: %s\n\n", a.id, msg.Content)
@@ -187,31 +415,39 @@ func (a *LLMAgent) StartMessageHandler(ctx context.Context) {
 	}()
 }
 
+// Run generates the agent's next turn and broadcasts it. The agent's task and persona are sent as
+// the system prompt and the messages it has received as history, in the user's turns.
 func (a *LLMAgent) Run(ctx context.Context) (string, error) {
-	// Generate a response based on memory and task
-	memories := a.memory.GetAllMessages()
-	var prompt string
-	if len(memories) == 0 {
-		prompt = fmt.Sprintf("You are %s. Your task is: %s\n\n Begin!",
-			a.id,
-			a.task)
-
-	} else {
-		prompt = fmt.Sprintf("You are %s. Your task is: %s\n\nRecent conversation history:\n%s\n\nBased on this context, generate a response:",
-			a.id,
-			a.task,
-			strings.Join(memories, "\n"))
-	}
-
-	response, err := a.client.Complete(ctx, a.model.Id, prompt, "", nil)
+	return a.RunTo(ctx, nil)
+}
+
+// RunTo generates the agent's next turn like Run but sends it only to the agents in to. With no
+// recipients the turn is broadcast.
+func (a *LLMAgent) RunTo(ctx context.Context, to []string) (string, error) {
+	systemPrompt := a.buildSystemPrompt()
+	history := a.memory.GetAllMessages()
+	prompt := "Begin!"
+	if len(history) > 0 {
+		prompt = "Based on the conversation so far, generate a response:"
+	}
+
+	// The other agents' messages, each stored with its sender's name, are the user's turns rather
+	// than the model's own
+	roles := make([]string, len(history))
+	for i := range roles {
+		roles[i] = "user"
+	}
+	ctx = providers.WithHistoryRoles(ctx, roles)
+	ctx = providers.WithSampling(ctx, a.model.Sampling())
+	response, err := a.complete(ctx, prompt, systemPrompt, history)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate response: %v", err)
+		return "", fmt.Errorf("failed to generate response: %w", err)
 	}
 
 	// Send the response through the message broker
 	err = a.Send(messaging.Message{
 		Content: response,
-		To:      []string{}, // broadcast to all
+		To:      to, // empty broadcasts to all
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to send message: %v", err)
@@ -219,3 +455,62 @@ func (a *LLMAgent) Run(ctx context.Context) (string, error) {
 
 	return response, nil
 }
+
+// buildSystemPrompt returns the agent's system prompt: the one it was given, or an introduction
+// to the agent and its task, followed by its persona if it has one
+func (a *LLMAgent) buildSystemPrompt() string {
+	prompt := a.systemPrompt
+	if prompt == "" {
+		prompt = fmt.Sprintf("You are %s. Your task is: %s", a.id, a.task)
+	}
+	if a.persona != "" {
+		prompt += fmt.Sprintf("\n\nYour persona: %s", a.persona)
+	}
+	return prompt
+}
+
+// complete generates a response to prompt, streaming it to streamOutput if streaming is enabled.
+// A client that can't stream has its whole response printed at once. An agent with tools doesn't
+// stream; its final answer is printed to streamOutput instead.
+func (a *LLMAgent) complete(ctx context.Context, prompt string, systemPrompt string, history []string) (string, error) {
+	if len(a.tools) > 0 {
+		return a.completeWithTools(ctx, prompt, systemPrompt, history)
+	}
+	if a.streamOutput == nil {
+		return a.client.Complete(ctx, a.model.Id, prompt, systemPrompt, history)
+	}
+
+	stream, err := providers.CompleteStream(ctx, a.client, a.model.Id, prompt, systemPrompt, history)
+	if err != nil {
+		return "", err
+	}
+	var response strings.Builder
+	fmt.Fprintf(a.streamOutput, "%s: ", a.id)
+	for delta := range stream.Deltas {
+		response.WriteString(delta)
+		io.WriteString(a.streamOutput, delta)
+	}
+	fmt.Fprintln(a.streamOutput)
+	if err := stream.Err(); err != nil {
+		return "", fmt.Errorf("stream ended early: %w", err)
+	}
+	if response.Len() == 0 {
+		return "", fmt.Errorf("stream ended without a response")
+	}
+	return response.String(), nil
+}
+
+// completeWithTools generates a response to prompt, letting the model call the agent's tools
+func (a *LLMAgent) completeWithTools(ctx context.Context, prompt string, systemPrompt string, history []string) (string, error) {
+	completion, err := providers.CompleteWithTools(ctx, a.client, a.model.Id, prompt, systemPrompt, history, a.tools)
+	if err != nil {
+		return "", err
+	}
+	for _, call := range completion.ToolCalls {
+		log.Printf("[%s] called %s(%s): %s", a.id, call.Name, call.Arguments, call.Result)
+	}
+	if a.streamOutput != nil {
+		fmt.Fprintf(a.streamOutput, "%s: %s\n", a.id, completion.Text)
+	}
+	return completion.Text, nil
+}
@@ -1,24 +1,26 @@
 package agent
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/boristopalov/petri/pkg/messaging"
+	"github.com/boristopalov/petri/pkg/providers"
 	"github.com/joho/godotenv"
+	"github.com/openai/openai-go"
 )
 
-// MockLLMClient implements LLMClient interface for testing
-type MockLLMClient struct{}
-
-func (m *MockLLMClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
-	return "mock response", nil
-}
-
 func init() {
 	envFilePath := filepath.Join("../../.env")
 
@@ -42,7 +44,7 @@ func TestLLMAgent(t *testing.T) {
 		WithModel(ModelInfo{Id: "gpt-4o-mini", Config: make(map[string]any)}),
 	)
 
-	agent.client = &MockLLMClient{}
+	agent.client = providers.NewMockClient(providers.WithMockResponse("mock response"))
 
 	if err != nil {
 		t.Fatalf("Failed to create agent: %v", err)
@@ -72,17 +74,18 @@ func TestLLMAgent(t *testing.T) {
 
 func TestAgentMessaging(t *testing.T) {
 	ctx := context.Background()
+	broker := messaging.NewBroker()
 	// Create two agents with mock clients
-	agent1, err := NewLLMAgent(ctx, WithAgentId("agent1"), WithModel(ModelInfo{
+	agent1, err := NewLLMAgent(ctx, WithAgentId("agent1"), WithMessageBroker(broker), WithModel(ModelInfo{
 		Id:     "mock-model",
 		Config: make(map[string]any),
 	}))
 	if err != nil {
 		t.Fatalf("Failed to create agent1: %v", err)
 	}
-	agent1.client = &MockLLMClient{} // Replace with mock client
+	agent1.client = providers.NewMockClient(providers.WithMockResponse("mock response")) // Replace with mock client
 
-	agent2, err := NewLLMAgent(ctx, WithAgentId("agent2"), WithModel(ModelInfo{
+	agent2, err := NewLLMAgent(ctx, WithAgentId("agent2"), WithMessageBroker(broker), WithModel(ModelInfo{
 		Id:     "mock-model",
 		Config: make(map[string]any),
 	}),
@@ -90,7 +93,7 @@ func TestAgentMessaging(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to create agent2: %v", err)
 	}
-	agent2.client = &MockLLMClient{} // Replace with mock client
+	agent2.client = providers.NewMockClient(providers.WithMockResponse("mock response")) // Replace with mock client
 
 	// Test direct message
 	t.Run("direct message between agents", func(t *testing.T) {
@@ -198,4 +201,374 @@ func TestAgentMessaging(t *testing.T) {
 			t.Errorf("Memory contents: %v", agent1.memory.GetAllMessages())
 		}
 	})
+
+	t.Run("test agents without a broker can't send messages", func(t *testing.T) {
+		loner, err := NewLLMAgent(ctx, WithAgentId("agent1"),
+			WithProvider(providers.NewMockClient(providers.WithMockResponse("mock response"))))
+		if err != nil {
+			t.Fatalf("Failed to create agent without a broker: %v", err)
+		}
+		if err := loner.Send(messaging.Message{Content: "Hello?"}); err == nil {
+			t.Error("Expected Send to fail without a broker")
+		}
+		if err := loner.Unsubscribe(); err != nil {
+			t.Errorf("Unsubscribe failed without a broker: %v", err)
+		}
+	})
+}
+
+func TestLLMAgentStreaming(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test streamed response is printed and broadcast", func(t *testing.T) {
+		broker := messaging.NewBroker()
+		listener := make(chan messaging.Message, 1)
+		if err := broker.Subscribe("listener", listener); err != nil {
+			t.Fatalf("Failed to subscribe listener: %v", err)
+		}
+		var out bytes.Buffer
+		a, err := NewLLMAgent(ctx,
+			WithAgentId("agent1"),
+			WithMessageBroker(broker),
+			WithProvider(providers.NewMockClient(providers.WithMockResponse("Hello there, everyone!"))),
+			WithStreamOutput(&out),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+
+		response, err := a.Run(ctx)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if response != "Hello there, everyone!" {
+			t.Errorf("response = %q, want %q", response, "Hello there, everyone!")
+		}
+		if out.String() != "agent1: Hello there, everyone!\n" {
+			t.Errorf("streamed output = %q", out.String())
+		}
+		select {
+		case msg := <-listener:
+			if msg.Content != response {
+				t.Errorf("broadcast content = %q, want %q", msg.Content, response)
+			}
+		case <-time.After(time.Second):
+			t.Error("Timeout waiting for the broadcast response")
+		}
+	})
+
+	t.Run("test response of a client that can't stream is printed whole", func(t *testing.T) {
+		var out bytes.Buffer
+		a, err := NewLLMAgent(ctx,
+			WithAgentId("agent1"),
+			WithMessageBroker(messaging.NewBroker()),
+			WithProvider(&flakyClient{}),
+			WithStreamOutput(&out),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+		if _, err := a.Run(ctx); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if out.String() != "agent1: Hello!\n" {
+			t.Errorf("output = %q", out.String())
+		}
+	})
+}
+
+// flakyClient fails its first failures calls with a server error and then responds
+type flakyClient struct {
+	failures int
+	calls    int
+}
+
+func (c *flakyClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	c.calls++
+	if c.calls <= c.failures {
+		return "", &openai.Error{
+			StatusCode: http.StatusServiceUnavailable,
+			Request:    httptest.NewRequest(http.MethodPost, "https://api.openai.com/v1/chat/completions", nil),
+			Response:   &http.Response{StatusCode: http.StatusServiceUnavailable},
+		}
+	}
+	return "Hello!", nil
+}
+
+// toolClient implements providers.ToolClient by calling every tool once before answering
+type toolClient struct {
+	flakyClient
+}
+
+func (c *toolClient) CompleteWithTools(ctx context.Context, model string, prompt string, systemPrompt string, history []string, tools []providers.Tool) (providers.Completion, error) {
+	var completion providers.Completion
+	for _, tool := range tools {
+		result, err := tool.Handler(ctx, "{}")
+		if err != nil {
+			return providers.Completion{}, err
+		}
+		completion.ToolCalls = append(completion.ToolCalls, providers.ToolCall{Name: tool.Name, Arguments: "{}", Result: result})
+	}
+	completion.Text = fmt.Sprintf("I called %d tools", len(tools))
+	return completion, nil
+}
+
+func TestLLMAgentRun(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test task is the system prompt and received messages are the history", func(t *testing.T) {
+		client := &scriptedClient{responses: []string{"Sounds good to me."}}
+		a, err := NewLLMAgent(ctx,
+			WithAgentId("agent1"),
+			WithTask("plan a picnic"),
+			WithMessageBroker(messaging.NewBroker()),
+			WithProvider(client),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+
+		if _, err := a.Run(ctx); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if client.system != "You are agent1. Your task is: plan a picnic" {
+			t.Errorf("system prompt = %q", client.system)
+		}
+		if len(client.history) != 0 || client.prompts[0] != "Begin!" {
+			t.Errorf("first turn sent prompt %q with history %v, want Begin! and no history", client.prompts[0], client.history)
+		}
+
+		memories := []string{"Message from agent2: How about Saturday?", "Message from agent3: I'll bring sandwiches."}
+		for _, m := range memories {
+			if err := a.memory.Store(m); err != nil {
+				t.Fatalf("Failed to store memory: %v", err)
+			}
+		}
+		if _, err := a.Run(ctx); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if !slices.Equal(client.history, memories) {
+			t.Errorf("history = %v, want %v", client.history, memories)
+		}
+		if prompt := client.prompts[1]; strings.Contains(prompt, "agent2") || strings.Contains(prompt, "plan a picnic") {
+			t.Errorf("prompt = %q, want only the current turn", prompt)
+		}
+	})
+
+	t.Run("test received messages reach the provider as user turns", func(t *testing.T) {
+		var request struct {
+			Messages []providers.ChatMessage `json:"messages"`
+		}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				t.Errorf("Failed to decode request: %v", err)
+			}
+			fmt.Fprint(w, `{"model":"llama3.2","message":{"role":"assistant","content":"See you there."},"done":true}`)
+		}))
+		defer server.Close()
+		client, err := providers.Ollama(ctx, providers.WithBaseURL(server.URL+"/"))
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		a, err := NewLLMAgent(ctx,
+			WithAgentId("agent1"),
+			WithTask("plan a picnic"),
+			WithModel(ModelInfo{Id: "llama3.2"}),
+			WithMessageBroker(messaging.NewBroker()),
+			WithProvider(client),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+		if err := a.memory.Store("Message from agent2: How about Saturday?"); err != nil {
+			t.Fatalf("Failed to store memory: %v", err)
+		}
+
+		if _, err := a.Run(ctx); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		want := []providers.ChatMessage{
+			{Role: "system", Content: "You are agent1. Your task is: plan a picnic"},
+			{Role: "user", Content: "Message from agent2: How about Saturday?"},
+			{Role: "user", Content: "Based on the conversation so far, generate a response:"},
+		}
+		if !slices.Equal(request.Messages, want) {
+			t.Errorf("messages = %+v, want %+v", request.Messages, want)
+		}
+	})
+
+	t.Run("test persona and custom system prompt", func(t *testing.T) {
+		client := &scriptedClient{responses: []string{"AI will cure diseases!"}}
+		optimist, err := NewLLMAgent(ctx,
+			WithAgentId("optimist"),
+			WithTask("debate AI"),
+			WithPersona("an optimist about AI"),
+			WithMessageBroker(messaging.NewBroker()),
+			WithProvider(client),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+		if _, err := optimist.Run(ctx); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if want := "You are optimist. Your task is: debate AI\n\nYour persona: an optimist about AI"; client.system != want {
+			t.Errorf("system prompt = %q, want %q", client.system, want)
+		}
+
+		skeptic, err := NewLLMAgent(ctx,
+			WithAgentId("skeptic"),
+			WithSystemPrompt("You doubt everything."),
+			WithPersona("a skeptic about AI"),
+			WithMessageBroker(messaging.NewBroker()),
+			WithProvider(client),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+		if _, err := skeptic.Run(ctx); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if want := "You doubt everything.\n\nYour persona: a skeptic about AI"; client.system != want {
+			t.Errorf("system prompt = %q, want %q", client.system, want)
+		}
+	})
+
+	t.Run("test failed completions are retried", func(t *testing.T) {
+		client := &flakyClient{failures: 2}
+		a, err := NewLLMAgent(ctx,
+			WithMessageBroker(messaging.NewBroker()),
+			WithProvider(client),
+			WithMaxRetries(2),
+			WithRetryDelay(time.Millisecond),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+		response, err := a.Run(ctx)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if response != "Hello!" || client.calls != 3 {
+			t.Errorf("got %q after %d calls, want Hello! after 3", response, client.calls)
+		}
+	})
+
+	t.Run("test error is returned once retries run out", func(t *testing.T) {
+		client := &flakyClient{failures: 10}
+		a, err := NewLLMAgent(ctx,
+			WithMessageBroker(messaging.NewBroker()),
+			WithProvider(client),
+			WithMaxRetries(1),
+			WithRetryDelay(time.Millisecond),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+		if _, err := a.Run(ctx); err == nil || !strings.Contains(err.Error(), "Service Unavailable") {
+			t.Errorf("Run error = %v, want the completion error", err)
+		}
+		if client.calls != 2 {
+			t.Errorf("got %d calls, want 2", client.calls)
+		}
+	})
+
+	t.Run("test retries stop when the context is done", func(t *testing.T) {
+		client := &flakyClient{failures: 10}
+		a, err := NewLLMAgent(ctx,
+			WithMessageBroker(messaging.NewBroker()),
+			WithProvider(client),
+			WithMaxRetries(5),
+			WithRetryDelay(time.Hour),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		if _, err := a.Run(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Run error = %v, want the context's error", err)
+		}
+		if client.calls != 1 {
+			t.Errorf("got %d calls, want 1", client.calls)
+		}
+	})
+
+	t.Run("test agents with tools let their model call them", func(t *testing.T) {
+		called := false
+		tool := providers.Tool{
+			Name: "look",
+			Handler: func(ctx context.Context, arguments string) (string, error) {
+				called = true
+				return "nothing here", nil
+			},
+		}
+		var out bytes.Buffer
+		a, err := NewLLMAgent(ctx,
+			WithAgentId("agent1"),
+			WithMessageBroker(messaging.NewBroker()),
+			WithProvider(&toolClient{}),
+			WithTools(tool),
+			WithStreamOutput(&out),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+		response, err := a.Run(ctx)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if !called || response != "I called 1 tools" {
+			t.Errorf("got %q with the tool called = %v", response, called)
+		}
+		if out.String() != "agent1: I called 1 tools\n" {
+			t.Errorf("output = %q", out.String())
+		}
+
+		plain, err := NewLLMAgent(ctx,
+			WithMessageBroker(messaging.NewBroker()),
+			WithProvider(&flakyClient{}),
+			WithTools(tool),
+			WithMaxRetries(0),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+		if _, err := plain.Run(ctx); err == nil {
+			t.Error("Expected an error from a provider that can't call tools")
+		}
+	})
+}
+
+func TestLLMAgentUniqueID(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test duplicate ids fail without the option", func(t *testing.T) {
+		broker := messaging.NewBroker()
+		if _, err := NewLLMAgent(ctx, WithAgentId("agent1"), WithMessageBroker(broker), WithProvider(&flakyClient{})); err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+		_, err := NewLLMAgent(ctx, WithAgentId("agent1"), WithMessageBroker(broker), WithProvider(&flakyClient{}))
+		if !errors.Is(err, messaging.ErrAlreadySubscribed) {
+			t.Errorf("error = %v, want ErrAlreadySubscribed", err)
+		}
+	})
+
+	t.Run("test duplicate ids are suffixed with the option", func(t *testing.T) {
+		broker := messaging.NewBroker()
+		var ids []string
+		for range 3 {
+			a, err := NewLLMAgent(ctx, WithAgentId("agent1"), WithMessageBroker(broker), WithProvider(&flakyClient{}), WithUniqueID())
+			if err != nil {
+				t.Fatalf("Failed to create agent: %v", err)
+			}
+			ids = append(ids, a.GetID())
+		}
+		if want := []string{"agent1", "agent1-2", "agent1-3"}; !slices.Equal(ids, want) {
+			t.Errorf("ids = %v, want %v", ids, want)
+		}
+		if broker.SubscriberCount() != 3 {
+			t.Errorf("got %d subscribers, want 3", broker.SubscriberCount())
+		}
+	})
 }
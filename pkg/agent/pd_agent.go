@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/boristopalov/petri/pkg/memory"
+	"github.com/boristopalov/petri/pkg/providers"
+)
+
+// PDMove is a move in the Prisoner's Dilemma
+type PDMove string
+
+const (
+	Cooperate PDMove = "COOPERATE"
+	Defect    PDMove = "DEFECT"
+)
+
+const (
+	PD_SYSTEM_PROMPT = `You are playing an iterated Prisoner's Dilemma. In each round, you are randomly paired with another player and you both choose, without knowing the other's choice, to either COOPERATE or DEFECT. Your score for the round depends on both choices, as described in each round. Your goal is to maximize your total score after the final round.`
+
+	PD_DECISION_PROMPT_TEMPLATE = `Your name is %s. As you will recall, here is the strategy you decided to follow: "%s"
+
+It is now round %d. In this round, you have been paired with %s.
+
+%s
+
+Do you COOPERATE or DEFECT? Very briefly think step by step about how you apply your strategy in this situation and then provide your answer. Your answer should follow the string "ANSWER" like so: ANSWER: COOPERATE`
+
+	PD_RETRY_PROMPT_TEMPLATE = `Your previous response did not include your answer in the required format. Here was your response:
+
+%s
+
+Please restate your move. Your answer must follow the string "ANSWER" and be either COOPERATE or DEFECT, like so: ANSWER: DEFECT`
+
+	// PD_DEFAULT_STRATEGY is assigned when the model fails to produce a parseable strategy
+	PD_DEFAULT_STRATEGY = "to cooperate unless my opponent defected in their most recent round."
+)
+
+// PDAgent is an agent that plays the Prisoner's Dilemma
+type PDAgent struct {
+	id       string
+	strategy string
+	memory   *memory.Memory
+	client   Client
+	model    ModelInfo
+	logger   *slog.Logger
+	// parseRetries is how many times to re-prompt for an unparseable move
+	parseRetries int
+	// fallbackStrategy is whether the agent was assigned PD_DEFAULT_STRATEGY
+	fallbackStrategy bool
+}
+
+// NewPDAgent creates a new Prisoner's Dilemma agent that follows strategy
+func NewPDAgent(ctx context.Context, id string, strategy string, opts ...AgentOption) (*PDAgent, error) {
+	params, err := defaultOpenAiAgentParams(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	params.AgentID = id
+	for _, opt := range opts {
+		opt(params)
+	}
+	if err := withDefaultClient(ctx, params); err != nil {
+		return nil, err
+	}
+
+	return &PDAgent{
+		id:           params.AgentID,
+		strategy:     strategy,
+		memory:       memory.NewMemory(100),
+		client:       params.Client,
+		model:        params.Model,
+		logger:       params.Logger,
+		parseRetries: params.ParseRetries,
+	}, nil
+}
+
+// GetID returns the agent's ID
+func (a *PDAgent) GetID() string {
+	return a.id
+}
+
+// GetModel returns the model the agent runs on
+func (a *PDAgent) GetModel() ModelInfo {
+	return a.model
+}
+
+// GetMemory returns the agent's memory
+func (a *PDAgent) GetMemory() *memory.Memory {
+	return a.memory
+}
+
+// GetStrategy returns the agent's strategy
+func (a *PDAgent) GetStrategy() string {
+	return a.strategy
+}
+
+// UsedFallbackStrategy reports whether the agent was assigned PD_DEFAULT_STRATEGY because
+// no strategy could be parsed from the model's response
+func (a *PDAgent) UsedFallbackStrategy() bool {
+	return a.fallbackStrategy
+}
+
+// GenerateStrategy generates a new strategy for the agent at the start of a generation.
+// If no strategy can be parsed even after a retry, the agent is assigned PD_DEFAULT_STRATEGY.
+func (a *PDAgent) GenerateStrategy(ctx context.Context, generation int, previousGenAdvice string) error {
+	generator := strategyGenerator{id: a.id, client: a.client, model: a.model, logger: a.logger, systemPrompt: PD_SYSTEM_PROMPT}
+	strategy, err := generator.generate(ctx, generation, previousGenAdvice)
+	if err != nil {
+		return err
+	}
+	a.strategy, a.fallbackStrategy = strategy, strategy == ""
+	if a.fallbackStrategy {
+		a.logger.Warn("no strategy found in response even after retry, using default strategy", "agent", a.id)
+		a.strategy = PD_DEFAULT_STRATEGY
+	}
+	a.logger.Info("generated strategy", "agent", a.id, "strategy", a.strategy)
+	return nil
+}
+
+// Decide asks the model whether to cooperate with or defect against opponentID. situation
+// describes the payoffs and whatever the agent knows about the opponent.
+func (a *PDAgent) Decide(ctx context.Context, round int, opponentID string, situation string) (PDMove, error) {
+	prompt := fmt.Sprintf(PD_DECISION_PROMPT_TEMPLATE, a.id, a.strategy, round, opponentID, situation)
+
+	ctx = providers.WithSampling(ctx, a.model.Sampling())
+	response, err := a.client.Complete(ctx, a.model.Id, prompt, PD_SYSTEM_PROMPT, a.memory.GetAllMessages())
+	if err != nil {
+		return "", fmt.Errorf("failed to generate response: %w", err)
+	}
+	a.logger.Debug("pd response", "agent", a.id, "response", response)
+
+	move, err := parseMove(response)
+	for retry := 0; err != nil && retry < a.parseRetries; retry++ {
+		a.logger.Warn("could not parse move, retrying", "agent", a.id, "attempt", retry+1)
+		response, err = a.client.Complete(ctx, a.model.Id, fmt.Sprintf(PD_RETRY_PROMPT_TEMPLATE, response), PD_SYSTEM_PROMPT, a.memory.GetAllMessages())
+		if err != nil {
+			return "", fmt.Errorf("failed to generate response on retry: %w", err)
+		}
+		a.logger.Debug("pd retry response", "agent", a.id, "response", response)
+		move, err = parseMove(response)
+	}
+	if err != nil {
+		return "", err
+	}
+	a.logger.Info("pd decision", "agent", a.id, "opponent", opponentID, "move", move)
+	return move, nil
+}
+
+var movePattern = regexp.MustCompile(`(?i)ANSWER:\s*\**\s*(COOPERATE|DEFECT)`)
+
+// parseMove returns the move following ANSWER in response
+func parseMove(response string) (PDMove, error) {
+	matches := movePattern.FindStringSubmatch(response)
+	if len(matches) < 2 {
+		return "", fmt.Errorf("could not find move in response: %s", response)
+	}
+	return PDMove(strings.ToUpper(matches[1])), nil
+}
@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestPDAgent(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test unparseable move is retried", func(t *testing.T) {
+		client := &scriptedClient{responses: []string{"I will trust them.", "answer: **cooperate**"}}
+		a, err := NewPDAgent(ctx, "agent1", "cooperate first", WithProvider(client))
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+
+		move, err := a.Decide(ctx, 1, "agent2", "agent2 has not played yet.")
+		if err != nil {
+			t.Fatalf("Failed to decide: %v", err)
+		}
+		if move != Cooperate {
+			t.Errorf("move = %q, want %q", move, Cooperate)
+		}
+		if len(client.prompts) != 2 || !strings.Contains(client.prompts[1], "I will trust them.") {
+			t.Errorf("Expected a retry prompt quoting the unparseable response, got %q", client.prompts)
+		}
+	})
+
+	t.Run("test move is given up on after the retry fails", func(t *testing.T) {
+		a, err := NewPDAgent(ctx, "agent1", "cooperate first", WithProvider(&scriptedClient{responses: []string{"Hmm."}}))
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+		if move, err := a.Decide(ctx, 1, "agent2", ""); err == nil {
+			t.Errorf("Decide() = %q, want an error", move)
+		}
+	})
+	t.Run("test strategy falls back to the default after the retry fails", func(t *testing.T) {
+		client := &scriptedClient{responses: []string{"I'd rather not say.", "Still no."}}
+		a, err := NewPDAgent(ctx, "agent1", "", WithProvider(client))
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+		if err := a.GenerateStrategy(ctx, 2, "Agent 1_0 (score 6.00): to cooperate."); err != nil {
+			t.Fatalf("Failed to generate strategy: %v", err)
+		}
+		if a.GetStrategy() != PD_DEFAULT_STRATEGY || !a.UsedFallbackStrategy() {
+			t.Errorf("strategy = %q, want the default strategy", a.GetStrategy())
+		}
+		if len(client.prompts) != 2 || !strings.Contains(client.prompts[0], "Agent 1_0 (score 6.00): to cooperate.") {
+			t.Errorf("Expected a strategy prompt with the advice and a retry, got %q", client.prompts)
+		}
+	})
+}
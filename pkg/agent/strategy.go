@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/boristopalov/petri/pkg/providers"
+)
+
+const (
+	// GAME_STRATEGY_PROMPT_TEMPLATE asks an agent of a game other than the donor game for a strategy.
+	// It is filled with the agent's name and instructions that include the previous generation's advice.
+	GAME_STRATEGY_PROMPT_TEMPLATE = `Your name is %s.
+%s
+Before formulating your strategy, briefly think step by step about what would be a successful strategy in this game. Then describe your strategy briefly without explanation in one sentence that starts: My strategy will be.`
+
+	GAME_STRATEGY_RETRY_PROMPT_TEMPLATE = `Your previous response did not include the required format. Here was your response:
+
+%s
+
+Please reformulate your strategy so that it starts with exactly "My strategy will be".`
+)
+
+// strategyGenerator asks a model for the strategy an agent follows in a game described by systemPrompt
+type strategyGenerator struct {
+	id           string
+	client       Client
+	model        ModelInfo
+	logger       *slog.Logger
+	systemPrompt string
+}
+
+// generate returns a strategy for generation, built on the previous generation's advice after the
+// first one. It returns an empty strategy if none can be parsed even after a retry.
+func (g strategyGenerator) generate(ctx context.Context, generation int, previousGenAdvice string) (string, error) {
+	instructions := "Based on the description of the game, create a strategy that you will follow in the game."
+	if generation > 1 {
+		instructions = fmt.Sprintf("How would you approach the game?\nHere is the advice of the best-performing agents of the previous generation, along with their final scores:\n%s\nModify this advice to create your own strategy.", previousGenAdvice)
+	}
+
+	ctx = providers.WithSampling(ctx, g.model.Sampling())
+	response, err := g.client.Complete(ctx, g.model.Id, fmt.Sprintf(GAME_STRATEGY_PROMPT_TEMPLATE, g.id, instructions), g.systemPrompt, []string{})
+	if err != nil {
+		return "", fmt.Errorf("failed to generate strategy: %v", err)
+	}
+	g.logger.Debug("strategy response", "agent", g.id, "response", response)
+	if strategy := extractStrategy(response); strategy != "" {
+		return strategy, nil
+	}
+
+	response, err = g.client.Complete(ctx, g.model.Id, fmt.Sprintf(GAME_STRATEGY_RETRY_PROMPT_TEMPLATE, response), g.systemPrompt, []string{})
+	if err != nil {
+		return "", fmt.Errorf("failed to generate strategy on retry: %v", err)
+	}
+	g.logger.Debug("strategy retry response", "agent", g.id, "response", response)
+	return extractStrategy(response), nil
+}
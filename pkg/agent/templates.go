@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// The format verbs, in order, that each donor game prompt template is filled with
+const (
+	strategyTemplateVerbs = "ss"       // agent name, instructions
+	donationTemplateVerbs = "ssddsfsf" // agent name, strategy, generation, round, recipient, recipient resources, history, donor resources
+)
+
+// verbKinds maps an expected argument type to the format verbs that can print it
+var verbKinds = map[rune]string{
+	's': "sqv",
+	'd': "dv*",
+	'f': "fFeEgGv",
+}
+
+var verbNames = map[rune]string{
+	's': "a string",
+	'd': "an integer",
+	'f': "a float",
+}
+
+// ValidatePromptTemplate checks that template uses exactly the format verbs in want, in order,
+// so that filling it with fmt.Sprintf won't produce %!(MISSING) or %!(EXTRA) garbage
+func ValidatePromptTemplate(name, template, want string) error {
+	verbs, err := formatVerbs(template)
+	if err != nil {
+		return fmt.Errorf("%s template: %v", name, err)
+	}
+
+	expected := []rune(want)
+	if len(verbs) != len(expected) {
+		return fmt.Errorf("%s template: expected %d format verbs (%s) but found %d (%s)",
+			name, len(expected), describeVerbs(expected), len(verbs), describeVerbs(verbs))
+	}
+	for i, verb := range verbs {
+		if !strings.ContainsRune(verbKinds[expected[i]], verb) {
+			return fmt.Errorf("%s template: format verb %d is %%%c but its argument is %s (expected %%%c)",
+				name, i+1, verb, verbNames[expected[i]], expected[i])
+		}
+	}
+	return nil
+}
+
+// formatVerbs returns the verbs of every format directive in template, ignoring %%. Each * width
+// or precision is returned as a '*' verb before the verb it belongs to.
+func formatVerbs(template string) ([]rune, error) {
+	var verbs []rune
+	runes := []rune(template)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '%' {
+			continue
+		}
+		i++
+		// Skip flags, width and precision. A * width or precision takes an integer argument of its own.
+		for i < len(runes) && strings.ContainsRune("+-# 0", runes[i]) {
+			i++
+		}
+		i = skipWidth(runes, i, &verbs)
+		if i < len(runes) && runes[i] == '.' {
+			i = skipWidth(runes, i+1, &verbs)
+		}
+		if i >= len(runes) {
+			return nil, fmt.Errorf("template ends with an incomplete format directive")
+		}
+		switch runes[i] {
+		case '%':
+			continue
+		case '[':
+			return nil, fmt.Errorf("explicit argument indexes are not supported")
+		}
+		verbs = append(verbs, runes[i])
+	}
+	return verbs, nil
+}
+
+// skipWidth returns the index after the width or precision starting at runes[i], appending a '*'
+// verb if it is read from an argument
+func skipWidth(runes []rune, i int, verbs *[]rune) int {
+	if i < len(runes) && runes[i] == '*' {
+		*verbs = append(*verbs, '*')
+		return i + 1
+	}
+	for i < len(runes) && unicode.IsDigit(runes[i]) {
+		i++
+	}
+	return i
+}
+
+func describeVerbs(verbs []rune) string {
+	parts := make([]string, len(verbs))
+	for i, v := range verbs {
+		parts[i] = "%" + string(v)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,197 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/boristopalov/petri/pkg/memory"
+	"github.com/boristopalov/petri/pkg/providers"
+)
+
+const (
+	ULTIMATUM_SYSTEM_PROMPT = `You are playing the ultimatum game. In each round, you are randomly paired with another player. One of you is the proposer and the other is the responder. The proposer offers the responder part of a pot of resources. If the responder accepts, the responder gets the offer and the proposer keeps the rest. If the responder rejects, neither of you gets anything. Your goal is to maximize the resources you have after the final round.`
+
+	ULTIMATUM_PROPOSE_PROMPT_TEMPLATE = `Your name is %s. As you will recall, here is the strategy you decided to follow: "%s"
+
+You are the proposer. You have a pot of %.2f units to split with the responder.
+
+%s
+
+How many units do you offer the responder? Very briefly think step by step about how you apply your strategy in this situation and then provide your answer. Your answer should follow the string "ANSWER" like so: ANSWER: 4`
+
+	ULTIMATUM_RESPOND_PROMPT_TEMPLATE = `Your name is %s. As you will recall, here is the strategy you decided to follow: "%s"
+
+You are the responder. The proposer has offered you %.2f units.
+
+%s
+
+Do you ACCEPT or REJECT the offer? Very briefly think step by step about how you apply your strategy in this situation and then provide your answer. Your answer should follow the string "ANSWER" like so: ANSWER: ACCEPT`
+
+	ULTIMATUM_RETRY_PROMPT_TEMPLATE = `Your previous response did not include your answer in the required format. Here was your response:
+
+%s
+
+Please restate your answer. It must follow the string "ANSWER" like so: %s`
+
+	// ULTIMATUM_DEFAULT_STRATEGY is assigned when the model fails to produce a parseable strategy
+	ULTIMATUM_DEFAULT_STRATEGY = "to offer half of the pot and to accept any offer of at least a third of it."
+)
+
+// UltimatumAgent is an agent that plays the ultimatum game
+type UltimatumAgent struct {
+	id       string
+	strategy string
+	memory   *memory.Memory
+	client   Client
+	model    ModelInfo
+	logger   *slog.Logger
+	// parseRetries is how many times to re-prompt for an unparseable answer
+	parseRetries int
+	// fallbackStrategy is whether the agent was assigned ULTIMATUM_DEFAULT_STRATEGY
+	fallbackStrategy bool
+}
+
+// NewUltimatumAgent creates a new ultimatum game agent that follows strategy
+func NewUltimatumAgent(ctx context.Context, id string, strategy string, opts ...AgentOption) (*UltimatumAgent, error) {
+	params, err := defaultOpenAiAgentParams(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	params.AgentID = id
+	for _, opt := range opts {
+		opt(params)
+	}
+	if err := withDefaultClient(ctx, params); err != nil {
+		return nil, err
+	}
+
+	return &UltimatumAgent{
+		id:           params.AgentID,
+		strategy:     strategy,
+		memory:       memory.NewMemory(100),
+		client:       params.Client,
+		model:        params.Model,
+		logger:       params.Logger,
+		parseRetries: params.ParseRetries,
+	}, nil
+}
+
+// GetID returns the agent's ID
+func (a *UltimatumAgent) GetID() string {
+	return a.id
+}
+
+// GetModel returns the model the agent runs on
+func (a *UltimatumAgent) GetModel() ModelInfo {
+	return a.model
+}
+
+// GetMemory returns the agent's memory
+func (a *UltimatumAgent) GetMemory() *memory.Memory {
+	return a.memory
+}
+
+// GetStrategy returns the agent's strategy
+func (a *UltimatumAgent) GetStrategy() string {
+	return a.strategy
+}
+
+// UsedFallbackStrategy reports whether the agent was assigned ULTIMATUM_DEFAULT_STRATEGY because
+// no strategy could be parsed from the model's response
+func (a *UltimatumAgent) UsedFallbackStrategy() bool {
+	return a.fallbackStrategy
+}
+
+// GenerateStrategy generates a new strategy for the agent at the start of a generation.
+// If no strategy can be parsed even after a retry, the agent is assigned ULTIMATUM_DEFAULT_STRATEGY.
+func (a *UltimatumAgent) GenerateStrategy(ctx context.Context, generation int, previousGenAdvice string) error {
+	generator := strategyGenerator{id: a.id, client: a.client, model: a.model, logger: a.logger, systemPrompt: ULTIMATUM_SYSTEM_PROMPT}
+	strategy, err := generator.generate(ctx, generation, previousGenAdvice)
+	if err != nil {
+		return err
+	}
+	a.strategy, a.fallbackStrategy = strategy, strategy == ""
+	if a.fallbackStrategy {
+		a.logger.Warn("no strategy found in response even after retry, using default strategy", "agent", a.id)
+		a.strategy = ULTIMATUM_DEFAULT_STRATEGY
+	}
+	a.logger.Info("generated strategy", "agent", a.id, "strategy", a.strategy)
+	return nil
+}
+
+// Propose asks the model how much of a pot of potSize to offer the responder. history describes
+// the round and whatever the agent knows about the responder. Offers are clamped to the pot.
+func (a *UltimatumAgent) Propose(ctx context.Context, potSize float64, history string) (float64, error) {
+	prompt := fmt.Sprintf(ULTIMATUM_PROPOSE_PROMPT_TEMPLATE, a.id, a.strategy, potSize, history)
+	response, err := a.complete(ctx, prompt)
+	if err != nil {
+		return 0, err
+	}
+
+	offer, err := parseDonationResponse(response)
+	for retry := 0; err != nil && retry < a.parseRetries; retry++ {
+		a.logger.Warn("could not parse offer, retrying", "agent", a.id, "attempt", retry+1)
+		if response, err = a.complete(ctx, fmt.Sprintf(ULTIMATUM_RETRY_PROMPT_TEMPLATE, response, "ANSWER: 4")); err != nil {
+			return 0, err
+		}
+		offer, err = parseDonationResponse(response)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	offer = min(max(offer, 0), potSize)
+	a.logger.Info("ultimatum offer", "agent", a.id, "pot", potSize, "offer", offer)
+	return offer, nil
+}
+
+// Respond asks the model whether to accept an offer. history describes the round, the pot the
+// offer was split from and whatever the agent knows about the proposer.
+func (a *UltimatumAgent) Respond(ctx context.Context, offer float64, history string) (bool, error) {
+	prompt := fmt.Sprintf(ULTIMATUM_RESPOND_PROMPT_TEMPLATE, a.id, a.strategy, offer, history)
+	response, err := a.complete(ctx, prompt)
+	if err != nil {
+		return false, err
+	}
+
+	accepted, err := parseAcceptance(response)
+	for retry := 0; err != nil && retry < a.parseRetries; retry++ {
+		a.logger.Warn("could not parse response to offer, retrying", "agent", a.id, "attempt", retry+1)
+		if response, err = a.complete(ctx, fmt.Sprintf(ULTIMATUM_RETRY_PROMPT_TEMPLATE, response, "ANSWER: ACCEPT or ANSWER: REJECT")); err != nil {
+			return false, err
+		}
+		accepted, err = parseAcceptance(response)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	a.logger.Info("ultimatum response", "agent", a.id, "offer", offer, "accepted", accepted)
+	return accepted, nil
+}
+
+// complete sends prompt to the agent's model along with its memories
+func (a *UltimatumAgent) complete(ctx context.Context, prompt string) (string, error) {
+	ctx = providers.WithSampling(ctx, a.model.Sampling())
+	response, err := a.client.Complete(ctx, a.model.Id, prompt, ULTIMATUM_SYSTEM_PROMPT, a.memory.GetAllMessages())
+	if err != nil {
+		return "", fmt.Errorf("failed to generate response: %w", err)
+	}
+	a.logger.Debug("ultimatum completion", "agent", a.id, "response", response)
+	return response, nil
+}
+
+var acceptPattern = regexp.MustCompile(`(?i)ANSWER:\s*\**\s*(ACCEPT|REJECT)`)
+
+// parseAcceptance reports whether response accepts the offer
+func parseAcceptance(response string) (bool, error) {
+	matches := acceptPattern.FindStringSubmatch(response)
+	if len(matches) < 2 {
+		return false, fmt.Errorf("could not find answer in response: %s", response)
+	}
+	return strings.EqualFold(matches[1], "ACCEPT"), nil
+}
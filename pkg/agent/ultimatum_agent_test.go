@@ -0,0 +1,39 @@
+package agent
+
+import (
+	"context"
+	"testing"
+)
+
+func TestUltimatumAgent(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test offer is clamped to the pot", func(t *testing.T) {
+		a, err := NewUltimatumAgent(ctx, "agent1", "offer half", WithProvider(&scriptedClient{responses: []string{"ANSWER: 15"}}))
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+		offer, err := a.Propose(ctx, 10, "")
+		if err != nil {
+			t.Fatalf("Failed to propose: %v", err)
+		}
+		if offer != 10 {
+			t.Errorf("offer = %.2f, want it clamped to 10.00", offer)
+		}
+	})
+
+	t.Run("test unparseable response is retried", func(t *testing.T) {
+		client := &scriptedClient{responses: []string{"That seems fair.", "answer: accept"}}
+		a, err := NewUltimatumAgent(ctx, "agent1", "accept fair offers", WithProvider(client))
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+		accepted, err := a.Respond(ctx, 5, "")
+		if err != nil {
+			t.Fatalf("Failed to respond: %v", err)
+		}
+		if !accepted || len(client.prompts) != 2 {
+			t.Errorf("accepted = %v after %d prompts, want an acceptance after one retry", accepted, len(client.prompts))
+		}
+	})
+}
@@ -1,7 +1,13 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 type ExperimentConfig struct {
@@ -14,15 +20,15 @@ type ExperimentConfig struct {
 }
 
 type LogConfig struct {
-	Level   string   `yaml:"level"`
-	Path    string   `yaml:"path"`
-	Metrics []string `yaml:"metrics"`
+	Level string `yaml:"level"` // slog level name; empty keeps the --log-level flag
+	Path  string `yaml:"path"`  // file the logs are appended to; empty logs to stderr
 }
 
 type AgentConfig struct {
-	Model  string         `yaml:"model"`
-	Count  int            `yaml:"count"`
-	Config map[string]any `yaml:"config"`
+	Model   string         `yaml:"model"`
+	Count   int            `yaml:"count"`
+	Config  map[string]any `yaml:"config"`
+	Persona string         `yaml:"persona"` // character played by chat agents in the group; empty means none
 }
 
 type EnvConfig struct {
@@ -30,7 +36,57 @@ type EnvConfig struct {
 	Config map[string]any `yaml:"config"`
 }
 
+// LoadConfig reads and validates the YAML experiment config at path
 func LoadConfig(path string) (*ExperimentConfig, error) {
-	// TODO: Implement configuration loading from YAML file
-	return nil, nil
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+	var config ExperimentConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// Validate checks that the config names the experiment, has at least one agent and uses a known
+// log level
+func (c *ExperimentConfig) Validate() error {
+	var errs []error
+	if c.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+	if len(c.Agents) == 0 {
+		errs = append(errs, errors.New("at least one agent is required"))
+	}
+	for i, a := range c.Agents {
+		if a.Model == "" {
+			errs = append(errs, fmt.Errorf("agents[%d]: model is required", i))
+		}
+		if a.Count < 1 {
+			errs = append(errs, fmt.Errorf("agents[%d]: count must be at least 1, got %d", i, a.Count))
+		}
+	}
+	if c.Steps < 0 {
+		errs = append(errs, fmt.Errorf("steps must not be negative, got %d", c.Steps))
+	}
+	if c.Logging.Level != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+			errs = append(errs, fmt.Errorf("logging: invalid level %q", c.Logging.Level))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NumAgents returns the total number of agents across all agent groups
+func (c *ExperimentConfig) NumAgents() int {
+	n := 0
+	for _, a := range c.Agents {
+		n += a.Count
+	}
+	return n
 }
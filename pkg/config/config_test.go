@@ -0,0 +1,67 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("test example config is parsed", func(t *testing.T) {
+		config, err := LoadConfig("../../configs/experiments/chat_room.yaml")
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		if config.Name != "chat_room_experiment" || config.Duration != time.Hour {
+			t.Errorf("config = %+v, want chat_room_experiment lasting 1h", config)
+		}
+		if config.NumAgents() != 4 || config.Agents[1].Model != "gpt-4" || config.Agents[1].Config["temperature"] != 0.7 {
+			t.Errorf("agents = %+v, want 2 gemini-2.0-flash and 2 gpt-4 agents at temperature 0.7", config.Agents)
+		}
+		if config.Environment.Type != "chat_room" || config.Environment.Config["topic"] != "climate change" {
+			t.Errorf("environment = %+v, want a chat room about climate change", config.Environment)
+		}
+		if config.Logging.Level != "DEBUG" || config.Logging.Path != "logs/chat_room.log" {
+			t.Errorf("logging = %+v, want DEBUG logs in logs/chat_room.log", config.Logging)
+		}
+	})
+
+	t.Run("test missing required fields are reported", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "experiment.yaml")
+		if err := os.WriteFile(path, []byte("environment:\n  type: donor_game\nagents:\n  - model: gpt-4\n"), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		_, err := LoadConfig(path)
+		if err == nil {
+			t.Fatal("Expected an invalid config to be rejected")
+		}
+		for _, want := range []string{"name is required", "agents[0]: count must be at least 1"} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("err = %v, want it to mention %q", err, want)
+			}
+		}
+	})
+
+	t.Run("test unknown log levels are rejected", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "experiment.yaml")
+		data := "name: test\nagents:\n  - model: gpt-4\n    count: 2\nlogging:\n  level: loud\n"
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), `invalid level "loud"`) {
+			t.Errorf("err = %v, want an invalid level error", err)
+		}
+	})
+
+	t.Run("test malformed YAML is rejected", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "experiment.yaml")
+		if err := os.WriteFile(path, []byte("name: [unterminated\n"), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "failed to parse config file") {
+			t.Errorf("err = %v, want a parse error", err)
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/boristopalov/petri/pkg/agent"
+	"github.com/boristopalov/petri/pkg/config"
+)
+
+// Defaults used by FromConfig for settings a donor game config leaves out
+const (
+	DefaultRoundsPerGen       = 3
+	DefaultDonationMultiplier = 2.0
+	DefaultInitialBalance     = 10.0
+)
+
+// Runner is the part of Environment that doesn't depend on the agent and state types, so
+// environments of any kind can be built by FromConfig
+type Runner interface {
+	Reset() error
+	Step(ctx context.Context) error
+}
+
+// FromConfig builds the environment named by cfg.Type:
+//
+//   - "donor_game" returns a *DonorGameEnvironment. It reads rounds_per_gen, donation_multiplier,
+//     initial_balance, seed, seed_per_generation, precision, public_stats and
+//     history_token_limit from cfg.Config.
+//   - "chat" or "chat_room" returns a *BaseEnvironment[*agent.LLMAgent, BaseState].
+//
+// Settings with the wrong type are an error. Other keys are ignored, since they may configure
+// the experiment or agents around the environment.
+func FromConfig(cfg config.EnvConfig) (Runner, error) {
+	switch cfg.Type {
+	case "donor_game":
+		return donorGameFromConfig(cfg.Config)
+	case "chat", "chat_room":
+		return NewBaseEnvironment[*agent.LLMAgent, BaseState](BaseState{
+			Status:    "idle",
+			Step:      0,
+			Timestamp: time.Now(),
+		}), nil
+	case "":
+		return nil, fmt.Errorf("environment type is required")
+	default:
+		return nil, fmt.Errorf("unsupported environment type %q", cfg.Type)
+	}
+}
+
+func donorGameFromConfig(settings map[string]any) (*DonorGameEnvironment, error) {
+	roundsPerGen, err := configInt(settings, "rounds_per_gen", DefaultRoundsPerGen)
+	if err != nil {
+		return nil, err
+	}
+	donationMult, err := configFloat(settings, "donation_multiplier", DefaultDonationMultiplier)
+	if err != nil {
+		return nil, err
+	}
+	initialBalance, err := configFloat(settings, "initial_balance", DefaultInitialBalance)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := configInt(settings, "seed", 0)
+	if err != nil {
+		return nil, err
+	}
+	precision, err := configInt(settings, "precision", 2)
+	if err != nil {
+		return nil, err
+	}
+	publicStats, err := configBool(settings, "public_stats", false)
+	if err != nil {
+		return nil, err
+	}
+	historyTokens, err := configInt(settings, "history_token_limit", 0)
+	if err != nil {
+		return nil, err
+	}
+	generationSeeds, err := configBool(settings, "seed_per_generation", false)
+	if err != nil {
+		return nil, err
+	}
+
+	if roundsPerGen < 1 {
+		return nil, fmt.Errorf("rounds_per_gen must be at least 1, got %d", roundsPerGen)
+	}
+	if donationMult < 0 || initialBalance < 0 {
+		return nil, fmt.Errorf("donation_multiplier and initial_balance must not be negative, got %v and %v", donationMult, initialBalance)
+	}
+	opts := []DonorGameOption{WithSeed(int64(seed)), WithPrecision(precision)}
+	if publicStats {
+		opts = append(opts, WithPublicStats())
+	}
+	if historyTokens > 0 {
+		opts = append(opts, WithHistoryTokenLimit(historyTokens))
+	}
+	if generationSeeds {
+		opts = append(opts, WithGenerationSeeds())
+	}
+	return NewDonorGameEnvironment(roundsPerGen, donationMult, initialBalance, opts...), nil
+}
+
+// configFloat returns settings[key] as a float64, or def if it's missing
+func configFloat(settings map[string]any, key string, def float64) (float64, error) {
+	switch v := settings[key].(type) {
+	case nil:
+		return def, nil
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	default:
+		return 0, fmt.Errorf("%s must be a number, got %v (%T)", key, v, v)
+	}
+}
+
+// configInt returns settings[key] as an int, or def if it's missing. Whole floats are accepted.
+func configInt(settings map[string]any, key string, def int) (int, error) {
+	switch v := settings[key].(type) {
+	case nil:
+		return def, nil
+	case int:
+		return v, nil
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, fmt.Errorf("%s must be a whole number, got %v", key, v)
+		}
+		return int(v), nil
+	default:
+		return 0, fmt.Errorf("%s must be a whole number, got %v (%T)", key, v, v)
+	}
+}
+
+// configBool returns settings[key] as a bool, or def if it's missing
+func configBool(settings map[string]any, key string, def bool) (bool, error) {
+	switch v := settings[key].(type) {
+	case nil:
+		return def, nil
+	case bool:
+		return v, nil
+	default:
+		return false, fmt.Errorf("%s must be true or false, got %v (%T)", key, v, v)
+	}
+}
@@ -0,0 +1,72 @@
+package environment
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/boristopalov/petri/pkg/agent"
+	"github.com/boristopalov/petri/pkg/config"
+)
+
+func TestFromConfig(t *testing.T) {
+	t.Run("test donor game settings are applied", func(t *testing.T) {
+		env, err := FromConfig(config.EnvConfig{
+			Type:   "donor_game",
+			Config: map[string]any{"rounds_per_gen": 5, "donation_multiplier": 2.5, "initial_balance": 20, "seed": 7, "generations": 3},
+		})
+		if err != nil {
+			t.Fatalf("Failed to build environment: %v", err)
+		}
+		donorGame, ok := env.(*DonorGameEnvironment)
+		if !ok {
+			t.Fatalf("env = %T, want *DonorGameEnvironment", env)
+		}
+		if donorGame.GetRoundsPerGen() != 5 || donorGame.GetDonationMultiplier() != 2.5 ||
+			donorGame.GetInitialBalance() != 20 || donorGame.GetSeed() != 7 {
+			t.Errorf("env has %d rounds, multiplier %v, balance %v, seed %d, want 5, 2.5, 20, 7",
+				donorGame.GetRoundsPerGen(), donorGame.GetDonationMultiplier(), donorGame.GetInitialBalance(), donorGame.GetSeed())
+		}
+	})
+
+	t.Run("test donor game defaults", func(t *testing.T) {
+		env, err := FromConfig(config.EnvConfig{Type: "donor_game"})
+		if err != nil {
+			t.Fatalf("Failed to build environment: %v", err)
+		}
+		donorGame := env.(*DonorGameEnvironment)
+		if donorGame.GetRoundsPerGen() != DefaultRoundsPerGen || donorGame.GetDonationMultiplier() != DefaultDonationMultiplier ||
+			donorGame.GetInitialBalance() != DefaultInitialBalance {
+			t.Errorf("env has %d rounds, multiplier %v, balance %v, want the defaults",
+				donorGame.GetRoundsPerGen(), donorGame.GetDonationMultiplier(), donorGame.GetInitialBalance())
+		}
+	})
+
+	t.Run("test chat builds a base environment", func(t *testing.T) {
+		env, err := FromConfig(config.EnvConfig{Type: "chat", Config: map[string]any{"topic": "climate change"}})
+		if err != nil {
+			t.Fatalf("Failed to build environment: %v", err)
+		}
+		if _, ok := env.(*BaseEnvironment[*agent.LLMAgent, BaseState]); !ok {
+			t.Errorf("env = %T, want *BaseEnvironment[*agent.LLMAgent, BaseState]", env)
+		}
+	})
+
+	t.Run("test invalid settings are rejected", func(t *testing.T) {
+		for _, tc := range []struct {
+			cfg  config.EnvConfig
+			want string
+		}{
+			{config.EnvConfig{Type: "donor_game", Config: map[string]any{"rounds_per_gen": "five"}}, "rounds_per_gen must be a whole number"},
+			{config.EnvConfig{Type: "donor_game", Config: map[string]any{"rounds_per_gen": 2.5}}, "rounds_per_gen must be a whole number"},
+			{config.EnvConfig{Type: "donor_game", Config: map[string]any{"donation_multiplier": true}}, "donation_multiplier must be a number"},
+			{config.EnvConfig{Type: "donor_game", Config: map[string]any{"rounds_per_gen": 0}}, "rounds_per_gen must be at least 1"},
+			{config.EnvConfig{Type: "marketplace"}, `unsupported environment type "marketplace"`},
+			{config.EnvConfig{}, "environment type is required"},
+		} {
+			_, err := FromConfig(tc.cfg)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("FromConfig(%+v) error = %v, want %q", tc.cfg, err, tc.want)
+			}
+		}
+	})
+}
@@ -2,15 +2,22 @@ package environment
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"math/rand"
+	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/boristopalov/petri/pkg/agent"
+	"github.com/boristopalov/petri/pkg/memory"
 )
 
 // DonorGameState extends State with donor game specific fields
@@ -18,9 +25,40 @@ type DonorGameState struct {
 	BaseState           State
 	Round               int
 	TotalRounds         int
-	AgentResources      map[string]float64 // maps agent ID to their current resources
-	SuccessfulDonations int                // number of successful donations in this generation
-	FailedDonations     int                // number of failed donations in this generation
+	AgentResources      map[string]float64          // maps agent ID to their current resources
+	Cooperation         map[string]CooperationStats // maps agent ID to how generous they have been as a donor
+	AgentModels         map[string]string           // maps agent ID to the ID of the model it runs on
+	SuccessfulDonations int                         // number of successful donations in this generation
+	FailedDonations     int                         // number of failed donations in this generation
+	TotalPunishments    int                         // number of donors that punished their recipient in this generation
+	PunishmentSpent     float64                     // total resources donors spent on punishment in this generation
+	Intergroup          IntergroupStats             // donations within and across groups in this generation
+	FinishReasons       map[string]int              // counts why the model stopped generating each donation response
+	Byes                map[string]int              // counts the rounds each agent sat out because the population was odd
+	GossipUndelivered   int                         // number of gossip notes in this generation that didn't reach every player
+}
+
+// IntergroupStats separates donations between agents of the same group from donations across groups.
+// Donations involving an agent without a group are not counted.
+type IntergroupStats struct {
+	IntraDonations int     // donations to a recipient in the donor's group
+	IntraAmount    float64 // total amount donated within groups
+	InterDonations int     // donations to a recipient in another group
+	InterAmount    float64 // total amount donated across groups
+}
+
+// CooperationStats tracks how generous an agent has been as a donor
+type CooperationStats struct {
+	Donations     int     // number of donations the agent made
+	TotalFraction float64 // sum of the fractions of their resources donated
+}
+
+// Rate returns the average fraction of resources donated, or 0 if the agent never donated
+func (c CooperationStats) Rate() float64 {
+	if c.Donations == 0 {
+		return 0
+	}
+	return c.TotalFraction / float64(c.Donations)
 }
 
 // Implement State interface methods
@@ -38,23 +76,203 @@ func (s DonorGameState) GetTimestamp() time.Time {
 
 // DonorGameEnvironment implements the donor game mechanics
 type DonorGameEnvironment struct {
-	agents         []*agent.DonorGameAgent
-	state          DonorGameState
-	roundsPerGen   int
-	donationMult   float64 // multiplier for donations (e.g. 2x)
-	initialBalance float64
-	mu             sync.RWMutex
+	agents          []*agent.DonorGameAgent
+	state           DonorGameState
+	roundsPerGen    int
+	donationMult    float64 // multiplier for donations (e.g. 2x)
+	initialBalance  float64
+	seed            int64             // seed of rng, recorded so runs can be reproduced
+	rng             *rand.Rand        // source of randomness for pairing
+	src             *countingSource   // rng's source, which counts draws so its position can be checkpointed
+	streamSeed      int64             // seed rng was last seeded with; differs from seed with generation seeds
+	generationSeeds bool              // whether rng is reseeded from seed at the start of each generation
+	noiseRng        *rand.Rand        // source of history noise, kept apart from rng so noise doesn't shift pairings
+	noiseSrc        *countingSource   // noiseRng's source
+	publicStats     bool              // whether donors are told aggregate population statistics
+	precision       int               // decimal places used when displaying resource amounts
+	groups          map[string]string // maps agent ID to a group label for in-group/out-group studies
+	noiseProb       float64           // probability that each donation in a recipient's history is corrupted
+	noise           HistoryNoise      // how corrupted donations are shown
+	historyTokens   int               // maximum tokens of recipient history shown to donors; 0 means no limit
+	punishment      bool              // whether donors' punishments are applied
+	gossip          bool              // whether partners share reputation notes about each other after each round
+	concurrency     int               // maximum model calls made at once during a step; 0 means no limit
+	pairing         PairingStrategy   // decides who donates to whom; nil pairs agents at random
+	maxFailureRate  float64           // fraction of a round's donations that may fail before the round is abandoned; negative never abandons
+	generation      int               // generation being played, as set with SetGeneration
+	roundLogs       []RoundLog        // pairs of every round played, across generations
+	mu              sync.RWMutex
+}
+
+// DonorGameOption configures optional DonorGameEnvironment behavior
+type DonorGameOption func(*DonorGameEnvironment)
+
+// WithSeed seeds the environment's random number generator. A seed of 0 seeds it from the current time.
+func WithSeed(seed int64) DonorGameOption {
+	return func(e *DonorGameEnvironment) {
+		e.seed = seed
+	}
+}
+
+// HistoryNoise selects how a donation in a recipient's history is corrupted
+type HistoryNoise int
+
+const (
+	// NoiseMisreport flips the donated fraction, so a generous donation looks stingy and vice versa
+	NoiseMisreport HistoryNoise = iota
+	// NoiseOmit leaves the donation out of the history
+	NoiseOmit
+)
+
+// WithHistoryNoise corrupts each donation shown in a recipient's history with probability p,
+// modelling a noisy reputation. Draws come from a generator seeded from the environment's seed,
+// so noise is reproducible with WithSeed and doesn't change the pairings.
+func WithHistoryNoise(p float64, noise HistoryNoise) DonorGameOption {
+	return func(e *DonorGameEnvironment) {
+		e.noiseProb = p
+		e.noise = noise
+	}
+}
+
+// WithHistoryTokenLimit caps the recipient history shown to donors at limit tokens, dropping its
+// oldest interactions first. The most recent interaction is always shown in full.
+func WithHistoryTokenLimit(limit int) DonorGameOption {
+	return func(e *DonorGameEnvironment) {
+		e.historyTokens = limit
+	}
+}
+
+// PunishmentMultiplier is how many units a punished recipient loses for each unit the donor spends
+const PunishmentMultiplier = 2.0
+
+// WithPunishment applies the punishments donors choose: a donor spending x takes away
+// PunishmentMultiplier times x from the recipient, down to nothing. Donors are only offered
+// punishment if their agents were created with agent.WithPunishment.
+func WithPunishment() DonorGameOption {
+	return func(e *DonorGameEnvironment) {
+		e.punishment = true
+	}
+}
+
+// WithGossip has both partners of every donation broadcast a one-sentence reputation note about
+// each other after each round, through their agents' message broker. Donors are shown the gossip
+// they have heard about their recipient along with the recipient's history. Agents without a
+// message broker can't gossip.
+func WithGossip() DonorGameOption {
+	return func(e *DonorGameEnvironment) {
+		e.gossip = true
+	}
 }
 
 type donation struct {
-	donorID     string
-	recipientID string
-	amount      float64
-	err         error
+	donorID      string
+	recipientID  string
+	amount       float64
+	punishment   float64 // units the donor chose to spend punishing the recipient
+	finishReason string  // why the model stopped generating the donor's final response
+	err          error
+}
+
+// Donation is a donation applied during a step
+type Donation struct {
+	DonorID     string
+	RecipientID string
+	Amount      float64 // amount the donor gave
+	Received    float64 // amount the recipient received after the multiplier
+	Punishment  float64 // amount the donor spent punishing the recipient
+	Penalty     float64 // amount the recipient lost to the punishment
+}
+
+// StepResult summarizes what happened during one step of the donor game
+type StepResult struct {
+	Round          int                // round of the generation the step played, starting at 0
+	Donations      []Donation         // donations applied, in the order they were applied
+	ResourceDeltas map[string]float64 // change in each participating agent's resources
+	Errors         []error            // donations that failed
+	Bye            string             // first agent that sat out the step, e.g. because the population was odd; empty if none did
+}
+
+// RoundLog is the pairs a donor game round was played with, so the pairings drawn from the
+// environment's RNG can be audited after a run
+type RoundLog struct {
+	Generation int         `json:"generation"`
+	Round      int         `json:"round"`               // round of the generation, starting at 0
+	Pairs      [][2]string `json:"pairs"`               // donor and recipient IDs of each pair, in the order they were paired
+	Byes       []string    `json:"byes,omitempty"`      // agents that sat out the round
+	Abandoned  bool        `json:"abandoned,omitempty"` // the round was abandoned with ErrRoundFailed and retried or ended the run
+}
+
+// DefaultConcurrency is the default maximum number of model calls a step makes at once
+const DefaultConcurrency = 8
+
+// WithConcurrency limits the model calls a step makes at once, such as donor decisions and
+// gossip, to n, so large populations don't trip provider rate limits. 0 means no limit.
+func WithConcurrency(n int) DonorGameOption {
+	return func(e *DonorGameEnvironment) {
+		e.concurrency = n
+	}
+}
+
+// callLimiter returns a semaphore that bounds concurrent model calls to the environment's
+// concurrency, for a step that makes calls calls
+func (e *DonorGameEnvironment) callLimiter(calls int) chan struct{} {
+	if e.concurrency > 0 {
+		calls = min(calls, e.concurrency)
+	}
+	return make(chan struct{}, max(calls, 1))
+}
+
+// ErrRoundFailed is returned by Step when too many of a round's donations fail, e.g. because the
+// provider is down, and WithMaxFailureRate is set. The round isn't played: no donations are applied
+// and the round counters don't advance, so the round can be retried.
+var ErrRoundFailed = errors.New("too many donations failed")
+
+// WithMaxFailureRate abandons a round with ErrRoundFailed when more than rate of its donations
+// fail, or when every one of them does. A rate of 0 abandons a round on any failure and a negative
+// rate never abandons, which is the default: failed donations are counted and the round is played
+// with the rest.
+func WithMaxFailureRate(rate float64) DonorGameOption {
+	return func(e *DonorGameEnvironment) {
+		e.maxFailureRate = rate
+	}
+}
+
+// WithPairingStrategy sets how agents are paired up each round. The default pairs them at random.
+func WithPairingStrategy(p PairingStrategy) DonorGameOption {
+	return func(e *DonorGameEnvironment) {
+		e.pairing = p
+	}
+}
+
+// WithPublicStats tells donors aggregate population statistics (average resources, round,
+// cooperation so far) in their donation prompt
+func WithPublicStats() DonorGameOption {
+	return func(e *DonorGameEnvironment) {
+		e.publicStats = true
+	}
+}
+
+// WithPrecision sets the number of decimal places used when displaying resource amounts
+// in agent memories and statistics. The default is 2.
+func WithPrecision(decimals int) DonorGameOption {
+	return func(e *DonorGameEnvironment) {
+		e.precision = decimals
+	}
+}
+
+// WithGroups tags agents with a group label, keyed by agent ID. Donors are told the recipient's
+// group and donations are tracked as within or across groups.
+func WithGroups(groups map[string]string) DonorGameOption {
+	return func(e *DonorGameEnvironment) {
+		e.groups = make(map[string]string, len(groups))
+		for id, group := range groups {
+			e.groups[id] = group
+		}
+	}
 }
 
 // NewDonorGameEnvironment creates a new donor game environment
-func NewDonorGameEnvironment(roundsPerGen int, donationMult float64, initialBalance float64) *DonorGameEnvironment {
+func NewDonorGameEnvironment(roundsPerGen int, donationMult float64, initialBalance float64, opts ...DonorGameOption) *DonorGameEnvironment {
 	initialState := DonorGameState{
 		BaseState: BaseState{
 			Status:    "idle",
@@ -64,17 +282,32 @@ func NewDonorGameEnvironment(roundsPerGen int, donationMult float64, initialBala
 		Round:               0,
 		TotalRounds:         0,
 		AgentResources:      make(map[string]float64),
+		Cooperation:         make(map[string]CooperationStats),
+		AgentModels:         make(map[string]string),
+		FinishReasons:       make(map[string]int),
+		Byes:                make(map[string]int),
 		SuccessfulDonations: 0,
 		FailedDonations:     0,
 	}
 
-	return &DonorGameEnvironment{
+	e := &DonorGameEnvironment{
 		agents:         make([]*agent.DonorGameAgent, 0),
 		state:          initialState,
 		roundsPerGen:   roundsPerGen,
 		donationMult:   donationMult,
 		initialBalance: initialBalance,
+		precision:      2,
+		concurrency:    DefaultConcurrency,
+		maxFailureRate: -1,
+	}
+	for _, opt := range opts {
+		opt(e)
 	}
+	if e.seed == 0 {
+		e.seed = time.Now().UnixNano()
+	}
+	e.reseed(e.seed)
+	return e
 }
 
 // AddAgent adds an agent to the environment
@@ -84,31 +317,55 @@ func (e *DonorGameEnvironment) AddAgent(agent *agent.DonorGameAgent) error {
 
 	e.agents = append(e.agents, agent)
 	e.state.AgentResources[agent.GetID()] = e.initialBalance
+	e.state.AgentModels[agent.GetID()] = agent.GetModel().Id
 	return nil
 }
 
 // RemoveAgent removes an agent from the environment
 func (e *DonorGameEnvironment) RemoveAgent(agent *agent.DonorGameAgent) error {
+	return e.RemoveAgentByID(agent.GetID())
+}
+
+// RemoveAgentByID removes the agent with the given ID along with its resources,
+// and unsubscribes it from its message broker
+func (e *DonorGameEnvironment) RemoveAgentByID(id string) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
 	for i, a := range e.agents {
-		if a.GetID() == agent.GetID() {
+		if a.GetID() == id {
 			e.agents = append(e.agents[:i], e.agents[i+1:]...)
-			delete(e.state.AgentResources, agent.GetID())
-			return nil
+			delete(e.state.AgentResources, id)
+			delete(e.state.Cooperation, id)
+			delete(e.state.AgentModels, id)
+			return unsubscribe(a)
 		}
 	}
-	return fmt.Errorf("agent %s not found", agent.GetID())
+	return fmt.Errorf("%w: %s", ErrAgentNotFound, id)
 }
 
 // Reset resets the environment for a new generation
 func (e *DonorGameEnvironment) Reset() error {
+	return e.ResetKeeping(nil)
+}
+
+// ResetKeeping resets the environment for a new generation like Reset, except that the agents in
+// keep stay in the environment with their strategies, memories and subscriptions. Their resources
+// are reset to the initial balance.
+func (e *DonorGameEnvironment) ResetKeeping(keep []*agent.DonorGameAgent) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	// Clear agents
-	e.agents = make([]*agent.DonorGameAgent, 0)
+	// Clear agents, unsubscribing them so the broker doesn't accumulate stale subscriptions
+	for _, a := range e.agents {
+		if slices.Contains(keep, a) {
+			continue
+		}
+		if err := unsubscribe(a); err != nil {
+			log.Printf("Warning: Failed to unsubscribe agent %s: %v", a.GetID(), err)
+		}
+	}
+	e.agents = make([]*agent.DonorGameAgent, 0, len(keep))
 
 	// Reset state but keep generation number
 	e.state = DonorGameState{
@@ -120,9 +377,18 @@ func (e *DonorGameEnvironment) Reset() error {
 		Round:               0,
 		TotalRounds:         0,
 		AgentResources:      make(map[string]float64),
+		Cooperation:         make(map[string]CooperationStats),
+		AgentModels:         make(map[string]string),
+		FinishReasons:       make(map[string]int),
+		Byes:                make(map[string]int),
 		SuccessfulDonations: 0,
 		FailedDonations:     0,
 	}
+	for _, a := range keep {
+		e.agents = append(e.agents, a)
+		e.state.AgentResources[a.GetID()] = e.initialBalance
+		e.state.AgentModels[a.GetID()] = a.GetModel().Id
+	}
 
 	return nil
 }
@@ -131,132 +397,410 @@ func (e *DonorGameEnvironment) Reset() error {
 func (e *DonorGameEnvironment) GetState() DonorGameState {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
-	return e.state
+	state := e.state
+	state.AgentResources = maps.Clone(e.state.AgentResources)
+	state.Cooperation = maps.Clone(e.state.Cooperation)
+	state.AgentModels = maps.Clone(e.state.AgentModels)
+	state.FinishReasons = maps.Clone(e.state.FinishReasons)
+	state.Byes = maps.Clone(e.state.Byes)
+	return state
 }
 
 // Step implements one round of the donor game
 func (e *DonorGameEnvironment) Step(ctx context.Context) error {
+	_, err := e.StepWithResult(ctx)
+	return err
+}
+
+// StepWithResult runs a round like Step and returns a summary of the donations it made,
+// so callers don't have to diff the state before and after the step
+func (e *DonorGameEnvironment) StepWithResult(ctx context.Context) (StepResult, error) {
 	log.Println("Running Donor Game step")
 
-	// get a copy of agents for shuffling
 	e.mu.Lock()
 	defer e.mu.Unlock()
-	agents := make([]*agent.DonorGameAgent, len(e.agents))
-	copy(agents, e.agents)
 
-	if len(agents)%2 != 0 {
-		return fmt.Errorf("need even number of agents")
-	}
+	pairs := e.pairs()
+	log.Println("Paired agents, starting donations")
 
-	// Shuffle agents for random pairing
-	rand.Shuffle(len(agents), func(i, j int) {
-		agents[i], agents[j] = agents[j], agents[i]
-	})
-	log.Println("Shuffled agents, starting pairs")
+	var publicInfo string
+	if e.publicStats {
+		publicInfo = e.publicStatsSummary()
+	}
 
 	// Channel to collect donations
-	donationChan := make(chan donation, len(agents)/2)
+	donationChan := make(chan donation, len(pairs))
+	limiter := e.callLimiter(len(pairs))
 
-	// Launch all donor decisions in parallel
-	for i := 0; i < len(agents); i += 2 {
-		donor, recipient := agents[i], agents[i+1]
+	// Launch all donor decisions in parallel, up to the concurrency limit at a time
+	for _, pair := range pairs {
+		donor, recipient := pair[0], pair[1]
 		log.Printf("Created pair: donor %s, recipient %s", donor.GetID(), recipient.GetID())
 
 		// Get recipient's history
-		recipientHistory := e.getRecentHistory(recipient.GetID())
+		recipientHistory := e.getRecentHistory(recipient.GetID(), donor)
+		if publicInfo != "" {
+			recipientHistory += "\n\n" + publicInfo
+		}
+		if groupInfo := e.groupSummary(donor.GetID(), recipient.GetID()); groupInfo != "" {
+			recipientHistory += "\n\n" + groupInfo
+		}
+
+		// Read the state now so goroutines still running after a cancelled step don't race with later steps
+		generation := e.generation
+		round := e.state.Round
+		recipientResources := e.state.AgentResources[recipient.GetID()]
+		donorResources := e.state.AgentResources[donor.GetID()]
 
 		go func(d, r *agent.DonorGameAgent) {
+			select {
+			case limiter <- struct{}{}:
+				defer func() { <-limiter }()
+			case <-ctx.Done():
+				donationChan <- donation{donorID: d.GetID(), err: fmt.Errorf("donor %s error: %w", d.GetID(), ctx.Err())}
+				return
+			}
 			log.Printf("Running donor %s", d.GetID())
-			donationAmount, err := d.MakeDonationDecision(ctx,
-				int(e.state.BaseState.GetStep()), // generation
-				e.state.Round,
+			decision, err := d.DecideDonation(ctx,
+				generation,
+				round,
 				r.GetID(),
-				e.state.AgentResources[r.GetID()],
+				recipientResources,
 				recipientHistory,
-				e.state.AgentResources[d.GetID()],
+				donorResources,
 			)
 			if err != nil {
 				donationChan <- donation{
-					donorID: d.GetID(),
-					err:     fmt.Errorf("donor %s error: %v", d.GetID(), err),
+					donorID:      d.GetID(),
+					finishReason: decision.FinishReason,
+					err:          fmt.Errorf("donor %s error: %w", d.GetID(), err),
 				}
 				return
 			}
 
 			donationChan <- donation{
-				donorID:     d.GetID(),
-				recipientID: r.GetID(),
-				amount:      donationAmount,
+				donorID:      d.GetID(),
+				recipientID:  r.GetID(),
+				amount:       decision.Amount,
+				punishment:   decision.Punishment,
+				finishReason: decision.FinishReason,
 			}
 		}(donor, recipient)
 	}
 
 	// Collect all donations
-	donations := make([]donation, 0, len(agents)/2)
-	var errors []error
-	for i := 0; i < len(agents)/2; i++ {
+	donations := make([]donation, 0, len(pairs))
+	var finishReasons []string
+	var errs []error
+	for range pairs {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return StepResult{}, ctx.Err()
 		case d := <-donationChan:
+			if d.finishReason != "" {
+				finishReasons = append(finishReasons, d.finishReason)
+			}
 			if d.err != nil {
-				errors = append(errors, d.err)
-				e.state.FailedDonations++
+				errs = append(errs, d.err)
 				continue
 			}
 			donations = append(donations, d)
-			e.state.SuccessfulDonations++
 		}
 	}
 
-	if len(errors) > 0 {
+	if len(errs) > 0 {
 		// Log errors but continue with successful donations
-		for _, err := range errors {
+		for _, err := range errs {
 			log.Printf("Donation error: %v", err)
 		}
 	}
+	if failed := len(errs); e.abandons(failed, len(pairs)) {
+		e.logRound(pairs, e.byes(pairs), true)
+		return StepResult{Round: e.state.Round, Errors: errs},
+			fmt.Errorf("%w: %d of %d donations in round %d failed", ErrRoundFailed, failed, len(pairs), e.state.Round)
+	}
+	for _, reason := range finishReasons {
+		e.state.FinishReasons[reason]++
+	}
+	e.state.FailedDonations += len(errs)
+
+	// Agents left out of the pairs sit out the round without donating or receiving
+	byes := e.byes(pairs)
+	for _, id := range byes {
+		e.state.Byes[id]++
+		log.Printf("Agent %s sits out this round", id)
+	}
+	var bye string
+	if len(byes) > 0 {
+		bye = byes[0]
+	}
+	e.logRound(pairs, byes, false)
+
+	result := StepResult{
+		Round:          e.state.Round,
+		ResourceDeltas: make(map[string]float64),
+		Errors:         errs,
+		Bye:            bye,
+	}
 
 	// Update round counters
 	e.state.Round++
 	e.state.TotalRounds++
 
 	// Apply donations and update memories
+	applied, applyErrors := e.applyDonations(ctx, donations)
+	result.Errors = append(result.Errors, applyErrors...)
+	result.Donations = applied
+	for _, d := range applied {
+		result.ResourceDeltas[d.DonorID] -= d.Amount + d.Punishment
+		result.ResourceDeltas[d.RecipientID] += d.Received - d.Penalty
+	}
+	if e.gossip {
+		e.spreadGossip(ctx, applied)
+	}
+
+	// Check if round needs to reset
+	if e.state.Round >= e.roundsPerGen {
+		e.state.Round = 0
+	}
+
+	return result, nil
+}
+
+// SkipRound advances the round counters without playing the round, for rounds the caller gave up on
+// (for example after a timeout), so later rounds keep their numbers
+func (e *DonorGameEnvironment) SkipRound() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.state.Round++
+	e.state.TotalRounds++
+	if e.state.Round >= e.roundsPerGen {
+		e.state.Round = 0
+	}
+}
+
+// pairs pairs up the agents for the current round with the pairing strategy. The default random
+// pairing draws from the environment's RNG and rotates byes by the counts in the state.
+func (e *DonorGameEnvironment) pairs() [][2]*agent.DonorGameAgent {
+	pairing := e.pairing
+	if pairing == nil {
+		pairing = &RandomPairing{rng: e.rng, byes: maps.Clone(e.state.Byes)}
+	}
+	return pairing.Pair(slices.Clone(e.agents), e.state.Round)
+}
+
+// abandons reports whether a round with failed of its total donations failing is abandoned under
+// the maximum failure rate
+func (e *DonorGameEnvironment) abandons(failed, total int) bool {
+	if e.maxFailureRate < 0 || failed == 0 {
+		return false
+	}
+	return failed == total || float64(failed)/float64(total) > e.maxFailureRate
+}
+
+// byes returns the IDs of the agents that aren't in any of the pairs
+func (e *DonorGameEnvironment) byes(pairs [][2]*agent.DonorGameAgent) []string {
+	paired := make(map[*agent.DonorGameAgent]bool, 2*len(pairs))
+	for _, pair := range pairs {
+		paired[pair[0]] = true
+		paired[pair[1]] = true
+	}
+	var byes []string
+	for _, a := range e.agents {
+		if !paired[a] {
+			byes = append(byes, a.GetID())
+		}
+	}
+	return byes
+}
+
+// logRound adds the current round's pairs and byes to the round logs, flagging rounds that were
+// abandoned with ErrRoundFailed
+func (e *DonorGameEnvironment) logRound(pairs [][2]*agent.DonorGameAgent, byes []string, abandoned bool) {
+	entry := RoundLog{
+		Generation: e.generation,
+		Round:      e.state.Round,
+		Pairs:      make([][2]string, len(pairs)),
+		Byes:       byes,
+		Abandoned:  abandoned,
+	}
+	for i, pair := range pairs {
+		entry.Pairs[i] = [2]string{pair[0].GetID(), pair[1].GetID()}
+	}
+	e.roundLogs = append(e.roundLogs, entry)
+}
+
+// SetGeneration sets the generation of the rounds played from now on, which donors are told and
+// which their round logs and donation records carry. It is called after Reset, before the
+// generation's first step.
+func (e *DonorGameEnvironment) SetGeneration(generation int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.generation = generation
+}
+
+// RestoreRoundLogs replaces the round logs with logs, such as the ones saved in a checkpoint
+func (e *DonorGameEnvironment) RestoreRoundLogs(logs []RoundLog) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.roundLogs = slices.Clone(logs)
+}
+
+// GetRoundLogs returns the pairs of every round played so far, oldest first. Unlike the state,
+// the logs aren't cleared by Reset, so they cover every generation of a run.
+func (e *DonorGameEnvironment) GetRoundLogs() []RoundLog {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return slices.Clone(e.roundLogs)
+}
+
+// applyDonations transfers each donation from donor to recipient and records it in both agents' memories.
+// Invalid donations are counted as failed, leave resources untouched and are returned as errors.
+func (e *DonorGameEnvironment) applyDonations(ctx context.Context, donations []donation) ([]Donation, []error) {
+	var applied []Donation
+	var errs []error
 	for _, d := range donations {
-		pctDonation := d.amount / e.state.AgentResources[d.donorID]
+		if d.donorID == d.recipientID {
+			err := fmt.Errorf("agent %s cannot donate to itself", d.donorID)
+			log.Printf("Donation error: %v", err)
+			errs = append(errs, err)
+			e.state.FailedDonations++
+			continue
+		}
+
+		var pctDonation float64
+		if e.state.AgentResources[d.donorID] > 0 {
+			pctDonation = d.amount / e.state.AgentResources[d.donorID]
+		}
+		cooperation := e.state.Cooperation[d.donorID]
+		cooperation.Donations++
+		cooperation.TotalFraction += pctDonation
+		e.state.Cooperation[d.donorID] = cooperation
+
 		e.state.AgentResources[d.donorID] -= d.amount
 		multipliedAmount := d.amount * e.donationMult
 		e.state.AgentResources[d.recipientID] += multipliedAmount
+		e.state.SuccessfulDonations++
+		e.recordIntergroup(d)
+		applied = append(applied, Donation{
+			DonorID:     d.donorID,
+			RecipientID: d.recipientID,
+			Amount:      d.amount,
+			Received:    multipliedAmount,
+		})
 
 		// Update donor's memory
-		for _, agent := range e.agents {
-			if agent.GetID() == d.donorID {
-				donorMemory := fmt.Sprintf("Round: I donated %.2f%% (%.2f) of my resources to %s, leaving me with %.2f resources",
-					pctDonation, d.amount, d.recipientID, e.state.AgentResources[d.donorID])
-				if err := agent.GetMemory().Store(donorMemory); err != nil {
+		for _, a := range e.agents {
+			if a.GetID() == d.donorID {
+				donorMemory := fmt.Sprintf("Round: I donated %.*f%% (%.*f) of my resources to %s, leaving me with %.*f resources",
+					e.precision, pctDonation, e.precision, d.amount, d.recipientID, e.precision, e.state.AgentResources[d.donorID])
+				if err := a.GetMemory().StoreContext(ctx, donorMemory); err != nil {
 					log.Printf("Warning: Failed to store memory for donor %s: %v", d.donorID, err)
 				}
+				a.RecordDonation(agent.DonationRecord{Generation: e.generation, Round: e.state.Round, PartnerID: d.recipientID, Amount: d.amount, Pct: pctDonation, AsDonor: true})
 			}
-			if agent.GetID() == d.recipientID {
-				recipientMemory := fmt.Sprintf("Round: I received %.2f%% (%.2f multiplied to %.2f) from %s, bringing my resources to %.2f",
-					pctDonation, d.amount, multipliedAmount, d.donorID, e.state.AgentResources[d.recipientID])
-				if err := agent.GetMemory().Store(recipientMemory); err != nil {
+			if a.GetID() == d.recipientID {
+				recipientMemory := fmt.Sprintf("Round: I received %.*f%% (%.*f multiplied to %.*f) from %s, bringing my resources to %.*f",
+					e.precision, pctDonation, e.precision, d.amount, e.precision, multipliedAmount, d.donorID, e.precision, e.state.AgentResources[d.recipientID])
+				if err := a.GetMemory().StoreContext(ctx, recipientMemory); err != nil {
 					log.Printf("Warning: Failed to store memory for recipient %s: %v", d.recipientID, err)
 				}
-				break
+				a.RecordDonation(agent.DonationRecord{Generation: e.generation, Round: e.state.Round, PartnerID: d.donorID, Amount: d.amount, Pct: pctDonation})
 			}
 		}
+
+		if e.punishment && d.punishment > 0 {
+			applied[len(applied)-1].Punishment, applied[len(applied)-1].Penalty = e.applyPunishment(ctx, d)
+		}
 	}
+	return applied, errs
+}
 
-	// Check if round needs to reset
-	if e.state.Round >= e.roundsPerGen {
-		e.state.Round = 0
+// applyPunishment charges the donor for punishing the recipient and takes the penalty from the
+// recipient, returning both amounts. Neither agent is taken below zero resources.
+func (e *DonorGameEnvironment) applyPunishment(ctx context.Context, d donation) (spent, penalty float64) {
+	spent = min(d.punishment, max(e.state.AgentResources[d.donorID], 0))
+	penalty = min(spent*PunishmentMultiplier, max(e.state.AgentResources[d.recipientID], 0))
+	if spent == 0 {
+		return 0, 0
 	}
+	e.state.AgentResources[d.donorID] -= spent
+	e.state.AgentResources[d.recipientID] -= penalty
+	e.state.TotalPunishments++
+	e.state.PunishmentSpent += spent
 
-	return nil
+	for _, agent := range e.agents {
+		var entry string
+		switch agent.GetID() {
+		case d.donorID:
+			entry = fmt.Sprintf("Round: I spent %.*f to punish %s, taking away %.*f of their resources and leaving me with %.*f resources",
+				e.precision, spent, d.recipientID, e.precision, penalty, e.precision, e.state.AgentResources[d.donorID])
+		case d.recipientID:
+			entry = fmt.Sprintf("Round: %s spent %.*f to punish me, taking away %.*f of my resources and leaving me with %.*f resources",
+				d.donorID, e.precision, spent, e.precision, penalty, e.precision, e.state.AgentResources[d.recipientID])
+		default:
+			continue
+		}
+		if err := agent.GetMemory().StoreContext(ctx, entry); err != nil {
+			log.Printf("Warning: Failed to store punishment memory for %s: %v", agent.GetID(), err)
+		}
+	}
+	return spent, penalty
+}
+
+// publicStatsSummary describes the population's aggregate state for donation prompts
+func (e *DonorGameEnvironment) publicStatsSummary() string {
+	var totalResources float64
+	for _, r := range e.state.AgentResources {
+		totalResources += r
+	}
+	var avgResources float64
+	if len(e.state.AgentResources) > 0 {
+		avgResources = totalResources / float64(len(e.state.AgentResources))
+	}
+
+	var donations int
+	var totalFraction float64
+	for _, c := range e.state.Cooperation {
+		donations += c.Donations
+		totalFraction += c.TotalFraction
+	}
+	if donations == 0 {
+		return fmt.Sprintf("Public information: It is round %d. The average player has %.*f units of the valuable resource. No donations have been made yet.",
+			e.state.Round, e.precision, avgResources)
+	}
+	return fmt.Sprintf("Public information: It is round %d. The average player has %.*f units of the valuable resource. So far, donors have given away %.1f%% of their resources on average.",
+		e.state.Round, e.precision, avgResources, totalFraction/float64(donations)*100)
+}
+
+// groupSummary tells the donor which group the recipient is in, or returns "" if either has no group
+func (e *DonorGameEnvironment) groupSummary(donorID, recipientID string) string {
+	donorGroup, recipientGroup := e.groups[donorID], e.groups[recipientID]
+	if donorGroup == "" || recipientGroup == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s belongs to group %s. You belong to group %s.", recipientID, recipientGroup, donorGroup)
 }
 
-// getRecentHistory returns a string describing the recipient's recent interactions
-func (e *DonorGameEnvironment) getRecentHistory(agentID string) string {
+// recordIntergroup counts a successful donation as within or across groups
+func (e *DonorGameEnvironment) recordIntergroup(d donation) {
+	donorGroup, recipientGroup := e.groups[d.donorID], e.groups[d.recipientID]
+	if donorGroup == "" || recipientGroup == "" {
+		return
+	}
+	if donorGroup == recipientGroup {
+		e.state.Intergroup.IntraDonations++
+		e.state.Intergroup.IntraAmount += d.amount
+	} else {
+		e.state.Intergroup.InterDonations++
+		e.state.Intergroup.InterAmount += d.amount
+	}
+}
+
+// getRecentHistory returns a string describing the recipient's recent interactions, followed by
+// the gossip the donor has heard about the recipient if gossip is enabled
+func (e *DonorGameEnvironment) getRecentHistory(agentID string, donor *agent.DonorGameAgent) string {
 	memories := make([]string, 0)
 	for _, agent := range e.agents {
 		if agent.GetID() == agentID {
@@ -271,11 +815,103 @@ func (e *DonorGameEnvironment) getRecentHistory(agentID string) string {
 		}
 	}
 
+	if e.noiseProb > 0 {
+		memories = e.addHistoryNoise(memories)
+	}
+	memories = memory.TruncateToTokens(memories, e.historyTokens)
+
+	history := strings.Join(memories, "\n")
 	if len(memories) == 0 {
-		return "This is the first round, so there is no history of previous interactions."
+		history = "This is the first round, so there is no history of previous interactions."
+	}
+	if e.gossip && donor != nil {
+		if heard := donor.HeardGossipAbout(agentID); len(heard) > 0 {
+			history += fmt.Sprintf("\n\nWhat other players have said about %s:\n%s", agentID, strings.Join(heard[max(0, len(heard)-3):], "\n"))
+		}
 	}
+	return history
+}
 
-	return strings.Join(memories, "\n")
+// spreadGossip has both partners of every applied donation gossip about each other, then has
+// every agent collect the gossip it received. Notes are shared in batches no larger than the
+// smallest inbox and collected after each batch, so a large population doesn't overflow the
+// inboxes. Notes that still don't reach every player are counted in the state's
+// GossipUndelivered.
+func (e *DonorGameEnvironment) spreadGossip(ctx context.Context, applied []Donation) {
+	agents := make(map[string]*agent.DonorGameAgent, len(e.agents))
+	batchSize := len(applied) * 2
+	for _, a := range e.agents {
+		agents[a.GetID()] = a
+		batchSize = min(batchSize, a.InboxCapacity())
+	}
+	type note struct {
+		speaker *agent.DonorGameAgent
+		about   string
+	}
+	var notes []note
+	for _, d := range applied {
+		for _, pair := range [][2]string{{d.DonorID, d.RecipientID}, {d.RecipientID, d.DonorID}} {
+			if speaker, ok := agents[pair[0]]; ok {
+				notes = append(notes, note{speaker, pair[1]})
+			}
+		}
+	}
+
+	var undelivered atomic.Int32
+	limiter := e.callLimiter(len(notes))
+	for batch := range slices.Chunk(notes, max(batchSize, 1)) {
+		var wg sync.WaitGroup
+		for _, n := range batch {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				limiter <- struct{}{}
+				defer func() { <-limiter }()
+				if err := n.speaker.ShareGossip(ctx, n.about); err != nil {
+					if errors.Is(err, agent.ErrGossipUndelivered) {
+						undelivered.Add(1)
+					}
+					log.Printf("Warning: Agent %s failed to gossip about %s: %v", n.speaker.GetID(), n.about, err)
+				}
+			}()
+		}
+		wg.Wait()
+		for _, a := range e.agents {
+			a.CollectGossip()
+		}
+	}
+	if n := int(undelivered.Load()); n > 0 {
+		e.state.GossipUndelivered += n
+		log.Printf("Warning: %d of %d gossip notes didn't reach every player", n, len(notes))
+	}
+}
+
+// donatedMemory matches the donor memory written by applyDonations
+var donatedMemory = regexp.MustCompile(`^Round: I donated ([\d.]+)% \(([\d.]+)\) of my resources to (\S+), leaving me with ([\d.]+) resources$`)
+
+// addHistoryNoise returns a copy of memories with each donation corrupted with probability noiseProb
+func (e *DonorGameEnvironment) addHistoryNoise(memories []string) []string {
+	noisy := make([]string, 0, len(memories))
+	for _, m := range memories {
+		match := donatedMemory.FindStringSubmatch(m)
+		if match == nil || e.noiseRng.Float64() >= e.noiseProb {
+			noisy = append(noisy, m)
+			continue
+		}
+		if e.noise == NoiseOmit {
+			continue
+		}
+
+		fraction, _ := strconv.ParseFloat(match[1], 64)
+		amount, _ := strconv.ParseFloat(match[2], 64)
+		remaining, _ := strconv.ParseFloat(match[4], 64)
+		before := amount + remaining
+		fraction = 1 - fraction
+		amount = before * fraction
+		noisy = append(noisy, fmt.Sprintf("Round: I donated %.*f%% (%.*f) of my resources to %s, leaving me with %.*f resources",
+			e.precision, fraction, e.precision, amount, match[3], e.precision, before-amount))
+	}
+	return noisy
 }
 
 // InitializeGeneration generates strategies for all agents at the start of a generation
@@ -302,9 +938,12 @@ func (e *DonorGameEnvironment) GetTopAgents(n int) []string {
 		scores = append(scores, agentScore{id, resources})
 	}
 
-	// Sort by resources descending
+	// Sort by resources descending, breaking ties by ID so the result doesn't depend on map order
 	sort.Slice(scores, func(i, j int) bool {
-		return scores[i].resources > scores[j].resources
+		if scores[i].resources != scores[j].resources {
+			return scores[i].resources > scores[j].resources
+		}
+		return scores[i].id < scores[j].id
 	})
 
 	// Get top N agent IDs
@@ -316,11 +955,85 @@ func (e *DonorGameEnvironment) GetTopAgents(n int) []string {
 	return result
 }
 
+// Shuffle returns a copy of ids in random order drawn from the environment's seeded RNG, e.g. to
+// break ties between agents reproducibly
+func (e *DonorGameEnvironment) Shuffle(ids []string) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return shuffled(e.rng, ids)
+}
+
+// SetResources sets the resources of the agent with the given ID, e.g. when restoring a checkpoint
+func (e *DonorGameEnvironment) SetResources(id string, resources float64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.state.AgentResources[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrAgentNotFound, id)
+	}
+	e.state.AgentResources[id] = resources
+	return nil
+}
+
+// GetSeed returns the seed of the environment's random number generator
+func (e *DonorGameEnvironment) GetSeed() int64 {
+	return e.seed
+}
+
+// GetIntergroupStats returns the current generation's donations within and across groups
+func (e *DonorGameEnvironment) GetIntergroupStats() IntergroupStats {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.state.Intergroup
+}
+
+// SetGroup tags an agent with a group label, e.g. for agents created after the environment
+func (e *DonorGameEnvironment) SetGroup(agentID string, group string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.groups == nil {
+		e.groups = make(map[string]string)
+	}
+	e.groups[agentID] = group
+}
+
+// GetPrecision returns the number of decimal places used when displaying resource amounts
+func (e *DonorGameEnvironment) GetPrecision() int {
+	return e.precision
+}
+
+// GetInitialBalance returns the resources each agent starts a generation with
+func (e *DonorGameEnvironment) GetInitialBalance() float64 {
+	return e.initialBalance
+}
+
 // GetRoundsPerGen returns the number of rounds per generation
 func (e *DonorGameEnvironment) GetRoundsPerGen() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 	return e.roundsPerGen
 }
 
+// SetRoundsPerGen changes the number of rounds per generation
+func (e *DonorGameEnvironment) SetRoundsPerGen(rounds int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.roundsPerGen = rounds
+}
+
+// GetDonationMultiplier returns the multiplier applied to donations
+func (e *DonorGameEnvironment) GetDonationMultiplier() float64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.donationMult
+}
+
+// SetDonationMultiplier changes the multiplier applied to donations
+func (e *DonorGameEnvironment) SetDonationMultiplier(mult float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.donationMult = mult
+}
+
 // GetAgents returns a copy of the agents slice
 func (e *DonorGameEnvironment) GetAgents() []*agent.DonorGameAgent {
 	e.mu.RLock()
@@ -329,3 +1042,12 @@ func (e *DonorGameEnvironment) GetAgents() []*agent.DonorGameAgent {
 	copy(agents, e.agents)
 	return agents
 }
+
+// GetAgentsShuffled returns the agents in a random order drawn from the environment's RNG,
+// for sampling that shouldn't favour agents created first. Unlike GetAgents the order differs
+// between calls, and each call advances the RNG used for pairings.
+func (e *DonorGameEnvironment) GetAgentsShuffled() []*agent.DonorGameAgent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return shuffled(e.rng, e.agents)
+}
@@ -0,0 +1,955 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boristopalov/petri/pkg/agent"
+	"github.com/boristopalov/petri/pkg/memory"
+	"github.com/boristopalov/petri/pkg/messaging"
+	"github.com/boristopalov/petri/pkg/providers"
+)
+
+// mockClient implements agent.Client and records prompts. It returns the queued responses
+// in order, then response for every later call.
+type mockClient struct {
+	response  string
+	responses []string
+	mu        sync.Mutex
+	prompts   []string
+}
+
+func (m *mockClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prompts = append(m.prompts, prompt)
+	if len(m.responses) > 0 {
+		response := m.responses[0]
+		m.responses = m.responses[1:]
+		return response, nil
+	}
+	return m.response, nil
+}
+
+// gossipClient implements agent.Client, answering gossip prompts with gossip and donation
+// prompts with a donation of 1
+type gossipClient struct {
+	gossip string
+}
+
+func (c *gossipClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	if strings.Contains(prompt, "reputation") {
+		return c.gossip, nil
+	}
+	return "ANSWER: 1", nil
+}
+
+// finishReasonClient implements providers.DetailedClient, answering every call with the same
+// donation and the next of its finish reasons in turn
+type finishReasonClient struct {
+	reasons []string
+	mu      sync.Mutex
+	calls   int
+}
+
+func (c *finishReasonClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	completion, err := c.CompleteDetailed(ctx, model, prompt, systemPrompt, history)
+	return completion.Text, err
+}
+
+func (c *finishReasonClient) CompleteDetailed(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (providers.Completion, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reason := c.reasons[c.calls%len(c.reasons)]
+	c.calls++
+	return providers.Completion{Text: "ANSWER: 1", FinishReason: reason}, nil
+}
+
+// concurrentClient implements agent.Client, donating 1 after a delay and recording the most
+// calls it was handling at once
+type concurrentClient struct {
+	delay   time.Duration
+	mu      sync.Mutex
+	active  int
+	maxSeen int
+}
+
+func (c *concurrentClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	c.mu.Lock()
+	c.active++
+	c.maxSeen = max(c.maxSeen, c.active)
+	c.mu.Unlock()
+
+	time.Sleep(c.delay)
+
+	c.mu.Lock()
+	c.active--
+	c.mu.Unlock()
+	return "ANSWER: 1", nil
+}
+
+func newTestAgent(t *testing.T, id string, client agent.Client) *agent.DonorGameAgent {
+	t.Helper()
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	a, err := agent.NewDonorGameAgent(context.Background(), id, "donate half", agent.WithProvider(client))
+	if err != nil {
+		t.Fatalf("Failed to create agent %s: %v", id, err)
+	}
+	return a
+}
+
+// fixedPairing pairs the agents at the given indexes, donor first, every round
+type fixedPairing [][2]int
+
+func (p fixedPairing) Pair(agents []*agent.DonorGameAgent, round int) [][2]*agent.DonorGameAgent {
+	pairs := make([][2]*agent.DonorGameAgent, len(p))
+	for i, pair := range p {
+		pairs[i] = [2]*agent.DonorGameAgent{agents[pair[0]], agents[pair[1]]}
+	}
+	return pairs
+}
+
+func TestDonorGameEnvironment(t *testing.T) {
+	t.Run("test rounds with too many failed donations are abandoned", func(t *testing.T) {
+		failing := &mockClient{response: "I'm not sure."}
+		working := &mockClient{response: "ANSWER: 1"}
+		newEnv := func(opts ...DonorGameOption) *DonorGameEnvironment {
+			opts = append(opts, WithPairingStrategy(fixedPairing{{0, 1}, {2, 3}}))
+			env := NewDonorGameEnvironment(3, 2.0, 10.0, opts...)
+			for i, client := range []*mockClient{failing, working, working, working} {
+				if err := env.AddAgent(newTestAgent(t, fmt.Sprintf("agent%d", i), client)); err != nil {
+					t.Fatalf("Failed to add agent: %v", err)
+				}
+			}
+			return env
+		}
+
+		// By default no round is abandoned
+		env := newEnv()
+		if _, err := env.StepWithResult(context.Background()); err != nil {
+			t.Fatalf("Step failed with one of two donations failing: %v", err)
+		}
+		if state := env.GetState(); state.FailedDonations != 1 {
+			t.Errorf("FailedDonations = %d, want 1", state.FailedDonations)
+		}
+
+		env = newEnv(WithMaxFailureRate(0.25))
+		result, err := env.StepWithResult(context.Background())
+		if !errors.Is(err, ErrRoundFailed) {
+			t.Fatalf("Step error = %v, want ErrRoundFailed", err)
+		}
+		if len(result.Errors) != 1 {
+			t.Errorf("result.Errors = %v, want the failed donation", result.Errors)
+		}
+		state := env.GetState()
+		if state.Round != 0 || state.TotalRounds != 0 || state.SuccessfulDonations != 0 || state.FailedDonations != 0 || state.AgentResources["agent3"] != 10 {
+			t.Errorf("Round = %d, TotalRounds = %d, SuccessfulDonations = %d, FailedDonations = %d, resources = %v, want the round not played",
+				state.Round, state.TotalRounds, state.SuccessfulDonations, state.FailedDonations, state.AgentResources)
+		}
+		logs := env.GetRoundLogs()
+		if len(logs) != 1 || !logs[0].Abandoned || len(logs[0].Pairs) != 2 {
+			t.Errorf("round logs = %v, want the abandoned round's pairs flagged as abandoned", logs)
+		}
+
+		newFailingEnv := func(opts ...DonorGameOption) *DonorGameEnvironment {
+			env := NewDonorGameEnvironment(3, 2.0, 10.0, opts...)
+			for _, id := range []string{"agent1", "agent2"} {
+				if err := env.AddAgent(newTestAgent(t, id, failing)); err != nil {
+					t.Fatalf("Failed to add agent: %v", err)
+				}
+			}
+			return env
+		}
+		if _, err := newFailingEnv().StepWithResult(context.Background()); err != nil {
+			t.Errorf("Step failed with every donation failing and no maximum failure rate: %v", err)
+		}
+		if _, err := newFailingEnv(WithMaxFailureRate(1)).StepWithResult(context.Background()); !errors.Is(err, ErrRoundFailed) {
+			t.Errorf("Step error = %v, want ErrRoundFailed when every donation fails", err)
+		}
+	})
+
+	t.Run("test self-donation is rejected", func(t *testing.T) {
+		env := NewDonorGameEnvironment(3, 2.0, 10.0)
+		client := &mockClient{response: "ANSWER: 5"}
+		for _, id := range []string{"agent1", "agent2"} {
+			if err := env.AddAgent(newTestAgent(t, id, client)); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+
+		env.applyDonations(context.Background(), []donation{
+			{donorID: "agent1", recipientID: "agent1", amount: 5},
+		})
+
+		state := env.GetState()
+		if state.FailedDonations != 1 {
+			t.Errorf("FailedDonations = %d, want 1", state.FailedDonations)
+		}
+		if state.SuccessfulDonations != 0 {
+			t.Errorf("SuccessfulDonations = %d, want 0", state.SuccessfulDonations)
+		}
+		for id, resources := range state.AgentResources {
+			if resources != 10.0 {
+				t.Errorf("%s resources = %.2f, want 10.00", id, resources)
+			}
+		}
+		for _, a := range env.GetAgents() {
+			if got := len(a.GetMemory().GetAllMessages()); got != 0 {
+				t.Errorf("%s has %d memories, want 0", a.GetID(), got)
+			}
+		}
+	})
+
+	t.Run("test remove agent by ID", func(t *testing.T) {
+		env := NewDonorGameEnvironment(3, 2.0, 10.0)
+		client := &mockClient{response: "ANSWER: 5"}
+		for _, id := range []string{"agent1", "agent2", "agent3"} {
+			if err := env.AddAgent(newTestAgent(t, id, client)); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+
+		if err := env.RemoveAgentByID("agent2"); err != nil {
+			t.Fatalf("Failed to remove agent: %v", err)
+		}
+
+		for _, a := range env.GetAgents() {
+			if a.GetID() == "agent2" {
+				t.Error("agent2 still in agents slice")
+			}
+		}
+		if got := len(env.GetAgents()); got != 2 {
+			t.Errorf("len(GetAgents()) = %d, want 2", got)
+		}
+		if _, ok := env.GetState().AgentResources["agent2"]; ok {
+			t.Error("agent2 still in resource map")
+		}
+
+		if err := env.RemoveAgentByID("agent2"); !errors.Is(err, ErrAgentNotFound) {
+			t.Errorf("Expected ErrAgentNotFound removing a missing agent, got %v", err)
+		}
+	})
+
+	t.Run("test reset keeping agents resets only their resources", func(t *testing.T) {
+		env := NewDonorGameEnvironment(3, 2.0, 10.0)
+		broker := messaging.NewBroker()
+		client := &mockClient{response: "ANSWER: 5"}
+		var agents []*agent.DonorGameAgent
+		for _, id := range []string{"agent1", "agent2", "agent3"} {
+			a, err := agent.NewDonorGameAgent(context.Background(), id, "donate half",
+				agent.WithProvider(client), agent.WithMessageBroker(broker))
+			if err != nil {
+				t.Fatalf("Failed to create agent %s: %v", id, err)
+			}
+			if err := env.AddAgent(a); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+			agents = append(agents, a)
+		}
+		env.applyDonations(context.Background(), []donation{{donorID: "agent1", recipientID: "agent2", amount: 5}})
+
+		if err := env.ResetKeeping(agents[:2]); err != nil {
+			t.Fatalf("Failed to reset: %v", err)
+		}
+		kept := env.GetAgents()
+		if len(kept) != 2 || kept[0] != agents[0] || kept[1] != agents[1] {
+			t.Fatalf("agents after reset = %v, want agent1 and agent2", kept)
+		}
+		state := env.GetState()
+		if len(state.AgentResources) != 2 || state.AgentResources["agent1"] != 10.0 || state.AgentResources["agent2"] != 10.0 {
+			t.Errorf("resources after reset = %v, want 10.00 for each kept agent", state.AgentResources)
+		}
+		if state.SuccessfulDonations != 0 {
+			t.Errorf("SuccessfulDonations = %d, want 0", state.SuccessfulDonations)
+		}
+		if agents[0].GetMemory().Len() == 0 || agents[1].GetMemory().Len() == 0 {
+			t.Error("Expected kept agents to keep their memories")
+		}
+		if broker.SubscriberCount() != 2 {
+			t.Errorf("got %d subscribers, want only the 2 kept agents", broker.SubscriberCount())
+		}
+	})
+
+	t.Run("test donors are told the generation set on the environment", func(t *testing.T) {
+		env := NewDonorGameEnvironment(3, 2.0, 10.0)
+		client := &mockClient{response: "ANSWER: 1"}
+		for _, id := range []string{"agent1", "agent2"} {
+			if err := env.AddAgent(newTestAgent(t, id, client)); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+		env.SetGeneration(4)
+		if err := env.Step(context.Background()); err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+		if len(client.prompts) != 1 || !strings.Contains(client.prompts[0], "This is generation 4.") {
+			t.Errorf("prompts = %q, want the donor told it's generation 4", client.prompts)
+		}
+	})
+
+	t.Run("test donations are recorded in both agents' histories", func(t *testing.T) {
+		env := NewDonorGameEnvironment(3, 2.0, 10.0)
+		client := &mockClient{response: "ANSWER: 5"}
+		for _, id := range []string{"agent1", "agent2"} {
+			if err := env.AddAgent(newTestAgent(t, id, client)); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+		env.SetGeneration(3)
+		env.state.Round = 2
+		env.applyDonations(context.Background(), []donation{
+			{donorID: "agent1", recipientID: "agent2", amount: 5},
+			{donorID: "agent1", recipientID: "agent1", amount: 1}, // rejected, so not recorded
+		})
+
+		agents := env.GetAgents()
+		want := map[string][]agent.DonationRecord{
+			"agent1": {{Generation: 3, Round: 2, PartnerID: "agent2", Amount: 5, Pct: 0.5, AsDonor: true}},
+			"agent2": {{Generation: 3, Round: 2, PartnerID: "agent1", Amount: 5, Pct: 0.5}},
+		}
+		for _, a := range agents {
+			if got := a.GetDonationHistory(); !slices.Equal(got, want[a.GetID()]) {
+				t.Errorf("%s donation history = %+v, want %+v", a.GetID(), got, want[a.GetID()])
+			}
+		}
+	})
+
+	t.Run("test captured seed reproduces pairings", func(t *testing.T) {
+		ids := []string{"agent1", "agent2", "agent3", "agent4", "agent5", "agent6"}
+		client := &mockClient{response: "ANSWER: 5"}
+		newEnv := func(seed int64) *DonorGameEnvironment {
+			env := NewDonorGameEnvironment(3, 2.0, 10.0, WithSeed(seed))
+			for _, id := range ids {
+				if err := env.AddAgent(newTestAgent(t, id, client)); err != nil {
+					t.Fatalf("Failed to add agent %s: %v", id, err)
+				}
+			}
+			return env
+		}
+
+		original := newEnv(0)
+		if original.GetSeed() == 0 {
+			t.Fatal("Expected a generated seed to be recorded")
+		}
+		replay := newEnv(original.GetSeed())
+
+		for round := 0; round < 5; round++ {
+			want := shuffled(original.rng, original.GetAgents())
+			got := shuffled(replay.rng, replay.GetAgents())
+			for i := range want {
+				if got[i].GetID() != want[i].GetID() {
+					t.Fatalf("round %d: pairing order differs at %d: got %s, want %s", round, i, got[i].GetID(), want[i].GetID())
+				}
+			}
+		}
+	})
+
+	t.Run("test public stats in donation prompt", func(t *testing.T) {
+		for _, enabled := range []bool{true, false} {
+			var opts []DonorGameOption
+			if enabled {
+				opts = append(opts, WithPublicStats())
+			}
+			env := NewDonorGameEnvironment(3, 2.0, 10.0, opts...)
+			client := &mockClient{response: "ANSWER: 5"}
+			for _, id := range []string{"agent1", "agent2"} {
+				if err := env.AddAgent(newTestAgent(t, id, client)); err != nil {
+					t.Fatalf("Failed to add agent %s: %v", id, err)
+				}
+			}
+
+			if err := env.Step(context.Background()); err != nil {
+				t.Fatalf("Step failed: %v", err)
+			}
+			if len(client.prompts) != 1 {
+				t.Fatalf("Expected 1 donation prompt, got %d", len(client.prompts))
+			}
+			found := strings.Contains(client.prompts[0], "The average player has 10.00 units")
+			if found != enabled {
+				t.Errorf("public stats enabled=%v but average resources in prompt=%v:\n%s", enabled, found, client.prompts[0])
+			}
+		}
+	})
+
+	t.Run("test unparseable donation is retried", func(t *testing.T) {
+		env := NewDonorGameEnvironment(3, 2.0, 10.0)
+		client := &mockClient{responses: []string{"I'll give a few units."}, response: "ANSWER: 3"}
+		for _, id := range []string{"agent1", "agent2"} {
+			if err := env.AddAgent(newTestAgent(t, id, client)); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+
+		if err := env.Step(context.Background()); err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+
+		state := env.GetState()
+		if state.SuccessfulDonations != 1 || state.FailedDonations != 0 {
+			t.Errorf("donations = (%d successful, %d failed), want (1, 0)", state.SuccessfulDonations, state.FailedDonations)
+		}
+		var total float64
+		for _, r := range state.AgentResources {
+			total += r
+		}
+		if total != 23.0 {
+			t.Errorf("total resources = %.2f, want 23.00", total)
+		}
+		if len(client.prompts) != 2 || !strings.Contains(client.prompts[1], "I'll give a few units.") {
+			t.Errorf("Expected a retry prompt quoting the unparseable response, got %v", client.prompts)
+		}
+	})
+
+	t.Run("test memory strings use the configured precision", func(t *testing.T) {
+		env := NewDonorGameEnvironment(3, 2.0, 10.0, WithPrecision(4))
+		client := &mockClient{response: "ANSWER: 1"}
+		donor := newTestAgent(t, "agent1", client)
+		for _, a := range []*agent.DonorGameAgent{donor, newTestAgent(t, "agent2", client)} {
+			if err := env.AddAgent(a); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", a.GetID(), err)
+			}
+		}
+
+		env.applyDonations(context.Background(), []donation{
+			{donorID: "agent1", recipientID: "agent2", amount: 1.23456},
+		})
+
+		memories := donor.GetMemory().GetAllMessages()
+		if len(memories) != 1 {
+			t.Fatalf("Expected 1 donor memory, got %d", len(memories))
+		}
+		if !strings.Contains(memories[0], "(1.2346)") || !strings.Contains(memories[0], "leaving me with 8.7654 resources") {
+			t.Errorf("Donor memory not formatted with 4 decimals: %s", memories[0])
+		}
+	})
+
+	t.Run("test donor with zero resources stores a finite percentage", func(t *testing.T) {
+		env := NewDonorGameEnvironment(3, 2.0, 10.0)
+		client := &mockClient{response: "ANSWER: 0"}
+		donor := newTestAgent(t, "agent1", client)
+		for _, a := range []*agent.DonorGameAgent{donor, newTestAgent(t, "agent2", client)} {
+			if err := env.AddAgent(a); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", a.GetID(), err)
+			}
+		}
+
+		// The first donation gives everything away, the second is made from an empty balance
+		env.applyDonations(context.Background(), []donation{{donorID: "agent1", recipientID: "agent2", amount: 10}})
+		env.applyDonations(context.Background(), []donation{{donorID: "agent1", recipientID: "agent2", amount: 0}})
+
+		memories := donor.GetMemory().GetAllMessages()
+		if len(memories) != 2 {
+			t.Fatalf("Expected 2 donor memories, got %d", len(memories))
+		}
+		for _, memory := range memories {
+			if strings.Contains(memory, "Inf") || strings.Contains(memory, "NaN") {
+				t.Errorf("Donor memory has a non-finite percentage: %s", memory)
+			}
+		}
+		if !strings.Contains(memories[1], "donated 0.00% (0.00)") {
+			t.Errorf("Expected a 0%% donation from an empty balance, got: %s", memories[1])
+		}
+	})
+
+	t.Run("test intergroup stats separate donations within and across groups", func(t *testing.T) {
+		groups := map[string]string{"a1": "red", "a2": "red", "b1": "blue", "b2": "blue"}
+		env := NewDonorGameEnvironment(3, 2.0, 10.0, WithGroups(groups))
+		client := &mockClient{response: "ANSWER: 1"}
+		for _, id := range []string{"a1", "a2", "b1", "b2"} {
+			if err := env.AddAgent(newTestAgent(t, id, client)); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+
+		env.applyDonations(context.Background(), []donation{
+			{donorID: "a1", recipientID: "a2", amount: 1},
+			{donorID: "b1", recipientID: "b2", amount: 2},
+			{donorID: "a2", recipientID: "b1", amount: 3},
+			{donorID: "b2", recipientID: "a1", amount: 0.5},
+		})
+
+		stats := env.GetIntergroupStats()
+		want := IntergroupStats{IntraDonations: 2, IntraAmount: 3, InterDonations: 2, InterAmount: 3.5}
+		if stats != want {
+			t.Errorf("GetIntergroupStats() = %+v, want %+v", stats, want)
+		}
+
+		if got := env.groupSummary("a1", "b1"); got != "b1 belongs to group blue. You belong to group red." {
+			t.Errorf("groupSummary = %q", got)
+		}
+	})
+
+	t.Run("test finish reasons are tallied", func(t *testing.T) {
+		env := NewDonorGameEnvironment(10, 2.0, 10.0)
+		client := &finishReasonClient{reasons: []string{
+			providers.FinishStop, providers.FinishLength, providers.FinishStop, providers.FinishContentFilter, providers.FinishStop,
+		}}
+		for _, id := range []string{"agent1", "agent2"} {
+			if err := env.AddAgent(newTestAgent(t, id, client)); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+
+		for round := 0; round < 5; round++ {
+			if err := env.Step(context.Background()); err != nil {
+				t.Fatalf("Step failed: %v", err)
+			}
+		}
+
+		want := map[string]int{providers.FinishStop: 3, providers.FinishLength: 1, providers.FinishContentFilter: 1}
+		got := env.GetState().FinishReasons
+		if len(got) != len(want) {
+			t.Fatalf("FinishReasons = %v, want %v", got, want)
+		}
+		for reason, n := range want {
+			if got[reason] != n {
+				t.Errorf("FinishReasons[%s] = %d, want %d", reason, got[reason], n)
+			}
+		}
+	})
+
+	t.Run("test step result lists the donations made", func(t *testing.T) {
+		env := NewDonorGameEnvironment(10, 2.0, 10.0, WithSeed(7))
+		client := &mockClient{response: "ANSWER: 2"}
+		for _, id := range []string{"agent1", "agent2", "agent3", "agent4"} {
+			if err := env.AddAgent(newTestAgent(t, id, client)); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+
+		result, err := env.StepWithResult(context.Background())
+		if err != nil {
+			t.Fatalf("StepWithResult failed: %v", err)
+		}
+		if result.Round != 0 || len(result.Errors) != 0 {
+			t.Errorf("result = %+v, want round 0 without errors", result)
+		}
+		if len(result.Donations) != 2 {
+			t.Fatalf("got %d donations, want 2", len(result.Donations))
+		}
+
+		state := env.GetState()
+		for _, d := range result.Donations {
+			if d.Amount != 2 || d.Received != 4 {
+				t.Errorf("donation %+v, want 2 given and 4 received", d)
+			}
+			if got := state.AgentResources[d.DonorID]; got != 8 {
+				t.Errorf("donor %s has %.2f resources, want 8.00", d.DonorID, got)
+			}
+			if got := state.AgentResources[d.RecipientID]; got != 14 {
+				t.Errorf("recipient %s has %.2f resources, want 14.00", d.RecipientID, got)
+			}
+			if result.ResourceDeltas[d.DonorID] != -2 || result.ResourceDeltas[d.RecipientID] != 4 {
+				t.Errorf("deltas = %v, want -2 for %s and 4 for %s", result.ResourceDeltas, d.DonorID, d.RecipientID)
+			}
+		}
+	})
+
+	t.Run("test shuffled agents are sampled uniformly", func(t *testing.T) {
+		env := NewDonorGameEnvironment(3, 2.0, 10.0, WithSeed(42))
+		client := &mockClient{response: "ANSWER: 1"}
+		ids := []string{"agent1", "agent2", "agent3", "agent4"}
+		for _, id := range ids {
+			if err := env.AddAgent(newTestAgent(t, id, client)); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+
+		// Count how often each agent is sampled as the first of the shuffled list
+		const samples = 4000
+		counts := make(map[string]int)
+		for range samples {
+			agents := env.GetAgentsShuffled()
+			if len(agents) != len(ids) {
+				t.Fatalf("Expected %d agents, got %d", len(ids), len(agents))
+			}
+			counts[agents[0].GetID()]++
+		}
+
+		want := samples / len(ids)
+		for _, id := range ids {
+			if got := counts[id]; got < want*8/10 || got > want*12/10 {
+				t.Errorf("%s sampled first %d times, want about %d", id, got, want)
+			}
+		}
+		if got := env.GetAgents()[0].GetID(); got != "agent1" {
+			t.Errorf("GetAgents should keep insertion order, first agent = %s", got)
+		}
+	})
+
+	t.Run("test donor memory is stored when the recipient was added first", func(t *testing.T) {
+		env := NewDonorGameEnvironment(3, 2.0, 10.0)
+		client := &mockClient{response: "ANSWER: 0"}
+		for _, id := range []string{"agent1", "agent2"} {
+			if err := env.AddAgent(newTestAgent(t, id, client)); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+
+		env.applyDonations(context.Background(), []donation{{donorID: "agent2", recipientID: "agent1", amount: 2}})
+
+		for _, a := range env.GetAgents() {
+			if got := len(a.GetMemory().GetAllMessages()); got != 1 {
+				t.Errorf("%s has %d memories, want 1", a.GetID(), got)
+			}
+		}
+	})
+
+	t.Run("test history noise corrupts donations shown to donors", func(t *testing.T) {
+		for _, tc := range []struct {
+			name  string
+			noise HistoryNoise
+			want  string
+		}{
+			{"misreport", NoiseMisreport, "Round: I donated 0.14% (1.96) of my resources to agent1, leaving me with 12.04 resources"},
+			{"omit", NoiseOmit, ""},
+		} {
+			env := NewDonorGameEnvironment(3, 2.0, 10.0, WithSeed(3), WithHistoryNoise(1.0, tc.noise))
+			client := &mockClient{response: "ANSWER: 0"}
+			for _, id := range []string{"agent1", "agent2"} {
+				if err := env.AddAgent(newTestAgent(t, id, client)); err != nil {
+					t.Fatalf("Failed to add agent %s: %v", id, err)
+				}
+			}
+			env.applyDonations(context.Background(), []donation{
+				{donorID: "agent1", recipientID: "agent2", amount: 2},
+				{donorID: "agent2", recipientID: "agent1", amount: 12},
+			})
+
+			if err := env.Step(context.Background()); err != nil {
+				t.Fatalf("%s: Step failed: %v", tc.name, err)
+			}
+			if len(client.prompts) != 1 {
+				t.Fatalf("%s: got %d prompts, want 1", tc.name, len(client.prompts))
+			}
+			// agent1 donates to agent2 under this seed, so it is shown agent2's history
+			prompt := client.prompts[0]
+			if !strings.Contains(prompt, "you have been paired with agent2") {
+				t.Fatalf("%s: expected agent1 to be paired with agent2:\n%s", tc.name, prompt)
+			}
+			if strings.Contains(prompt, "I donated 0.86% (12.00)") {
+				t.Errorf("%s: prompt contains the uncorrupted donation:\n%s", tc.name, prompt)
+			}
+			if tc.want != "" && !strings.Contains(prompt, tc.want) {
+				t.Errorf("%s: expected %q in prompt:\n%s", tc.name, tc.want, prompt)
+			}
+			if tc.noise == NoiseOmit && strings.Contains(prompt, "I donated") {
+				t.Errorf("%s: expected donations to be omitted from prompt:\n%s", tc.name, prompt)
+			}
+		}
+	})
+
+	t.Run("test history noise draws from its own generator", func(t *testing.T) {
+		env := NewDonorGameEnvironment(3, 2.0, 10.0, WithSeed(3), WithHistoryNoise(0.5, NoiseMisreport))
+		memories := []string{
+			"Round: I donated 0.20% (2.00) of my resources to agent2, leaving me with 8.00 resources",
+			"Round: I donated 0.10% (0.50) of my resources to agent4, leaving me with 4.50 resources",
+			"Round: I donated 0.40% (1.80) of my resources to agent5, leaving me with 2.70 resources",
+		}
+
+		want := []string{
+			"Round: I donated 0.80% (8.00) of my resources to agent2, leaving me with 2.00 resources",
+			"Round: I donated 0.10% (0.50) of my resources to agent4, leaving me with 4.50 resources",
+			"Round: I donated 0.60% (2.70) of my resources to agent5, leaving me with 1.80 resources",
+		}
+		if got := env.addHistoryNoise(memories); !slices.Equal(got, want) {
+			t.Errorf("noisy history = %q, want %q", got, want)
+		}
+		if state := env.RNGState(); state.Draws != 0 || state.NoiseDraws != 3 {
+			t.Errorf("draws = %d and noise draws = %d, want 0 and 3", state.Draws, state.NoiseDraws)
+		}
+	})
+
+	t.Run("test recipient history is truncated to the token limit", func(t *testing.T) {
+		const limit = 40
+		env := NewDonorGameEnvironment(3, 2.0, 10.0, WithHistoryTokenLimit(limit))
+		recipient := newTestAgent(t, "agent1", &mockClient{response: "ANSWER: 1"})
+		if err := env.AddAgent(recipient); err != nil {
+			t.Fatalf("Failed to add agent: %v", err)
+		}
+		verbose := strings.Repeat("the other agent explained its reasoning at length ", 10)
+		latest := "Round: I donated 0.50% (5.00) of my resources to agent2, leaving me with 5.00 resources"
+		for _, entry := range []string{"oldest: " + verbose, "older: " + verbose, latest} {
+			if err := recipient.GetMemory().Store(entry); err != nil {
+				t.Fatalf("Failed to store memory: %v", err)
+			}
+		}
+
+		history := env.getRecentHistory("agent1", nil)
+		if tokens := memory.CountTokens(history); tokens > limit {
+			t.Errorf("history has %d tokens, want at most %d:\n%s", tokens, limit, history)
+		}
+		if !strings.HasSuffix(history, latest) {
+			t.Errorf("history doesn't end with the latest interaction:\n%s", history)
+		}
+		if strings.Contains(history, "oldest:") || strings.Contains(history, "older:") {
+			t.Errorf("history kept verbose entries that don't fit:\n%s", history)
+		}
+	})
+
+	t.Run("test punishment costs the donor x and the recipient twice that", func(t *testing.T) {
+		for _, enabled := range []bool{true, false} {
+			var opts []DonorGameOption
+			if enabled {
+				opts = append(opts, WithPunishment())
+			}
+			env := NewDonorGameEnvironment(3, 2.0, 10.0, append(opts, WithSeed(7))...)
+			client := &mockClient{response: "They kept everything. ANSWER: 2\nPUNISH: 1"}
+			t.Setenv("OPENAI_API_KEY", "test-key")
+			for _, id := range []string{"agent1", "agent2"} {
+				a, err := agent.NewDonorGameAgent(context.Background(), id, "donate half",
+					agent.WithProvider(client), agent.WithPunishment())
+				if err != nil {
+					t.Fatalf("Failed to create agent %s: %v", id, err)
+				}
+				if err := env.AddAgent(a); err != nil {
+					t.Fatalf("Failed to add agent %s: %v", id, err)
+				}
+			}
+
+			result, err := env.StepWithResult(context.Background())
+			if err != nil {
+				t.Fatalf("StepWithResult failed: %v", err)
+			}
+			if len(result.Donations) != 1 {
+				t.Fatalf("got %d donations, want 1", len(result.Donations))
+			}
+			d := result.Donations[0]
+			state := env.GetState()
+
+			wantDonor, wantRecipient, wantPunishments := 7.0, 12.0, 1
+			if !enabled {
+				wantDonor, wantRecipient, wantPunishments = 8, 14, 0
+			}
+			if got := state.AgentResources[d.DonorID]; got != wantDonor {
+				t.Errorf("punishment %t: donor has %.2f resources, want %.2f", enabled, got, wantDonor)
+			}
+			if got := state.AgentResources[d.RecipientID]; got != wantRecipient {
+				t.Errorf("punishment %t: recipient has %.2f resources, want %.2f", enabled, got, wantRecipient)
+			}
+			if state.TotalPunishments != wantPunishments || state.PunishmentSpent != float64(wantPunishments) {
+				t.Errorf("punishment %t: %d punishments spending %.2f, want %d", enabled, state.TotalPunishments, state.PunishmentSpent, wantPunishments)
+			}
+			if delta := result.ResourceDeltas[d.RecipientID]; delta != wantRecipient-10 {
+				t.Errorf("punishment %t: recipient delta = %.2f, want %.2f", enabled, delta, wantRecipient-10)
+			}
+		}
+	})
+
+	t.Run("test generation seeds reproduce pairings despite unrelated draws", func(t *testing.T) {
+		ids := []string{"agent1", "agent2", "agent3", "agent4", "agent5", "agent6"}
+		client := &mockClient{response: "ANSWER: 5"}
+		// pairings runs three generations of two rounds each, drawing extra values from the RNG
+		// in generation 1 before its rounds to stand in for an unrelated change
+		pairings := func(opts []DonorGameOption, extraDraws int) [][]string {
+			env := NewDonorGameEnvironment(2, 2.0, 10.0, append(opts, WithSeed(42))...)
+			for _, id := range ids {
+				if err := env.AddAgent(newTestAgent(t, id, client)); err != nil {
+					t.Fatalf("Failed to add agent %s: %v", id, err)
+				}
+			}
+			var orders [][]string
+			for generation := 1; generation <= 3; generation++ {
+				env.SeedGeneration(generation)
+				if generation == 1 {
+					for i := 0; i < extraDraws; i++ {
+						env.rng.Float64()
+					}
+				}
+				for round := 0; round < 2; round++ {
+					var order []string
+					for _, a := range shuffled(env.rng, env.GetAgents()) {
+						order = append(order, a.GetID())
+					}
+					orders = append(orders, order)
+				}
+			}
+			return orders
+		}
+
+		seeded := []DonorGameOption{WithGenerationSeeds()}
+		want, got := pairings(seeded, 0), pairings(seeded, 3)
+		for i := 2; i < len(want); i++ {
+			if fmt.Sprint(got[i]) != fmt.Sprint(want[i]) {
+				t.Errorf("generation %d round %d pairing = %v, want %v", i/2+1, i%2, got[i], want[i])
+			}
+		}
+		if fmt.Sprint(pairings(seeded, 0)) != fmt.Sprint(want) {
+			t.Error("Expected runs with the same master seed to produce the same pairings")
+		}
+
+		// A single stream shifts every later pairing
+		if fmt.Sprint(pairings(nil, 0)[2:]) == fmt.Sprint(pairings(nil, 3)[2:]) {
+			t.Error("Expected extra draws to change later pairings without generation seeds")
+		}
+	})
+
+	t.Run("test gossip reaches the other players' donation prompts", func(t *testing.T) {
+		t.Setenv("OPENAI_API_KEY", "test-key")
+		broker := messaging.NewBroker()
+		env := NewDonorGameEnvironment(3, 2.0, 10.0, WithSeed(1), WithGossip())
+		clients := make(map[string]*gossipClient)
+		for _, id := range []string{"agent1", "agent2", "agent3", "agent4"} {
+			clients[id] = &gossipClient{gossip: id + " keeps their word."}
+			a, err := agent.NewDonorGameAgent(context.Background(), id, "donate half",
+				agent.WithProvider(clients[id]), agent.WithMessageBroker(broker))
+			if err != nil {
+				t.Fatalf("Failed to create agent %s: %v", id, err)
+			}
+			t.Cleanup(func() { a.Unsubscribe() })
+			if err := env.AddAgent(a); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+
+		result, err := env.StepWithResult(context.Background())
+		if err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+		// Every agent hears the two notes about each of the partners it wasn't paired with, and
+		// keeps them apart from its memory
+		for _, a := range env.GetAgents() {
+			if heard := a.HeardGossip(); len(heard) != 3 {
+				t.Errorf("agent %s heard %d notes, want 3", a.GetID(), len(heard))
+			}
+			for _, m := range a.GetMemory().GetAllMessages() {
+				if strings.HasPrefix(m, agent.GOSSIP_ENTRY_PREFIX) {
+					t.Errorf("agent %s stored gossip in its memory: %s", a.GetID(), m)
+				}
+			}
+		}
+
+		// Gossip is shown to donors but doesn't displace the recipient's own interactions
+		d := result.Donations[0]
+		var listener *agent.DonorGameAgent
+		for _, a := range env.GetAgents() {
+			if a.GetID() != d.DonorID && a.GetID() != d.RecipientID {
+				listener = a
+				break
+			}
+		}
+		history := env.getRecentHistory(d.RecipientID, listener)
+		if !strings.Contains(history, "What other players have said about "+d.RecipientID) ||
+			!strings.Contains(history, d.DonorID+" said about "+d.RecipientID) {
+			t.Errorf("Expected the donor's gossip about %s in history:\n%s", d.RecipientID, history)
+		}
+		if !strings.HasPrefix(history, "Round: I received") {
+			t.Errorf("Expected the recipient's interaction first in history:\n%s", history)
+		}
+	})
+
+	t.Run("test gossip in populations larger than an inbox is delivered", func(t *testing.T) {
+		t.Setenv("OPENAI_API_KEY", "test-key")
+		broker := messaging.NewBroker()
+		env := NewDonorGameEnvironment(1, 2.0, 10.0, WithSeed(1), WithGossip())
+		for i := range 110 {
+			id := fmt.Sprintf("agent%d", i)
+			a, err := agent.NewDonorGameAgent(context.Background(), id, "donate half",
+				agent.WithProvider(&gossipClient{gossip: id + " keeps their word."}), agent.WithMessageBroker(broker))
+			if err != nil {
+				t.Fatalf("Failed to create agent %s: %v", id, err)
+			}
+			t.Cleanup(func() { a.Unsubscribe() })
+			if err := env.AddAgent(a); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+		if capacity := env.GetAgents()[0].InboxCapacity(); capacity >= 109 {
+			t.Fatalf("inbox capacity = %d, want fewer than the 109 notes each agent hears", capacity)
+		}
+
+		if err := env.Step(context.Background()); err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+		if got := env.GetState().GossipUndelivered; got != 0 {
+			t.Errorf("undelivered gossip = %d, want 0", got)
+		}
+		if dead := broker.DeadLetters(); len(dead) != 0 {
+			t.Errorf("got %d dead letters, want none", len(dead))
+		}
+		// Only the newest notes are kept
+		for _, a := range env.GetAgents() {
+			if heard := a.HeardGossip(); len(heard) != agent.MAX_GOSSIP {
+				t.Errorf("agent %s kept %d notes, want %d", a.GetID(), len(heard), agent.MAX_GOSSIP)
+			}
+		}
+	})
+
+	t.Run("test concurrent donor decisions are limited", func(t *testing.T) {
+		env := NewDonorGameEnvironment(1, 2.0, 10.0, WithConcurrency(3))
+		client := &concurrentClient{delay: 20 * time.Millisecond}
+		for i := range 20 {
+			if err := env.AddAgent(newTestAgent(t, fmt.Sprintf("agent%d", i), client)); err != nil {
+				t.Fatalf("Failed to add agent: %v", err)
+			}
+		}
+
+		result, err := env.StepWithResult(context.Background())
+		if err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+		if client.maxSeen > 3 {
+			t.Errorf("%d calls ran at once, want at most 3", client.maxSeen)
+		}
+		if len(result.Donations) != 10 || env.GetState().SuccessfulDonations != 10 {
+			t.Errorf("got %d donations and %d successful, want all 10 pairs to donate",
+				len(result.Donations), env.GetState().SuccessfulDonations)
+		}
+	})
+
+	t.Run("test odd population rotates byes", func(t *testing.T) {
+		env := NewDonorGameEnvironment(3, 2.0, 10.0, WithSeed(5))
+		client := &mockClient{response: "ANSWER: 1"}
+		for _, id := range []string{"agent1", "agent2", "agent3", "agent4", "agent5"} {
+			if err := env.AddAgent(newTestAgent(t, id, client)); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+
+		expectedTotal := 50.0
+		for round := 0; round < 3; round++ {
+			before := env.GetState().AgentResources
+			result, err := env.StepWithResult(context.Background())
+			if err != nil {
+				t.Fatalf("round %d: Step failed: %v", round, err)
+			}
+			if result.Bye == "" || len(result.Donations) != 2 {
+				t.Fatalf("round %d: result = %+v, want a bye and 2 donations", round, result)
+			}
+			for _, d := range result.Donations {
+				if d.DonorID == result.Bye || d.RecipientID == result.Bye {
+					t.Errorf("round %d: %s had a bye but took part in %+v", round, result.Bye, d)
+				}
+				expectedTotal += d.Received - d.Amount
+			}
+			after := env.GetState().AgentResources
+			if after[result.Bye] != before[result.Bye] {
+				t.Errorf("round %d: %s's resources changed from %.2f to %.2f during its bye", round, result.Bye, before[result.Bye], after[result.Bye])
+			}
+			var total float64
+			for _, r := range after {
+				total += r
+			}
+			if total != expectedTotal {
+				t.Errorf("round %d: total resources = %.2f, want %.2f", round, total, expectedTotal)
+			}
+		}
+
+		// Nobody sits out twice before everyone has sat out once
+		byes := env.GetState().Byes
+		if len(byes) != 3 {
+			t.Errorf("byes = %v, want 3 different agents to have sat out once", byes)
+		}
+		for id, n := range byes {
+			if n != 1 {
+				t.Errorf("agent %s sat out %d rounds, want 1", id, n)
+			}
+		}
+	})
+}
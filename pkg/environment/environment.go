@@ -2,6 +2,7 @@ package environment
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
@@ -10,6 +11,22 @@ import (
 	"github.com/boristopalov/petri/pkg/agent"
 )
 
+// ErrAgentNotFound is returned when an agent is not present in an environment
+var ErrAgentNotFound = errors.New("agent not found")
+
+// subscriber is implemented by agents that are subscribed to a message broker
+type subscriber interface {
+	Unsubscribe() error
+}
+
+// unsubscribe removes an agent's broker subscription if it has one
+func unsubscribe(a any) error {
+	if s, ok := a.(subscriber); ok {
+		return s.Unsubscribe()
+	}
+	return nil
+}
+
 // State represents the basic state any environment must track
 type State interface {
 	GetStatus() string
@@ -80,15 +97,20 @@ func (e *BaseEnvironment[A, S]) AddAgent(agent A) error {
 }
 
 func (e *BaseEnvironment[A, S]) RemoveAgent(agent A) error {
+	return e.RemoveAgentByID(agent.GetID())
+}
+
+// RemoveAgentByID removes the agent with the given ID and unsubscribes it from its message broker
+func (e *BaseEnvironment[A, S]) RemoveAgentByID(id string) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	for i, a := range e.agents {
-		if a.GetID() == agent.GetID() {
+		if a.GetID() == id {
 			e.agents = append(e.agents[:i], e.agents[i+1:]...)
-			return nil
+			return unsubscribe(a)
 		}
 	}
-	return fmt.Errorf("agent not found")
+	return fmt.Errorf("%w: %s", ErrAgentNotFound, id)
 }
 
 func (e *BaseEnvironment[A, S]) GetAgents() []A {
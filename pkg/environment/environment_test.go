@@ -0,0 +1,49 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/boristopalov/petri/pkg/agent"
+	"github.com/boristopalov/petri/pkg/messaging"
+)
+
+func TestBaseEnvironment(t *testing.T) {
+	t.Run("test remove agent by ID unsubscribes it", func(t *testing.T) {
+		t.Setenv("OPENAI_API_KEY", "test-key")
+		ctx := context.Background()
+		broker := messaging.NewBroker()
+		env := NewBaseEnvironment[*agent.LLMAgent, BaseState](BaseState{Status: "idle", Timestamp: time.Now()})
+
+		for _, id := range []string{"agent1", "agent2"} {
+			a, err := agent.NewLLMAgent(ctx,
+				agent.WithAgentId(id),
+				agent.WithMessageBroker(broker),
+				agent.WithProvider(&mockClient{response: "hello"}),
+			)
+			if err != nil {
+				t.Fatalf("Failed to create agent %s: %v", id, err)
+			}
+			if err := env.AddAgent(a); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+
+		if err := env.RemoveAgentByID("agent1"); err != nil {
+			t.Fatalf("Failed to remove agent: %v", err)
+		}
+		if got := len(env.GetAgents()); got != 1 {
+			t.Errorf("len(GetAgents()) = %d, want 1", got)
+		}
+		// The agent's ID should be free to subscribe again
+		if err := broker.Subscribe("agent1", make(chan messaging.Message, 1)); err != nil {
+			t.Errorf("agent1 still subscribed to broker: %v", err)
+		}
+
+		if err := env.RemoveAgentByID("agent1"); !errors.Is(err, ErrAgentNotFound) {
+			t.Errorf("Expected ErrAgentNotFound removing a missing agent, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,257 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/boristopalov/petri/pkg/agent"
+)
+
+// ErrGridFull is returned when an agent is added to a grid with no free cells
+var ErrGridFull = errors.New("grid is full")
+
+// GridAgent is an agent that can address its turn to chosen agents, so a GridEnvironment can
+// limit it to its neighbors
+type GridAgent interface {
+	agent.Agent
+	RunTo(ctx context.Context, to []string) (string, error)
+}
+
+// Cell is a position on a grid. X is the column and Y the row, both counted from 0.
+type Cell struct {
+	X, Y int
+}
+
+// GridState extends State with the layout of a grid
+type GridState struct {
+	BaseState State
+	Width     int
+	Height    int
+	Positions map[string]Cell // maps agent ID to the cell it occupies
+	Turns     int             // number of turns agents have taken
+}
+
+func (s GridState) GetStatus() string {
+	return s.BaseState.GetStatus()
+}
+
+func (s GridState) GetStep() uint32 {
+	return s.BaseState.GetStep()
+}
+
+func (s GridState) GetTimestamp() time.Time {
+	return s.BaseState.GetTimestamp()
+}
+
+// GridEnvironment places agents on the cells of a width by height grid. Each agent only
+// interacts with its Moore neighborhood, the up to eight agents in the cells around it: a
+// step sends each agent's turn to its neighbors alone, so the messages it sees come from them.
+type GridEnvironment[A GridAgent] struct {
+	agents    []A
+	occupants map[Cell]A
+	state     GridState
+	mu        sync.RWMutex
+}
+
+// NewGridEnvironment creates an empty width by height grid
+func NewGridEnvironment[A GridAgent](width, height int) *GridEnvironment[A] {
+	return &GridEnvironment[A]{
+		agents:    make([]A, 0),
+		occupants: make(map[Cell]A),
+		state:     newGridState(width, height),
+	}
+}
+
+func newGridState(width, height int) GridState {
+	return GridState{
+		BaseState: BaseState{
+			Status:    "idle",
+			Step:      0,
+			Timestamp: time.Now(),
+		},
+		Width:     width,
+		Height:    height,
+		Positions: make(map[string]Cell),
+	}
+}
+
+// AddAgent places an agent in the first free cell, going row by row from the top left
+func (e *GridEnvironment[A]) AddAgent(a A) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for y := range e.state.Height {
+		for x := range e.state.Width {
+			cell := Cell{X: x, Y: y}
+			if _, taken := e.occupants[cell]; !taken {
+				return e.place(a, cell)
+			}
+		}
+	}
+	return fmt.Errorf("%w: no cell for agent %s", ErrGridFull, a.GetID())
+}
+
+// PlaceAgent places an agent in the given cell, which must be on the grid and free
+func (e *GridEnvironment[A]) PlaceAgent(a A, cell Cell) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if cell.X < 0 || cell.X >= e.state.Width || cell.Y < 0 || cell.Y >= e.state.Height {
+		return fmt.Errorf("cell (%d, %d) is outside the %dx%d grid", cell.X, cell.Y, e.state.Width, e.state.Height)
+	}
+	if occupant, taken := e.occupants[cell]; taken {
+		return fmt.Errorf("cell (%d, %d) is taken by agent %s", cell.X, cell.Y, occupant.GetID())
+	}
+	return e.place(a, cell)
+}
+
+// place puts an agent in a free cell
+func (e *GridEnvironment[A]) place(a A, cell Cell) error {
+	id := a.GetID()
+	if _, ok := e.state.Positions[id]; ok {
+		return fmt.Errorf("agent %s is already on the grid", id)
+	}
+	e.agents = append(e.agents, a)
+	e.occupants[cell] = a
+	e.state.Positions[id] = cell
+	return nil
+}
+
+// RemoveAgent removes an agent from the grid, freeing its cell
+func (e *GridEnvironment[A]) RemoveAgent(a A) error {
+	return e.RemoveAgentByID(a.GetID())
+}
+
+// RemoveAgentByID removes the agent with the given ID, freeing its cell, and unsubscribes it from
+// its message broker
+func (e *GridEnvironment[A]) RemoveAgentByID(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, a := range e.agents {
+		if a.GetID() == id {
+			e.agents = append(e.agents[:i], e.agents[i+1:]...)
+			delete(e.occupants, e.state.Positions[id])
+			delete(e.state.Positions, id)
+			return unsubscribe(a)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrAgentNotFound, id)
+}
+
+// GetAgents returns a copy of the agents on the grid in the order they were added
+func (e *GridEnvironment[A]) GetAgents() []A {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return slices.Clone(e.agents)
+}
+
+// Position returns the cell of the agent with the given ID and whether it is on the grid
+func (e *GridEnvironment[A]) Position(agentID string) (Cell, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	cell, ok := e.state.Positions[agentID]
+	return cell, ok
+}
+
+// GetNeighbors returns the agents in the Moore neighborhood of the agent with the given ID, going
+// row by row from the top left. The grid doesn't wrap, so agents on an edge have fewer neighbors.
+// An agent that isn't on the grid has none.
+func (e *GridEnvironment[A]) GetNeighbors(agentID string) []A {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.neighbors(agentID)
+}
+
+func (e *GridEnvironment[A]) neighbors(agentID string) []A {
+	cell, ok := e.state.Positions[agentID]
+	if !ok {
+		return nil
+	}
+	var neighbors []A
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			if dx == 0 && dy == 0 {
+				continue
+			}
+			if a, ok := e.occupants[Cell{X: cell.X + dx, Y: cell.Y + dy}]; ok {
+				neighbors = append(neighbors, a)
+			}
+		}
+	}
+	return neighbors
+}
+
+// GetState returns the current state of the grid
+func (e *GridEnvironment[A]) GetState() GridState {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	state := e.state
+	state.Positions = maps.Clone(e.state.Positions)
+	return state
+}
+
+// Reset removes every agent from the grid and clears the state, keeping the grid's size
+func (e *GridEnvironment[A]) Reset() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Unsubscribe the agents so the broker doesn't accumulate stale subscriptions
+	for _, a := range e.agents {
+		if err := unsubscribe(a); err != nil {
+			log.Printf("Warning: Failed to unsubscribe agent %s: %v", a.GetID(), err)
+		}
+	}
+	e.agents = make([]A, 0)
+	e.occupants = make(map[Cell]A)
+	e.state = newGridState(e.state.Width, e.state.Height)
+	return nil
+}
+
+// Step runs every agent that has neighbors in parallel and sends each one's turn only to its
+// neighbors. Agents with no neighbors sit the step out.
+func (e *GridEnvironment[A]) Step(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	turns := 0
+	for _, a := range e.agents {
+		neighbors := e.neighbors(a.GetID())
+		if len(neighbors) == 0 {
+			continue
+		}
+		to := make([]string, len(neighbors))
+		for i, n := range neighbors {
+			to[i] = n.GetID()
+		}
+
+		wg.Add(1)
+		go func(a A) {
+			defer wg.Done()
+			if _, err := a.RunTo(ctx, to); err != nil {
+				log.Printf("error running agent %s: %s", a.GetID(), err)
+				return
+			}
+			mu.Lock()
+			turns++
+			mu.Unlock()
+		}(a)
+	}
+	wg.Wait()
+
+	e.state.Turns += turns
+	e.state.BaseState = BaseState{
+		Status:    e.state.GetStatus(),
+		Step:      e.state.GetStep() + 1,
+		Timestamp: time.Now(),
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/boristopalov/petri/pkg/agent"
+	"github.com/boristopalov/petri/pkg/messaging"
+)
+
+func newTestGridAgent(t *testing.T, id string, broker messaging.Broker) *agent.LLMAgent {
+	t.Helper()
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	a, err := agent.NewLLMAgent(context.Background(),
+		agent.WithAgentId(id),
+		agent.WithMessageBroker(broker),
+		agent.WithProvider(&mockClient{response: "hello from " + id}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create agent %s: %v", id, err)
+	}
+	return a
+}
+
+// neighborIDs returns the IDs of agents in order
+func neighborIDs(agents []*agent.LLMAgent) []string {
+	ids := make([]string, len(agents))
+	for i, a := range agents {
+		ids[i] = a.GetID()
+	}
+	return ids
+}
+
+func TestGridEnvironment(t *testing.T) {
+	t.Run("test neighbors are the Moore neighborhood", func(t *testing.T) {
+		broker := messaging.NewBroker()
+		env := NewGridEnvironment[*agent.LLMAgent](3, 3)
+		for _, id := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i"} {
+			if err := env.AddAgent(newTestGridAgent(t, id, broker)); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+
+		tests := map[string][]string{
+			"a": {"b", "d", "e"},                          // corner
+			"b": {"a", "c", "d", "e", "f"},                // edge
+			"e": {"a", "b", "c", "d", "f", "g", "h", "i"}, // center
+		}
+		for id, want := range tests {
+			if got := neighborIDs(env.GetNeighbors(id)); !slices.Equal(got, want) {
+				t.Errorf("GetNeighbors(%s) = %v, want %v", id, got, want)
+			}
+		}
+		if cell, ok := env.Position("f"); !ok || cell != (Cell{X: 2, Y: 1}) {
+			t.Errorf("Position(f) = %v, %v, want (2, 1)", cell, ok)
+		}
+		if got := env.GetNeighbors("missing"); len(got) != 0 {
+			t.Errorf("GetNeighbors(missing) = %v, want none", neighborIDs(got))
+		}
+	})
+
+	t.Run("test full grid and taken cells are rejected", func(t *testing.T) {
+		broker := messaging.NewBroker()
+		env := NewGridEnvironment[*agent.LLMAgent](2, 1)
+		if err := env.PlaceAgent(newTestGridAgent(t, "a", broker), Cell{X: 1, Y: 0}); err != nil {
+			t.Fatalf("Failed to place agent: %v", err)
+		}
+		if err := env.PlaceAgent(newTestGridAgent(t, "b", broker), Cell{X: 1, Y: 0}); err == nil {
+			t.Error("Expected an error placing an agent in a taken cell")
+		}
+		if err := env.PlaceAgent(newTestGridAgent(t, "c", broker), Cell{X: 2, Y: 0}); err == nil {
+			t.Error("Expected an error placing an agent off the grid")
+		}
+		if err := env.AddAgent(newTestGridAgent(t, "d", broker)); err != nil {
+			t.Fatalf("Failed to add agent to the free cell: %v", err)
+		}
+		if cell, _ := env.Position("d"); cell != (Cell{X: 0, Y: 0}) {
+			t.Errorf("Position(d) = %v, want the free cell (0, 0)", cell)
+		}
+		if err := env.AddAgent(newTestGridAgent(t, "e", broker)); !errors.Is(err, ErrGridFull) {
+			t.Errorf("Expected ErrGridFull adding to a full grid, got %v", err)
+		}
+
+		if err := env.RemoveAgentByID("a"); err != nil {
+			t.Fatalf("Failed to remove agent: %v", err)
+		}
+		if err := env.AddAgent(newTestGridAgent(t, "f", broker)); err != nil {
+			t.Errorf("Failed to add agent to the freed cell: %v", err)
+		}
+	})
+
+	t.Run("test reset unsubscribes the agents", func(t *testing.T) {
+		broker := messaging.NewBroker()
+		env := NewGridEnvironment[*agent.LLMAgent](2, 2)
+		for _, id := range []string{"a", "b"} {
+			if err := env.AddAgent(newTestGridAgent(t, id, broker)); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+		agents := env.GetAgents()
+		agents[0] = nil
+		if env.GetAgents()[0] == nil {
+			t.Error("Expected GetAgents to return a copy")
+		}
+
+		if err := env.Reset(); err != nil {
+			t.Fatalf("Reset failed: %v", err)
+		}
+		if n := broker.SubscriberCount(); n != 0 {
+			t.Errorf("got %d subscribers after reset, want 0", n)
+		}
+		if err := env.AddAgent(newTestGridAgent(t, "a", broker)); err != nil {
+			t.Errorf("Failed to add an agent under a reset agent's ID: %v", err)
+		}
+	})
+
+	t.Run("test step only sends turns to neighbors", func(t *testing.T) {
+		broker := messaging.NewBroker()
+		env := NewGridEnvironment[*agent.LLMAgent](5, 1)
+		agents := make(map[string]*agent.LLMAgent)
+		for x, id := range []string{"a", "b", "c"} {
+			agents[id] = newTestGridAgent(t, id, broker)
+			if err := env.PlaceAgent(agents[id], Cell{X: x, Y: 0}); err != nil {
+				t.Fatalf("Failed to place agent %s: %v", id, err)
+			}
+		}
+		// An agent with no neighbors sits the step out
+		agents["loner"] = newTestGridAgent(t, "loner", broker)
+		if err := env.PlaceAgent(agents["loner"], Cell{X: 4, Y: 0}); err != nil {
+			t.Fatalf("Failed to place agent: %v", err)
+		}
+
+		if err := env.Step(context.Background()); err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+
+		want := map[string][]string{
+			"a":     {"b"},
+			"b":     {"a", "c"},
+			"c":     {"b"},
+			"loner": nil,
+		}
+		for id, senders := range want {
+			var got []string
+			for len(agents[id].Receive()) > 0 {
+				got = append(got, (<-agents[id].Receive()).From)
+			}
+			if !sameStrings(got, senders) {
+				t.Errorf("%s received messages from %v, want %v", id, got, senders)
+			}
+		}
+
+		state := env.GetState()
+		if state.Turns != 3 || state.GetStep() != 1 {
+			t.Errorf("Turns = %d, Step = %d, want 3 turns in 1 step", state.Turns, state.GetStep())
+		}
+	})
+}
+
+// sameStrings reports whether a and b hold the same strings in any order
+func sameStrings(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
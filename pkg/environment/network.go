@@ -0,0 +1,209 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/boristopalov/petri/pkg/agent"
+)
+
+// NetworkState extends State with the size of a network
+type NetworkState struct {
+	BaseState State
+	Nodes     int // number of nodes in the network
+	Edges     int // number of edges between them
+}
+
+func (s NetworkState) GetStatus() string {
+	return s.BaseState.GetStatus()
+}
+
+func (s NetworkState) GetStep() uint32 {
+	return s.BaseState.GetStep()
+}
+
+func (s NetworkState) GetTimestamp() time.Time {
+	return s.BaseState.GetTimestamp()
+}
+
+// NetworkEnvironment connects agents along the edges of an interaction graph, such as a
+// small-world or scale-free network. It implements messaging.Topology: agents sharing a broker
+// wrapped with messaging.NewTopologyBroker(broker, env) only hear from their neighbors, and
+// NewNetworkPairing pairs them only with their neighbors.
+type NetworkEnvironment[A agent.Agent] struct {
+	agents  []A
+	present map[string]bool            // IDs of the agents in the network
+	edges   map[string]map[string]bool // maps each node to the nodes it is connected to
+	state   NetworkState
+	mu      sync.RWMutex
+}
+
+// NewNetworkEnvironment creates a network from an adjacency list mapping each agent ID to the IDs
+// it is connected to. Edges go both ways, so each only needs to be listed once, and an agent
+// listed as connected to itself isn't.
+func NewNetworkEnvironment[A agent.Agent](edges map[string][]string) *NetworkEnvironment[A] {
+	e := &NetworkEnvironment[A]{
+		agents:  make([]A, 0),
+		present: make(map[string]bool),
+		edges:   make(map[string]map[string]bool),
+	}
+	connect := func(from, to string) {
+		if e.edges[from] == nil {
+			e.edges[from] = make(map[string]bool)
+		}
+		if from != to {
+			e.edges[from][to] = true
+		}
+	}
+	for from, tos := range edges {
+		connect(from, from)
+		for _, to := range tos {
+			connect(from, to)
+			connect(to, from)
+		}
+	}
+	e.state = e.newNetworkState()
+	return e
+}
+
+func (e *NetworkEnvironment[A]) newNetworkState() NetworkState {
+	edges := 0
+	for _, neighbors := range e.edges {
+		edges += len(neighbors)
+	}
+	return NetworkState{
+		BaseState: BaseState{
+			Status:    "idle",
+			Step:      0,
+			Timestamp: time.Now(),
+		},
+		Nodes: len(e.edges),
+		Edges: edges / 2,
+	}
+}
+
+// AddAgent adds an agent at the node with its ID, which must be in the network
+func (e *NetworkEnvironment[A]) AddAgent(a A) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	id := a.GetID()
+	if _, ok := e.edges[id]; !ok {
+		return fmt.Errorf("agent %s is not a node of the network", id)
+	}
+	if e.present[id] {
+		return fmt.Errorf("agent %s is already in the network", id)
+	}
+	e.agents = append(e.agents, a)
+	e.present[id] = true
+	return nil
+}
+
+// RemoveAgent removes an agent from the network. Its node stays, so it can be added again.
+func (e *NetworkEnvironment[A]) RemoveAgent(a A) error {
+	return e.RemoveAgentByID(a.GetID())
+}
+
+// RemoveAgentByID removes the agent with the given ID and unsubscribes it from its message broker
+func (e *NetworkEnvironment[A]) RemoveAgentByID(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, a := range e.agents {
+		if a.GetID() == id {
+			e.agents = append(e.agents[:i], e.agents[i+1:]...)
+			delete(e.present, id)
+			return unsubscribe(a)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrAgentNotFound, id)
+}
+
+// GetAgents returns a copy of the agents in the network in the order they were added
+func (e *NetworkEnvironment[A]) GetAgents() []A {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return slices.Clone(e.agents)
+}
+
+// Neighbors returns the sorted IDs of the agents in the network connected to the agent with the
+// given ID. Nodes without an agent are left out, so messages aren't sent to agents that aren't there.
+func (e *NetworkEnvironment[A]) Neighbors(agentID string) []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.neighbors(agentID)
+}
+
+func (e *NetworkEnvironment[A]) neighbors(agentID string) []string {
+	var neighbors []string
+	for _, id := range slices.Sorted(maps.Keys(e.edges[agentID])) {
+		if e.present[id] {
+			neighbors = append(neighbors, id)
+		}
+	}
+	return neighbors
+}
+
+// GetState returns the current state of the network
+func (e *NetworkEnvironment[A]) GetState() NetworkState {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.state
+}
+
+// Reset removes every agent and clears the state, keeping the network's edges
+func (e *NetworkEnvironment[A]) Reset() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Unsubscribe the agents so the broker doesn't accumulate stale subscriptions
+	for _, a := range e.agents {
+		if err := unsubscribe(a); err != nil {
+			log.Printf("Warning: Failed to unsubscribe agent %s: %v", a.GetID(), err)
+		}
+	}
+	e.agents = make([]A, 0)
+	e.present = make(map[string]bool)
+	e.state = e.newNetworkState()
+	return nil
+}
+
+// Step runs every agent that has neighbors in parallel. Agents with no neighbors sit the step out,
+// since there is no one to hear them. The agents run without the environment locked, so a
+// topology broker can look up their neighbors as they send.
+func (e *NetworkEnvironment[A]) Step(ctx context.Context) error {
+	e.mu.RLock()
+	var running []A
+	for _, a := range e.agents {
+		if len(e.neighbors(a.GetID())) > 0 {
+			running = append(running, a)
+		}
+	}
+	e.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, a := range running {
+		wg.Add(1)
+		go func(a A) {
+			defer wg.Done()
+			if _, err := a.Run(ctx); err != nil {
+				log.Printf("error running agent %s: %s", a.GetID(), err)
+			}
+		}(a)
+	}
+	wg.Wait()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.state.BaseState = BaseState{
+		Status:    e.state.GetStatus(),
+		Step:      e.state.GetStep() + 1,
+		Timestamp: time.Now(),
+	}
+	return nil
+}
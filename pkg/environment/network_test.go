@@ -0,0 +1,102 @@
+package environment
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/boristopalov/petri/pkg/agent"
+	"github.com/boristopalov/petri/pkg/messaging"
+)
+
+func TestNetworkEnvironment(t *testing.T) {
+	t.Run("test edges go both ways and only count agents present", func(t *testing.T) {
+		env := NewNetworkEnvironment[*agent.LLMAgent](map[string][]string{
+			"a": {"b", "c", "a"},
+			"b": {"a"},
+			"d": nil,
+		})
+		state := env.GetState()
+		if state.Nodes != 4 || state.Edges != 2 {
+			t.Errorf("Nodes = %d, Edges = %d, want 4 nodes and 2 edges", state.Nodes, state.Edges)
+		}
+
+		broker := messaging.NewBroker()
+		for _, id := range []string{"a", "c"} {
+			if err := env.AddAgent(newTestGridAgent(t, id, broker)); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+		if got := env.Neighbors("a"); !slices.Equal(got, []string{"c"}) {
+			t.Errorf("Neighbors(a) = %v, want only c since b isn't in the network", got)
+		}
+		if got := env.Neighbors("c"); !slices.Equal(got, []string{"a"}) {
+			t.Errorf("Neighbors(c) = %v, want [a]", got)
+		}
+		if err := env.AddAgent(newTestGridAgent(t, "e", broker)); err == nil {
+			t.Error("Expected an error adding an agent that isn't a node")
+		}
+	})
+
+	t.Run("test broadcasts only reach neighbors", func(t *testing.T) {
+		// a - b - c, with d on its own
+		env := NewNetworkEnvironment[*agent.LLMAgent](map[string][]string{
+			"a": {"b"},
+			"b": {"c"},
+			"d": nil,
+		})
+		broker := messaging.NewTopologyBroker(messaging.NewBroker(), env)
+		agents := make(map[string]*agent.LLMAgent)
+		for _, id := range []string{"a", "b", "c", "d"} {
+			agents[id] = newTestGridAgent(t, id, broker)
+			if err := env.AddAgent(agents[id]); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+
+		if err := env.Step(context.Background()); err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+
+		want := map[string][]string{
+			"a": {"b"},
+			"b": {"a", "c"},
+			"c": {"b"},
+			"d": nil,
+		}
+		for id, senders := range want {
+			var got []string
+			for len(agents[id].Receive()) > 0 {
+				got = append(got, (<-agents[id].Receive()).From)
+			}
+			if !sameStrings(got, senders) {
+				t.Errorf("%s received messages from %v, want %v", id, got, senders)
+			}
+		}
+		if step := env.GetState().GetStep(); step != 1 {
+			t.Errorf("Step = %d, want 1", step)
+		}
+	})
+	t.Run("test reset unsubscribes the agents", func(t *testing.T) {
+		env := NewNetworkEnvironment[*agent.LLMAgent](map[string][]string{"a": {"b"}})
+		broker := messaging.NewBroker()
+		for _, id := range []string{"a", "b"} {
+			if err := env.AddAgent(newTestGridAgent(t, id, broker)); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+
+		if err := env.Reset(); err != nil {
+			t.Fatalf("Reset failed: %v", err)
+		}
+		if n := broker.SubscriberCount(); n != 0 {
+			t.Errorf("got %d subscribers after reset, want 0", n)
+		}
+		if got := env.Neighbors("a"); len(got) != 0 {
+			t.Errorf("Neighbors(a) = %v after reset, want none", got)
+		}
+		if err := env.AddAgent(newTestGridAgent(t, "a", broker)); err != nil {
+			t.Errorf("Failed to add an agent under a reset agent's ID: %v", err)
+		}
+	})
+}
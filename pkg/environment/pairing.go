@@ -0,0 +1,168 @@
+package environment
+
+import (
+	"math/rand"
+	"slices"
+	"time"
+
+	"github.com/boristopalov/petri/pkg/agent"
+	"github.com/boristopalov/petri/pkg/messaging"
+)
+
+// identified is implemented by every agent type an environment pairs up
+type identified interface {
+	GetID() string
+}
+
+// shuffled returns a copy of agents in an order drawn from rng
+func shuffled[A any](rng *rand.Rand, agents []A) []A {
+	out := slices.Clone(agents)
+	rng.Shuffle(len(out), func(i, j int) {
+		out[i], out[j] = out[j], out[i]
+	})
+	return out
+}
+
+// takeBye removes the agent that sits out the round from the shuffled agents and records its bye.
+// Byes rotate: the agent with the fewest byes so far sits out, and ties go to whichever of them
+// was shuffled first.
+func takeBye[A identified](shuffled []A, byes map[string]int) ([]A, string) {
+	sitOut := 0
+	for i, a := range shuffled {
+		if byes[a.GetID()] < byes[shuffled[sitOut].GetID()] {
+			sitOut = i
+		}
+	}
+	id := shuffled[sitOut].GetID()
+	byes[id]++
+	return slices.Delete(shuffled, sitOut, sitOut+1), id
+}
+
+// PairingStrategy decides which agents play together in a donor game round. Each pair is a donor
+// followed by its recipient, and agents left out of every pair sit the round out.
+type PairingStrategy interface {
+	Pair(agents []*agent.DonorGameAgent, round int) [][2]*agent.DonorGameAgent
+}
+
+// RandomPairing pairs agents uniformly at random, the donor game's default. With an odd
+// population, byes rotate as they do in the other games. The zero value draws from a source
+// seeded with the time of its first pairing.
+type RandomPairing struct {
+	rng  *rand.Rand
+	byes map[string]int // byes each agent has had, used to choose who sits out next
+}
+
+// NewRandomPairing returns a random pairing strategy that draws from rng
+func NewRandomPairing(rng *rand.Rand) *RandomPairing {
+	return &RandomPairing{rng: rng, byes: make(map[string]int)}
+}
+
+func (p *RandomPairing) Pair(agents []*agent.DonorGameAgent, round int) [][2]*agent.DonorGameAgent {
+	if p.rng == nil {
+		p.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	if p.byes == nil {
+		p.byes = make(map[string]int)
+	}
+	agents = shuffled(p.rng, agents)
+	if len(agents)%2 != 0 {
+		agents, _ = takeBye(agents, p.byes)
+	}
+	pairs := make([][2]*agent.DonorGameAgent, 0, len(agents)/2)
+	for i := 0; i+1 < len(agents); i += 2 {
+		pairs = append(pairs, [2]*agent.DonorGameAgent{agents[i], agents[i+1]})
+	}
+	return pairs
+}
+
+// RoundRobinPairing pairs every agent with every other agent once over n-1 rounds, where n is
+// the population rounded up to even, using the circle method on the order of the agents. Later
+// rounds repeat the schedule with donors and recipients swapped on every other repeat. Each agent
+// donates in about half of its pairings, and with an odd population a different agent sits out
+// each round. The donor game numbers rounds from 0 in every generation, so the schedule restarts
+// with each generation's agents.
+type RoundRobinPairing struct{}
+
+func (RoundRobinPairing) Pair(agents []*agent.DonorGameAgent, round int) [][2]*agent.DonorGameAgent {
+	players := slices.Clone(agents)
+	if len(players)%2 != 0 {
+		players = append(players, nil) // whoever is paired with nil sits out
+	}
+	n := len(players)
+	if n < 2 {
+		return nil
+	}
+
+	// Keep the first player in place and rotate the rest by one position per round
+	rest := players[1:]
+	k := round % (n - 1)
+	rotated := append([]*agent.DonorGameAgent{players[0]}, rest[len(rest)-k:]...)
+	rotated = append(rotated, rest[:len(rest)-k]...)
+
+	index := make(map[*agent.DonorGameAgent]int, len(agents))
+	for i, a := range agents {
+		index[a] = i
+	}
+	swap := (round/(n-1))%2 != 0
+	pairs := make([][2]*agent.DonorGameAgent, 0, n/2)
+	for i := range n / 2 {
+		donor, recipient := rotated[i], rotated[n-1-i]
+		if donor == nil || recipient == nil {
+			continue
+		}
+		// The agent that comes first donates if their positions add up to an even number
+		if index[donor] > index[recipient] {
+			donor, recipient = recipient, donor
+		}
+		if ((index[donor]+index[recipient])%2 != 0) != swap {
+			donor, recipient = recipient, donor
+		}
+		pairs = append(pairs, [2]*agent.DonorGameAgent{donor, recipient})
+	}
+	return pairs
+}
+
+// NetworkPairing pairs agents only with their neighbors in a topology, such as a
+// NetworkEnvironment. Each round it goes through the agents in random order and pairs each one
+// that is still unpaired with a random unpaired neighbor; agents left without one sit the round
+// out. Which agent of a pair donates is also random.
+type NetworkPairing struct {
+	topology messaging.Topology
+	rng      *rand.Rand
+}
+
+// NewNetworkPairing returns a pairing strategy along topology's edges that draws from rng
+func NewNetworkPairing(topology messaging.Topology, rng *rand.Rand) *NetworkPairing {
+	return &NetworkPairing{topology: topology, rng: rng}
+}
+
+func (p *NetworkPairing) Pair(agents []*agent.DonorGameAgent, round int) [][2]*agent.DonorGameAgent {
+	unpaired := make(map[string]*agent.DonorGameAgent, len(agents))
+	for _, a := range agents {
+		unpaired[a.GetID()] = a
+	}
+
+	var pairs [][2]*agent.DonorGameAgent
+	for _, a := range shuffled(p.rng, agents) {
+		if _, ok := unpaired[a.GetID()]; !ok {
+			continue
+		}
+		var candidates []*agent.DonorGameAgent
+		for _, id := range p.topology.Neighbors(a.GetID()) {
+			if b, ok := unpaired[id]; ok && id != a.GetID() {
+				candidates = append(candidates, b)
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		b := candidates[p.rng.Intn(len(candidates))]
+		delete(unpaired, a.GetID())
+		delete(unpaired, b.GetID())
+		if p.rng.Intn(2) == 0 {
+			a, b = b, a
+		}
+		pairs = append(pairs, [2]*agent.DonorGameAgent{a, b})
+	}
+	return pairs
+}
@@ -0,0 +1,186 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/boristopalov/petri/pkg/agent"
+)
+
+// pairingAgents creates n agents named agent0 to agent(n-1)
+func pairingAgents(t *testing.T, n int) []*agent.DonorGameAgent {
+	t.Helper()
+	client := &mockClient{response: "ANSWER: 1"}
+	agents := make([]*agent.DonorGameAgent, n)
+	for i := range agents {
+		agents[i] = newTestAgent(t, fmt.Sprintf("agent%d", i), client)
+	}
+	return agents
+}
+
+// staticTopology maps each agent ID to its neighbors
+type staticTopology map[string][]string
+
+func (s staticTopology) Neighbors(agentID string) []string {
+	return s[agentID]
+}
+
+// checkRound fails if an agent is in more than one of pairs or paired with itself, and returns
+// the agents that played
+func checkRound(t *testing.T, pairs [][2]*agent.DonorGameAgent) map[string]bool {
+	t.Helper()
+	played := make(map[string]bool)
+	for _, pair := range pairs {
+		if pair[0] == pair[1] {
+			t.Errorf("%s is paired with itself", pair[0].GetID())
+		}
+		for _, a := range pair {
+			if played[a.GetID()] {
+				t.Errorf("%s is in more than one pair", a.GetID())
+			}
+			played[a.GetID()] = true
+		}
+	}
+	return played
+}
+
+func TestPairing(t *testing.T) {
+	t.Run("test round robin pairs everyone with everyone once", func(t *testing.T) {
+		for _, n := range []int{4, 5} {
+			agents := pairingAgents(t, n)
+			rounds := n - 1
+			if n%2 != 0 {
+				rounds = n
+			}
+			met := make(map[[2]string]int)
+			donated := make(map[string]int)
+			byes := make(map[string]int)
+			for round := range rounds {
+				pairs := RoundRobinPairing{}.Pair(agents, round)
+				played := checkRound(t, pairs)
+				if len(pairs) != n/2 {
+					t.Errorf("n=%d round %d has %d pairs, want %d", n, round, len(pairs), n/2)
+				}
+				for _, pair := range pairs {
+					a, b := pair[0].GetID(), pair[1].GetID()
+					if a > b {
+						a, b = b, a
+					}
+					met[[2]string{a, b}]++
+					donated[pair[0].GetID()]++
+				}
+				for _, a := range agents {
+					if !played[a.GetID()] {
+						byes[a.GetID()]++
+					}
+				}
+			}
+			if want := n * (n - 1) / 2; len(met) != want {
+				t.Errorf("n=%d: %d distinct pairs met, want %d", n, len(met), want)
+			}
+			for pair, count := range met {
+				if count != 1 {
+					t.Errorf("n=%d: %v met %d times, want once", n, pair, count)
+				}
+			}
+			for _, a := range agents {
+				if n%2 != 0 && byes[a.GetID()] != 1 {
+					t.Errorf("n=%d: %s sat out %d rounds, want 1", n, a.GetID(), byes[a.GetID()])
+				}
+				// Each agent plays n-1 games and donates in about half of them
+				if d := 2*donated[a.GetID()] - (n - 1); d < -1 || d > 1 {
+					t.Errorf("n=%d: %s donated in %d of %d games, want about half", n, a.GetID(), donated[a.GetID()], n-1)
+				}
+			}
+		}
+	})
+
+	t.Run("test random pairing plays everyone and rotates byes", func(t *testing.T) {
+		agents := pairingAgents(t, 5)
+		p := NewRandomPairing(rand.New(rand.NewSource(1)))
+		byes := make(map[string]int)
+		for round := range 5 {
+			pairs := p.Pair(agents, round)
+			played := checkRound(t, pairs)
+			if len(pairs) != 2 {
+				t.Fatalf("round %d has %d pairs, want 2", round, len(pairs))
+			}
+			for _, a := range agents {
+				if !played[a.GetID()] {
+					byes[a.GetID()]++
+				}
+			}
+		}
+		for _, a := range agents {
+			if byes[a.GetID()] != 1 {
+				t.Errorf("%s sat out %d rounds, want 1", a.GetID(), byes[a.GetID()])
+			}
+		}
+	})
+
+	t.Run("test zero value random pairing draws from a default source", func(t *testing.T) {
+		agents := pairingAgents(t, 5)
+		var p RandomPairing
+		if pairs := p.Pair(agents, 0); len(pairs) != 2 {
+			t.Errorf("got %d pairs, want 2", len(pairs))
+		}
+	})
+
+	t.Run("test network pairing only pairs neighbors", func(t *testing.T) {
+		agents := pairingAgents(t, 6)
+		// agent0 to agent3 form a ring, agent4 and agent5 are only connected to each other
+		topology := staticTopology{
+			"agent0": {"agent1", "agent3"},
+			"agent1": {"agent0", "agent2"},
+			"agent2": {"agent1", "agent3"},
+			"agent3": {"agent2", "agent0"},
+			"agent4": {"agent5"},
+			"agent5": {"agent4"},
+		}
+		p := NewNetworkPairing(topology, rand.New(rand.NewSource(1)))
+		donated := make(map[string]int)
+		for round := range 20 {
+			pairs := p.Pair(agents, round)
+			checkRound(t, pairs)
+			for _, pair := range pairs {
+				if !slices.Contains(topology[pair[0].GetID()], pair[1].GetID()) {
+					t.Errorf("round %d pairs %s with %s, who aren't neighbors", round, pair[0].GetID(), pair[1].GetID())
+				}
+				donated[pair[0].GetID()]++
+			}
+			if len(pairs) != 3 {
+				t.Errorf("round %d has %d pairs, want 3", round, len(pairs))
+			}
+		}
+		for _, a := range agents {
+			if donated[a.GetID()] == 0 {
+				t.Errorf("%s never donated", a.GetID())
+			}
+		}
+	})
+
+	t.Run("test environment plays the pairs from its strategy", func(t *testing.T) {
+		env := NewDonorGameEnvironment(3, 2.0, 10.0, WithPairingStrategy(RoundRobinPairing{}))
+		agents := pairingAgents(t, 3)
+		for _, a := range agents {
+			if err := env.AddAgent(a); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", a.GetID(), err)
+			}
+		}
+		want := RoundRobinPairing{}.Pair(agents, 0)
+
+		result, err := env.StepWithResult(context.Background())
+		if err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+		if len(result.Donations) != 1 || result.Donations[0].DonorID != want[0][0].GetID() || result.Donations[0].RecipientID != want[0][1].GetID() {
+			t.Errorf("donations = %+v, want %s donating to %s", result.Donations, want[0][0].GetID(), want[0][1].GetID())
+		}
+		if result.Bye == "" || env.GetState().Byes[result.Bye] != 1 {
+			t.Errorf("bye = %q with byes %v, want the unpaired agent recorded", result.Bye, env.GetState().Byes)
+		}
+	})
+}
@@ -0,0 +1,361 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boristopalov/petri/pkg/agent"
+)
+
+// PayoffMatrix is what each player of a Prisoner's Dilemma scores for every combination of moves
+type PayoffMatrix struct {
+	Reward     float64 // both players cooperate
+	Sucker     float64 // the player cooperates and the opponent defects
+	Temptation float64 // the player defects and the opponent cooperates
+	Punishment float64 // both players defect
+}
+
+// DefaultPayoffs is the payoff matrix of Axelrod's tournaments
+var DefaultPayoffs = PayoffMatrix{Reward: 3, Sucker: 0, Temptation: 5, Punishment: 1}
+
+// Score returns what a player making move scores against an opponent making opponentMove
+func (p PayoffMatrix) Score(move, opponentMove agent.PDMove) float64 {
+	switch {
+	case move == agent.Cooperate && opponentMove == agent.Cooperate:
+		return p.Reward
+	case move == agent.Cooperate:
+		return p.Sucker
+	case opponentMove == agent.Cooperate:
+		return p.Temptation
+	default:
+		return p.Punishment
+	}
+}
+
+// String describes the payoffs to the players
+func (p PayoffMatrix) String() string {
+	return fmt.Sprintf("If you both COOPERATE, you each score %.2f. If you both DEFECT, you each score %.2f. "+
+		"If one of you COOPERATEs and the other DEFECTs, the one who cooperated scores %.2f and the one who defected scores %.2f.",
+		p.Reward, p.Punishment, p.Sucker, p.Temptation)
+}
+
+// PDState extends State with Prisoner's Dilemma specific fields
+type PDState struct {
+	BaseState       State
+	Round           int
+	TotalRounds     int
+	Scores          map[string]float64 // maps agent ID to their cumulative score
+	Cooperations    map[string]int     // maps agent ID to the number of times they cooperated
+	Matches         int                // number of matches played
+	FailedMatches   int                // number of matches skipped because a player failed to decide
+	MutualCooperate int                // number of matches in which both players cooperated
+	MutualDefect    int                // number of matches in which both players defected
+	Byes            map[string]int     // counts the rounds each agent sat out because the population was odd
+}
+
+func (s PDState) GetStatus() string {
+	return s.BaseState.GetStatus()
+}
+
+func (s PDState) GetStep() uint32 {
+	return s.BaseState.GetStep()
+}
+
+func (s PDState) GetTimestamp() time.Time {
+	return s.BaseState.GetTimestamp()
+}
+
+// PDMatch is a match played during a step
+type PDMatch struct {
+	Round   int
+	PlayerA string
+	PlayerB string
+	MoveA   agent.PDMove
+	MoveB   agent.PDMove
+	ScoreA  float64
+	ScoreB  float64
+}
+
+// PDOption configures optional PrisonersDilemmaEnvironment behavior
+type PDOption func(*PrisonersDilemmaEnvironment)
+
+// WithPDSeed seeds the environment's random number generator. A seed of 0 seeds it from the current time.
+func WithPDSeed(seed int64) PDOption {
+	return func(e *PrisonersDilemmaEnvironment) {
+		e.seed = seed
+	}
+}
+
+// pdHistoryLength is how many of an opponent's most recent matches a player is told about
+const pdHistoryLength = 3
+
+// PrisonersDilemmaEnvironment pairs agents up every round to play the Prisoner's Dilemma
+type PrisonersDilemmaEnvironment struct {
+	agents       []*agent.PDAgent
+	state        PDState
+	payoffs      PayoffMatrix
+	roundsPerGen int
+	seed         int64      // seed of rng, recorded so runs can be reproduced
+	rng          *rand.Rand // source of randomness for pairing
+	history      []PDMatch  // matches played this generation, oldest first
+	mu           sync.RWMutex
+	stepMu       sync.Mutex // serializes steps, which release mu while the players decide
+}
+
+// NewPrisonersDilemmaEnvironment creates a Prisoner's Dilemma environment that scores matches
+// with payoffs and plays rounds rounds per generation
+func NewPrisonersDilemmaEnvironment(payoffs PayoffMatrix, rounds int, opts ...PDOption) *PrisonersDilemmaEnvironment {
+	e := &PrisonersDilemmaEnvironment{
+		agents:       make([]*agent.PDAgent, 0),
+		state:        newPDState(),
+		payoffs:      payoffs,
+		roundsPerGen: rounds,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.seed == 0 {
+		e.seed = time.Now().UnixNano()
+	}
+	e.rng = rand.New(rand.NewSource(e.seed))
+	return e
+}
+
+func newPDState() PDState {
+	return PDState{
+		BaseState: BaseState{
+			Status:    "idle",
+			Step:      0,
+			Timestamp: time.Now(),
+		},
+		Scores:       make(map[string]float64),
+		Cooperations: make(map[string]int),
+		Byes:         make(map[string]int),
+	}
+}
+
+// Seed returns the seed of the environment's random number generator
+func (e *PrisonersDilemmaEnvironment) Seed() int64 {
+	return e.seed
+}
+
+// AddAgent adds an agent to the environment with a score of 0
+func (e *PrisonersDilemmaEnvironment) AddAgent(a *agent.PDAgent) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.agents = append(e.agents, a)
+	e.state.Scores[a.GetID()] = 0
+	return nil
+}
+
+// RemoveAgent removes an agent from the environment
+func (e *PrisonersDilemmaEnvironment) RemoveAgent(a *agent.PDAgent) error {
+	return e.RemoveAgentByID(a.GetID())
+}
+
+// RemoveAgentByID removes the agent with the given ID along with its score
+func (e *PrisonersDilemmaEnvironment) RemoveAgentByID(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, a := range e.agents {
+		if a.GetID() == id {
+			e.agents = append(e.agents[:i], e.agents[i+1:]...)
+			delete(e.state.Scores, id)
+			delete(e.state.Cooperations, id)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrAgentNotFound, id)
+}
+
+// GetAgents returns the agents in the environment
+func (e *PrisonersDilemmaEnvironment) GetAgents() []*agent.PDAgent {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.agents
+}
+
+// Reset removes every agent and clears the state for a new generation
+func (e *PrisonersDilemmaEnvironment) Reset() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.agents = make([]*agent.PDAgent, 0)
+	e.state = newPDState()
+	e.history = nil
+	return nil
+}
+
+// GetState returns the current state of the environment
+func (e *PrisonersDilemmaEnvironment) GetState() PDState {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	state := e.state
+	state.Scores = maps.Clone(e.state.Scores)
+	state.Cooperations = maps.Clone(e.state.Cooperations)
+	state.Byes = maps.Clone(e.state.Byes)
+	return state
+}
+
+// Step implements one round of the Prisoner's Dilemma
+func (e *PrisonersDilemmaEnvironment) Step(ctx context.Context) error {
+	_, err := e.StepWithResult(ctx)
+	return err
+}
+
+// pdDecision is one player's move in a match, or why it couldn't be made
+type pdDecision struct {
+	match int  // index of the match in the round
+	first bool // whether the move is the first player's
+	move  agent.PDMove
+	err   error
+}
+
+// StepWithResult runs a round like Step and returns the matches it played. Both players of a
+// match decide in parallel; a match in which either fails to decide is skipped.
+func (e *PrisonersDilemmaEnvironment) StepWithResult(ctx context.Context) ([]PDMatch, error) {
+	e.stepMu.Lock()
+	defer e.stepMu.Unlock()
+
+	// The lock is only held to pair the players and to apply the matches, so the state can be
+	// read while they decide
+	e.mu.Lock()
+	agents := shuffled(e.rng, e.agents)
+	if len(agents)%2 != 0 {
+		var bye string
+		agents, bye = takeBye(agents, e.state.Byes)
+		log.Printf("Agent %s sits out this round", bye)
+	}
+	round := e.state.Round
+	situations := make([]string, len(agents))
+	for i := 0; i < len(agents); i += 2 {
+		situations[i], situations[i+1] = e.situation(agents[i+1].GetID()), e.situation(agents[i].GetID())
+	}
+	e.mu.Unlock()
+
+	decisions := make(chan pdDecision, len(agents))
+	for i := 0; i < len(agents); i += 2 {
+		a, b := agents[i], agents[i+1]
+		go decide(ctx, decisions, pdDecision{match: i / 2, first: true}, a, round, b.GetID(), situations[i])
+		go decide(ctx, decisions, pdDecision{match: i / 2}, b, round, a.GetID(), situations[i+1])
+	}
+
+	moves := make([][2]agent.PDMove, len(agents)/2)
+	failed := make([]bool, len(agents)/2)
+	var errs []error
+	for range agents {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case d := <-decisions:
+			if d.err != nil {
+				errs = append(errs, d.err)
+				failed[d.match] = true
+				continue
+			}
+			if d.first {
+				moves[d.match][0] = d.move
+			} else {
+				moves[d.match][1] = d.move
+			}
+		}
+	}
+	for _, err := range errs {
+		log.Printf("Decision error: %v", err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var played []PDMatch
+	for i := range moves {
+		if failed[i] {
+			e.state.FailedMatches++
+			continue
+		}
+		played = append(played, e.applyMatch(round, agents[2*i], agents[2*i+1], moves[i][0], moves[i][1]))
+	}
+
+	e.state.Round++
+	e.state.TotalRounds++
+	if e.state.Round >= e.roundsPerGen {
+		e.state.Round = 0
+	}
+	return played, nil
+}
+
+// decide asks player for its move and sends it to decisions as decision
+func decide(ctx context.Context, decisions chan<- pdDecision, decision pdDecision, player *agent.PDAgent, round int, opponentID string, situation string) {
+	move, err := player.Decide(ctx, round, opponentID, situation)
+	if err != nil {
+		err = fmt.Errorf("player %s error: %w", player.GetID(), err)
+	}
+	decision.move, decision.err = move, err
+	decisions <- decision
+}
+
+// situation describes the payoffs and the opponent's most recent matches to a player
+func (e *PrisonersDilemmaEnvironment) situation(opponentID string) string {
+	var recent []string
+	for i := len(e.history) - 1; i >= 0 && len(recent) < pdHistoryLength; i-- {
+		m := e.history[i]
+		switch opponentID {
+		case m.PlayerA:
+			recent = append(recent, fmt.Sprintf("In round %d, %s played %s against %s.", m.Round, opponentID, m.MoveA, m.PlayerB))
+		case m.PlayerB:
+			recent = append(recent, fmt.Sprintf("In round %d, %s played %s against %s.", m.Round, opponentID, m.MoveB, m.PlayerA))
+		}
+	}
+
+	history := fmt.Sprintf("%s has not played yet.", opponentID)
+	if len(recent) > 0 {
+		history = fmt.Sprintf("Here is what %s did in their most recent rounds:\n%s", opponentID, strings.Join(recent, "\n"))
+	}
+	return e.payoffs.String() + "\n\n" + history
+}
+
+// applyMatch scores a match and records it in both players' memories
+func (e *PrisonersDilemmaEnvironment) applyMatch(round int, a, b *agent.PDAgent, moveA, moveB agent.PDMove) PDMatch {
+	match := PDMatch{
+		Round:   round,
+		PlayerA: a.GetID(),
+		PlayerB: b.GetID(),
+		MoveA:   moveA,
+		MoveB:   moveB,
+		ScoreA:  e.payoffs.Score(moveA, moveB),
+		ScoreB:  e.payoffs.Score(moveB, moveA),
+	}
+	e.history = append(e.history, match)
+	e.state.Matches++
+	switch {
+	case moveA == agent.Cooperate && moveB == agent.Cooperate:
+		e.state.MutualCooperate++
+	case moveA == agent.Defect && moveB == agent.Defect:
+		e.state.MutualDefect++
+	}
+
+	e.scoreMove(round, a, b.GetID(), moveA, moveB, match.ScoreA)
+	e.scoreMove(round, b, a.GetID(), moveB, moveA, match.ScoreB)
+	return match
+}
+
+// scoreMove adds score to player's total and records the match in its memory
+func (e *PrisonersDilemmaEnvironment) scoreMove(round int, player *agent.PDAgent, opponentID string, move, opponentMove agent.PDMove, score float64) {
+	id := player.GetID()
+	e.state.Scores[id] += score
+	if move == agent.Cooperate {
+		e.state.Cooperations[id]++
+	}
+	entry := fmt.Sprintf("Round %d: I played %s against %s, who played %s. I scored %.2f, bringing my score to %.2f",
+		round, move, opponentID, opponentMove, score, e.state.Scores[id])
+	if err := player.GetMemory().Store(entry); err != nil {
+		log.Printf("Warning: Failed to store memory for player %s: %v", id, err)
+	}
+}
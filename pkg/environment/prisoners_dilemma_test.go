@@ -0,0 +1,146 @@
+package environment
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boristopalov/petri/pkg/agent"
+)
+
+func newTestPDAgent(t *testing.T, id string, client agent.Client) *agent.PDAgent {
+	t.Helper()
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	a, err := agent.NewPDAgent(context.Background(), id, "cooperate with cooperators", agent.WithProvider(client))
+	if err != nil {
+		t.Fatalf("Failed to create agent %s: %v", id, err)
+	}
+	return a
+}
+
+// blockingClient signals started on every call and answers once release is closed
+type blockingClient struct {
+	response string
+	started  chan struct{}
+	release  chan struct{}
+}
+
+func (c *blockingClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	c.started <- struct{}{}
+	<-c.release
+	return c.response, nil
+}
+
+func TestPrisonersDilemmaEnvironment(t *testing.T) {
+	t.Run("test payoffs are applied and opponents' moves are shown", func(t *testing.T) {
+		env := NewPrisonersDilemmaEnvironment(DefaultPayoffs, 3, WithPDSeed(42))
+		cooperatorClient := &mockClient{response: "They seem trustworthy. ANSWER: COOPERATE"}
+		cooperator := newTestPDAgent(t, "agent1", cooperatorClient)
+		defector := newTestPDAgent(t, "agent2", &mockClient{response: "answer: defect"})
+		for _, a := range []*agent.PDAgent{cooperator, defector} {
+			if err := env.AddAgent(a); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", a.GetID(), err)
+			}
+		}
+
+		for i := 0; i < 2; i++ {
+			if err := env.Step(context.Background()); err != nil {
+				t.Fatalf("Step failed: %v", err)
+			}
+		}
+
+		state := env.GetState()
+		if state.Scores["agent1"] != 0 || state.Scores["agent2"] != 10 {
+			t.Errorf("Scores = %v, want agent1: 0, agent2: 10", state.Scores)
+		}
+		if state.Matches != 2 || state.Cooperations["agent1"] != 2 || state.Cooperations["agent2"] != 0 {
+			t.Errorf("Matches = %d, Cooperations = %v, want 2 matches and only agent1 cooperating", state.Matches, state.Cooperations)
+		}
+		if !strings.Contains(cooperatorClient.prompts[1], "In round 0, agent2 played DEFECT against agent1.") {
+			t.Errorf("Expected the opponent's last move in the second prompt:\n%s", cooperatorClient.prompts[1])
+		}
+		memories := cooperator.GetMemory().GetAllMessages()
+		if len(memories) != 2 || !strings.Contains(memories[1], "I played COOPERATE against agent2, who played DEFECT. I scored 0.00") {
+			t.Errorf("Expected a memory of each match, got %v", memories)
+		}
+	})
+
+	t.Run("test failed decision skips the match", func(t *testing.T) {
+		env := NewPrisonersDilemmaEnvironment(DefaultPayoffs, 3)
+		for _, a := range []*agent.PDAgent{
+			newTestPDAgent(t, "agent1", &mockClient{response: "ANSWER: COOPERATE"}),
+			newTestPDAgent(t, "agent2", &mockClient{response: "I'm not sure."}),
+		} {
+			if err := env.AddAgent(a); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", a.GetID(), err)
+			}
+		}
+
+		matches, err := env.StepWithResult(context.Background())
+		if err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+		state := env.GetState()
+		if len(matches) != 0 || state.FailedMatches != 1 || state.Scores["agent1"] != 0 {
+			t.Errorf("matches = %v, FailedMatches = %d, Scores = %v, want the match skipped", matches, state.FailedMatches, state.Scores)
+		}
+	})
+
+	t.Run("test odd population gives one agent a bye", func(t *testing.T) {
+		env := NewPrisonersDilemmaEnvironment(DefaultPayoffs, 3)
+		client := &mockClient{response: "ANSWER: COOPERATE"}
+		for _, id := range []string{"agent1", "agent2", "agent3"} {
+			if err := env.AddAgent(newTestPDAgent(t, id, client)); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+
+		matches, err := env.StepWithResult(context.Background())
+		if err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+		if len(matches) != 1 || matches[0].ScoreA != DefaultPayoffs.Reward || matches[0].ScoreB != DefaultPayoffs.Reward {
+			t.Errorf("matches = %+v, want one match of mutual cooperation", matches)
+		}
+		if byes := env.GetState().Byes; len(byes) != 1 {
+			t.Errorf("Byes = %v, want one agent sitting out", byes)
+		}
+	})
+	t.Run("test state can be read while the players decide", func(t *testing.T) {
+		env := NewPrisonersDilemmaEnvironment(DefaultPayoffs, 3)
+		client := &blockingClient{response: "ANSWER: COOPERATE", started: make(chan struct{}, 2), release: make(chan struct{})}
+		for _, id := range []string{"agent1", "agent2"} {
+			if err := env.AddAgent(newTestPDAgent(t, id, client)); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+
+		stepped := make(chan error)
+		go func() {
+			stepped <- env.Step(context.Background())
+		}()
+		<-client.started
+
+		read := make(chan PDState)
+		go func() {
+			read <- env.GetState()
+		}()
+		select {
+		case state := <-read:
+			if state.Matches != 0 {
+				t.Errorf("Matches = %d while the players decide, want 0", state.Matches)
+			}
+		case <-time.After(time.Second):
+			t.Error("GetState blocked while the players were deciding")
+		}
+
+		close(client.release)
+		if err := <-stepped; err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+		if state := env.GetState(); state.Matches != 1 {
+			t.Errorf("Matches = %d after the step, want 1", state.Matches)
+		}
+	})
+}
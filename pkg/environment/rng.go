@@ -0,0 +1,122 @@
+package environment
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+)
+
+// RNGState is the position of a DonorGameEnvironment's random number generators: their seed and
+// the number of values drawn since seeding
+type RNGState struct {
+	Seed int64 `json:"seed"`
+	// Stream is the seed the generator was last seeded with, if it isn't Seed because the
+	// environment derives a seed for each generation
+	Stream int64  `json:"stream_seed,omitempty"`
+	Draws  uint64 `json:"draws"`
+	// NoiseDraws is the number of values drawn by the history noise generator since seeding
+	NoiseDraws uint64 `json:"noise_draws,omitempty"`
+}
+
+// GenerationSeed derives the seed of a generation's random number generator from the master
+// seed by hashing both, so a generation's draws don't depend on how many values earlier
+// generations drew
+func GenerationSeed(master int64, generation int) int64 {
+	var buf [16]byte
+	binary.LittleEndian.PutUint64(buf[:8], uint64(master))
+	binary.LittleEndian.PutUint64(buf[8:], uint64(generation))
+	h := fnv.New64a()
+	h.Write(buf[:])
+	return int64(h.Sum64())
+}
+
+// WithGenerationSeeds reseeds the environment's random number generator at the start of each
+// generation with GenerationSeed, so every generation's pairings can be reproduced from the
+// master seed on their own and aren't shifted by changes to what earlier generations draw.
+// See SeedGeneration.
+func WithGenerationSeeds() DonorGameOption {
+	return func(e *DonorGameEnvironment) {
+		e.generationSeeds = true
+	}
+}
+
+// SeedGeneration reseeds the random number generator for the given generation if the environment
+// was created with WithGenerationSeeds, and is a no-op otherwise. It is called after Reset,
+// before the generation's agents are added.
+func (e *DonorGameEnvironment) SeedGeneration(generation int) {
+	if !e.generationSeeds {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.reseed(GenerationSeed(e.seed, generation))
+}
+
+// noiseStream is passed to GenerationSeed to derive the history noise generator's seed. No
+// generation is numbered -1, so the noise and pairing streams never share a seed.
+const noiseStream = -1
+
+// reseed replaces the random number generators with new ones seeded from seed
+func (e *DonorGameEnvironment) reseed(seed int64) {
+	e.streamSeed = seed
+	e.src = newCountingSource(seed)
+	e.rng = rand.New(e.src)
+	e.noiseSrc = newCountingSource(GenerationSeed(seed, noiseStream))
+	e.noiseRng = rand.New(e.noiseSrc)
+}
+
+// countingSource wraps a rand.Source and counts the values drawn from it, so the generator's
+// position can be saved and restored by replaying the draws
+type countingSource struct {
+	src   rand.Source64
+	draws uint64
+}
+
+func newCountingSource(seed int64) *countingSource {
+	return &countingSource{src: rand.NewSource(seed).(rand.Source64)}
+}
+
+func (s *countingSource) Int63() int64 {
+	s.draws++
+	return s.src.Int63()
+}
+
+func (s *countingSource) Uint64() uint64 {
+	s.draws++
+	return s.src.Uint64()
+}
+
+func (s *countingSource) Seed(seed int64) {
+	s.src.Seed(seed)
+	s.draws = 0
+}
+
+// RNGState returns the current position of the environment's random number generators
+func (e *DonorGameEnvironment) RNGState() RNGState {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	state := RNGState{Seed: e.seed, Draws: e.src.draws, NoiseDraws: e.noiseSrc.draws}
+	if e.streamSeed != e.seed {
+		state.Stream = e.streamSeed
+	}
+	return state
+}
+
+// RestoreRNG moves the environment's random number generators to a position saved with RNGState,
+// so the rest of a run draws the same values it would have without interruption
+func (e *DonorGameEnvironment) RestoreRNG(state RNGState) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.seed = state.Seed
+	if state.Stream != 0 {
+		e.reseed(state.Stream)
+	} else {
+		e.reseed(state.Seed)
+	}
+	for i := uint64(0); i < state.Draws; i++ {
+		e.src.Uint64()
+	}
+	for i := uint64(0); i < state.NoiseDraws; i++ {
+		e.noiseSrc.Uint64()
+	}
+}
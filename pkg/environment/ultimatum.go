@@ -0,0 +1,357 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boristopalov/petri/pkg/agent"
+)
+
+// UltimatumState extends State with ultimatum game specific fields
+type UltimatumState struct {
+	BaseState    State
+	Round        int
+	TotalRounds  int
+	Resources    map[string]float64 // maps agent ID to the resources they have won
+	ProposerRuns map[string]int     // maps agent ID to the number of times they proposed in a pair that completed
+	Offers       int                // number of offers responded to
+	Accepted     int                // number of offers accepted
+	TotalOffered float64            // sum of all offers responded to
+	FailedOffers int                // number of pairs skipped because the proposer or responder failed to answer
+	Byes         map[string]int     // counts the rounds each agent sat out because the population was odd
+}
+
+// AcceptanceRate returns the fraction of offers that were accepted, or 0 if none were made
+func (s UltimatumState) AcceptanceRate() float64 {
+	if s.Offers == 0 {
+		return 0
+	}
+	return float64(s.Accepted) / float64(s.Offers)
+}
+
+// AverageOffer returns the average offer, or 0 if none were made
+func (s UltimatumState) AverageOffer() float64 {
+	if s.Offers == 0 {
+		return 0
+	}
+	return s.TotalOffered / float64(s.Offers)
+}
+
+func (s UltimatumState) GetStatus() string {
+	return s.BaseState.GetStatus()
+}
+
+func (s UltimatumState) GetStep() uint32 {
+	return s.BaseState.GetStep()
+}
+
+func (s UltimatumState) GetTimestamp() time.Time {
+	return s.BaseState.GetTimestamp()
+}
+
+// UltimatumOffer is an offer made and answered during a step
+type UltimatumOffer struct {
+	Round       int
+	ProposerID  string
+	ResponderID string
+	Offer       float64
+	Accepted    bool
+}
+
+// UltimatumOption configures optional UltimatumEnvironment behavior
+type UltimatumOption func(*UltimatumEnvironment)
+
+// WithUltimatumSeed seeds the environment's random number generator. A seed of 0 seeds it from the current time.
+func WithUltimatumSeed(seed int64) UltimatumOption {
+	return func(e *UltimatumEnvironment) {
+		e.seed = seed
+	}
+}
+
+// ultimatumHistoryLength is how many of a partner's most recent offers a player is told about
+const ultimatumHistoryLength = 3
+
+// UltimatumEnvironment pairs agents up every round to play the ultimatum game: the proposer
+// offers part of a pot and the responder accepts the split or rejects it, leaving both with nothing
+type UltimatumEnvironment struct {
+	agents       []*agent.UltimatumAgent
+	state        UltimatumState
+	potSize      float64
+	roundsPerGen int
+	seed         int64            // seed of rng, recorded so runs can be reproduced
+	rng          *rand.Rand       // source of randomness for pairing
+	history      []UltimatumOffer // offers made this generation, oldest first
+	mu           sync.RWMutex
+	stepMu       sync.Mutex // serializes steps, which release mu while the pairs play
+}
+
+// NewUltimatumEnvironment creates an ultimatum game environment in which every proposer splits
+// a pot of potSize, playing rounds rounds per generation
+func NewUltimatumEnvironment(potSize float64, rounds int, opts ...UltimatumOption) *UltimatumEnvironment {
+	e := &UltimatumEnvironment{
+		agents:       make([]*agent.UltimatumAgent, 0),
+		state:        newUltimatumState(),
+		potSize:      potSize,
+		roundsPerGen: rounds,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.seed == 0 {
+		e.seed = time.Now().UnixNano()
+	}
+	e.rng = rand.New(rand.NewSource(e.seed))
+	return e
+}
+
+func newUltimatumState() UltimatumState {
+	return UltimatumState{
+		BaseState: BaseState{
+			Status:    "idle",
+			Step:      0,
+			Timestamp: time.Now(),
+		},
+		Resources:    make(map[string]float64),
+		ProposerRuns: make(map[string]int),
+		Byes:         make(map[string]int),
+	}
+}
+
+// Seed returns the seed of the environment's random number generator
+func (e *UltimatumEnvironment) Seed() int64 {
+	return e.seed
+}
+
+// AddAgent adds an agent to the environment with no resources
+func (e *UltimatumEnvironment) AddAgent(a *agent.UltimatumAgent) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.agents = append(e.agents, a)
+	e.state.Resources[a.GetID()] = 0
+	return nil
+}
+
+// RemoveAgent removes an agent from the environment
+func (e *UltimatumEnvironment) RemoveAgent(a *agent.UltimatumAgent) error {
+	return e.RemoveAgentByID(a.GetID())
+}
+
+// RemoveAgentByID removes the agent with the given ID along with its resources
+func (e *UltimatumEnvironment) RemoveAgentByID(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, a := range e.agents {
+		if a.GetID() == id {
+			e.agents = append(e.agents[:i], e.agents[i+1:]...)
+			delete(e.state.Resources, id)
+			delete(e.state.ProposerRuns, id)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrAgentNotFound, id)
+}
+
+// GetAgents returns the agents in the environment
+func (e *UltimatumEnvironment) GetAgents() []*agent.UltimatumAgent {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.agents
+}
+
+// Reset removes every agent and clears the state for a new generation
+func (e *UltimatumEnvironment) Reset() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.agents = make([]*agent.UltimatumAgent, 0)
+	e.state = newUltimatumState()
+	e.history = nil
+	return nil
+}
+
+// GetState returns the current state of the environment
+func (e *UltimatumEnvironment) GetState() UltimatumState {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	state := e.state
+	state.Resources = maps.Clone(e.state.Resources)
+	state.ProposerRuns = maps.Clone(e.state.ProposerRuns)
+	state.Byes = maps.Clone(e.state.Byes)
+	return state
+}
+
+// Step implements one round of the ultimatum game
+func (e *UltimatumEnvironment) Step(ctx context.Context) error {
+	_, err := e.StepWithResult(ctx)
+	return err
+}
+
+// ultimatumResult is the outcome of one pair's offer, or why it couldn't be made
+type ultimatumResult struct {
+	offer UltimatumOffer
+	err   error
+}
+
+// StepWithResult runs a round like Step and returns the offers that were answered. Pairs play in
+// parallel; a pair in which either player fails to answer is skipped.
+func (e *UltimatumEnvironment) StepWithResult(ctx context.Context) ([]UltimatumOffer, error) {
+	e.stepMu.Lock()
+	defer e.stepMu.Unlock()
+
+	// The lock is only held to pair the players and to apply the offers, so the state can be read
+	// while they play
+	e.mu.Lock()
+	agents := shuffled(e.rng, e.agents)
+	if len(agents)%2 != 0 {
+		var bye string
+		agents, bye = takeBye(agents, e.state.Byes)
+		log.Printf("Agent %s sits out this round", bye)
+	}
+	round := e.state.Round
+	type pair struct {
+		proposer, responder               *agent.UltimatumAgent
+		proposerHistory, responderHistory string
+	}
+	pairs := make([]pair, 0, len(agents)/2)
+	for i := 0; i < len(agents); i += 2 {
+		// Roles alternate: whichever partner has proposed less often proposes
+		proposer, responder := agents[i], agents[i+1]
+		if e.state.ProposerRuns[responder.GetID()] < e.state.ProposerRuns[proposer.GetID()] {
+			proposer, responder = responder, proposer
+		}
+		pairs = append(pairs, pair{
+			proposer:        proposer,
+			responder:       responder,
+			proposerHistory: e.partnerHistory(round, responder.GetID()),
+			responderHistory: fmt.Sprintf("The proposer, %s, split a pot of %.2f units.\n\n%s",
+				proposer.GetID(), e.potSize, e.partnerHistory(round, proposer.GetID())),
+		})
+	}
+	e.mu.Unlock()
+
+	results := make(chan ultimatumResult, len(pairs))
+	for _, p := range pairs {
+		go func() {
+			results <- e.playPair(ctx, round, p.proposer, p.responder, p.proposerHistory, p.responderHistory)
+		}()
+	}
+
+	var completed []UltimatumOffer
+	var failed int
+	for range pairs {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case result := <-results:
+			if result.err != nil {
+				log.Printf("Offer error: %v", result.err)
+				failed++
+				continue
+			}
+			completed = append(completed, result.offer)
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.state.FailedOffers += failed
+	for _, offer := range completed {
+		e.applyOffer(offer)
+	}
+
+	e.state.Round++
+	e.state.TotalRounds++
+	if e.state.Round >= e.roundsPerGen {
+		e.state.Round = 0
+	}
+	return completed, nil
+}
+
+// playPair asks the proposer for an offer and the responder whether to accept it
+func (e *UltimatumEnvironment) playPair(ctx context.Context, round int, proposer, responder *agent.UltimatumAgent, proposerHistory, responderHistory string) ultimatumResult {
+	offer, err := proposer.Propose(ctx, e.potSize, proposerHistory)
+	if err != nil {
+		return ultimatumResult{err: fmt.Errorf("proposer %s error: %w", proposer.GetID(), err)}
+	}
+	accepted, err := responder.Respond(ctx, offer, responderHistory)
+	if err != nil {
+		return ultimatumResult{err: fmt.Errorf("responder %s error: %w", responder.GetID(), err)}
+	}
+	return ultimatumResult{offer: UltimatumOffer{
+		Round:       round,
+		ProposerID:  proposer.GetID(),
+		ResponderID: responder.GetID(),
+		Offer:       offer,
+		Accepted:    accepted,
+	}}
+}
+
+// partnerHistory describes the round and a partner's most recent offers and responses
+func (e *UltimatumEnvironment) partnerHistory(round int, partnerID string) string {
+	var recent []string
+	for i := len(e.history) - 1; i >= 0 && len(recent) < ultimatumHistoryLength; i-- {
+		o := e.history[i]
+		verdict := "rejected"
+		if o.Accepted {
+			verdict = "accepted"
+		}
+		switch partnerID {
+		case o.ProposerID:
+			recent = append(recent, fmt.Sprintf("In round %d, %s offered %.2f units to %s, who %s.", o.Round, partnerID, o.Offer, o.ResponderID, verdict))
+		case o.ResponderID:
+			recent = append(recent, fmt.Sprintf("In round %d, %s %s an offer of %.2f units from %s.", o.Round, partnerID, verdict, o.Offer, o.ProposerID))
+		}
+	}
+
+	history := fmt.Sprintf("It is now round %d. You have been paired with %s, who has not played yet.", round, partnerID)
+	if len(recent) > 0 {
+		history = fmt.Sprintf("It is now round %d. You have been paired with %s. Here is what they did in their most recent rounds:\n%s",
+			round, partnerID, strings.Join(recent, "\n"))
+	}
+	return history
+}
+
+// applyOffer counts the proposer's turn, pays out an answered offer and records it in both players' memories
+func (e *UltimatumEnvironment) applyOffer(o UltimatumOffer) {
+	e.history = append(e.history, o)
+	e.state.ProposerRuns[o.ProposerID]++
+	e.state.Offers++
+	e.state.TotalOffered += o.Offer
+
+	proposerMemory := fmt.Sprintf("Round %d: I offered %.2f of %.2f units to %s, who rejected it, so neither of us got anything",
+		o.Round, o.Offer, e.potSize, o.ResponderID)
+	responderMemory := fmt.Sprintf("Round %d: I rejected an offer of %.2f of %.2f units from %s, so neither of us got anything",
+		o.Round, o.Offer, e.potSize, o.ProposerID)
+	if o.Accepted {
+		e.state.Accepted++
+		e.state.Resources[o.ProposerID] += e.potSize - o.Offer
+		e.state.Resources[o.ResponderID] += o.Offer
+		proposerMemory = fmt.Sprintf("Round %d: I offered %.2f of %.2f units to %s, who accepted it, bringing my resources to %.2f",
+			o.Round, o.Offer, e.potSize, o.ResponderID, e.state.Resources[o.ProposerID])
+		responderMemory = fmt.Sprintf("Round %d: I accepted an offer of %.2f of %.2f units from %s, bringing my resources to %.2f",
+			o.Round, o.Offer, e.potSize, o.ProposerID, e.state.Resources[o.ResponderID])
+	}
+
+	for _, a := range e.agents {
+		var entry string
+		switch a.GetID() {
+		case o.ProposerID:
+			entry = proposerMemory
+		case o.ResponderID:
+			entry = responderMemory
+		default:
+			continue
+		}
+		if err := a.GetMemory().Store(entry); err != nil {
+			log.Printf("Warning: Failed to store memory for agent %s: %v", a.GetID(), err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package environment
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boristopalov/petri/pkg/agent"
+)
+
+// ultimatumClient implements agent.Client, answering proposer prompts with offer and responder
+// prompts with answer
+type ultimatumClient struct {
+	offer  string
+	answer string
+}
+
+func (c *ultimatumClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	if strings.Contains(prompt, "You are the proposer") {
+		return c.offer, nil
+	}
+	return c.answer, nil
+}
+
+func newTestUltimatumAgent(t *testing.T, id string, client agent.Client) *agent.UltimatumAgent {
+	t.Helper()
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	a, err := agent.NewUltimatumAgent(context.Background(), id, "offer a fair split", agent.WithProvider(client))
+	if err != nil {
+		t.Fatalf("Failed to create agent %s: %v", id, err)
+	}
+	return a
+}
+
+func TestUltimatumEnvironment(t *testing.T) {
+	t.Run("test accepted offers split the pot and roles alternate", func(t *testing.T) {
+		env := NewUltimatumEnvironment(10, 3, WithUltimatumSeed(42))
+		client := &ultimatumClient{offer: "ANSWER: 4", answer: "ANSWER: ACCEPT"}
+		for _, id := range []string{"agent1", "agent2"} {
+			if err := env.AddAgent(newTestUltimatumAgent(t, id, client)); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+
+		first, err := env.StepWithResult(context.Background())
+		if err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+		second, err := env.StepWithResult(context.Background())
+		if err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+		if len(first) != 1 || len(second) != 1 || first[0].ProposerID != second[0].ResponderID {
+			t.Fatalf("offers = %+v then %+v, want the responder of the first round to propose in the second", first, second)
+		}
+
+		state := env.GetState()
+		if state.Resources["agent1"] != 10 || state.Resources["agent2"] != 10 {
+			t.Errorf("Resources = %v, want 10 each after proposing once and responding once", state.Resources)
+		}
+		if state.AcceptanceRate() != 1 || state.AverageOffer() != 4 {
+			t.Errorf("AcceptanceRate, AverageOffer = %.2f, %.2f, want 1.00, 4.00", state.AcceptanceRate(), state.AverageOffer())
+		}
+	})
+
+	t.Run("test rejected offer leaves both players with nothing", func(t *testing.T) {
+		env := NewUltimatumEnvironment(10, 3)
+		client := &ultimatumClient{offer: "ANSWER: 1", answer: "That is unfair. ANSWER: REJECT"}
+		proposer := newTestUltimatumAgent(t, "agent1", client)
+		for _, a := range []*agent.UltimatumAgent{proposer, newTestUltimatumAgent(t, "agent2", client)} {
+			if err := env.AddAgent(a); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", a.GetID(), err)
+			}
+		}
+
+		if err := env.Step(context.Background()); err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+		state := env.GetState()
+		if state.Resources["agent1"] != 0 || state.Resources["agent2"] != 0 {
+			t.Errorf("Resources = %v, want nothing for either player", state.Resources)
+		}
+		if state.Offers != 1 || state.AcceptanceRate() != 0 || state.AverageOffer() != 1 {
+			t.Errorf("Offers = %d, AcceptanceRate = %.2f, AverageOffer = %.2f, want 1, 0.00, 1.00", state.Offers, state.AcceptanceRate(), state.AverageOffer())
+		}
+		if memories := proposer.GetMemory().GetAllMessages(); len(memories) != 1 || !strings.Contains(memories[0], "rejected") {
+			t.Errorf("Expected a memory of the rejection, got %v", memories)
+		}
+	})
+	t.Run("test failed pair doesn't count as a proposer turn", func(t *testing.T) {
+		env := NewUltimatumEnvironment(10, 3)
+		client := &ultimatumClient{offer: "I'm not sure.", answer: "ANSWER: ACCEPT"}
+		for _, id := range []string{"agent1", "agent2"} {
+			if err := env.AddAgent(newTestUltimatumAgent(t, id, client)); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+
+		offers, err := env.StepWithResult(context.Background())
+		if err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+		state := env.GetState()
+		if len(offers) != 0 || state.FailedOffers != 1 {
+			t.Errorf("offers = %v, FailedOffers = %d, want the pair skipped", offers, state.FailedOffers)
+		}
+		if len(state.ProposerRuns) != 0 {
+			t.Errorf("ProposerRuns = %v, want no turns counted for a pair that didn't complete", state.ProposerRuns)
+		}
+	})
+	t.Run("test state can be read while the pairs play", func(t *testing.T) {
+		env := NewUltimatumEnvironment(10, 3)
+		client := &blockingClient{response: "ANSWER: 5 ANSWER: ACCEPT", started: make(chan struct{}, 2), release: make(chan struct{})}
+		for _, id := range []string{"agent1", "agent2"} {
+			if err := env.AddAgent(newTestUltimatumAgent(t, id, client)); err != nil {
+				t.Fatalf("Failed to add agent %s: %v", id, err)
+			}
+		}
+
+		stepped := make(chan error)
+		go func() {
+			stepped <- env.Step(context.Background())
+		}()
+		<-client.started
+
+		read := make(chan UltimatumState)
+		go func() {
+			read <- env.GetState()
+		}()
+		select {
+		case state := <-read:
+			if state.Offers != 0 {
+				t.Errorf("Offers = %d while the pair plays, want 0", state.Offers)
+			}
+		case <-time.After(time.Second):
+			t.Error("GetState blocked while the pair was playing")
+		}
+
+		close(client.release)
+		if err := <-stepped; err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+		if state := env.GetState(); state.Offers != 1 || state.AcceptanceRate() != 1 {
+			t.Errorf("Offers = %d, AcceptanceRate = %.2f after the step, want 1, 1.00", state.Offers, state.AcceptanceRate())
+		}
+	})
+}
@@ -0,0 +1,190 @@
+package experiment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/boristopalov/petri/pkg/agent"
+	"github.com/boristopalov/petri/pkg/environment"
+)
+
+// Checkpoint is the state a DonorGameExperiment needs to resume at the start of a generation
+type Checkpoint struct {
+	Generation        int                  `json:"generation"` // generation the experiment resumes at
+	Finished          bool                 `json:"finished"`   // whether the experiment had already run its last generation
+	Agents            []StrategyRecord     `json:"agents"`
+	StrategyFallbacks int                  `json:"strategy_fallbacks"`
+	RNG               environment.RNGState `json:"rng"`
+	Lineage           Lineage              `json:"lineage,omitempty"`
+	StatsPath         string               `json:"stats_path,omitempty"` // stats file a resumed experiment appends to
+	// Memories and Donations hold the memories and donation histories of the agents that have
+	// any by the start of a generation, the survivors carried over with elitism, by agent ID
+	Memories  map[string][]string               `json:"memories,omitempty"`
+	Donations map[string][]agent.DonationRecord `json:"donations,omitempty"`
+	// RoundLogs are the pairs of the rounds played so far, so a resumed run's round log covers them
+	RoundLogs []environment.RoundLog `json:"round_logs,omitempty"`
+}
+
+// WithCheckpoint saves a checkpoint to path whenever a generation is ready to run and when the
+// experiment finishes. If path already holds a checkpoint, Run resumes from it.
+func WithCheckpoint(path string) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.checkpointPath = path
+	}
+}
+
+// SaveCheckpoint writes the current generation, its agents with the memories and donation
+// histories they carried over, and the environment's random number generator position to path as
+// JSON. It is meant to be called between generations, before the current one runs; memories and
+// donation counts of a partly run generation aren't saved.
+func (e *DonorGameExperiment) SaveCheckpoint(path string) error {
+	var err error
+	checkpoint := Checkpoint{
+		Generation:        e.generation,
+		Finished:          e.done,
+		Agents:            e.strategyRecords(e.generation),
+		StrategyFallbacks: e.strategyFallbacks,
+		RNG:               e.env.RNGState(),
+		Lineage:           e.lineage,
+		Memories:          make(map[string][]string),
+		Donations:         make(map[string][]agent.DonationRecord),
+		RoundLogs:         e.env.GetRoundLogs(),
+	}
+	for _, a := range e.env.GetAgents() {
+		if memories := a.GetMemory().GetAllMessages(); len(memories) > 0 {
+			checkpoint.Memories[a.GetID()] = memories
+		}
+		if donations := a.GetDonationHistory(); len(donations) > 0 {
+			checkpoint.Donations[a.GetID()] = donations
+		}
+	}
+	if e.statsPath != "" {
+		if checkpoint.StatsPath, err = filepath.Abs(e.statsPath); err != nil {
+			return fmt.Errorf("failed to resolve stats file: %v", err)
+		}
+	}
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %v", err)
+	}
+	// Write to a temporary file first so an interrupted save doesn't destroy the last checkpoint
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %v", err)
+	}
+	return nil
+}
+
+// LoadCheckpoint replaces the experiment's agents with the ones saved in the checkpoint at path
+// and restores the environment's random number generator, so the next Step runs the saved generation
+func (e *DonorGameExperiment) LoadCheckpoint(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return fmt.Errorf("failed to parse checkpoint %s: %v", path, err)
+	}
+	if checkpoint.Generation < 1 || len(checkpoint.Agents) == 0 {
+		return fmt.Errorf("checkpoint %s has no generation to resume", path)
+	}
+
+	if e.pool != nil {
+		e.pool.Put(e.env.GetAgents()...)
+	}
+	if err := e.env.Reset(); err != nil {
+		return err
+	}
+	// Agents are only created through the factory here; strategies come from the checkpoint
+	ctx := context.Background()
+	for _, record := range checkpoint.Agents {
+		a, err := e.newAgent(ctx, record.AgentID, record.Strategy)
+		if err != nil {
+			return fmt.Errorf("failed to restore agent %s: %v", record.AgentID, err)
+		}
+		for _, memory := range checkpoint.Memories[record.AgentID] {
+			if err := a.GetMemory().Store(memory); err != nil {
+				return fmt.Errorf("failed to restore memories of agent %s: %v", record.AgentID, err)
+			}
+		}
+		for _, donation := range checkpoint.Donations[record.AgentID] {
+			a.RecordDonation(donation)
+		}
+		if err := e.env.AddAgent(a); err != nil {
+			return fmt.Errorf("failed to add agent to environment: %v", err)
+		}
+		if err := e.env.SetResources(record.AgentID, record.Resources); err != nil {
+			return err
+		}
+	}
+	e.env.RestoreRNG(checkpoint.RNG)
+	e.env.RestoreRoundLogs(checkpoint.RoundLogs)
+	e.env.SetGeneration(checkpoint.Generation)
+
+	e.generation = checkpoint.Generation
+	e.done = checkpoint.Finished
+	e.strategyFallbacks = checkpoint.StrategyFallbacks
+	e.lineage = checkpoint.Lineage
+	if e.lineage == nil {
+		e.lineage = make(Lineage)
+	}
+	e.generationStarted()
+	return nil
+}
+
+// resumeFromCheckpoint loads the configured checkpoint if one has been saved
+func (e *DonorGameExperiment) resumeFromCheckpoint() error {
+	if _, err := os.Stat(e.checkpointPath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err := e.LoadCheckpoint(e.checkpointPath); err != nil {
+		return err
+	}
+	if e.done {
+		log.Printf("Checkpoint %s is from a finished experiment, nothing to resume", e.checkpointPath)
+	} else {
+		log.Printf("Resuming from checkpoint %s at generation %d", e.checkpointPath, e.generation)
+	}
+	return nil
+}
+
+// checkpointStatsPath returns the stats file recorded in the configured checkpoint if the checkpoint
+// will be resumed and the file still exists, or "" otherwise
+func (e *DonorGameExperiment) checkpointStatsPath() string {
+	if e.checkpointPath == "" {
+		return ""
+	}
+	data, err := os.ReadFile(e.checkpointPath)
+	if err != nil {
+		return ""
+	}
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil || checkpoint.Finished || checkpoint.StatsPath == "" {
+		return ""
+	}
+	if _, err := os.Stat(checkpoint.StatsPath); err != nil {
+		log.Printf("Warning: Stats file %s of checkpoint %s is missing, writing a new one", checkpoint.StatsPath, e.checkpointPath)
+		return ""
+	}
+	return checkpoint.StatsPath
+}
+
+// saveCheckpoint saves the configured checkpoint, if any and if a generation has been
+// initialized. A failed save is logged rather than stopping the experiment.
+func (e *DonorGameExperiment) saveCheckpoint() {
+	if e.checkpointPath == "" || e.generation == 0 {
+		return
+	}
+	if err := e.SaveCheckpoint(e.checkpointPath); err != nil {
+		log.Printf("Warning: Failed to save checkpoint: %v", err)
+	}
+}
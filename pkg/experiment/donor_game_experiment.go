@@ -2,15 +2,22 @@ package experiment
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"math"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/boristopalov/petri/pkg/agent"
 	"github.com/boristopalov/petri/pkg/environment"
+	"github.com/boristopalov/petri/pkg/providers"
 )
 
 // DonorGameExperiment runs the donor game with generational evolution
@@ -21,7 +28,240 @@ type DonorGameExperiment struct {
 	numAgents           int     // number of agents per generation
 	numGenerations      int
 	roundsPerGeneration int
-	statsFile           *os.File // file for logging statistics
+	statsFile           *os.File                // file for logging statistics, closed when the experiment finishes
+	statsPath           string                  // path of statsFile, kept after it is closed
+	stats               io.Writer               // where statistics rows are written; statsFile unless WithStatsWriter is used
+	outputDir           string                  // directory the stats file and manifest are created in
+	format              OutputFormat            // which files the per-generation statistics are written to
+	reportPath          string                  // file the JSON report is written to; empty if it isn't written
+	report              Report                  // generations recorded so far for the JSON report
+	now                 func() time.Time        // clock used to timestamp output files
+	seedStrategies      []StrategyRecord        // strategies assigned to generation 1 instead of generating new ones
+	strategyDumpPath    string                  // file the final generation's strategies are written to
+	strategyFallbacks   int                     // number of agents in the current generation assigned the default strategy
+	fitness             FitnessFunc             // scores agents for survivor selection; nil ranks by resources
+	generationHook      GenerationHook          // called at the start of each generation's initialization
+	pool                *AgentPool              // recycles agents across generations; nil creates new agents
+	roundTimeout        time.Duration           // maximum duration of a single round; 0 means no limit
+	roundRetries        int                     // times a round abandoned with environment.ErrRoundFailed is retried
+	collapseThreshold   float64                 // average donation fraction below which cooperation has collapsed
+	adviceLimit         int                     // maximum number of survivors whose strategies are passed on; 0 means all
+	shuffleAdvice       bool                    // whether advisors are drawn from the survivors at random instead of by rank
+	callBudget          *providers.CallBudget   // caps provider calls across the experiment; nil means unlimited
+	lineage             Lineage                 // parents of every agent created so far
+	lineageDumpPath     string                  // file the lineage is written to when the experiment finishes
+	roundLogPath        string                  // file the pairs of every round are written to when Run returns
+	usage               *providers.UsageTracker // token usage of the agents' calls; nil if not tracked
+	reportedUsage       providers.Usage         // usage already attributed to earlier generations
+	subscribers         SubscriberCounter       // broker checked for leaked subscriptions; nil skips the check
+	baseSubscribers     int                     // subscribers the broker had before any agents were created
+	minViablePopulation int                     // fewest agents a generation may start with when some fail; 0 requires all
+	objectives          []Objective             // objectives recorded for every agent at the end of each generation
+	objectiveHistory    []GenerationObjectives  // objective values of each generation run so far
+	generation          int                     // generation the next Step runs; 0 before the first one is initialized
+	done                bool                    // whether the last generation has run
+	checkpointPath      string                  // file checkpoints are saved to and resumed from; empty disables checkpoints
+	sink                StatsSink               // receives per-round records; nil if they aren't exported
+	roundStatsPath      string                  // file a row of statistics is written to after every round; empty disables it
+	roundStats          *os.File                // open roundStatsPath, closed when the experiment finishes
+	progress            ExperimentProgress      // how far the experiment has got, read concurrently by Progress
+	status              Status                  // whether the experiment is running, read concurrently by GetStatus
+	progressMu          sync.RWMutex            // guards progress and status
+	stopRequested       atomic.Bool             // set by Stop to end the experiment after the current round
+	reporter            *ProgressReporter       // shows the experiment's progress after every round; nil if not reported
+	hooks               lifecycleHooks          // callbacks registered with OnGenerationStart, OnRoundEnd and OnGenerationEnd
+	elitism             bool                    // whether survivors themselves carry over into the next generation
+}
+
+// SubscriberCounter is implemented by message brokers that can report how many agents are subscribed
+type SubscriberCounter interface {
+	SubscriberCount() int
+}
+
+// GenerationHook is called at the start of each generation and may change environment parameters
+type GenerationHook func(gen int, env *environment.DonorGameEnvironment)
+
+// StatsHook is called with a generation's statistics once its rounds have run
+type StatsHook func(stats GenerationStats)
+
+// ExperimentOption configures optional DonorGameExperiment behavior
+type ExperimentOption func(*DonorGameExperiment)
+
+// WithSeedStrategies assigns the given strategies to generation 1 agents instead of generating them.
+// If there are fewer strategies than agents, they are reused in order.
+func WithSeedStrategies(records []StrategyRecord) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.seedStrategies = records
+	}
+}
+
+// WithGenerationHook calls hook at the start of each generation's initialization,
+// e.g. to change the donation multiplier on a schedule
+func WithGenerationHook(hook GenerationHook) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.generationHook = hook
+	}
+}
+
+// WithStatsHook calls hook with each generation's statistics after they are logged, e.g. to
+// stream them to a remote observer. It is registered like a hook passed to OnGenerationEnd.
+func WithStatsHook(hook StatsHook) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.OnGenerationEnd(func(gen int, stats GenerationStats) {
+			hook(stats)
+		})
+	}
+}
+
+// WithStrategyDump writes the final generation's strategies to path when the experiment finishes.
+// A relative path is resolved against the output directory.
+func WithStrategyDump(path string) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.strategyDumpPath = path
+	}
+}
+
+// WithLineageDump writes the experiment's lineage to path when the experiment finishes,
+// as Graphviz DOT if path ends in .dot and as JSON otherwise. A relative path is resolved against
+// the output directory.
+func WithLineageDump(path string) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.lineageDumpPath = path
+	}
+}
+
+// WithRoundLog writes the pairs of every round to path as JSON when Run returns, even if it fails,
+// so together with the seed every interaction of a run can be audited. A relative path is resolved
+// against the output directory.
+func WithRoundLog(path string) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.roundLogPath = path
+	}
+}
+
+// WithElitism carries each generation's survivors into the next generation with their strategies
+// and memories, instead of only passing their strategies on as advice. Their resources are reset,
+// and only the remaining slots are filled with new agents.
+func WithElitism() ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.elitism = true
+	}
+}
+
+// WithRoundStats writes a CSV row of population statistics to path after every round, so the
+// dynamics within a generation can be plotted. A relative path is resolved against the output
+// directory, and a resumed experiment appends to the file.
+func WithRoundStats(path string) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.roundStatsPath = path
+	}
+}
+
+// WithAgentPool recycles agents from one generation to the next instead of creating new ones
+func WithAgentPool() ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.pool = NewAgentPool(e.agentFactory)
+	}
+}
+
+// WithRoundTimeout limits each round to d. A round that times out is skipped and the
+// experiment moves on to the next round rather than failing.
+func WithRoundTimeout(d time.Duration) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.roundTimeout = d
+	}
+}
+
+// WithRoundRetries retries a round up to n times when too many of its donations fail, as set with
+// environment.WithMaxFailureRate, before the experiment stops with the error. By default the
+// experiment stops on the first abandoned round rather than recording meaningless statistics.
+func WithRoundRetries(n int) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.roundRetries = n
+	}
+}
+
+// WithCollapseThreshold sets the average donation fraction below which a generation is
+// flagged as a cooperation collapse. The default is DefaultCollapseThreshold.
+func WithCollapseThreshold(threshold float64) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.collapseThreshold = threshold
+	}
+}
+
+// WithAdviceLimit passes only the strategies of the top n survivors on to the next generation,
+// keeping strategy prompts bounded when there are many survivors
+func WithAdviceLimit(n int) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.adviceLimit = n
+	}
+}
+
+// WithShuffledAdvice draws the survivors whose strategies are passed on, and the order they are
+// listed in, at random from the environment's RNG instead of by rank, so neither the advice limit
+// nor the prompt's order favours particular survivors
+func WithShuffledAdvice() ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.shuffleAdvice = true
+	}
+}
+
+// WithMinViablePopulation lets a generation start with the agents that were created and got a
+// strategy when others fail, as long as there are at least n of them. Without it any failure
+// aborts the generation.
+func WithMinViablePopulation(n int) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.minViablePopulation = n
+	}
+}
+
+// WithCallBudget stops the experiment gracefully once budget is exhausted: the current round
+// finishes, its generation's stats are reported and survivors are selected, then the run ends.
+// The agents' clients must be wrapped with budget.Wrap for their calls to count.
+func WithCallBudget(budget *providers.CallBudget) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.callBudget = budget
+	}
+}
+
+// WithUsageTracker reports the tokens consumed by each generation in its statistics.
+// The agents' clients must be wrapped with tracker.Wrap for their usage to be tracked.
+func WithUsageTracker(tracker *providers.UsageTracker) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.usage = tracker
+	}
+}
+
+// WithSubscriberCheck warns if broker has more subscribers after a generation is initialized
+// than one generation of agents accounts for, which means agents from earlier generations
+// were never unsubscribed
+func WithSubscriberCheck(broker SubscriberCounter) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.subscribers = broker
+		e.baseSubscribers = broker.SubscriberCount()
+	}
+}
+
+// WithStatsWriter writes the statistics CSV to w instead of a file
+func WithStatsWriter(w io.Writer) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.stats = w
+	}
+}
+
+// WithOutputDir creates the stats file, report and manifest in dir instead of the working
+// directory. dir is created if it doesn't exist.
+func WithOutputDir(dir string) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.outputDir = dir
+	}
+}
+
+// WithClock sets the clock used to timestamp output files, e.g. a fake clock in tests
+func WithClock(now func() time.Time) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.now = now
+	}
 }
 
 // NewDonorGameExperiment creates a new donor game experiment
@@ -32,203 +272,724 @@ func NewDonorGameExperiment(
 	numAgents int,
 	numGenerations int,
 	roundsPerGeneration int,
+	opts ...ExperimentOption,
 ) (*DonorGameExperiment, error) {
-	// Create stats file with timestamp
-	timestamp := time.Now().Format("2006-01-02_15-04-05")
-	statsFile, err := os.Create(fmt.Sprintf("experiment_stats_%s.csv", timestamp))
-	if err != nil {
-		log.Printf("Warning: Failed to create stats file: %v", err)
-	} else {
-		// Write CSV header
-		header := "Generation,TotalResources,AverageResources,StandardDeviation,ResourceInequality,SuccessfulDonations,FailedDonations,SuccessRate\n"
-		statsFile.WriteString(header)
-	}
-
-	return &DonorGameExperiment{
+	e := &DonorGameExperiment{
 		env:                 env,
 		agentFactory:        agentFactory,
 		survivorRatio:       survivorRatio,
 		numAgents:           numAgents,
 		numGenerations:      numGenerations,
 		roundsPerGeneration: roundsPerGeneration,
-		statsFile:           statsFile,
-	}, nil
-}
+		now:                 time.Now,
+		collapseThreshold:   DefaultCollapseThreshold,
+		lineage:             make(Lineage),
+		format:              FormatCSV,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
 
-// Run executes the experiment for the specified number of generations
-func (e *DonorGameExperiment) Run(ctx context.Context) error {
-	// Initialize first generation
-	if err := e.initializeGeneration(ctx, 1, ""); err != nil {
-		return fmt.Errorf("failed to initialize first generation: %v", err)
+	if e.outputDir != "" {
+		if err := os.MkdirAll(e.outputDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create output directory: %v", err)
+		}
 	}
+	e.roundStatsPath = e.outputPath(e.roundStatsPath)
+	e.roundLogPath = e.outputPath(e.roundLogPath)
+	e.strategyDumpPath = e.outputPath(e.strategyDumpPath)
+	e.lineageDumpPath = e.outputPath(e.lineageDumpPath)
 
-	// Run for specified number of generations
-	for gen := 1; gen <= e.numGenerations; gen++ {
-		log.Printf("Starting generation %d", gen)
+	// A resumed experiment appends to the stats files of the run it resumes, which have headers
+	resumedStats := e.checkpointStatsPath()
+	if e.roundStatsPath != "" {
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if resumedStats != "" {
+			flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+		roundStats, err := os.OpenFile(e.roundStatsPath, flags, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create round stats file: %v", err)
+		}
+		e.roundStats = roundStats
+		if info, err := roundStats.Stat(); err == nil && info.Size() == 0 {
+			io.WriteString(e.roundStats, "Generation,Round,TotalResources,AverageResources,Gini,SuccessfulDonations,FailedDonations\n")
+		}
+	}
 
-		// Run all rounds in this generation
-		if err := e.runGeneration(ctx, gen); err != nil {
-			return fmt.Errorf("failed to run generation %d: %v", gen, err)
+	// Create stats file with timestamp
+	startedAt := e.now()
+	timestamp := startedAt.Format("2006-01-02_15-04-05")
+	appending := false
+	if e.stats == nil && e.format.csv() {
+		var statsFile *os.File
+		var err error
+		if resumedStats != "" {
+			statsFile, err = os.OpenFile(resumedStats, os.O_APPEND|os.O_WRONLY, 0644)
+			appending = true
+		} else {
+			statsFile, err = os.Create(filepath.Join(e.outputDir, fmt.Sprintf("experiment_stats_%s.csv", timestamp)))
 		}
+		if err != nil {
+			if e.roundStats != nil {
+				e.roundStats.Close()
+			}
+			return nil, fmt.Errorf("failed to create stats file: %v", err)
+		}
+		e.statsFile = statsFile
+		e.statsPath = statsFile.Name()
+		e.stats = statsFile
+	}
+	if e.stats != nil && !appending {
+		// Write CSV header
+		header := "Generation,TotalResources,AverageResources,StandardDeviation,ResourceInequality,Gini,SuccessfulDonations,FailedDonations,SuccessRate,StrategyFallbacks,DonationMultiplier,RoundsPerGen,AvgDonationFraction,CooperationCollapse,FinishReasons,ModelBreakdown,TotalPunishments,PunishmentSpent\n"
+		io.WriteString(e.stats, header)
+	}
+
+	if e.format.json() {
+		e.reportPath = filepath.Join(e.outputDir, fmt.Sprintf("experiment_%s.json", timestamp))
+		e.report.Manifest = e.manifest(startedAt)
+	}
+
+	log.Printf("Experiment seed: %d", env.GetSeed())
+	if err := writeManifest(filepath.Join(e.outputDir, fmt.Sprintf("experiment_manifest_%s.json", timestamp)), e.manifest(startedAt)); err != nil {
+		log.Printf("Warning: Failed to write manifest: %v", err)
+	}
+	return e, nil
+}
 
-		// Print generation statistics
-		e.printGenerationStats(gen)
+// outputPath resolves a relative path of an output file against the output directory
+func (e *DonorGameExperiment) outputPath(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(e.outputDir, path)
+}
 
-		// Select survivors and get their strategies
-		survivors := e.selectSurvivors()
-		survivorAdvice := e.getSurvivorAdvice(survivors)
+// ErrExperimentDone is returned by Step once the experiment has run its last generation
+var ErrExperimentDone = errors.New("experiment has finished")
 
-		// Initialize next generation with survivors' strategies
-		if gen < e.numGenerations {
-			if err := e.initializeGeneration(ctx, gen+1, survivorAdvice); err != nil {
-				return fmt.Errorf("failed to initialize generation %d: %v", gen+1, err)
+// Run executes the experiment for the specified number of generations. If a checkpoint has been
+// saved to the path given to WithCheckpoint, a new experiment resumes from it. The stats outputs
+// are closed when Run returns, even if it fails.
+func (e *DonorGameExperiment) Run(ctx context.Context) (err error) {
+	defer func() {
+		if closeErr := e.closeOutputs(); err == nil {
+			err = closeErr
+		}
+		// The round log is written even if the run failed, since it is most useful for auditing one that did
+		if e.roundLogPath != "" {
+			if logErr := SaveRoundLogs(e.roundLogPath, e.env.GetRoundLogs()); err == nil {
+				err = logErr
 			}
 		}
+	}()
+	e.updateStatus(func(s *Status) {
+		s.Running = true
+		if s.StartTime.IsZero() {
+			s.StartTime = e.now()
+		}
+	})
+	defer e.updateStatus(func(s *Status) {
+		s.Running = false
+		s.EndTime = e.now()
+	})
+	if e.reporter != nil {
+		defer e.reporter.Finish()
 	}
 
-	// Close stats file
-	if e.statsFile != nil {
-		e.statsFile.Close()
+	if e.checkpointPath != "" && e.generation == 0 {
+		if err := e.resumeFromCheckpoint(); err != nil {
+			e.recordError(err)
+			return err
+		}
+	}
+	for !e.Done() {
+		if err := e.Step(ctx); err != nil {
+			e.recordError(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// recordError adds an error the experiment stopped with to its status
+func (e *DonorGameExperiment) recordError(err error) {
+	e.updateStatus(func(s *Status) {
+		s.Errors = append(s.Errors, err)
+	})
+}
+
+// Step runs the next generation, initializing the first one if needed, and then either
+// initializes the generation after it or finishes the experiment
+func (e *DonorGameExperiment) Step(ctx context.Context) error {
+	if e.done {
+		return ErrExperimentDone
+	}
+	if e.stopping() {
+		log.Printf("Experiment stopped before generation %d", max(e.generation, 1))
+		return e.finish()
+	}
+	if e.generation == 0 {
+		if err := e.initializeGeneration(ctx, 1, nil, "", nil); err != nil {
+			return fmt.Errorf("failed to initialize first generation: %v", err)
+		}
+		e.generation = 1
+		e.generationStarted()
+		e.saveCheckpoint()
 	}
 
+	gen := e.generation
+	log.Printf("Starting generation %d", gen)
+
+	// Run all rounds in this generation
+	if err := e.runGeneration(ctx, gen); err != nil {
+		return fmt.Errorf("failed to run generation %d: %w", gen, err)
+	}
+
+	// Print generation statistics
+	stats := e.printGenerationStats(gen)
+	for _, hook := range e.hooks.generationEnd {
+		hook(gen, stats)
+	}
+	if len(e.objectives) > 0 {
+		record := e.recordObjectives(gen)
+		log.Printf("Generation %d Pareto front over %v: %v", gen, record.Objectives, record.Front)
+	}
+
+	// Select survivors and get their strategies
+	survivors := e.selectSurvivors()
+	survivorAdvice := e.getSurvivorAdvice(survivors)
+	advisors := e.advisors(survivors)
+	e.recordGeneration(stats, survivors)
+
+	if gen >= e.numGenerations {
+		return e.finish()
+	}
+	if e.stopping() {
+		log.Printf("Experiment stopped in generation %d with survivors %v", gen, survivors)
+		return e.finish()
+	}
+
+	// Stop if the budget can't pay for the next generation's strategies
+	if e.callBudget != nil && e.callBudget.Remaining() < e.numAgents {
+		log.Printf("Call budget exhausted after %d calls in generation %d, stopping with survivors %v",
+			e.callBudget.Used(), gen, survivors)
+		return e.finish()
+	}
+
+	// Initialize next generation with survivors' strategies, and the survivors themselves with elitism
+	var elites []*agent.DonorGameAgent
+	if e.elitism {
+		elites = e.agentsByID(survivors)
+	}
+	if err := e.initializeGeneration(ctx, gen+1, advisors, survivorAdvice, elites); err != nil {
+		return fmt.Errorf("failed to initialize generation %d: %v", gen+1, err)
+	}
+	e.generation = gen + 1
+	e.generationStarted()
+	e.saveCheckpoint()
+	return nil
+}
+
+// Done reports whether the experiment has run its last generation
+func (e *DonorGameExperiment) Done() bool {
+	return e.done
+}
+
+// Generation returns the generation the next Step runs, or the last one run once the experiment is done.
+// It is 0 before the first Step.
+func (e *DonorGameExperiment) Generation() int {
+	return e.generation
+}
+
+// finish closes the stats outputs and writes the strategy and lineage dumps for the last generation
+func (e *DonorGameExperiment) finish() error {
+	e.done = true
+	e.updateProgress(func(p *ExperimentProgress) {
+		p.Done = true
+	})
+	e.saveCheckpoint()
+
+	if e.reporter != nil {
+		e.reporter.Finish()
+	}
+
+	if err := e.closeOutputs(); err != nil {
+		return err
+	}
+
+	if e.strategyDumpPath != "" {
+		if err := SaveStrategies(e.strategyDumpPath, e.strategyRecords(e.generation)); err != nil {
+			return err
+		}
+	}
+	if e.lineageDumpPath != "" {
+		if err := SaveLineage(e.lineageDumpPath, e.lineage); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// Initialize a new generation of agents
-func (e *DonorGameExperiment) initializeGeneration(ctx context.Context, generation int, survivorAdvice string) error {
+// closeOutputs closes the stats file, round stats file and stats sink, returning the first error.
+// It can be called more than once; outputs closed earlier are skipped.
+func (e *DonorGameExperiment) closeOutputs() error {
+	var first error
+	if e.statsFile != nil {
+		if err := e.statsFile.Close(); err != nil {
+			first = fmt.Errorf("failed to close stats file: %v", err)
+		}
+		if e.stats == io.Writer(e.statsFile) {
+			e.stats = nil
+		}
+		e.statsFile = nil
+	}
+	if e.roundStats != nil {
+		if err := e.roundStats.Close(); err != nil && first == nil {
+			first = fmt.Errorf("failed to close round stats file: %v", err)
+		}
+		e.roundStats = nil
+	}
+	if e.sink != nil {
+		if err := e.sink.Close(); err != nil && first == nil {
+			first = fmt.Errorf("failed to close stats sink: %v", err)
+		}
+		e.sink = nil
+	}
+	return first
+}
+
+// generationStarted records the generation that was just initialized in the experiment's progress
+// and calls the OnGenerationStart hooks
+func (e *DonorGameExperiment) generationStarted() {
+	population := len(e.env.GetAgents())
+	rounds := e.env.GetRoundsPerGen()
+	e.updateProgress(func(p *ExperimentProgress) {
+		p.Generation = e.generation
+		p.Round = 0
+		p.Rounds = rounds
+		p.Population = population
+		p.Done = e.done
+	})
+	for _, hook := range e.hooks.generationStart {
+		hook(e.generation)
+	}
+}
+
+// Lineage returns the parents of every agent the experiment has created
+func (e *DonorGameExperiment) Lineage() Lineage {
+	lineage := make(Lineage, len(e.lineage))
+	for child, parents := range e.lineage {
+		lineage[child] = slices.Clone(parents)
+	}
+	return lineage
+}
+
+// strategyRecords returns the strategies and resources of the current agents
+func (e *DonorGameExperiment) strategyRecords(generation int) []StrategyRecord {
+	state := e.env.GetState()
+	agents := e.env.GetAgents()
+	records := make([]StrategyRecord, 0, len(agents))
+	for _, a := range agents {
+		records = append(records, StrategyRecord{
+			AgentID:    a.GetID(),
+			Generation: generation,
+			Resources:  state.AgentResources[a.GetID()],
+			Strategy:   a.GetStrategy(),
+			Model:      a.GetModel().Id,
+		})
+	}
+	return records
+}
+
+// Initialize a new generation of agents. Each agent's lineage is recorded as parents,
+// the survivors whose strategies make up survivorAdvice. The elites stay in the environment
+// and new agents are only created for the remaining slots.
+func (e *DonorGameExperiment) initializeGeneration(ctx context.Context, generation int, parents []string, survivorAdvice string, elites []*agent.DonorGameAgent) error {
 	log.Printf("Initializing generation %d", generation)
+	if len(elites) > 0 {
+		log.Printf("Carrying %d survivors into generation %d", len(elites), generation)
+	}
+
+	if e.generationHook != nil {
+		e.generationHook(generation, e.env)
+	}
+
+	// Return the previous generation's agents to the pool before they are removed
+	if e.pool != nil {
+		e.pool.Put(slices.DeleteFunc(e.env.GetAgents(), func(a *agent.DonorGameAgent) bool {
+			return slices.Contains(elites, a)
+		})...)
+	}
 
 	// Reset environment
-	if err := e.env.Reset(); err != nil {
+	if err := e.env.ResetKeeping(elites); err != nil {
 		return err
 	}
+	e.env.SeedGeneration(generation)
+	e.env.SetGeneration(generation)
+
+	seeded := generation == 1 && len(e.seedStrategies) > 0
+	if seeded && len(e.seedStrategies) < e.numAgents {
+		log.Printf("Only %d seed strategies for %d agents, reusing them in order", len(e.seedStrategies), e.numAgents)
+	}
+
+	e.strategyFallbacks = 0
 
 	// Create agents
-	for i := 0; i < e.numAgents; i++ {
+	agents := make([]*agent.DonorGameAgent, 0, e.numAgents-len(elites))
+	for i := len(elites); i < e.numAgents; i++ {
 		id := fmt.Sprintf("%d_%d", generation, i)
-		agent, err := e.agentFactory(ctx, id, "")
+		strategy := ""
+		e.lineage[id] = slices.Clone(parents)
+		if seeded {
+			seed := e.seedStrategies[i%len(e.seedStrategies)]
+			strategy = seed.Strategy
+			e.lineage[id] = []string{seed.AgentID}
+		}
+		agent, err := e.newAgent(ctx, id, strategy)
 		if err != nil {
+			if e.minViablePopulation > 0 {
+				log.Printf("Warning: Dropping agent %s from generation %d, failed to create it: %v", id, generation, err)
+				delete(e.lineage, id)
+				continue
+			}
 			return fmt.Errorf("failed to create agent: %v", err)
 		}
 
-		// Generate strategy for the agent
-		if err := agent.GenerateStrategy(ctx, generation, survivorAdvice); err != nil {
-			return fmt.Errorf("failed to generate strategy for agent %s: %v", id, err)
+		// Generate strategy for the agent unless one was imported
+		if !seeded {
+			if err := agent.GenerateStrategy(ctx, generation, survivorAdvice); err != nil {
+				if e.minViablePopulation > 0 {
+					log.Printf("Warning: Dropping agent %s from generation %d, failed to generate its strategy: %v", id, generation, err)
+					e.discardAgent(agent)
+					continue
+				}
+				return fmt.Errorf("failed to generate strategy for agent %s: %v", id, err)
+			}
+			if agent.UsedFallbackStrategy() {
+				e.strategyFallbacks++
+			}
 		}
+		agents = append(agents, agent)
+	}
 
-		// Add agent to environment
+	if population := len(elites) + len(agents); population < e.numAgents {
+		if population < e.minViablePopulation {
+			for _, a := range agents {
+				e.discardAgent(a)
+			}
+			return fmt.Errorf("only %d of %d agents in generation %d are viable, below the minimum viable population of %d",
+				population, e.numAgents, generation, e.minViablePopulation)
+		}
+		log.Printf("Warning: Starting generation %d with %d of %d agents", generation, population, e.numAgents)
+	}
+
+	// Add agents to environment
+	for _, agent := range agents {
 		if err := e.env.AddAgent(agent); err != nil {
 			return fmt.Errorf("failed to add agent to environment: %v", err)
 		}
 	}
 
+	e.checkSubscribers(generation)
 	return nil
 }
 
+// checkSubscribers warns if the broker has subscribers left over from earlier generations
+func (e *DonorGameExperiment) checkSubscribers(generation int) {
+	if e.subscribers == nil {
+		return
+	}
+	if count, limit := e.subscribers.SubscriberCount(), e.baseSubscribers+e.numAgents; count > limit {
+		log.Printf("Warning: Broker has %d subscribers after initializing generation %d, expected at most %d; agents from earlier generations may not have been unsubscribed",
+			count, generation, limit)
+	}
+}
+
+// discardAgent drops an agent that won't take part in the generation, unsubscribing it
+// and returning it to the pool if there is one
+func (e *DonorGameExperiment) discardAgent(a *agent.DonorGameAgent) {
+	delete(e.lineage, a.GetID())
+	if err := a.Unsubscribe(); err != nil {
+		log.Printf("Warning: Failed to unsubscribe agent %s: %v", a.GetID(), err)
+	}
+	if e.pool != nil {
+		e.pool.Put(a)
+	}
+}
+
+// newAgent takes an agent from the pool if there is one, otherwise it uses the agent factory
+func (e *DonorGameExperiment) newAgent(ctx context.Context, id string, strategy string) (*agent.DonorGameAgent, error) {
+	if e.pool != nil {
+		return e.pool.Get(ctx, id, strategy)
+	}
+	return e.agentFactory(ctx, id, strategy)
+}
+
 // Run all rounds in current generation
 func (e *DonorGameExperiment) runGeneration(ctx context.Context, generation int) error {
 	roundsPerGen := e.env.GetRoundsPerGen()
 	for round := 0; round < roundsPerGen; round++ {
 		log.Printf("Generation %d, Round %d/%d", generation, round+1, roundsPerGen)
-		if err := e.env.Step(ctx); err != nil {
+		e.updateProgress(func(p *ExperimentProgress) {
+			p.Round = round + 1
+		})
+		roundStart := e.now()
+		err := e.runRound(ctx, generation)
+		for retry := 1; retry <= e.roundRetries && errors.Is(err, environment.ErrRoundFailed); retry++ {
+			log.Printf("Warning: Generation %d, Round %d failed, retrying (%d/%d): %v", generation, round+1, retry, e.roundRetries, err)
+			err = e.runRound(ctx, generation)
+		}
+		if err != nil {
+			// Only the round's own deadline is recoverable; the experiment's context ending is not
+			if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+				log.Printf("Warning: Generation %d, Round %d timed out after %v, skipping", generation, round+1, e.roundTimeout)
+				e.env.SkipRound()
+				e.reportRound(generation, round, roundsPerGen, e.now().Sub(roundStart))
+				continue
+			}
 			return err
 		}
+		e.reportRound(generation, round, roundsPerGen, e.now().Sub(roundStart))
+		if len(e.hooks.roundEnd) > 0 {
+			state := e.env.GetState()
+			for _, hook := range e.hooks.roundEnd {
+				hook(generation, round, state)
+			}
+		}
+		if e.budgetExhausted() {
+			log.Printf("Call budget exhausted, ending generation %d after round %d", generation, round+1)
+			return nil
+		}
+		if e.stopping() {
+			log.Printf("Experiment stopped, ending generation %d after round %d", generation, round+1)
+			return nil
+		}
 	}
 	return nil
 }
 
+// reportRound reports a finished round, numbered from 0, to the progress reporter if there is one
+func (e *DonorGameExperiment) reportRound(generation, round, rounds int, d time.Duration) {
+	if e.reporter != nil {
+		e.reporter.RoundDone(generation, e.numGenerations, round+1, rounds, d)
+	}
+}
+
+// budgetExhausted reports whether the experiment has used up its call budget
+func (e *DonorGameExperiment) budgetExhausted() bool {
+	return e.callBudget != nil && e.callBudget.Exhausted()
+}
+
+// runRound steps the environment once, bounded by the round timeout if one is set,
+// and writes the round's records to the stats sink and its statistics to the round stats file
+func (e *DonorGameExperiment) runRound(ctx context.Context, generation int) error {
+	if e.roundTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.roundTimeout)
+		defer cancel()
+	}
+	result, err := e.env.StepWithResult(ctx)
+	if err != nil {
+		return err
+	}
+	if e.sink != nil {
+		if err := e.sink.WriteRecords(roundRecords(generation, result, e.env.GetState())); err != nil {
+			log.Printf("Warning: Failed to write generation %d round %d records: %v", generation, result.Round+1, err)
+		}
+	}
+	if e.roundStats != nil {
+		e.writeRoundStats(computeRoundStats(generation, result.Round, e.env.GetState()))
+	}
+	return nil
+}
+
+// writeRoundStats appends a round's statistics to the round stats file
+func (e *DonorGameExperiment) writeRoundStats(stats RoundStats) {
+	precision := e.env.GetPrecision()
+	csvLine := fmt.Sprintf("%d,%d,%.*f,%.*f,%.4f,%d,%d\n",
+		stats.Generation,
+		stats.Round,
+		precision,
+		stats.TotalResources,
+		precision,
+		stats.AverageResources,
+		stats.Gini,
+		stats.SuccessfulDonations,
+		stats.FailedDonations,
+	)
+	if _, err := io.WriteString(e.roundStats, csvLine); err != nil {
+		log.Printf("Warning: Failed to write to round stats file: %v", err)
+	}
+}
+
 // Select top performing agents to survive to next generation
 func (e *DonorGameExperiment) selectSurvivors() []string {
 	numSurvivors := int(float64(e.numAgents) * e.survivorRatio)
-	return e.env.GetTopAgents(numSurvivors)
+	if e.fitness == nil {
+		return e.env.GetTopAgents(numSurvivors)
+	}
+
+	// Ties are broken with the seeded RNG so agents on the same Pareto front, for example, aren't
+	// picked by ID
+	ranked := rankAgents(e.env.GetState(), e.fitness, e.env.Shuffle)
+	if numSurvivors < len(ranked) {
+		ranked = ranked[:numSurvivors]
+	}
+	return ranked
 }
 
-// Get advice from surviving agents for the next generation
+// Get advice from surviving agents for the next generation. Survivors are ranked best first,
+// so only the first adviceLimit of them are included, unless advice is shuffled.
 func (e *DonorGameExperiment) getSurvivorAdvice(survivors []string) string {
+	omitted := len(survivors)
+	survivors = e.advisors(survivors)
+	omitted -= len(survivors)
+
 	state := e.env.GetState()
 	var advice []string
 	for _, id := range survivors {
 		resources := state.AgentResources[id]
 		for _, agent := range e.env.GetAgents() {
 			if agent.GetID() == id {
-				advice = append(advice, fmt.Sprintf("Agent %s (%.2f resources): %s",
-					id, resources, agent.GetStrategy()))
+				advice = append(advice, fmt.Sprintf("Agent %s (%.*f resources): %s",
+					id, e.env.GetPrecision(), resources, agent.GetStrategy()))
 				break
 			}
 		}
 	}
+	if omitted > 0 {
+		advice = append(advice, fmt.Sprintf("(%d more surviving strategies omitted)", omitted))
+	}
 	return "Successful strategies from previous generation:\n" +
 		strings.Join(advice, "\n")
 }
 
-// Print statistics for the current generation
-func (e *DonorGameExperiment) printGenerationStats(generation int) {
-	state := e.env.GetState()
-
-	// Calculate statistics
-	var totalResources float64
-	var minResources = math.MaxFloat64
-	var maxResources = -math.MaxFloat64
-	resources := make([]float64, 0, len(state.AgentResources))
-
-	for _, r := range state.AgentResources {
-		totalResources += r
-		resources = append(resources, r)
-		if r < minResources {
-			minResources = r
-		}
-		if r > maxResources {
-			maxResources = r
+// agentsByID returns the current agents with the given IDs, in the order of ids
+func (e *DonorGameExperiment) agentsByID(ids []string) []*agent.DonorGameAgent {
+	agents := e.env.GetAgents()
+	found := make([]*agent.DonorGameAgent, 0, len(ids))
+	for _, id := range ids {
+		if i := slices.IndexFunc(agents, func(a *agent.DonorGameAgent) bool { return a.GetID() == id }); i >= 0 {
+			found = append(found, agents[i])
 		}
 	}
+	return found
+}
 
-	// Calculate mean
-	avgResources := totalResources / float64(len(state.AgentResources))
-
-	// Calculate standard deviation
-	var sumSquares float64
-	for _, r := range resources {
-		diff := r - avgResources
-		sumSquares += diff * diff
+// advisors returns the survivors whose strategies are passed on to the next generation
+func (e *DonorGameExperiment) advisors(survivors []string) []string {
+	if e.shuffleAdvice {
+		ranked := survivors
+		survivors = nil
+		for _, a := range e.env.GetAgentsShuffled() {
+			if slices.Contains(ranked, a.GetID()) {
+				survivors = append(survivors, a.GetID())
+			}
+		}
 	}
-	stdDev := math.Sqrt(sumSquares / float64(len(resources)))
+	if e.adviceLimit > 0 && len(survivors) > e.adviceLimit {
+		return survivors[:e.adviceLimit]
+	}
+	return survivors
+}
 
-	resourceInequality := maxResources - minResources
+// Print statistics for the current generation and return them
+func (e *DonorGameExperiment) printGenerationStats(generation int) GenerationStats {
+	stats := computeGenerationStats(generation, e.env.GetState())
+	stats.StrategyFallbacks = e.strategyFallbacks
+	stats.DonationMultiplier = e.env.GetDonationMultiplier()
+	stats.RoundsPerGen = e.env.GetRoundsPerGen()
 
-	// Calculate donation success rate
-	totalDonations := state.SuccessfulDonations + state.FailedDonations
-	var successRate float64
-	if totalDonations > 0 {
-		successRate = float64(state.SuccessfulDonations) / float64(totalDonations) * 100
+	// Resource metrics are meaningless for an extinct population, so they are reported as NA
+	precision := e.env.GetPrecision()
+	totalResources := fmt.Sprintf("%.*f", precision, stats.TotalResources)
+	avgResources := fmt.Sprintf("%.*f", precision, stats.AverageResources)
+	stdDev := fmt.Sprintf("%.*f", precision, stats.StandardDeviation)
+	resourceInequality := fmt.Sprintf("%.*f", precision, stats.ResourceInequality)
+	gini := fmt.Sprintf("%.4f", stats.Gini)
+	if stats.Extinct() {
+		log.Printf("Warning: Population extinct in generation %d, resource metrics are not available", generation)
+		avgResources, stdDev, resourceInequality, gini = "NA", "NA", "NA", "NA"
+	}
+	collapsed := stats.CooperationCollapsed(e.collapseThreshold)
+	if collapsed {
+		log.Printf("Warning: Cooperation collapsed in generation %d, donors gave away %.1f%% of their resources on average",
+			generation, stats.AvgDonationFraction*100)
 	}
 
 	// Print to console
 	log.Printf("\n=== Generation %d Statistics ===", generation)
 	log.Printf("Resource Metrics:")
-	log.Printf("  Total Resources: %.2f", totalResources)
-	log.Printf("  Average Resources: %.2f", avgResources)
-	log.Printf("  Standard Deviation: %.2f", stdDev)
-	log.Printf("  Resource Inequality (max-min): %.2f", resourceInequality)
+	log.Printf("  Population: %d", stats.Population)
+	log.Printf("  Total Resources: %s", totalResources)
+	log.Printf("  Average Resources: %s", avgResources)
+	log.Printf("  Standard Deviation: %s", stdDev)
+	log.Printf("  Resource Inequality (max-min): %s", resourceInequality)
+	log.Printf("  Gini Coefficient: %s", gini)
 	log.Printf("\nDonation Metrics:")
-	log.Printf("  Successful Donations: %d", state.SuccessfulDonations)
-	log.Printf("  Failed Donations: %d", state.FailedDonations)
-	log.Printf("  Success Rate: %.1f%%", successRate)
+	log.Printf("  Successful Donations: %d", stats.SuccessfulDonations)
+	log.Printf("  Failed Donations: %d", stats.FailedDonations)
+	log.Printf("  Success Rate: %.1f%%", stats.SuccessRate)
+	log.Printf("  Average Donation Fraction: %.1f%%", stats.AvgDonationFraction*100)
+	log.Printf("  Cooperation Collapse: %t", collapsed)
+	log.Printf("  Punishments: %d (%.*f resources spent)", stats.TotalPunishments, precision, stats.PunishmentSpent)
+	log.Printf("  Finish Reasons: %s", stats.FinishReasonSummary())
+	log.Printf("\nModel Breakdown:")
+	for _, model := range slices.Sorted(maps.Keys(stats.Models)) {
+		m := stats.Models[model]
+		log.Printf("  %s: %d agents, average resources %.*f, average donation fraction %.1f%%",
+			model, m.Agents, precision, m.AverageResources, m.AvgDonationFraction*100)
+	}
+	log.Printf("\nStrategy Metrics:")
+	log.Printf("  Default Strategy Fallbacks: %d", stats.StrategyFallbacks)
+	if e.usage != nil {
+		total := e.usage.Usage()
+		generationUsage := total.Sub(e.reportedUsage)
+		e.reportedUsage = total
+		log.Printf("\nToken Usage:")
+		log.Printf("  Prompt Tokens: %d", generationUsage.PromptTokens)
+		log.Printf("  Completion Tokens: %d", generationUsage.CompletionTokens)
+		log.Printf("  Total Tokens: %d (%d across the experiment)", generationUsage.TotalTokens, total.TotalTokens)
+	}
+	log.Printf("\nEnvironment Parameters:")
+	log.Printf("  Donation Multiplier: %.2f", stats.DonationMultiplier)
+	log.Printf("  Rounds Per Generation: %d", stats.RoundsPerGen)
 	log.Printf("==========================\n")
 
 	// Log to CSV file
-	if e.statsFile != nil {
-		csvLine := fmt.Sprintf("%d,%.2f,%.2f,%.2f,%.2f,%d,%d,%.1f\n",
-			generation,
+	if e.stats != nil {
+		csvLine := fmt.Sprintf("%d,%s,%s,%s,%s,%s,%d,%d,%.1f,%d,%.2f,%d,%.4f,%t,%s,%s,%d,%.*f\n",
+			stats.Generation,
 			totalResources,
 			avgResources,
 			stdDev,
 			resourceInequality,
-			state.SuccessfulDonations,
-			state.FailedDonations,
-			successRate,
+			gini,
+			stats.SuccessfulDonations,
+			stats.FailedDonations,
+			stats.SuccessRate,
+			stats.StrategyFallbacks,
+			stats.DonationMultiplier,
+			stats.RoundsPerGen,
+			stats.AvgDonationFraction,
+			collapsed,
+			stats.FinishReasonSummary(),
+			stats.ModelSummary(precision),
+			stats.TotalPunishments,
+			precision,
+			stats.PunishmentSpent,
 		)
-		if _, err := e.statsFile.WriteString(csvLine); err != nil {
+		if _, err := io.WriteString(e.stats, csvLine); err != nil {
 			log.Printf("Warning: Failed to write to stats file: %v", err)
 		}
 	}
+
+	e.updateProgress(func(p *ExperimentProgress) {
+		p.Round = 0
+		p.LastStats = &stats
+	})
+	return stats
 }
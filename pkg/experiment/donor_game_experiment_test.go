@@ -0,0 +1,1103 @@
+package experiment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boristopalov/petri/pkg/agent"
+	"github.com/boristopalov/petri/pkg/environment"
+	"github.com/boristopalov/petri/pkg/messaging"
+	"github.com/boristopalov/petri/pkg/providers"
+)
+
+// mockClient implements agent.Client, always returning the same response and recording prompts
+type mockClient struct {
+	response string
+	mu       sync.Mutex
+	calls    int
+	prompts  []string
+}
+
+func (m *mockClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls++
+	m.prompts = append(m.prompts, prompt)
+	return m.response, nil
+}
+
+// hangingClient implements agent.Client. Its first call blocks until release is closed,
+// ignoring the context, and later calls return response.
+type hangingClient struct {
+	response string
+	release  chan struct{}
+	mu       sync.Mutex
+	calls    int
+}
+
+func (h *hangingClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	h.mu.Lock()
+	h.calls++
+	first := h.calls == 1
+	h.mu.Unlock()
+	if first {
+		<-h.release
+	}
+	return h.response, nil
+}
+
+// errClient implements agent.Client and fails every call
+type errClient struct {
+	err error
+}
+
+func (c *errClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	return "", c.err
+}
+
+// stoppingClient implements agent.Client, returning response and calling stop on its nth call
+type stoppingClient struct {
+	response string
+	n        int
+	stop     func() error
+	mu       sync.Mutex
+	calls    int
+}
+
+func (c *stoppingClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if c.calls == c.n {
+		c.stop()
+	}
+	return c.response, nil
+}
+
+// outageClient implements agent.Client, failing its first failures donation decisions and
+// returning response for everything else
+type outageClient struct {
+	response string
+	failures int
+	mu       sync.Mutex
+}
+
+func (c *outageClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if strings.Contains(prompt, "It is now round") && c.failures > 0 {
+		c.failures--
+		return "", errors.New("service unavailable")
+	}
+	return c.response, nil
+}
+
+// recordingSink implements StatsSink, keeping the records it is given
+type recordingSink struct {
+	records []RoundRecord
+	closed  bool
+}
+
+func (s *recordingSink) WriteRecords(records []RoundRecord) error {
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+// chdirTemp runs the test from a temporary directory so stats files don't land in the package
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+	})
+	return dir
+}
+
+func newTestExperiment(t *testing.T, client agent.Client, numAgents, numGenerations, rounds int, opts ...ExperimentOption) *DonorGameExperiment {
+	t.Helper()
+	chdirTemp(t)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+
+	env := environment.NewDonorGameEnvironment(rounds, 2.0, 10.0)
+	factory := func(ctx context.Context, id string, strategy string) (*agent.DonorGameAgent, error) {
+		return agent.NewDonorGameAgent(ctx, id, strategy, agent.WithProvider(client))
+	}
+	e, err := NewDonorGameExperiment(env, factory, 0.5, numAgents, numGenerations, rounds, opts...)
+	if err != nil {
+		t.Fatalf("Failed to create experiment: %v", err)
+	}
+	t.Cleanup(func() {
+		if e.statsFile != nil {
+			e.statsFile.Close()
+		}
+	})
+	return e
+}
+
+func TestDonorGameExperiment(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test seed strategies are assigned to generation 1", func(t *testing.T) {
+		records := []StrategyRecord{
+			{AgentID: "3_0", Generation: 3, Resources: 25, Strategy: "to always donate half."},
+			{AgentID: "3_1", Generation: 3, Resources: 20, Strategy: "to donate nothing to stingy agents."},
+		}
+		path := filepath.Join(t.TempDir(), "strategies.json")
+		if err := SaveStrategies(path, records); err != nil {
+			t.Fatalf("Failed to save strategies: %v", err)
+		}
+		loaded, err := LoadStrategies(path)
+		if err != nil {
+			t.Fatalf("Failed to load strategies: %v", err)
+		}
+
+		client := &mockClient{response: "ANSWER: 1"}
+		e := newTestExperiment(t, client, 4, 1, 1, WithSeedStrategies(loaded))
+		if err := e.initializeGeneration(ctx, 1, nil, "", nil); err != nil {
+			t.Fatalf("Failed to initialize generation: %v", err)
+		}
+
+		for i, a := range e.env.GetAgents() {
+			want := records[i%len(records)].Strategy
+			if got := a.GetStrategy(); got != want {
+				t.Errorf("agent %s strategy = %q, want %q", a.GetID(), got, want)
+			}
+		}
+		if client.calls != 0 {
+			t.Errorf("Expected no strategy generation calls, got %d", client.calls)
+		}
+	})
+
+	t.Run("test unparseable strategies fall back to the default", func(t *testing.T) {
+		client := &mockClient{response: "I am not sure what to do."}
+		e := newTestExperiment(t, client, 2, 1, 1)
+
+		if err := e.Run(ctx); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+
+		for _, a := range e.env.GetAgents() {
+			if got := a.GetStrategy(); got != agent.DEFAULT_STRATEGY {
+				t.Errorf("agent %s strategy = %q, want default strategy", a.GetID(), got)
+			}
+			if !a.UsedFallbackStrategy() {
+				t.Errorf("agent %s should be flagged as using the fallback strategy", a.GetID())
+			}
+		}
+		if e.strategyFallbacks != 2 {
+			t.Errorf("strategyFallbacks = %d, want 2", e.strategyFallbacks)
+		}
+	})
+
+	t.Run("test generation hook changes the donation multiplier", func(t *testing.T) {
+		client := &mockClient{response: "ANSWER: 1"}
+		hook := func(gen int, env *environment.DonorGameEnvironment) {
+			if gen == 2 {
+				env.SetDonationMultiplier(env.GetDonationMultiplier() * 2)
+			}
+		}
+		e := newTestExperiment(t, client, 2, 2, 1, WithGenerationHook(hook))
+
+		if err := e.initializeGeneration(ctx, 2, nil, "", nil); err != nil {
+			t.Fatalf("Failed to initialize generation: %v", err)
+		}
+		if got := e.env.GetDonationMultiplier(); got != 4.0 {
+			t.Fatalf("donation multiplier = %.2f, want 4.00", got)
+		}
+		if err := e.env.Step(ctx); err != nil {
+			t.Fatalf("Step failed: %v", err)
+		}
+
+		// The donor gives 1 unit and the recipient receives 4
+		var total float64
+		for _, r := range e.env.GetState().AgentResources {
+			total += r
+		}
+		if total != 23.0 {
+			t.Errorf("total resources = %.2f, want 23.00", total)
+		}
+	})
+
+	t.Run("test pooled agents are reused across generations", func(t *testing.T) {
+		client := &mockClient{response: "My strategy will be to donate half."}
+		e := newTestExperiment(t, client, 4, 2, 1, WithAgentPool())
+
+		if err := e.initializeGeneration(ctx, 1, nil, "", nil); err != nil {
+			t.Fatalf("Failed to initialize generation 1: %v", err)
+		}
+		first := make(map[*agent.DonorGameAgent]bool)
+		for _, a := range e.env.GetAgents() {
+			first[a] = true
+			a.GetMemory().Store("Round: I donated 50% to someone")
+		}
+
+		if err := e.initializeGeneration(ctx, 2, nil, "", nil); err != nil {
+			t.Fatalf("Failed to initialize generation 2: %v", err)
+		}
+		agents := e.env.GetAgents()
+		if len(agents) != 4 {
+			t.Fatalf("Expected 4 agents, got %d", len(agents))
+		}
+		for _, a := range agents {
+			if !first[a] {
+				t.Errorf("agent %s was freshly allocated instead of reused", a.GetID())
+			}
+			if !strings.HasPrefix(a.GetID(), "2_") {
+				t.Errorf("reused agent has ID %s, want a generation 2 ID", a.GetID())
+			}
+			if n := a.GetMemory().Len(); n != 0 {
+				t.Errorf("reused agent %s has %d memories, want 0", a.GetID(), n)
+			}
+		}
+		if e.pool.Len() != 0 {
+			t.Errorf("pool has %d idle agents, want 0", e.pool.Len())
+		}
+	})
+
+	t.Run("test elitism carries survivors into the next generation", func(t *testing.T) {
+		e := newTestExperiment(t, &mockClient{response: "ANSWER: 3"}, 4, 2, 1, WithElitism())
+		var survivors []string
+		e.OnGenerationEnd(func(gen int, stats GenerationStats) {
+			survivors = e.env.GetTopAgents(2)
+		})
+
+		if err := e.Step(ctx); err != nil {
+			t.Fatalf("Failed to run generation 1: %v", err)
+		}
+		var ids []string
+		state := e.env.GetState()
+		for _, a := range e.env.GetAgents() {
+			ids = append(ids, a.GetID())
+			if state.AgentResources[a.GetID()] != 10.0 {
+				t.Errorf("agent %s has %.2f resources, want the initial 10.00", a.GetID(), state.AgentResources[a.GetID()])
+			}
+			if slices.Contains(survivors, a.GetID()) && a.GetMemory().Len() == 0 {
+				t.Errorf("survivor %s lost its memories", a.GetID())
+			}
+		}
+		if want := append(slices.Clone(survivors), "2_2", "2_3"); !slices.Equal(ids, want) {
+			t.Errorf("generation 2 agents = %v, want the survivors %v and 2 new agents", ids, survivors)
+		}
+
+		if err := e.Step(ctx); err != nil {
+			t.Fatalf("Failed to run generation 2: %v", err)
+		}
+		if !e.Done() {
+			t.Error("Expected the experiment to be done after 2 generations")
+		}
+	})
+
+	t.Run("test hung round times out without ending the experiment", func(t *testing.T) {
+		client := &hangingClient{response: "ANSWER: 1", release: make(chan struct{})}
+		defer close(client.release)
+		e := newTestExperiment(t, client, 2, 1, 2, WithRoundTimeout(50*time.Millisecond))
+
+		ctx, cancel := context.WithTimeout(ctx, time.Hour)
+		defer cancel()
+		for _, id := range []string{"1_0", "1_1"} {
+			a, err := agent.NewDonorGameAgent(ctx, id, "donate half", agent.WithProvider(client))
+			if err != nil {
+				t.Fatalf("Failed to create agent: %v", err)
+			}
+			if err := e.env.AddAgent(a); err != nil {
+				t.Fatalf("Failed to add agent: %v", err)
+			}
+		}
+
+		start := time.Now()
+		if err := e.runGeneration(ctx, 1); err != nil {
+			t.Fatalf("runGeneration failed: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("generation took %v, expected the hung round to time out after 50ms", elapsed)
+		}
+		if ctx.Err() != nil {
+			t.Errorf("experiment context should still be live, got %v", ctx.Err())
+		}
+		if got := e.env.GetState().SuccessfulDonations; got != 1 {
+			t.Errorf("SuccessfulDonations = %d, want 1 from the round after the timeout", got)
+		}
+		if state := e.env.GetState(); state.TotalRounds != 2 || state.Round != 0 {
+			t.Errorf("TotalRounds = %d and Round = %d, want the timed out round counted: 2 and 0", state.TotalRounds, state.Round)
+		}
+	})
+
+	t.Run("test advice is limited to the top survivors", func(t *testing.T) {
+		client := &mockClient{response: "My strategy will be to donate half."}
+		// Rank agents by the index in their ID so the order of survivors is known
+		byIndex := func(id string, state environment.DonorGameState) float64 {
+			var gen, i int
+			fmt.Sscanf(id, "%d_%d", &gen, &i)
+			return float64(i)
+		}
+		e := newTestExperiment(t, client, 10, 2, 1, WithAdviceLimit(3), WithFitnessFunc(byIndex))
+
+		if err := e.initializeGeneration(ctx, 1, nil, "", nil); err != nil {
+			t.Fatalf("Failed to initialize generation 1: %v", err)
+		}
+		advice := e.getSurvivorAdvice(e.selectSurvivors())
+
+		client.prompts = nil
+		if err := e.initializeGeneration(ctx, 2, nil, advice, nil); err != nil {
+			t.Fatalf("Failed to initialize generation 2: %v", err)
+		}
+		if len(client.prompts) == 0 {
+			t.Fatal("Expected strategy prompts for generation 2")
+		}
+		prompt := client.prompts[0]
+		for _, id := range []string{"1_9", "1_8", "1_7"} {
+			if !strings.Contains(prompt, "Agent "+id+" ") {
+				t.Errorf("Expected advice from %s in prompt:\n%s", id, prompt)
+			}
+		}
+		for _, id := range []string{"1_6", "1_5"} {
+			if strings.Contains(prompt, "Agent "+id+" ") {
+				t.Errorf("Did not expect advice from %s in prompt:\n%s", id, prompt)
+			}
+		}
+		if !strings.Contains(prompt, "(2 more surviving strategies omitted)") {
+			t.Errorf("Expected a note about omitted strategies in prompt:\n%s", prompt)
+		}
+	})
+
+	t.Run("test shuffled advice samples every survivor", func(t *testing.T) {
+		client := &mockClient{response: "My strategy will be to donate half."}
+		e := newTestExperiment(t, client, 10, 2, 1, WithAdviceLimit(3), WithShuffledAdvice())
+		if err := e.initializeGeneration(ctx, 1, nil, "", nil); err != nil {
+			t.Fatalf("Failed to initialize generation 1: %v", err)
+		}
+		survivors := e.selectSurvivors()
+
+		const samples = 1000
+		counts := make(map[string]int)
+		for range samples {
+			advisors := e.advisors(survivors)
+			if len(advisors) != 3 {
+				t.Fatalf("advisors = %v, want 3", advisors)
+			}
+			for _, id := range advisors {
+				counts[id]++
+			}
+		}
+		want := samples * 3 / len(survivors)
+		for _, id := range survivors {
+			if got := counts[id]; got < want*8/10 || got > want*12/10 {
+				t.Errorf("%s advised %d times, want about %d", id, got, want)
+			}
+		}
+
+		// Without the option the top survivors advise, in rank order
+		e.shuffleAdvice = false
+		if got := e.advisors(survivors); !slices.Equal(got, survivors[:3]) {
+			t.Errorf("advisors = %v, want the top survivors %v", got, survivors[:3])
+		}
+	})
+
+	t.Run("test experiment stops when the call budget is exhausted", func(t *testing.T) {
+		client := &mockClient{response: "My strategy will be to donate half. ANSWER: 1"}
+		budget := providers.NewCallBudget(5)
+		e := newTestExperiment(t, budget.Wrap(client), 4, 3, 3, WithCallBudget(budget))
+
+		// 4 strategy calls, then the budget runs out during the first round
+		if err := e.Run(ctx); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if client.calls != 5 {
+			t.Errorf("client calls = %d, want 5", client.calls)
+		}
+		if got := e.env.GetState().TotalRounds; got != 1 {
+			t.Errorf("TotalRounds = %d, want the generation to end after 1 round", got)
+		}
+
+		data, err := os.ReadFile(e.statsPath)
+		if err != nil {
+			t.Fatalf("Failed to read stats file: %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != 2 || !strings.HasPrefix(lines[1], "1,") {
+			t.Errorf("Expected stats for generation 1 only, got:\n%s", data)
+		}
+	})
+
+	t.Run("test lineage references the survivors that seeded each agent", func(t *testing.T) {
+		client := &mockClient{response: "My strategy will be to donate half. ANSWER: 1"}
+		byIndex := func(id string, state environment.DonorGameState) float64 {
+			var gen, i int
+			fmt.Sscanf(id, "%d_%d", &gen, &i)
+			return float64(i)
+		}
+		dotPath := filepath.Join(t.TempDir(), "lineage.dot")
+		e := newTestExperiment(t, client, 4, 2, 1, WithFitnessFunc(byIndex), WithLineageDump(dotPath))
+
+		if err := e.Run(ctx); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+
+		lineage := e.Lineage()
+		if len(lineage) != 8 {
+			t.Fatalf("lineage has %d agents, want 8", len(lineage))
+		}
+		for i := 0; i < 4; i++ {
+			if parents := lineage[fmt.Sprintf("1_%d", i)]; len(parents) != 0 {
+				t.Errorf("generation 1 agent 1_%d has parents %v, want none", i, parents)
+			}
+			// The two highest-indexed agents survive generation 1
+			id := fmt.Sprintf("2_%d", i)
+			if got := fmt.Sprint(lineage[id]); got != "[1_3 1_2]" {
+				t.Errorf("agent %s parents = %s, want [1_3 1_2]", id, got)
+			}
+		}
+
+		data, err := os.ReadFile(dotPath)
+		if err != nil {
+			t.Fatalf("Failed to read lineage file: %v", err)
+		}
+		if !strings.HasPrefix(string(data), "digraph lineage {") || !strings.Contains(string(data), `"1_3" -> "2_0";`) {
+			t.Errorf("Unexpected DOT lineage:\n%s", data)
+		}
+	})
+
+	t.Run("test round log records the pairs of every round", func(t *testing.T) {
+		client := &mockClient{response: "My strategy will be to donate half. ANSWER: 1"}
+		path := filepath.Join(t.TempDir(), "rounds.json")
+		e := newTestExperiment(t, client, 5, 2, 2, WithRoundLog(path))
+
+		if err := e.Run(ctx); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read round log: %v", err)
+		}
+		var logs []environment.RoundLog
+		if err := json.Unmarshal(data, &logs); err != nil {
+			t.Fatalf("Failed to parse round log: %v", err)
+		}
+		if len(logs) != 4 {
+			t.Fatalf("round log has %d rounds, want 4:\n%s", len(logs), data)
+		}
+		for i, entry := range logs {
+			if entry.Generation != i/2+1 || entry.Round != i%2 {
+				t.Errorf("round %d is generation %d round %d, want generation %d round %d", i, entry.Generation, entry.Round, i/2+1, i%2)
+			}
+			if len(entry.Pairs) != 2 || len(entry.Byes) != 1 {
+				t.Errorf("round %d has pairs %v and byes %v, want 2 pairs and 1 bye", i, entry.Pairs, entry.Byes)
+			}
+			prefix := fmt.Sprintf("%d_", entry.Generation)
+			for _, pair := range entry.Pairs {
+				if !strings.HasPrefix(pair[0], prefix) || !strings.HasPrefix(pair[1], prefix) {
+					t.Errorf("round %d pairs %v, want agents of generation %d", i, pair, entry.Generation)
+				}
+			}
+		}
+	})
+
+	t.Run("test failed rounds stop the experiment or are retried", func(t *testing.T) {
+		client := &outageClient{response: "My strategy will be to donate half. ANSWER: 1", failures: 1}
+		e := newTestExperiment(t, client, 2, 1, 1)
+		if err := e.Run(ctx); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if state := e.env.GetState(); state.TotalRounds != 1 || state.FailedDonations != 1 {
+			t.Errorf("TotalRounds = %d, FailedDonations = %d, want the round played with its failed donation by default",
+				state.TotalRounds, state.FailedDonations)
+		}
+
+		client = &outageClient{response: "My strategy will be to donate half. ANSWER: 1", failures: 1}
+		e = newTestExperiment(t, client, 2, 1, 1)
+		environment.WithMaxFailureRate(0)(e.env)
+		if err := e.Run(ctx); !errors.Is(err, environment.ErrRoundFailed) {
+			t.Fatalf("Run error = %v, want ErrRoundFailed", err)
+		}
+		if state := e.env.GetState(); state.TotalRounds != 0 || state.SuccessfulDonations != 0 {
+			t.Errorf("TotalRounds = %d, SuccessfulDonations = %d, want the failed round not played", state.TotalRounds, state.SuccessfulDonations)
+		}
+
+		client = &outageClient{response: "My strategy will be to donate half. ANSWER: 1", failures: 1}
+		e = newTestExperiment(t, client, 2, 1, 1, WithRoundRetries(1))
+		environment.WithMaxFailureRate(0)(e.env)
+		if err := e.Run(ctx); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if state := e.env.GetState(); state.TotalRounds != 1 || state.SuccessfulDonations != 1 || state.FailedDonations != 0 {
+			t.Errorf("TotalRounds = %d, SuccessfulDonations = %d, FailedDonations = %d, want the round retried once",
+				state.TotalRounds, state.SuccessfulDonations, state.FailedDonations)
+		}
+	})
+
+	t.Run("test round log of a resumed run covers the rounds before the checkpoint", func(t *testing.T) {
+		dir := t.TempDir()
+		checkpoint := filepath.Join(dir, "checkpoint.json")
+		path := filepath.Join(dir, "rounds.json")
+		client := &mockClient{response: "My strategy will be to donate half. ANSWER: 1"}
+		first := newTestExperiment(t, client, 4, 2, 2, WithCheckpoint(checkpoint))
+		if err := first.Step(ctx); err != nil {
+			t.Fatalf("Failed to step experiment: %v", err)
+		}
+
+		resumed := newTestExperiment(t, client, 4, 2, 2, WithCheckpoint(checkpoint), WithRoundLog(path))
+		if err := resumed.Run(ctx); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		logs, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read round log: %v", err)
+		}
+		var entries []environment.RoundLog
+		if err := json.Unmarshal(logs, &entries); err != nil {
+			t.Fatalf("Failed to parse round log: %v", err)
+		}
+		if len(entries) != 4 || entries[0].Generation != 1 || entries[3].Generation != 2 {
+			t.Errorf("round log = %+v, want both rounds of both generations", entries)
+		}
+	})
+
+	t.Run("test round log is written when run fails", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "rounds.json")
+		e := newTestExperiment(t, &mockClient{response: "ANSWER: 1"}, 4, 2, 2, WithRoundLog(path))
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		if err := e.Run(cancelled); err == nil {
+			t.Fatal("Expected Run to fail with a cancelled context")
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected the round log to be written: %v", err)
+		}
+	})
+
+	t.Run("test broker subscriptions stay bounded across generations", func(t *testing.T) {
+		chdirTemp(t)
+		broker := messaging.NewBroker()
+		client := &mockClient{response: "My strategy will be to donate half. ANSWER: 1"}
+		factory := func(ctx context.Context, id string, strategy string) (*agent.DonorGameAgent, error) {
+			return agent.NewDonorGameAgent(ctx, id, strategy, agent.WithProvider(client), agent.WithMessageBroker(broker))
+		}
+		var counts []int
+		hook := func(gen int, env *environment.DonorGameEnvironment) {
+			counts = append(counts, broker.SubscriberCount())
+		}
+		env := environment.NewDonorGameEnvironment(1, 2.0, 10.0)
+		e, err := NewDonorGameExperiment(env, factory, 0.5, 4, 4, 1,
+			WithGenerationHook(hook), WithSubscriberCheck(broker), WithStatsWriter(io.Discard))
+		if err != nil {
+			t.Fatalf("Failed to create experiment: %v", err)
+		}
+
+		if err := e.Run(ctx); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		// Each hook runs before the previous generation is cleaned up, so it sees one generation's agents
+		if fmt.Sprint(counts) != "[0 4 4 4]" {
+			t.Errorf("subscriber counts at the start of each generation = %v, want [0 4 4 4]", counts)
+		}
+		if got := broker.SubscriberCount(); got != 4 {
+			t.Errorf("final subscriber count = %d, want 4", got)
+		}
+	})
+
+	t.Run("test generation starts with the viable agents when strategies fail", func(t *testing.T) {
+		chdirTemp(t)
+		broker := messaging.NewBroker()
+		ok := &mockClient{response: "My strategy will be to donate half. ANSWER: 1"}
+		failing := &errClient{err: errors.New("service unavailable")}
+		// Agents 1, 3 and 5 fail, leaving 3 viable agents
+		factory := func(ctx context.Context, id string, strategy string) (*agent.DonorGameAgent, error) {
+			var gen, i int
+			fmt.Sscanf(id, "%d_%d", &gen, &i)
+			var client agent.Client = ok
+			if i%2 == 1 {
+				client = failing
+			}
+			return agent.NewDonorGameAgent(ctx, id, strategy, agent.WithProvider(client), agent.WithMessageBroker(broker))
+		}
+		newExperiment := func(minViable int) *DonorGameExperiment {
+			env := environment.NewDonorGameEnvironment(1, 2.0, 10.0)
+			e, err := NewDonorGameExperiment(env, factory, 0.5, 6, 1, 1,
+				WithMinViablePopulation(minViable), WithStatsWriter(io.Discard))
+			if err != nil {
+				t.Fatalf("Failed to create experiment: %v", err)
+			}
+			return e
+		}
+
+		e := newExperiment(2)
+		if err := e.initializeGeneration(ctx, 1, nil, "", nil); err != nil {
+			t.Fatalf("Expected the generation to start with the viable agents, got %v", err)
+		}
+		var ids []string
+		for _, a := range e.env.GetAgents() {
+			ids = append(ids, a.GetID())
+		}
+		if fmt.Sprint(ids) != "[1_0 1_2 1_4]" {
+			t.Errorf("agents = %v, want [1_0 1_2 1_4]", ids)
+		}
+		if len(e.Lineage()) != 3 {
+			t.Errorf("lineage = %v, want only the agents that started", e.Lineage())
+		}
+		if got := broker.SubscriberCount(); got != 3 {
+			t.Errorf("subscriber count = %d, want 3 after dropping failed agents", got)
+		}
+		if err := e.runGeneration(ctx, 1); err != nil {
+			t.Errorf("Failed to run the partial generation: %v", err)
+		}
+		e.env.Reset()
+
+		e = newExperiment(4)
+		if err := e.initializeGeneration(ctx, 1, nil, "", nil); err == nil {
+			t.Error("Expected an error when the viable agents are below the minimum")
+		}
+		if got := len(e.env.GetAgents()); got != 0 {
+			t.Errorf("environment has %d agents, want none after a failed start", got)
+		}
+		if got := broker.SubscriberCount(); got != 0 {
+			t.Errorf("subscriber count = %d, want 0 after a failed start", got)
+		}
+	})
+
+	t.Run("test strategy failure aborts the generation by default", func(t *testing.T) {
+		e := newTestExperiment(t, &errClient{err: errors.New("service unavailable")}, 4, 1, 1)
+		if err := e.initializeGeneration(ctx, 1, nil, "", nil); err == nil {
+			t.Error("Expected a strategy failure to abort the generation")
+		}
+	})
+
+	t.Run("test checkpoint resumes the saved generation", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "checkpoint.json")
+		client := &mockClient{response: "ANSWER: 1"}
+		original := newTestExperiment(t, client, 4, 3, 2, WithCheckpoint(path))
+		if err := original.Step(ctx); err != nil {
+			t.Fatalf("Failed to step experiment: %v", err)
+		}
+
+		resumed := newTestExperiment(t, client, 4, 3, 2)
+		if err := resumed.LoadCheckpoint(path); err != nil {
+			t.Fatalf("Failed to load checkpoint: %v", err)
+		}
+		if resumed.Generation() != 2 || resumed.Done() {
+			t.Errorf("resumed at generation %d, done %t, want generation 2 not done", resumed.Generation(), resumed.Done())
+		}
+		want, got := original.strategyRecords(2), resumed.strategyRecords(2)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("resumed agents = %v, want %v", got, want)
+		}
+		if got, want := resumed.Lineage(), original.Lineage(); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("resumed lineage = %v, want %v", got, want)
+		}
+
+		// Both experiments draw the same donor pairings from here on
+		for _, e := range []*DonorGameExperiment{original, resumed} {
+			if err := e.Step(ctx); err != nil {
+				t.Fatalf("Failed to step experiment: %v", err)
+			}
+		}
+		if got, want := resumed.env.RNGState(), original.env.RNGState(); got != want {
+			t.Errorf("resumed RNG state = %+v, want %+v", got, want)
+		}
+		if got, want := resumed.env.GetState().AgentResources, original.env.GetState().AgentResources; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("resumed resources = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("test checkpoint keeps the memories and donations of carried over survivors", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "checkpoint.json")
+		client := &mockClient{response: "ANSWER: 1"}
+		original := newTestExperiment(t, client, 4, 3, 2, WithCheckpoint(path), WithElitism())
+		if err := original.Step(ctx); err != nil {
+			t.Fatalf("Failed to step experiment: %v", err)
+		}
+
+		resumed := newTestExperiment(t, client, 4, 3, 2, WithElitism())
+		if err := resumed.LoadCheckpoint(path); err != nil {
+			t.Fatalf("Failed to load checkpoint: %v", err)
+		}
+		var carried int
+		for i, a := range resumed.env.GetAgents() {
+			o := original.env.GetAgents()[i]
+			if got, want := a.GetMemory().GetAllMessages(), o.GetMemory().GetAllMessages(); !slices.Equal(got, want) {
+				t.Errorf("agent %s memories = %v, want %v", a.GetID(), got, want)
+			}
+			history := a.GetDonationHistory()
+			if got, want := history, o.GetDonationHistory(); !slices.Equal(got, want) {
+				t.Errorf("agent %s donations = %+v, want %+v", a.GetID(), got, want)
+			}
+			if len(history) > 0 {
+				carried++
+				if history[0].Generation != 1 {
+					t.Errorf("agent %s donation generation = %d, want 1", a.GetID(), history[0].Generation)
+				}
+			}
+		}
+		if carried == 0 {
+			t.Error("Expected the carried over survivors to have donation histories")
+		}
+	})
+
+	t.Run("test run resumes from an existing checkpoint", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "checkpoint.json")
+		client := &mockClient{response: "ANSWER: 1"}
+		first := newTestExperiment(t, client, 4, 3, 1, WithCheckpoint(path))
+		if err := first.Step(ctx); err != nil {
+			t.Fatalf("Failed to step experiment: %v", err)
+		}
+
+		var started []int
+		e := newTestExperiment(t, client, 4, 3, 1, WithCheckpoint(path),
+			WithGenerationHook(func(generation int, env *environment.DonorGameEnvironment) {
+				started = append(started, generation)
+			}))
+		if err := e.Run(ctx); err != nil {
+			t.Fatalf("Failed to run experiment: %v", err)
+		}
+		if fmt.Sprint(started) != "[3]" {
+			t.Errorf("initialized generations = %v, want only [3] after resuming at generation 2", started)
+		}
+
+		checkpoint, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read checkpoint: %v", err)
+		}
+		if !strings.Contains(string(checkpoint), `"finished": true`) {
+			t.Errorf("final checkpoint isn't marked finished:\n%s", checkpoint)
+		}
+		if err := e.Run(ctx); err != nil {
+			t.Errorf("Run on a finished experiment = %v, want nil", err)
+		}
+	})
+
+	t.Run("test resumed run appends to the original stats file", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "checkpoint.json")
+		client := &mockClient{response: "ANSWER: 1"}
+		clock := func(hour int) func() time.Time {
+			return func() time.Time { return time.Date(2025, 1, 1, hour, 0, 0, 0, time.UTC) }
+		}
+		first := newTestExperiment(t, client, 4, 3, 1, WithCheckpoint(path), WithOutputDir(dir), WithClock(clock(1)), WithRoundStats("rounds.csv"))
+		if err := first.Step(ctx); err != nil {
+			t.Fatalf("Failed to step experiment: %v", err)
+		}
+		first.closeOutputs()
+
+		resumed := newTestExperiment(t, client, 4, 3, 1, WithCheckpoint(path), WithOutputDir(dir), WithClock(clock(2)), WithRoundStats("rounds.csv"))
+		if err := resumed.Run(ctx); err != nil {
+			t.Fatalf("Failed to run experiment: %v", err)
+		}
+		files, err := filepath.Glob(filepath.Join(dir, "experiment_stats_*.csv"))
+		if err != nil || len(files) != 1 {
+			t.Fatalf("stats files = %v, want only the original run's", files)
+		}
+		data, err := os.ReadFile(files[0])
+		if err != nil {
+			t.Fatalf("Failed to read stats file: %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != 4 || !strings.HasPrefix(lines[0], "Generation,") {
+			t.Errorf("stats file has %d lines, want a header and 3 generations:\n%s", len(lines), data)
+		}
+		for i, line := range lines[1:] {
+			if !strings.HasPrefix(line, fmt.Sprintf("%d,", i+1)) {
+				t.Errorf("row %d = %q, want generation %d", i+1, line, i+1)
+			}
+		}
+
+		// The round stats path is relative, so it is written to the output directory
+		data, err = os.ReadFile(filepath.Join(dir, "rounds.csv"))
+		if err != nil {
+			t.Fatalf("Failed to read round stats: %v", err)
+		}
+		lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != 4 || !strings.HasPrefix(lines[0], "Generation,Round,") {
+			t.Errorf("round stats file has %d lines, want a header and a round of each of 3 generations:\n%s", len(lines), data)
+		}
+		for i, line := range lines[1:] {
+			if !strings.HasPrefix(line, fmt.Sprintf("%d,0,", i+1)) {
+				t.Errorf("round stats row %d = %q, want generation %d", i+1, line, i+1)
+			}
+		}
+	})
+
+	t.Run("test runs with the same seed write identical stats", func(t *testing.T) {
+		chdirTemp(t)
+		t.Setenv("OPENAI_API_KEY", "test-key")
+
+		run := func(seed int64) string {
+			var stats strings.Builder
+			env := environment.NewDonorGameEnvironment(3, 2.0, 10.0, environment.WithSeed(seed))
+			// Each agent donates a different amount, so the stats depend on who is paired with whom
+			factory := func(ctx context.Context, id string, strategy string) (*agent.DonorGameAgent, error) {
+				client := &mockClient{response: "ANSWER: " + id[strings.Index(id, "_")+1:]}
+				return agent.NewDonorGameAgent(ctx, id, strategy, agent.WithProvider(client))
+			}
+			e, err := NewDonorGameExperiment(env, factory, 0.5, 6, 3, 3, WithStatsWriter(&stats))
+			if err != nil {
+				t.Fatalf("Failed to create experiment: %v", err)
+			}
+			if err := e.Run(ctx); err != nil {
+				t.Fatalf("Failed to run experiment: %v", err)
+			}
+			return stats.String()
+		}
+
+		first, second := run(42), run(42)
+		if strings.Count(first, "\n") != 4 {
+			t.Fatalf("Expected a header and 3 generations of stats, got:\n%s", first)
+		}
+		if first != second {
+			t.Errorf("stats differ between runs with the same seed:\n%s\nvs\n%s", first, second)
+		}
+	})
+
+	t.Run("test failed run closes the stats outputs", func(t *testing.T) {
+		sink := &recordingSink{}
+		roundStats := filepath.Join(t.TempDir(), "rounds.csv")
+		e := newTestExperiment(t, &errClient{err: errors.New("service unavailable")}, 4, 2, 1,
+			WithStatsSink(sink), WithRoundStats(roundStats))
+		if err := e.Run(ctx); err == nil {
+			t.Fatal("Expected the run to fail")
+		}
+		if !sink.closed {
+			t.Error("Expected the sink to be closed when the run fails")
+		}
+		if e.statsFile != nil || e.roundStats != nil {
+			t.Error("Expected the stats and round stats files to be closed when the run fails")
+		}
+	})
+
+	t.Run("test stats sink receives a record per donor and recipient", func(t *testing.T) {
+		sink := &recordingSink{}
+		e := newTestExperiment(t, &mockClient{response: "ANSWER: 1"}, 4, 2, 3, WithStatsSink(sink))
+		if err := e.Run(ctx); err != nil {
+			t.Fatalf("Failed to run experiment: %v", err)
+		}
+		if !sink.closed {
+			t.Error("Expected the sink to be closed when the experiment finishes")
+		}
+		// 2 generations of 3 rounds, each pairing the 4 agents into 2 donors and 2 recipients
+		if len(sink.records) != 2*3*4 {
+			t.Fatalf("got %d records, want %d", len(sink.records), 2*3*4)
+		}
+		last := sink.records[len(sink.records)-1]
+		if last.Generation != 2 || last.Round != 2 || last.Model != "gpt-4o-mini" {
+			t.Errorf("last record = %+v, want generation 2 round 2 on gpt-4o-mini", last)
+		}
+		for _, r := range sink.records {
+			if r.Role == RoleDonor && r.Donation != 1 {
+				t.Errorf("donor record %+v, want a donation of 1", r)
+			}
+			if r.Role == RoleRecipient && r.Donation != 2 {
+				t.Errorf("recipient record %+v, want 2 received", r)
+			}
+		}
+	})
+
+	t.Run("test round stats get a row per round with cumulative donations", func(t *testing.T) {
+		e := newTestExperiment(t, &mockClient{response: "ANSWER: 1"}, 4, 2, 3, WithRoundStats("rounds.csv"))
+		if err := e.Run(ctx); err != nil {
+			t.Fatalf("Failed to run experiment: %v", err)
+		}
+		data, err := os.ReadFile("rounds.csv")
+		if err != nil {
+			t.Fatalf("Failed to read round stats: %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != 1+2*3 {
+			t.Fatalf("got %d lines, want a header and %d rows:\n%s", len(lines), 2*3, data)
+		}
+		if !strings.HasPrefix(lines[0], "Generation,Round,") {
+			t.Errorf("header = %q", lines[0])
+		}
+		// Each round pairs the 4 agents into 2 successful donations, counted from the start of the generation
+		for i, line := range lines[1:] {
+			fields := strings.Split(line, ",")
+			want := fmt.Sprintf("%d,%d", i/3+1, i%3)
+			if got := strings.Join(fields[:2], ","); got != want {
+				t.Errorf("row %d starts with %s, want %s", i, got, want)
+			}
+			if got, want := fields[5], fmt.Sprint(2*(i%3+1)); got != want {
+				t.Errorf("row %d has %s successful donations, want %s", i, got, want)
+			}
+		}
+	})
+
+	t.Run("test output directory is created for the stats files", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "results", "run1")
+		e := newTestExperiment(t, &mockClient{response: "ANSWER: 1"}, 2, 1, 1, WithOutputDir(dir), WithOutputFormat(FormatBoth))
+		if err := e.Run(ctx); err != nil {
+			t.Fatalf("Failed to run experiment: %v", err)
+		}
+		for _, pattern := range []string{"experiment_stats_*.csv", "experiment_manifest_*.json", "experiment_2*.json"} {
+			if matches, _ := filepath.Glob(filepath.Join(dir, pattern)); len(matches) != 1 {
+				t.Errorf("Expected one %s in the output directory, got %v", pattern, matches)
+			}
+		}
+		if matches, _ := filepath.Glob("experiment_*"); len(matches) != 0 {
+			t.Errorf("Expected nothing in the working directory, got %v", matches)
+		}
+	})
+
+	t.Run("test relative output paths are resolved against the output directory", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "results")
+		e := newTestExperiment(t, &mockClient{response: "ANSWER: 1"}, 2, 1, 1, WithOutputDir(dir),
+			WithStrategyDump("strategies.json"), WithLineageDump("lineage.json"), WithRoundLog("rounds.json"), WithRoundStats("rounds.csv"))
+		if err := e.Run(ctx); err != nil {
+			t.Fatalf("Failed to run experiment: %v", err)
+		}
+		for _, name := range []string{"strategies.json", "lineage.json", "rounds.json", "rounds.csv"} {
+			if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+				t.Errorf("Expected %s in the output directory: %v", name, err)
+			}
+			if _, err := os.Stat(name); err == nil {
+				t.Errorf("Expected no %s in the working directory", name)
+			}
+		}
+	})
+
+	t.Run("test uncreatable output directory is an error", func(t *testing.T) {
+		chdirTemp(t)
+		if err := os.WriteFile("file", nil, 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+		env := environment.NewDonorGameEnvironment(1, 2.0, 10.0)
+		factory := func(ctx context.Context, id string, strategy string) (*agent.DonorGameAgent, error) {
+			return nil, errors.New("no agents needed")
+		}
+		if _, err := NewDonorGameExperiment(env, factory, 0.5, 2, 1, 1, WithOutputDir(filepath.Join("file", "stats"))); err == nil {
+			t.Error("Expected an error for an output directory that can't be created")
+		}
+	})
+
+	t.Run("test lifecycle hooks are called in order", func(t *testing.T) {
+		var events []string
+		// The stats hook is an OnGenerationEnd hook registered when the experiment is created
+		statsHook := WithStatsHook(func(stats GenerationStats) {
+			events = append(events, fmt.Sprintf("stats %d", stats.Generation))
+		})
+		e := newTestExperiment(t, &mockClient{response: "ANSWER: 1"}, 4, 2, 2, WithStatsWriter(io.Discard), statsHook)
+		e.OnGenerationStart(func(gen int) {
+			events = append(events, fmt.Sprintf("start %d", gen))
+		})
+		e.OnRoundEnd(func(gen, round int, state environment.DonorGameState) {
+			events = append(events, fmt.Sprintf("round %d.%d donations=%d", gen, round, state.SuccessfulDonations))
+		})
+		e.OnGenerationEnd(func(gen int, stats GenerationStats) {
+			events = append(events, fmt.Sprintf("end %d population=%d", gen, stats.Population))
+		})
+		if err := e.Run(ctx); err != nil {
+			t.Fatalf("Failed to run experiment: %v", err)
+		}
+
+		want := []string{
+			"start 1", "round 1.0 donations=2", "round 1.1 donations=4", "stats 1", "end 1 population=4",
+			"start 2", "round 2.0 donations=2", "round 2.1 donations=4", "stats 2", "end 2 population=4",
+		}
+		if strings.Join(events, "\n") != strings.Join(want, "\n") {
+			t.Errorf("events =\n%s\nwant\n%s", strings.Join(events, "\n"), strings.Join(want, "\n"))
+		}
+	})
+
+	t.Run("test progress advances while the experiment runs", func(t *testing.T) {
+		e := newTestExperiment(t, &mockClient{response: "ANSWER: 1"}, 4, 4, 2, WithStatsWriter(io.Discard))
+		if p := e.Progress(); p.Generation != 0 || p.LastStats != nil {
+			t.Errorf("progress before running = %+v, want generation 0 without stats", p)
+		}
+
+		done := make(chan error)
+		go func() { done <- e.Run(ctx) }()
+
+		var seen []int
+		last := 0
+		for running := true; running; {
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("Failed to run experiment: %v", err)
+				}
+				running = false
+			default:
+			}
+			p := e.Progress()
+			if p.Generation < last {
+				t.Fatalf("generation went back from %d to %d", last, p.Generation)
+			}
+			if p.Generation > last {
+				seen = append(seen, p.Generation)
+				last = p.Generation
+			}
+			if p.LastStats != nil && p.LastStats.Generation > p.Generation {
+				t.Errorf("stats of generation %d reported during generation %d", p.LastStats.Generation, p.Generation)
+			}
+		}
+
+		p := e.Progress()
+		if !p.Done || p.Generation != 4 || p.Population != 4 || p.Rounds != 2 {
+			t.Errorf("final progress = %+v, want generation 4 of 4 agents and 2 rounds, done", p)
+		}
+		if p.LastStats == nil || p.LastStats.Generation != 4 {
+			t.Errorf("final stats = %+v, want generation 4", p.LastStats)
+		}
+		if len(seen) == 0 || seen[len(seen)-1] != 4 {
+			t.Errorf("observed generations %v, want them to end at 4", seen)
+		}
+	})
+
+	t.Run("test stop finishes the current round and ends the experiment", func(t *testing.T) {
+		// 4 strategy calls, then Stop is called during the first round
+		client := &stoppingClient{response: "My strategy will be to donate half. ANSWER: 1", n: 5}
+		e := newTestExperiment(t, client, 4, 3, 3)
+		client.stop = e.Stop
+
+		if err := e.Run(ctx); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if !e.Done() || client.calls != 6 {
+			t.Errorf("Done() = %v after %d calls, want the experiment done once the round's 2 donations are made", e.Done(), client.calls)
+		}
+		if got := e.env.GetState().TotalRounds; got != 1 {
+			t.Errorf("TotalRounds = %d, want the generation to end after 1 round", got)
+		}
+		data, err := os.ReadFile(e.statsPath)
+		if err != nil {
+			t.Fatalf("Failed to read stats file: %v", err)
+		}
+		if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], "1,") {
+			t.Errorf("Expected stats for generation 1 only, got:\n%s", data)
+		}
+
+		status := e.GetStatus()
+		if status.Running || status.StartTime.IsZero() || status.EndTime.Before(status.StartTime) || len(status.Errors) != 0 {
+			t.Errorf("status = %+v, want a finished run without errors", status)
+		}
+	})
+
+	t.Run("test status records the error a run stopped with", func(t *testing.T) {
+		e := newTestExperiment(t, &errClient{err: errors.New("provider down")}, 2, 1, 1)
+		if err := e.Run(ctx); err == nil {
+			t.Fatal("Run succeeded, want an error")
+		}
+		if status := e.GetStatus(); status.Running || len(status.Errors) != 1 {
+			t.Errorf("status = %+v, want a stopped run with one error", status)
+		}
+	})
+}
@@ -19,16 +19,17 @@ type Experiment[A agent.Agent, S environment.State] interface {
 	// Stop gracefully stops the experiment
 	Stop() error
 	// GetStatus returns current experiment status
-	GetStatus() status
+	GetStatus() Status
 	// Steps through
 	Step(ctx context.Context) error
 }
 
-type status struct {
+// Status is whether an experiment is running and how its run went
+type Status struct {
 	Running   bool
-	StartTime time.Time
-	EndTime   time.Time
-	Errors    []error
+	StartTime time.Time // when the experiment started running; zero before it has
+	EndTime   time.Time // when the experiment last stopped running; zero while it runs for the first time
+	Errors    []error   // errors the experiment stopped with
 }
 
 type Metrics interface {
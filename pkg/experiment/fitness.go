@@ -0,0 +1,68 @@
+package experiment
+
+import (
+	"math"
+	"sort"
+
+	"github.com/boristopalov/petri/pkg/environment"
+)
+
+// FitnessFunc scores an agent for survivor selection; agents with higher scores survive
+type FitnessFunc func(agentID string, state environment.DonorGameState) float64
+
+// FitnessWeights weights the signals combined by CompositeFitness
+type FitnessWeights struct {
+	Resources   float64 // weight of final resources relative to the population mean
+	Cooperation float64 // weight of the average fraction of resources donated
+	Inequality  float64 // penalty for deviating from the population mean, relative to the mean
+}
+
+// DefaultFitnessWeights ranks agents by final resources only
+var DefaultFitnessWeights = FitnessWeights{Resources: 1}
+
+// WithFitnessFunc selects survivors by the given fitness function instead of by final resources
+func WithFitnessFunc(f FitnessFunc) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.fitness = f
+	}
+}
+
+// CompositeFitness returns a FitnessFunc combining final resources, cooperation rate,
+// and inequality contribution into a single score using the given weights
+func CompositeFitness(w FitnessWeights) FitnessFunc {
+	return func(agentID string, state environment.DonorGameState) float64 {
+		var total float64
+		for _, r := range state.AgentResources {
+			total += r
+		}
+		var relativeResources, inequality float64
+		if mean := total / float64(len(state.AgentResources)); mean > 0 {
+			relativeResources = state.AgentResources[agentID] / mean
+			inequality = math.Abs(state.AgentResources[agentID]-mean) / mean
+		}
+
+		return w.Resources*relativeResources +
+			w.Cooperation*state.Cooperation[agentID].Rate() -
+			w.Inequality*inequality
+	}
+}
+
+// rankAgents returns agent IDs ordered by descending fitness. Ties keep the order given by
+// shuffle, which is passed the IDs sorted; a nil shuffle breaks ties by ID.
+func rankAgents(state environment.DonorGameState, fitness FitnessFunc, shuffle func([]string) []string) []string {
+	ids := make([]string, 0, len(state.AgentResources))
+	scores := make(map[string]float64, len(state.AgentResources))
+	for id := range state.AgentResources {
+		ids = append(ids, id)
+		scores[id] = fitness(id, state)
+	}
+
+	sort.Strings(ids)
+	if shuffle != nil {
+		ids = shuffle(ids)
+	}
+	sort.SliceStable(ids, func(i, j int) bool {
+		return scores[ids[i]] > scores[ids[j]]
+	})
+	return ids
+}
@@ -0,0 +1,42 @@
+package experiment
+
+import (
+	"testing"
+
+	"github.com/boristopalov/petri/pkg/environment"
+)
+
+func TestCompositeFitness(t *testing.T) {
+	state := environment.DonorGameState{
+		AgentResources: map[string]float64{
+			"hoarder":  20,
+			"generous": 15,
+			"average":  10,
+		},
+		Cooperation: map[string]environment.CooperationStats{
+			"hoarder":  {Donations: 2, TotalFraction: 0.2},
+			"generous": {Donations: 2, TotalFraction: 1.8},
+			"average":  {Donations: 2, TotalFraction: 1.0},
+		},
+	}
+
+	t.Run("test resources only ranks by resources", func(t *testing.T) {
+		ranked := rankAgents(state, CompositeFitness(DefaultFitnessWeights), nil)
+		want := []string{"hoarder", "generous", "average"}
+		for i := range want {
+			if ranked[i] != want[i] {
+				t.Fatalf("ranking = %v, want %v", ranked, want)
+			}
+		}
+	})
+
+	t.Run("test cooperation weight promotes generous agents", func(t *testing.T) {
+		ranked := rankAgents(state, CompositeFitness(FitnessWeights{Resources: 1, Cooperation: 2}), nil)
+		want := []string{"generous", "average", "hoarder"}
+		for i := range want {
+			if ranked[i] != want[i] {
+				t.Fatalf("ranking = %v, want %v", ranked, want)
+			}
+		}
+	})
+}
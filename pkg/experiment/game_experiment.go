@@ -0,0 +1,286 @@
+package experiment
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boristopalov/petri/pkg/agent"
+)
+
+// GameAgent is an agent whose strategy a GameExperiment evolves from one generation to the next
+type GameAgent interface {
+	GetID() string
+	GetModel() agent.ModelInfo
+	GetStrategy() string
+	GenerateStrategy(ctx context.Context, generation int, previousGenAdvice string) error
+	UsedFallbackStrategy() bool
+}
+
+// Game is a pairwise game that a GameExperiment plays with every generation
+type Game[A GameAgent] interface {
+	AddAgent(a A) error
+	Reset() error
+	Step(ctx context.Context) error
+	// Scores returns each agent's score this generation; the agents with the highest scores survive
+	Scores() map[string]float64
+	// Summary describes how the generation played, e.g. its cooperation rate, for the log and stats file
+	Summary() string
+}
+
+// GameGenerationStats summarizes a generation of a GameExperiment
+type GameGenerationStats struct {
+	Generation        int
+	Population        int
+	AverageScore      float64
+	BestScore         float64
+	StrategyFallbacks int    // number of agents assigned their game's default strategy
+	Summary           string // the game's own summary of the generation
+}
+
+// GameExperiment runs a pairwise game other than the donor game with generational evolution: every
+// generation plays a number of rounds, and the strategies of the highest-scoring agents are passed
+// on as advice to the agents of the next one
+type GameExperiment[A GameAgent] struct {
+	name                string // name of the game, used to name the output files
+	game                Game[A]
+	agentFactory        func(ctx context.Context, id string, strategy string) (A, error)
+	survivorRatio       float64 // fraction of agents whose strategies are passed on to the next generation
+	numAgents           int
+	numGenerations      int
+	roundsPerGeneration int
+	agents              []A              // agents of the current generation
+	outputDir           string           // directory the stats file is created in
+	strategyDumpPath    string           // file the final generation's strategies are written to
+	now                 func() time.Time // clock used to timestamp output files
+	stats               []GameGenerationStats
+}
+
+// GameOption configures optional GameExperiment behavior
+type GameOption func(*gameOptions)
+
+// gameOptions holds the options of a GameExperiment, which don't depend on its agent type
+type gameOptions struct {
+	outputDir        string
+	strategyDumpPath string
+	now              func() time.Time
+}
+
+// WithGameOutputDir creates the stats file in dir instead of the working directory
+func WithGameOutputDir(dir string) GameOption {
+	return func(o *gameOptions) {
+		o.outputDir = dir
+	}
+}
+
+// WithGameStrategyDump writes the final generation's strategies to path when the experiment finishes.
+// A relative path is resolved against the output directory.
+func WithGameStrategyDump(path string) GameOption {
+	return func(o *gameOptions) {
+		o.strategyDumpPath = path
+	}
+}
+
+// WithGameClock sets the clock used to timestamp output files, e.g. a fake clock in tests
+func WithGameClock(now func() time.Time) GameOption {
+	return func(o *gameOptions) {
+		o.now = now
+	}
+}
+
+// newGameExperiment creates a generational experiment of the game called name
+func newGameExperiment[A GameAgent](
+	name string,
+	game Game[A],
+	agentFactory func(ctx context.Context, id string, strategy string) (A, error),
+	survivorRatio float64,
+	numAgents int,
+	numGenerations int,
+	roundsPerGeneration int,
+	opts ...GameOption,
+) (*GameExperiment[A], error) {
+	if numAgents < 2 {
+		return nil, fmt.Errorf("a %s experiment needs at least 2 agents, got %d", name, numAgents)
+	}
+	if survivorRatio <= 0 || survivorRatio > 1 {
+		return nil, fmt.Errorf("survivor ratio must be in (0, 1], got %v", survivorRatio)
+	}
+	options := gameOptions{now: time.Now}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.outputDir != "" {
+		if err := os.MkdirAll(options.outputDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create output directory: %v", err)
+		}
+	}
+	if options.strategyDumpPath != "" && !filepath.IsAbs(options.strategyDumpPath) {
+		options.strategyDumpPath = filepath.Join(options.outputDir, options.strategyDumpPath)
+	}
+	return &GameExperiment[A]{
+		name:                name,
+		game:                game,
+		agentFactory:        agentFactory,
+		survivorRatio:       survivorRatio,
+		numAgents:           numAgents,
+		numGenerations:      numGenerations,
+		roundsPerGeneration: roundsPerGeneration,
+		outputDir:           options.outputDir,
+		strategyDumpPath:    options.strategyDumpPath,
+		now:                 options.now,
+	}, nil
+}
+
+// Stats returns the statistics of every generation run so far
+func (e *GameExperiment[A]) Stats() []GameGenerationStats {
+	return slices.Clone(e.stats)
+}
+
+// Run plays every generation, writing a row of statistics to the stats file after each one
+func (e *GameExperiment[A]) Run(ctx context.Context) (err error) {
+	timestamp := e.now().Format("2006-01-02_15-04-05")
+	statsFile, err := os.Create(filepath.Join(e.outputDir, fmt.Sprintf("%s_stats_%s.csv", e.name, timestamp)))
+	if err != nil {
+		return fmt.Errorf("failed to create stats file: %v", err)
+	}
+	defer func() {
+		if closeErr := statsFile.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to close stats file: %v", closeErr)
+		}
+	}()
+	stats := csv.NewWriter(statsFile)
+	stats.Write([]string{"Generation", "Population", "AverageScore", "BestScore", "StrategyFallbacks", "Summary"})
+
+	advice := ""
+	for gen := 1; gen <= e.numGenerations; gen++ {
+		if err := e.initializeGeneration(ctx, gen, advice); err != nil {
+			return fmt.Errorf("failed to initialize generation %d: %v", gen, err)
+		}
+		log.Printf("Starting generation %d", gen)
+		for round := 0; round < e.roundsPerGeneration; round++ {
+			log.Printf("Generation %d, Round %d/%d", gen, round+1, e.roundsPerGeneration)
+			if err := e.game.Step(ctx); err != nil {
+				return fmt.Errorf("failed to run generation %d: %w", gen, err)
+			}
+		}
+
+		ranked := e.rank()
+		genStats := e.generationStats(gen)
+		e.stats = append(e.stats, genStats)
+		log.Printf("Generation %d: %d agents, average score %.2f, best score %.2f. %s",
+			gen, genStats.Population, genStats.AverageScore, genStats.BestScore, genStats.Summary)
+		stats.Write([]string{
+			strconv.Itoa(gen),
+			strconv.Itoa(genStats.Population),
+			strconv.FormatFloat(genStats.AverageScore, 'f', 2, 64),
+			strconv.FormatFloat(genStats.BestScore, 'f', 2, 64),
+			strconv.Itoa(genStats.StrategyFallbacks),
+			genStats.Summary,
+		})
+		stats.Flush()
+		if err := stats.Error(); err != nil {
+			log.Printf("Warning: Failed to write to stats file: %v", err)
+		}
+
+		numSurvivors := max(int(float64(len(ranked))*e.survivorRatio), 1)
+		advice = e.survivorAdvice(ranked[:min(numSurvivors, len(ranked))])
+	}
+
+	if e.strategyDumpPath != "" {
+		if err := SaveStrategies(e.strategyDumpPath, e.strategyRecords()); err != nil {
+			log.Printf("Warning: Failed to dump strategies: %v", err)
+		}
+	}
+	return nil
+}
+
+// initializeGeneration replaces the agents with new ones whose strategies build on advice
+func (e *GameExperiment[A]) initializeGeneration(ctx context.Context, generation int, advice string) error {
+	if err := e.game.Reset(); err != nil {
+		return fmt.Errorf("failed to reset game: %v", err)
+	}
+	e.agents = make([]A, 0, e.numAgents)
+	for i := 0; i < e.numAgents; i++ {
+		a, err := e.agentFactory(ctx, fmt.Sprintf("%d_%d", generation, i), "")
+		if err != nil {
+			return fmt.Errorf("failed to create agent: %v", err)
+		}
+		if err := a.GenerateStrategy(ctx, generation, advice); err != nil {
+			return fmt.Errorf("failed to generate strategy for agent %s: %v", a.GetID(), err)
+		}
+		if err := e.game.AddAgent(a); err != nil {
+			return fmt.Errorf("failed to add agent to game: %v", err)
+		}
+		e.agents = append(e.agents, a)
+	}
+	return nil
+}
+
+// rank returns the current agents ordered by descending score, breaking ties by ID
+func (e *GameExperiment[A]) rank() []A {
+	scores := e.game.Scores()
+	ranked := slices.Clone(e.agents)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if scores[ranked[i].GetID()] != scores[ranked[j].GetID()] {
+			return scores[ranked[i].GetID()] > scores[ranked[j].GetID()]
+		}
+		return ranked[i].GetID() < ranked[j].GetID()
+	})
+	return ranked
+}
+
+// generationStats summarizes the current generation's scores
+func (e *GameExperiment[A]) generationStats(generation int) GameGenerationStats {
+	stats := GameGenerationStats{Generation: generation, Population: len(e.agents), Summary: e.game.Summary()}
+	scores := e.game.Scores()
+	var total float64
+	for i, a := range e.agents {
+		score := scores[a.GetID()]
+		total += score
+		if i == 0 || score > stats.BestScore {
+			stats.BestScore = score
+		}
+		if a.UsedFallbackStrategy() {
+			stats.StrategyFallbacks++
+		}
+	}
+	if len(e.agents) > 0 {
+		stats.AverageScore = total / float64(len(e.agents))
+	}
+	return stats
+}
+
+// survivorAdvice lists the survivors' strategies and scores for the next generation
+func (e *GameExperiment[A]) survivorAdvice(survivors []A) string {
+	scores := e.game.Scores()
+	advice := make([]string, 0, len(survivors))
+	for _, a := range survivors {
+		advice = append(advice, fmt.Sprintf("Agent %s (score %.2f): %s", a.GetID(), scores[a.GetID()], a.GetStrategy()))
+	}
+	return "Successful strategies from previous generation:\n" + strings.Join(advice, "\n")
+}
+
+// strategyRecords returns the current generation's strategies, with each agent's score as its resources
+func (e *GameExperiment[A]) strategyRecords() []StrategyRecord {
+	scores := e.game.Scores()
+	generation := len(e.stats)
+	records := make([]StrategyRecord, 0, len(e.agents))
+	for _, a := range e.agents {
+		records = append(records, StrategyRecord{
+			AgentID:    a.GetID(),
+			Generation: generation,
+			Resources:  scores[a.GetID()],
+			Strategy:   a.GetStrategy(),
+			Model:      a.GetModel().Id,
+		})
+	}
+	return records
+}
@@ -0,0 +1,110 @@
+package experiment
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/boristopalov/petri/pkg/agent"
+	"github.com/boristopalov/petri/pkg/environment"
+)
+
+func TestGameExperiment(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test prisoners dilemma evolves strategies across generations", func(t *testing.T) {
+		t.Setenv("OPENAI_API_KEY", "test-key")
+		dir := t.TempDir()
+		client := &mockClient{response: "My strategy will be to cooperate.\nANSWER: COOPERATE"}
+		factory := func(ctx context.Context, id string, strategy string) (*agent.PDAgent, error) {
+			return agent.NewPDAgent(ctx, id, strategy, agent.WithProvider(client))
+		}
+		env := environment.NewPrisonersDilemmaEnvironment(environment.DefaultPayoffs, 2, environment.WithPDSeed(1))
+		dumpPath := filepath.Join(dir, "strategies.json")
+		e, err := NewPrisonersDilemmaExperiment(env, factory, 0.5, 4, 2, 2,
+			WithGameOutputDir(dir), WithGameStrategyDump(dumpPath))
+		if err != nil {
+			t.Fatalf("Failed to create experiment: %v", err)
+		}
+
+		if err := e.Run(ctx); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+
+		stats := e.Stats()
+		if len(stats) != 2 {
+			t.Fatalf("Expected stats for 2 generations, got %d", len(stats))
+		}
+		for _, s := range stats {
+			if s.Population != 4 || s.AverageScore != 2*environment.DefaultPayoffs.Reward {
+				t.Errorf("Generation %d: population %d, average score %v, want 4 agents cooperating every round",
+					s.Generation, s.Population, s.AverageScore)
+			}
+		}
+
+		var advised bool
+		for _, prompt := range client.prompts {
+			if strings.Contains(prompt, "Agent 1_0 (score 6.00): to cooperate.") {
+				advised = true
+			}
+		}
+		if !advised {
+			t.Error("Expected generation 2 to be advised with generation 1's surviving strategies")
+		}
+
+		csvs, _ := filepath.Glob(filepath.Join(dir, "prisoners_dilemma_stats_*.csv"))
+		if len(csvs) != 1 {
+			t.Fatalf("Expected one stats file in the output directory, got %v", csvs)
+		}
+		data, err := os.ReadFile(csvs[0])
+		if err != nil {
+			t.Fatalf("Failed to read stats file: %v", err)
+		}
+		if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 3 {
+			t.Errorf("Expected a header and 2 rows, got:\n%s", data)
+		}
+
+		records, err := LoadStrategies(dumpPath)
+		if err != nil {
+			t.Fatalf("Failed to load dumped strategies: %v", err)
+		}
+		if len(records) != 4 || records[0].Generation != 2 || records[0].Strategy != "to cooperate." {
+			t.Errorf("Expected generation 2's strategies to be dumped, got %+v", records)
+		}
+	})
+
+	t.Run("test ultimatum game ranks agents by the resources they won", func(t *testing.T) {
+		t.Setenv("OPENAI_API_KEY", "test-key")
+		client := &mockClient{response: "My strategy will be to split the pot evenly.\nANSWER: 4\nANSWER: ACCEPT"}
+		factory := func(ctx context.Context, id string, strategy string) (*agent.UltimatumAgent, error) {
+			return agent.NewUltimatumAgent(ctx, id, strategy, agent.WithProvider(client))
+		}
+		env := environment.NewUltimatumEnvironment(10, 2, environment.WithUltimatumSeed(1))
+		e, err := NewUltimatumExperiment(env, factory, 0.5, 4, 1, 2, WithGameOutputDir(t.TempDir()))
+		if err != nil {
+			t.Fatalf("Failed to create experiment: %v", err)
+		}
+
+		if err := e.Run(ctx); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+
+		// Every accepted offer pays out the whole pot, so 2 pairs playing 2 rounds win 10 per agent on average
+		stats := e.Stats()
+		if len(stats) != 1 || stats[0].AverageScore != 10 || !strings.Contains(stats[0].Summary, "100% accepted") {
+			t.Errorf("stats = %+v, want an average of 10 with every offer accepted", stats)
+		}
+	})
+
+	t.Run("test too few agents are rejected", func(t *testing.T) {
+		env := environment.NewPrisonersDilemmaEnvironment(environment.DefaultPayoffs, 2)
+		factory := func(ctx context.Context, id string, strategy string) (*agent.PDAgent, error) {
+			return agent.NewPDAgent(ctx, id, strategy, agent.WithProvider(&mockClient{}))
+		}
+		if _, err := NewPrisonersDilemmaExperiment(env, factory, 0.5, 1, 2, 2); err == nil {
+			t.Error("Expected an error for a single agent")
+		}
+	})
+}
@@ -0,0 +1,28 @@
+package experiment
+
+import "github.com/boristopalov/petri/pkg/environment"
+
+// lifecycleHooks are the callbacks registered to run as an experiment progresses
+type lifecycleHooks struct {
+	generationStart []func(gen int)
+	roundEnd        []func(gen, round int, state environment.DonorGameState)
+	generationEnd   []func(gen int, stats GenerationStats)
+}
+
+// OnGenerationStart registers hook to be called with each generation's number once its agents
+// have been created. Hooks must be registered before Run or Step is called.
+func (e *DonorGameExperiment) OnGenerationStart(hook func(gen int)) {
+	e.hooks.generationStart = append(e.hooks.generationStart, hook)
+}
+
+// OnRoundEnd registers hook to be called with the environment state after every round that
+// finishes. Rounds are numbered from 0 within their generation. Rounds that time out are skipped.
+func (e *DonorGameExperiment) OnRoundEnd(hook func(gen, round int, state environment.DonorGameState)) {
+	e.hooks.roundEnd = append(e.hooks.roundEnd, hook)
+}
+
+// OnGenerationEnd registers hook to be called with each generation's statistics once its rounds
+// have run, before survivors are selected
+func (e *DonorGameExperiment) OnGenerationEnd(hook func(gen int, stats GenerationStats)) {
+	e.hooks.generationEnd = append(e.hooks.generationEnd, hook)
+}
@@ -0,0 +1,65 @@
+package experiment
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Lineage maps each agent ID to the IDs of the survivors its strategy was derived from.
+// Generation 1 agents have no parents unless they were seeded from a strategies file,
+// in which case their parent is the agent the seed strategy came from.
+type Lineage map[string][]string
+
+// WriteJSON writes the lineage as a JSON object of child ID to parent IDs
+func (l Lineage) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode lineage: %v", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// WriteDOT writes the lineage as a Graphviz digraph with an edge from each parent to its children
+func (l Lineage) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph lineage {\n")
+	children := make([]string, 0, len(l))
+	for child := range l {
+		children = append(children, child)
+	}
+	slices.Sort(children)
+	for _, child := range children {
+		fmt.Fprintf(&b, "  %q;\n", child)
+		for _, parent := range l[child] {
+			fmt.Fprintf(&b, "  %q -> %q;\n", parent, child)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// SaveLineage writes the lineage to path, as DOT if path ends in .dot and as JSON otherwise
+func SaveLineage(path string, lineage Lineage) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create lineage file: %v", err)
+	}
+	defer f.Close()
+
+	if filepath.Ext(path) == ".dot" {
+		err = lineage.WriteDOT(f)
+	} else {
+		err = lineage.WriteJSON(f)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write lineage file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package experiment
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Manifest records the parameters needed to reproduce an experiment run
+type Manifest struct {
+	StartedAt           time.Time `json:"started_at"`
+	Seed                int64     `json:"seed"`
+	NumAgents           int       `json:"num_agents"`
+	NumGenerations      int       `json:"num_generations"`
+	RoundsPerGeneration int       `json:"rounds_per_generation"`
+	SurvivorRatio       float64   `json:"survivor_ratio"`
+	DonationMultiplier  float64   `json:"donation_multiplier"`
+	InitialBalance      float64   `json:"initial_balance"`
+	Elitism             bool      `json:"elitism"`
+}
+
+// manifest returns the experiment's reproducibility manifest
+func (e *DonorGameExperiment) manifest(startedAt time.Time) Manifest {
+	return Manifest{
+		StartedAt:           startedAt,
+		Seed:                e.env.GetSeed(),
+		NumAgents:           e.numAgents,
+		NumGenerations:      e.numGenerations,
+		RoundsPerGeneration: e.env.GetRoundsPerGen(),
+		SurvivorRatio:       e.survivorRatio,
+		DonationMultiplier:  e.env.GetDonationMultiplier(),
+		InitialBalance:      e.env.GetInitialBalance(),
+		Elitism:             e.elitism,
+	}
+}
+
+// writeManifest writes the manifest as JSON to path
+func writeManifest(path string, m Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
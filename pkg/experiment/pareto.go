@@ -0,0 +1,143 @@
+package experiment
+
+import (
+	"slices"
+
+	"github.com/boristopalov/petri/pkg/environment"
+)
+
+// Objective is one of the values multi-objective selection maximizes
+type Objective struct {
+	Name  string
+	Score FitnessFunc
+}
+
+// WealthObjective scores agents by their final resources
+var WealthObjective = Objective{
+	Name: "wealth",
+	Score: func(agentID string, state environment.DonorGameState) float64 {
+		return state.AgentResources[agentID]
+	},
+}
+
+// CooperationObjective scores agents by the average fraction of their resources they donated
+var CooperationObjective = Objective{
+	Name: "cooperation",
+	Score: func(agentID string, state environment.DonorGameState) float64 {
+		return state.Cooperation[agentID].Rate()
+	},
+}
+
+// GenerationObjectives records every agent's objective values at the end of a generation
+type GenerationObjectives struct {
+	Generation int
+	Objectives []string             // objective names, in the order of each agent's values
+	Values     map[string][]float64 // maps agent ID to its value for each objective
+	Front      []string             // agents no other agent dominates, sorted by ID
+}
+
+// WithObjectives records each agent's values for objectives at the end of every generation,
+// along with the generation's Pareto front. See ObjectiveHistory.
+func WithObjectives(objectives ...Objective) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.objectives = objectives
+	}
+}
+
+// ObjectiveHistory returns the objective values recorded for each generation run so far
+func (e *DonorGameExperiment) ObjectiveHistory() []GenerationObjectives {
+	return slices.Clone(e.objectiveHistory)
+}
+
+// recordObjectives scores the current agents on the experiment's objectives
+func (e *DonorGameExperiment) recordObjectives(generation int) GenerationObjectives {
+	values := ObjectiveValues(e.env.GetState(), e.objectives...)
+	record := GenerationObjectives{
+		Generation: generation,
+		Values:     values,
+		Front:      ParetoFront(values),
+	}
+	for _, o := range e.objectives {
+		record.Objectives = append(record.Objectives, o.Name)
+	}
+	e.objectiveHistory = append(e.objectiveHistory, record)
+	return record
+}
+
+// ObjectiveValues scores every agent in state on each objective
+func ObjectiveValues(state environment.DonorGameState, objectives ...Objective) map[string][]float64 {
+	values := make(map[string][]float64, len(state.AgentResources))
+	for id := range state.AgentResources {
+		v := make([]float64, len(objectives))
+		for i, o := range objectives {
+			v[i] = o.Score(id, state)
+		}
+		values[id] = v
+	}
+	return values
+}
+
+// dominates reports whether a is at least as good as b on every objective and better on at least one
+func dominates(a, b []float64) bool {
+	better := false
+	for i := range a {
+		if a[i] < b[i] {
+			return false
+		}
+		if a[i] > b[i] {
+			better = true
+		}
+	}
+	return better
+}
+
+// ParetoFront returns the agents whose objective values no other agent dominates, sorted by ID
+func ParetoFront(values map[string][]float64) []string {
+	var front []string
+	for id, v := range values {
+		dominated := false
+		for other, w := range values {
+			if other != id && dominates(w, v) {
+				dominated = true
+				break
+			}
+		}
+		if !dominated {
+			front = append(front, id)
+		}
+	}
+	slices.Sort(front)
+	return front
+}
+
+// ParetoRanks sorts agents into successive non-dominated fronts, as in NSGA-II: the first front
+// is the Pareto front, the second is the front once the first is removed, and so on
+func ParetoRanks(values map[string][]float64) [][]string {
+	remaining := make(map[string][]float64, len(values))
+	for id, v := range values {
+		remaining[id] = v
+	}
+	var fronts [][]string
+	for len(remaining) > 0 {
+		front := ParetoFront(remaining)
+		for _, id := range front {
+			delete(remaining, id)
+		}
+		fronts = append(fronts, front)
+	}
+	return fronts
+}
+
+// ParetoFitness returns a FitnessFunc that ranks agents by the Pareto front they are on for the
+// given objectives, so agents on earlier fronts survive first. Agents on the same front are
+// tied, and the experiment picks among them with the environment's seeded RNG.
+func ParetoFitness(objectives ...Objective) FitnessFunc {
+	return func(agentID string, state environment.DonorGameState) float64 {
+		for rank, front := range ParetoRanks(ObjectiveValues(state, objectives...)) {
+			if slices.Contains(front, agentID) {
+				return -float64(rank)
+			}
+		}
+		return 0
+	}
+}
@@ -0,0 +1,104 @@
+package experiment
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/boristopalov/petri/pkg/environment"
+)
+
+func TestParetoFront(t *testing.T) {
+	// Wealth and cooperation trade off: the hoarder is richest, the altruist most generous,
+	// the balanced agent is between them, and the others are worse than someone on both
+	state := environment.DonorGameState{
+		AgentResources: map[string]float64{
+			"hoarder":    30,
+			"balanced":   20,
+			"altruist":   10,
+			"laggard":    15,
+			"freeloader": 5,
+		},
+		Cooperation: map[string]environment.CooperationStats{
+			"hoarder":    {Donations: 2, TotalFraction: 0.2},
+			"balanced":   {Donations: 2, TotalFraction: 1.0},
+			"altruist":   {Donations: 2, TotalFraction: 1.6},
+			"laggard":    {Donations: 2, TotalFraction: 0.8},
+			"freeloader": {Donations: 2, TotalFraction: 0},
+		},
+	}
+	values := ObjectiveValues(state, WealthObjective, CooperationObjective)
+
+	t.Run("test front contains the non-dominated agents", func(t *testing.T) {
+		if got := fmt.Sprint(ParetoFront(values)); got != "[altruist balanced hoarder]" {
+			t.Errorf("front = %s, want [altruist balanced hoarder]", got)
+		}
+		if got := fmt.Sprint(values["balanced"]); got != "[20 0.5]" {
+			t.Errorf("balanced values = %s, want [20 0.5]", got)
+		}
+	})
+
+	t.Run("test ranks peel off successive fronts", func(t *testing.T) {
+		if got := fmt.Sprint(ParetoRanks(values)); got != "[[altruist balanced hoarder] [laggard] [freeloader]]" {
+			t.Errorf("ranks = %s, want [[altruist balanced hoarder] [laggard] [freeloader]]", got)
+		}
+	})
+
+	t.Run("test equal agents do not dominate each other", func(t *testing.T) {
+		tied := map[string][]float64{"a": {1, 1}, "b": {1, 1}}
+		if got := fmt.Sprint(ParetoFront(tied)); got != "[a b]" {
+			t.Errorf("front = %s, want [a b]", got)
+		}
+	})
+
+	t.Run("test pareto fitness selects the front first", func(t *testing.T) {
+		ranked := rankAgents(state, ParetoFitness(WealthObjective, CooperationObjective), nil)
+		if got := fmt.Sprint(ranked); got != "[altruist balanced hoarder laggard freeloader]" {
+			t.Errorf("ranking = %s, want [altruist balanced hoarder laggard freeloader]", got)
+		}
+	})
+
+	t.Run("test pareto ties are broken by the seeded rng", func(t *testing.T) {
+		rank := func(seed int64) []string {
+			env := environment.NewDonorGameEnvironment(1, 2, 10, environment.WithSeed(seed))
+			return rankAgents(state, ParetoFitness(WealthObjective, CooperationObjective), env.Shuffle)
+		}
+		leaders := make(map[string]bool)
+		for seed := range int64(20) {
+			ranked := rank(seed + 1)
+			if got := fmt.Sprint(ranked[3:]); got != "[laggard freeloader]" {
+				t.Fatalf("seed %d: ranking = %v, want the front first and then [laggard freeloader]", seed+1, ranked)
+			}
+			if fmt.Sprint(rank(seed+1)) != fmt.Sprint(ranked) {
+				t.Errorf("seed %d: ranking isn't reproducible", seed+1)
+			}
+			leaders[ranked[0]] = true
+		}
+		if len(leaders) < 2 {
+			t.Errorf("the front is always led by %v, want ties broken randomly", leaders)
+		}
+	})
+
+	t.Run("test experiment records objectives per generation", func(t *testing.T) {
+		client := &mockClient{response: "My strategy will be to donate half. ANSWER: 1"}
+		e := newTestExperiment(t, client, 4, 2, 1, WithObjectives(WealthObjective, CooperationObjective))
+		if err := e.Run(context.Background()); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+
+		history := e.ObjectiveHistory()
+		if len(history) != 2 {
+			t.Fatalf("recorded %d generations, want 2", len(history))
+		}
+		for i, record := range history {
+			if record.Generation != i+1 || fmt.Sprint(record.Objectives) != "[wealth cooperation]" {
+				t.Errorf("record %d = generation %d objectives %v, want generation %d [wealth cooperation]",
+					i, record.Generation, record.Objectives, i+1)
+			}
+			if len(record.Values) != 4 || len(record.Front) == 0 {
+				t.Errorf("generation %d has %d agents and front %v, want 4 agents and a non-empty front",
+					record.Generation, len(record.Values), record.Front)
+			}
+		}
+	})
+}
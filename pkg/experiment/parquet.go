@@ -0,0 +1,47 @@
+//go:build parquet
+
+package experiment
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// ParquetSink is a StatsSink that writes records to a Parquet file with one column per
+// RoundRecord field, for loading large runs into pandas, Polars or DuckDB
+type ParquetSink struct {
+	file   *os.File
+	writer *parquet.GenericWriter[RoundRecord]
+}
+
+// NewParquetSink creates the Parquet file at path. Records are buffered into row groups, so
+// the file isn't readable until the sink is closed.
+func NewParquetSink(path string) (*ParquetSink, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create parquet file: %v", err)
+	}
+	return &ParquetSink{
+		file:   file,
+		writer: parquet.NewGenericWriter[RoundRecord](file, parquet.Compression(&parquet.Snappy)),
+	}, nil
+}
+
+// WriteRecords appends records to the file
+func (s *ParquetSink) WriteRecords(records []RoundRecord) error {
+	if _, err := s.writer.Write(records); err != nil {
+		return fmt.Errorf("failed to write parquet records: %v", err)
+	}
+	return nil
+}
+
+// Close flushes the buffered records, writes the file footer and closes the file
+func (s *ParquetSink) Close() error {
+	if err := s.writer.Close(); err != nil {
+		s.file.Close()
+		return fmt.Errorf("failed to finish parquet file: %v", err)
+	}
+	return s.file.Close()
+}
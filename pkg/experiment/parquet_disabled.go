@@ -0,0 +1,22 @@
+//go:build !parquet
+
+package experiment
+
+import "errors"
+
+// ParquetSink is only available in builds with the parquet tag
+type ParquetSink struct{}
+
+// NewParquetSink always fails, since petri was built without Parquet support.
+// Build with -tags parquet to enable it.
+func NewParquetSink(path string) (*ParquetSink, error) {
+	return nil, errors.New("parquet export is not available in this build, rebuild with -tags parquet")
+}
+
+func (s *ParquetSink) WriteRecords(records []RoundRecord) error {
+	return errors.New("parquet export is not available in this build")
+}
+
+func (s *ParquetSink) Close() error {
+	return nil
+}
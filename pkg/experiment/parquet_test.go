@@ -0,0 +1,64 @@
+//go:build parquet
+
+package experiment
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestParquetSink(t *testing.T) {
+	t.Run("test records are read back with their schema", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "rounds.parquet")
+		sink, err := NewParquetSink(path)
+		if err != nil {
+			t.Fatalf("Failed to create sink: %v", err)
+		}
+		records := []RoundRecord{
+			{Generation: 1, Round: 0, AgentID: "1_0", Role: RoleDonor, Donation: 2.5, Balance: 7.5, Model: "gpt-4o-mini"},
+			{Generation: 1, Round: 0, AgentID: "1_1", Role: RoleRecipient, Donation: 5, Balance: 15, Model: "gpt-4o-mini"},
+		}
+		for _, batch := range [][]RoundRecord{records[:1], records[1:]} {
+			if err := sink.WriteRecords(batch); err != nil {
+				t.Fatalf("Failed to write records: %v", err)
+			}
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("Failed to close sink: %v", err)
+		}
+
+		got, err := parquet.ReadFile[RoundRecord](path)
+		if err != nil {
+			t.Fatalf("Failed to read parquet file: %v", err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(records) {
+			t.Errorf("read records %v, want %v", got, records)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("Failed to open parquet file: %v", err)
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			t.Fatalf("Failed to stat parquet file: %v", err)
+		}
+		file, err := parquet.OpenFile(f, info.Size())
+		if err != nil {
+			t.Fatalf("Failed to open parquet file: %v", err)
+		}
+		var columns []string
+		for _, field := range file.Schema().Fields() {
+			columns = append(columns, field.Name())
+		}
+		want := "[generation round agent role donation balance model]"
+		if fmt.Sprint(columns) != want {
+			t.Errorf("columns = %v, want %s", columns, want)
+		}
+	})
+}
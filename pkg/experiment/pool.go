@@ -0,0 +1,49 @@
+package experiment
+
+import (
+	"context"
+	"sync"
+
+	"github.com/boristopalov/petri/pkg/agent"
+)
+
+// AgentPool keeps agents from finished generations so they can be reset and reused
+// instead of constructed from scratch
+type AgentPool struct {
+	factory func(ctx context.Context, id string, strategy string) (*agent.DonorGameAgent, error)
+	idle    []*agent.DonorGameAgent
+	mu      sync.Mutex
+}
+
+// NewAgentPool creates an empty pool that falls back to factory when no idle agent is available
+func NewAgentPool(factory func(ctx context.Context, id string, strategy string) (*agent.DonorGameAgent, error)) *AgentPool {
+	return &AgentPool{factory: factory}
+}
+
+// Get returns an idle agent reset to the given ID and strategy, or a new one if the pool is empty
+func (p *AgentPool) Get(ctx context.Context, id string, strategy string) (*agent.DonorGameAgent, error) {
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		a := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		a.Reset(id, strategy)
+		return a, nil
+	}
+	p.mu.Unlock()
+	return p.factory(ctx, id, strategy)
+}
+
+// Put returns agents to the pool for later reuse
+func (p *AgentPool) Put(agents ...*agent.DonorGameAgent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idle = append(p.idle, agents...)
+}
+
+// Len returns the number of idle agents in the pool
+func (p *AgentPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle)
+}
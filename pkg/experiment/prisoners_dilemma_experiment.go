@@ -0,0 +1,44 @@
+package experiment
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/boristopalov/petri/pkg/agent"
+	"github.com/boristopalov/petri/pkg/environment"
+)
+
+// pdGame adapts a Prisoner's Dilemma environment to the Game a GameExperiment plays
+type pdGame struct {
+	*environment.PrisonersDilemmaEnvironment
+}
+
+func (g pdGame) Scores() map[string]float64 {
+	return g.GetState().Scores
+}
+
+func (g pdGame) Summary() string {
+	state := g.GetState()
+	var mutualCooperation float64
+	if state.Matches > 0 {
+		mutualCooperation = float64(state.MutualCooperate) / float64(state.Matches)
+	}
+	return fmt.Sprintf("%d matches, %.0f%% mutual cooperation, %d mutual defection, %d failed",
+		state.Matches, 100*mutualCooperation, state.MutualDefect, state.FailedMatches)
+}
+
+// NewPrisonersDilemmaExperiment creates a generational Prisoner's Dilemma experiment in env. Every
+// generation has numAgents agents created by agentFactory that play roundsPerGeneration rounds, and
+// the strategies of the best survivorRatio of them are passed on to the next generation.
+func NewPrisonersDilemmaExperiment(
+	env *environment.PrisonersDilemmaEnvironment,
+	agentFactory func(ctx context.Context, id string, strategy string) (*agent.PDAgent, error),
+	survivorRatio float64,
+	numAgents int,
+	numGenerations int,
+	roundsPerGeneration int,
+	opts ...GameOption,
+) (*GameExperiment[*agent.PDAgent], error) {
+	return newGameExperiment[*agent.PDAgent]("prisoners_dilemma", pdGame{env}, agentFactory,
+		survivorRatio, numAgents, numGenerations, roundsPerGeneration, opts...)
+}
@@ -0,0 +1,136 @@
+package experiment
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// ExperimentProgress is a snapshot of how far a DonorGameExperiment has got
+type ExperimentProgress struct {
+	Generation int              // generation being run, or the last one run once the experiment is done; 0 before the first
+	Round      int              // round of the generation being run, starting at 1; 0 between generations
+	Rounds     int              // rounds per generation
+	Population int              // agents in the current generation
+	Done       bool             // whether the last generation has run
+	LastStats  *GenerationStats // statistics of the last generation that finished; nil before the first one does
+}
+
+// Progress returns how far the experiment has got. It is safe to call while Run or Step is in
+// progress on another goroutine.
+func (e *DonorGameExperiment) Progress() ExperimentProgress {
+	e.progressMu.RLock()
+	defer e.progressMu.RUnlock()
+	progress := e.progress
+	if progress.LastStats != nil {
+		stats := *progress.LastStats
+		progress.LastStats = &stats
+	}
+	return progress
+}
+
+// updateProgress applies update to the experiment's progress under its lock
+func (e *DonorGameExperiment) updateProgress(update func(p *ExperimentProgress)) {
+	e.progressMu.Lock()
+	defer e.progressMu.Unlock()
+	update(&e.progress)
+}
+
+// ProgressReporter shows which generation and round an experiment is on and estimates the time
+// left from the average round duration. On a terminal it rewrites a single line; anywhere else it
+// writes a structured log record per round. Logs written to the same terminal should go through
+// LogWriter so they don't run into the progress line.
+type ProgressReporter struct {
+	w         io.Writer
+	tty       bool          // whether w is a terminal
+	logger    *slog.Logger  // writes to w when it isn't a terminal
+	rounds    int           // rounds reported so far
+	roundTime time.Duration // total duration of the rounds reported so far
+	line      string        // progress line last written to the terminal
+	lineOpen  bool          // whether line has been written to the terminal without ending it
+	mu        sync.Mutex    // guards the terminal output, which logs are written to concurrently
+}
+
+// NewProgressReporter creates a reporter that writes to w
+func NewProgressReporter(w io.Writer) *ProgressReporter {
+	return &ProgressReporter{
+		w:      w,
+		tty:    isTerminal(w),
+		logger: slog.New(slog.NewTextHandler(w, nil)),
+	}
+}
+
+// isTerminal reports whether w is a character device such as a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// WithProgressReporter reports the experiment's progress to r after every round
+func WithProgressReporter(r *ProgressReporter) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.reporter = r
+	}
+}
+
+// RoundDone reports that round of rounds in generation of generations finished after d.
+// Rounds and generations are numbered from 1.
+func (r *ProgressReporter) RoundDone(generation, generations, round, rounds int, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rounds++
+	r.roundTime += d
+	remaining := (generations-generation)*rounds + rounds - round
+	eta := (r.roundTime / time.Duration(r.rounds) * time.Duration(remaining)).Round(time.Second)
+
+	if !r.tty {
+		r.logger.Info("progress", "generation", generation, "generations", generations,
+			"round", round, "rounds", rounds, "eta", eta)
+		return
+	}
+	r.line = fmt.Sprintf("generation %d/%d, round %d/%d, ETA %v", generation, generations, round, rounds, eta)
+	fmt.Fprint(r.w, "\r\033[K"+r.line)
+	r.lineOpen = true
+}
+
+// Finish ends the progress line on a terminal so later output starts on a new line. It is safe
+// to call more than once.
+func (r *ProgressReporter) Finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lineOpen {
+		fmt.Fprintln(r.w)
+		r.lineOpen = false
+	}
+}
+
+// LogWriter returns a writer for logs shown on the reporter's terminal. Each write clears the
+// progress line, writes the log to w and then redraws the line below it.
+func (r *ProgressReporter) LogWriter(w io.Writer) io.Writer {
+	return progressLogWriter{r: r, w: w}
+}
+
+// progressLogWriter writes logs around a ProgressReporter's progress line
+type progressLogWriter struct {
+	r *ProgressReporter
+	w io.Writer
+}
+
+func (l progressLogWriter) Write(p []byte) (int, error) {
+	l.r.mu.Lock()
+	defer l.r.mu.Unlock()
+	if !l.r.lineOpen {
+		return l.w.Write(p)
+	}
+	fmt.Fprint(l.r.w, "\r\033[K")
+	n, err := l.w.Write(p)
+	fmt.Fprint(l.r.w, l.r.line)
+	return n, err
+}
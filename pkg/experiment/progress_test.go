@@ -0,0 +1,78 @@
+package experiment
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressReporter(t *testing.T) {
+	t.Run("test eta is based on the average round duration", func(t *testing.T) {
+		var buf bytes.Buffer
+		r := NewProgressReporter(&buf)
+		r.tty = true
+		r.RoundDone(1, 2, 1, 3, time.Second)
+		r.RoundDone(1, 2, 2, 3, 3*time.Second)
+		r.Finish()
+		r.Finish()
+
+		// 4 rounds remain at an average of 2s
+		if want := "\r\033[Kgeneration 1/2, round 2/3, ETA 8s\n"; !strings.HasSuffix(buf.String(), want) {
+			t.Errorf("output = %q, want it to end with %q", buf.String(), want)
+		}
+		if strings.Count(buf.String(), "\n") != 1 {
+			t.Errorf("Expected Finish to end the line once, got %q", buf.String())
+		}
+	})
+
+	t.Run("test logs are written above the progress line", func(t *testing.T) {
+		var buf bytes.Buffer
+		r := NewProgressReporter(&buf)
+		r.tty = true
+		logs := r.LogWriter(&buf)
+		logs.Write([]byte("before any progress\n"))
+		r.RoundDone(1, 1, 1, 2, time.Second)
+		logs.Write([]byte("Generation 1, Round 2/2\n"))
+
+		want := "before any progress\n" +
+			"\r\033[Kgeneration 1/1, round 1/2, ETA 1s" +
+			"\r\033[KGeneration 1, Round 2/2\ngeneration 1/1, round 1/2, ETA 1s"
+		if buf.String() != want {
+			t.Errorf("output = %q, want %q", buf.String(), want)
+		}
+	})
+
+	t.Run("test non-terminal output is logged a record per round", func(t *testing.T) {
+		var buf bytes.Buffer
+		r := NewProgressReporter(&buf)
+		r.RoundDone(2, 2, 3, 3, time.Second)
+		r.Finish()
+
+		out := buf.String()
+		if strings.Contains(out, "\r") || strings.Count(out, "\n") != 1 {
+			t.Errorf("Expected a single log record, got %q", out)
+		}
+		for _, field := range []string{"msg=progress", "generation=2", "generations=2", "round=3", "rounds=3", "eta=0s"} {
+			if !strings.Contains(out, field) {
+				t.Errorf("log record %q is missing %s", out, field)
+			}
+		}
+	})
+
+	t.Run("test experiment reports every round", func(t *testing.T) {
+		var buf bytes.Buffer
+		e := newTestExperiment(t, &mockClient{response: "ANSWER: 1"}, 2, 2, 3, WithProgressReporter(NewProgressReporter(&buf)))
+		if err := e.Run(context.Background()); err != nil {
+			t.Fatalf("Failed to run experiment: %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 2*3 {
+			t.Fatalf("got %d progress records, want %d:\n%s", len(lines), 2*3, buf.String())
+		}
+		if last := lines[len(lines)-1]; !strings.Contains(last, "generation=2") || !strings.Contains(last, "round=3") {
+			t.Errorf("last record = %q, want generation 2 round 3", last)
+		}
+	})
+}
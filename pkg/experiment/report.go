@@ -0,0 +1,85 @@
+package experiment
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+// OutputFormat selects which files an experiment writes its per-generation statistics to
+type OutputFormat string
+
+const (
+	FormatCSV  OutputFormat = "csv"  // a row per generation in the stats CSV
+	FormatJSON OutputFormat = "json" // a JSON report with every generation's statistics and survivors
+	FormatBoth OutputFormat = "both" // both the stats CSV and the JSON report
+)
+
+// ParseOutputFormat parses an output format name: csv, json or both
+func ParseOutputFormat(name string) (OutputFormat, error) {
+	switch format := OutputFormat(name); format {
+	case FormatCSV, FormatJSON, FormatBoth:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown output format %q, want csv, json or both", name)
+	}
+}
+
+func (f OutputFormat) csv() bool {
+	return f == FormatCSV || f == FormatBoth
+}
+
+func (f OutputFormat) json() bool {
+	return f == FormatJSON || f == FormatBoth
+}
+
+// WithOutputFormat sets which files the per-generation statistics are written to. The default is
+// FormatCSV; a writer given to WithStatsWriter is written to whatever the format.
+func WithOutputFormat(format OutputFormat) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.format = format
+	}
+}
+
+// Report is everything an experiment recorded about its generations, written as JSON
+type Report struct {
+	Manifest    Manifest           `json:"manifest"`
+	Generations []GenerationReport `json:"generations"`
+}
+
+// GenerationReport is a generation's statistics and the agents that survived it
+type GenerationReport struct {
+	Stats     GenerationStats  `json:"stats"`
+	Survivors []StrategyRecord `json:"survivors"` // in order of selection, best first
+}
+
+// recordGeneration adds a generation to the report and rewrites the report file, so a run that
+// fails part way still leaves the generations it finished
+func (e *DonorGameExperiment) recordGeneration(stats GenerationStats, survivors []string) {
+	if e.reportPath == "" {
+		return
+	}
+	records := make(map[string]StrategyRecord)
+	for _, r := range e.strategyRecords(stats.Generation) {
+		records[r.AgentID] = r
+	}
+	generation := GenerationReport{Stats: stats, Survivors: make([]StrategyRecord, 0, len(survivors))}
+	for _, id := range survivors {
+		generation.Survivors = append(generation.Survivors, records[id])
+	}
+	e.report.Generations = append(e.report.Generations, generation)
+
+	if err := writeReport(e.reportPath, e.report); err != nil {
+		log.Printf("Warning: Failed to write report: %v", err)
+	}
+}
+
+// writeReport writes the report as JSON to path
+func writeReport(path string, r Report) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %v", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
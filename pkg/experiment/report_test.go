@@ -0,0 +1,84 @@
+package experiment
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test json format writes a report instead of the stats csv", func(t *testing.T) {
+		e := newTestExperiment(t, &mockClient{response: "ANSWER: 1"}, 4, 2, 2, WithOutputFormat(FormatJSON))
+		if err := e.Run(ctx); err != nil {
+			t.Fatalf("Failed to run experiment: %v", err)
+		}
+
+		if csvs, _ := filepath.Glob("experiment_stats_*.csv"); len(csvs) != 0 {
+			t.Errorf("Expected no stats csv, got %v", csvs)
+		}
+		data, err := os.ReadFile(e.reportPath)
+		if err != nil {
+			t.Fatalf("Failed to read report: %v", err)
+		}
+		var report Report
+		if err := json.Unmarshal(data, &report); err != nil {
+			t.Fatalf("Failed to parse report: %v", err)
+		}
+		if report.Manifest.NumAgents != 4 || report.Manifest.NumGenerations != 2 {
+			t.Errorf("manifest = %+v, want 4 agents over 2 generations", report.Manifest)
+		}
+		if len(report.Generations) != 2 {
+			t.Fatalf("got %d generations, want 2", len(report.Generations))
+		}
+		for i, g := range report.Generations {
+			if g.Stats.Generation != i+1 || g.Stats.Population != 4 {
+				t.Errorf("generation %d stats = %+v, want generation %d of 4 agents", i, g.Stats, i+1)
+			}
+			// Half of the 4 agents survive
+			if len(g.Survivors) != 2 {
+				t.Fatalf("generation %d has %d survivors, want 2", i+1, len(g.Survivors))
+			}
+			for _, s := range g.Survivors {
+				if s.AgentID == "" || s.Strategy == "" || s.Generation != i+1 {
+					t.Errorf("generation %d survivor = %+v, want its id, strategy and generation", i+1, s)
+				}
+			}
+		}
+	})
+
+	t.Run("test both formats write the stats csv and the report", func(t *testing.T) {
+		e := newTestExperiment(t, &mockClient{response: "ANSWER: 1"}, 2, 1, 1, WithOutputFormat(FormatBoth))
+		if err := e.Run(ctx); err != nil {
+			t.Fatalf("Failed to run experiment: %v", err)
+		}
+		if csvs, _ := filepath.Glob("experiment_stats_*.csv"); len(csvs) != 1 {
+			t.Errorf("Expected a stats csv, got %v", csvs)
+		}
+		if _, err := os.Stat(e.reportPath); err != nil {
+			t.Errorf("Expected a report: %v", err)
+		}
+	})
+
+	t.Run("test csv format writes no report", func(t *testing.T) {
+		e := newTestExperiment(t, &mockClient{response: "ANSWER: 1"}, 2, 1, 1)
+		if err := e.Run(ctx); err != nil {
+			t.Fatalf("Failed to run experiment: %v", err)
+		}
+		if reports, _ := filepath.Glob("experiment_2*.json"); e.reportPath != "" || len(reports) != 0 {
+			t.Errorf("Expected no report, got %q and %v", e.reportPath, reports)
+		}
+	})
+
+	t.Run("test unknown output format is rejected", func(t *testing.T) {
+		if _, err := ParseOutputFormat("xml"); err == nil {
+			t.Error("Expected an error for an unknown format")
+		}
+		if format, err := ParseOutputFormat("both"); err != nil || format != FormatBoth {
+			t.Errorf("ParseOutputFormat(both) = %q, %v, want both", format, err)
+		}
+	})
+}
@@ -0,0 +1,24 @@
+package experiment
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/boristopalov/petri/pkg/environment"
+)
+
+// SaveRoundLogs writes the pairs of every round to a JSON file at path
+func SaveRoundLogs(path string, logs []environment.RoundLog) error {
+	if logs == nil {
+		logs = []environment.RoundLog{}
+	}
+	data, err := json.MarshalIndent(logs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode round logs: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write round log file: %v", err)
+	}
+	return nil
+}
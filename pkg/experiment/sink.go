@@ -0,0 +1,65 @@
+package experiment
+
+import (
+	"github.com/boristopalov/petri/pkg/environment"
+)
+
+// Roles an agent can play in a RoundRecord
+const (
+	RoleDonor     = "donor"
+	RoleRecipient = "recipient"
+)
+
+// RoundRecord is what happened to one agent in one round: the amount it donated as a donor, or
+// received as a recipient, and its resources once the round was over
+type RoundRecord struct {
+	Generation int     `parquet:"generation" json:"generation"`
+	Round      int     `parquet:"round" json:"round"` // round of the generation, starting at 0
+	AgentID    string  `parquet:"agent,dict" json:"agent"`
+	Role       string  `parquet:"role,dict" json:"role"`
+	Donation   float64 `parquet:"donation" json:"donation"`
+	Balance    float64 `parquet:"balance" json:"balance"`
+	Model      string  `parquet:"model,dict" json:"model,omitempty"`
+}
+
+// StatsSink receives per-round, per-agent records as the experiment runs. It is closed once
+// the experiment finishes.
+type StatsSink interface {
+	WriteRecords(records []RoundRecord) error
+	Close() error
+}
+
+// WithStatsSink writes a RoundRecord for every donor and recipient of every round to sink
+func WithStatsSink(sink StatsSink) ExperimentOption {
+	return func(e *DonorGameExperiment) {
+		e.sink = sink
+	}
+}
+
+// roundRecords turns a round's donations into one record per donor and recipient
+func roundRecords(generation int, result environment.StepResult, state environment.DonorGameState) []RoundRecord {
+	records := make([]RoundRecord, 0, 2*len(result.Donations))
+	for _, d := range result.Donations {
+		records = append(records,
+			RoundRecord{
+				Generation: generation,
+				Round:      result.Round,
+				AgentID:    d.DonorID,
+				Role:       RoleDonor,
+				Donation:   d.Amount,
+				Balance:    state.AgentResources[d.DonorID],
+				Model:      state.AgentModels[d.DonorID],
+			},
+			RoundRecord{
+				Generation: generation,
+				Round:      result.Round,
+				AgentID:    d.RecipientID,
+				Role:       RoleRecipient,
+				Donation:   d.Received,
+				Balance:    state.AgentResources[d.RecipientID],
+				Model:      state.AgentModels[d.RecipientID],
+			},
+		)
+	}
+	return records
+}
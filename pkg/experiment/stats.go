@@ -0,0 +1,224 @@
+package experiment
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/boristopalov/petri/pkg/environment"
+)
+
+// GenerationStats summarizes the outcome of a single generation
+type GenerationStats struct {
+	Generation          int
+	Population          int
+	TotalResources      float64
+	AverageResources    float64
+	StandardDeviation   float64
+	ResourceInequality  float64 // max - min resources
+	Gini                float64 // Gini coefficient of the agents' resources
+	SuccessfulDonations int
+	FailedDonations     int
+	SuccessRate         float64 // percentage of donations that succeeded
+	Donations           int     // number of donations recorded in the cooperation stats
+	AvgDonationFraction float64 // average fraction of their resources donors gave away
+	StrategyFallbacks   int
+	TotalPunishments    int                   // number of donors that punished their recipient
+	PunishmentSpent     float64               // total resources donors spent on punishment
+	FinishReasons       map[string]int        // why the model stopped generating each donation response
+	Models              map[string]ModelStats // breakdown of the population by the model each agent runs on
+	DonationMultiplier  float64
+	RoundsPerGen        int
+}
+
+// ModelStats summarizes the agents of a generation that run on the same model
+type ModelStats struct {
+	Agents              int
+	AverageResources    float64
+	Donations           int     // number of donations made by the model's agents
+	AvgDonationFraction float64 // average fraction of their resources the model's agents gave away
+}
+
+// Extinct reports whether the generation ended with no agents
+func (s GenerationStats) Extinct() bool {
+	return s.Population == 0
+}
+
+// DefaultCollapseThreshold is the average donation fraction below which a generation is
+// considered to have collapsed into defection
+const DefaultCollapseThreshold = 0.05
+
+// CooperationCollapsed reports whether donations were made but donors gave away less than
+// threshold of their resources on average. Zero donations still count as successful, so a
+// collapsed generation can have a high success rate.
+func (s GenerationStats) CooperationCollapsed(threshold float64) bool {
+	return s.Donations > 0 && s.AvgDonationFraction < threshold
+}
+
+// FinishReasonSummary formats the finish reason counts as "reason=count" pairs sorted by reason,
+// separated by semicolons so the summary fits in a single CSV field
+func (s GenerationStats) FinishReasonSummary() string {
+	reasons := make([]string, 0, len(s.FinishReasons))
+	for reason := range s.FinishReasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for i, reason := range reasons {
+		reasons[i] = fmt.Sprintf("%s=%d", reason, s.FinishReasons[reason])
+	}
+	return strings.Join(reasons, ";")
+}
+
+// ModelSummary formats the per-model breakdown as "model:agents=n|avg_resources=r|avg_donation_fraction=f"
+// entries sorted by model, separated by semicolons so the summary fits in a single CSV field
+func (s GenerationStats) ModelSummary(precision int) string {
+	models := make([]string, 0, len(s.Models))
+	for model := range s.Models {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for i, model := range models {
+		m := s.Models[model]
+		models[i] = fmt.Sprintf("%s:agents=%d|avg_resources=%.*f|avg_donation_fraction=%.4f",
+			model, m.Agents, precision, m.AverageResources, m.AvgDonationFraction)
+	}
+	return strings.Join(models, ";")
+}
+
+// computeGenerationStats calculates a generation's statistics from the environment state.
+// An extinct population has all resource metrics reported as zero.
+func computeGenerationStats(generation int, state environment.DonorGameState) GenerationStats {
+	stats := GenerationStats{
+		Generation:          generation,
+		Population:          len(state.AgentResources),
+		SuccessfulDonations: state.SuccessfulDonations,
+		FailedDonations:     state.FailedDonations,
+		TotalPunishments:    state.TotalPunishments,
+		PunishmentSpent:     state.PunishmentSpent,
+		FinishReasons:       make(map[string]int, len(state.FinishReasons)),
+	}
+	for reason, n := range state.FinishReasons {
+		stats.FinishReasons[reason] = n
+	}
+
+	// Calculate donation success rate
+	totalDonations := state.SuccessfulDonations + state.FailedDonations
+	if totalDonations > 0 {
+		stats.SuccessRate = float64(state.SuccessfulDonations) / float64(totalDonations) * 100
+	}
+
+	var totalFraction float64
+	for _, c := range state.Cooperation {
+		stats.Donations += c.Donations
+		totalFraction += c.TotalFraction
+	}
+	if stats.Donations > 0 {
+		stats.AvgDonationFraction = totalFraction / float64(stats.Donations)
+	}
+
+	stats.Models = computeModelStats(state)
+
+	if stats.Extinct() {
+		return stats
+	}
+
+	minResources := math.MaxFloat64
+	maxResources := -math.MaxFloat64
+	for _, r := range state.AgentResources {
+		stats.TotalResources += r
+		minResources = math.Min(minResources, r)
+		maxResources = math.Max(maxResources, r)
+	}
+	stats.AverageResources = stats.TotalResources / float64(stats.Population)
+
+	// Calculate standard deviation
+	var sumSquares float64
+	for _, r := range state.AgentResources {
+		diff := r - stats.AverageResources
+		sumSquares += diff * diff
+	}
+	stats.StandardDeviation = math.Sqrt(sumSquares / float64(stats.Population))
+	stats.ResourceInequality = maxResources - minResources
+	stats.Gini = Gini(slices.Collect(maps.Values(state.AgentResources)))
+
+	return stats
+}
+
+// computeModelStats groups the agents' resources and donations by the model each agent runs on.
+// Agents without a recorded model are grouped under "unknown".
+func computeModelStats(state environment.DonorGameState) map[string]ModelStats {
+	models := make(map[string]ModelStats)
+	fractions := make(map[string]float64)
+	for id, resources := range state.AgentResources {
+		model := state.AgentModels[id]
+		if model == "" {
+			model = "unknown"
+		}
+		m := models[model]
+		m.Agents++
+		m.AverageResources += resources // summed here, divided below
+		m.Donations += state.Cooperation[id].Donations
+		fractions[model] += state.Cooperation[id].TotalFraction
+		models[model] = m
+	}
+	for model, m := range models {
+		m.AverageResources /= float64(m.Agents)
+		if m.Donations > 0 {
+			m.AvgDonationFraction = fractions[model] / float64(m.Donations)
+		}
+		models[model] = m
+	}
+	return models
+}
+
+// RoundStats summarizes the population once a round has been played. Donation counts are
+// cumulative over the generation so far.
+type RoundStats struct {
+	Generation          int
+	Round               int // round of the generation, starting at 0
+	TotalResources      float64
+	AverageResources    float64
+	Gini                float64 // Gini coefficient of the agents' resources
+	SuccessfulDonations int
+	FailedDonations     int
+}
+
+// computeRoundStats calculates the statistics of a round from the environment state after it
+func computeRoundStats(generation, round int, state environment.DonorGameState) RoundStats {
+	stats := RoundStats{
+		Generation:          generation,
+		Round:               round,
+		SuccessfulDonations: state.SuccessfulDonations,
+		FailedDonations:     state.FailedDonations,
+	}
+	resources := make([]float64, 0, len(state.AgentResources))
+	for _, r := range state.AgentResources {
+		stats.TotalResources += r
+		resources = append(resources, r)
+	}
+	if len(resources) > 0 {
+		stats.AverageResources = stats.TotalResources / float64(len(resources))
+	}
+	stats.Gini = Gini(resources)
+	return stats
+}
+
+// Gini returns the Gini coefficient of values: 0 when they are all equal, approaching 1 as one
+// value holds the whole total. It is 0 for no values or a total that isn't positive.
+func Gini(values []float64) float64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	var total, weighted float64
+	for i, v := range sorted {
+		total += v
+		weighted += float64(i+1) * v
+	}
+	if total <= 0 {
+		return 0
+	}
+	n := float64(len(sorted))
+	return 2*weighted/(n*total) - (n+1)/n
+}
@@ -0,0 +1,208 @@
+package experiment
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/boristopalov/petri/pkg/agent"
+	"github.com/boristopalov/petri/pkg/environment"
+)
+
+func TestGenerationStats(t *testing.T) {
+	t.Run("test empty population", func(t *testing.T) {
+		stats := computeGenerationStats(1, environment.DonorGameState{
+			AgentResources:  map[string]float64{},
+			FailedDonations: 2,
+		})
+		if !stats.Extinct() {
+			t.Error("Expected an empty population to be extinct")
+		}
+		for name, v := range map[string]float64{
+			"TotalResources":     stats.TotalResources,
+			"AverageResources":   stats.AverageResources,
+			"StandardDeviation":  stats.StandardDeviation,
+			"ResourceInequality": stats.ResourceInequality,
+			"Gini":               stats.Gini,
+		} {
+			if v != 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+				t.Errorf("%s = %v, want 0", name, v)
+			}
+		}
+		if stats.SuccessRate != 0 {
+			t.Errorf("SuccessRate = %v, want 0", stats.SuccessRate)
+		}
+	})
+
+	t.Run("test extinct generation is reported with a warning", func(t *testing.T) {
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		t.Cleanup(func() {
+			log.SetOutput(os.Stderr)
+		})
+
+		e := newTestExperiment(t, &mockClient{response: "ANSWER: 1"}, 2, 1, 1)
+		e.printGenerationStats(1)
+
+		if !strings.Contains(buf.String(), "Population extinct in generation 1") {
+			t.Errorf("Expected an extinction warning, got logs:\n%s", buf.String())
+		}
+		for _, bad := range []string{"NaN", "Inf"} {
+			if strings.Contains(buf.String(), bad) {
+				t.Errorf("Logs contain %s:\n%s", bad, buf.String())
+			}
+		}
+
+		data, err := os.ReadFile(e.statsPath)
+		if err != nil {
+			t.Fatalf("Failed to read stats file: %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if want := "1,0.00,NA,NA,NA,NA,0,0,0.0,0,2.00,1,0.0000,false,,,0,0.00"; lines[len(lines)-1] != want {
+			t.Errorf("CSV row = %q, want %q", lines[len(lines)-1], want)
+		}
+	})
+
+	t.Run("test all-zero donations flag a cooperation collapse", func(t *testing.T) {
+		stats := computeGenerationStats(1, environment.DonorGameState{
+			AgentResources: map[string]float64{"1_0": 10, "1_1": 10},
+			Cooperation: map[string]environment.CooperationStats{
+				"1_0": {Donations: 3, TotalFraction: 0},
+				"1_1": {Donations: 3, TotalFraction: 0},
+			},
+			SuccessfulDonations: 6,
+		})
+
+		if stats.SuccessRate != 100 {
+			t.Errorf("SuccessRate = %.1f, want 100.0", stats.SuccessRate)
+		}
+		if stats.AvgDonationFraction != 0 {
+			t.Errorf("AvgDonationFraction = %v, want 0", stats.AvgDonationFraction)
+		}
+		if !stats.CooperationCollapsed(DefaultCollapseThreshold) {
+			t.Error("Expected all-zero donations to be flagged as a cooperation collapse")
+		}
+
+		stats.AvgDonationFraction = 0.5
+		if stats.CooperationCollapsed(DefaultCollapseThreshold) {
+			t.Error("Did not expect a collapse when donors give half their resources")
+		}
+	})
+
+	t.Run("test mixed model population is broken down by model", func(t *testing.T) {
+		stats := computeGenerationStats(1, environment.DonorGameState{
+			AgentResources: map[string]float64{"1_0": 14, "1_1": 6, "1_2": 20, "1_3": 10},
+			Cooperation: map[string]environment.CooperationStats{
+				"1_0": {Donations: 2, TotalFraction: 1.0},
+				"1_1": {Donations: 2, TotalFraction: 0.6},
+				"1_2": {Donations: 2, TotalFraction: 0},
+				"1_3": {Donations: 1, TotalFraction: 0.1},
+			},
+			AgentModels: map[string]string{
+				"1_0": "gpt-4o-mini",
+				"1_1": "gpt-4o-mini",
+				"1_2": "gemini-1.5-flash",
+				"1_3": "gemini-1.5-flash",
+			},
+		})
+
+		want := map[string]ModelStats{
+			"gpt-4o-mini":      {Agents: 2, AverageResources: 10, Donations: 4, AvgDonationFraction: 0.4},
+			"gemini-1.5-flash": {Agents: 2, AverageResources: 15, Donations: 3, AvgDonationFraction: 0.1 / 3},
+		}
+		if len(stats.Models) != len(want) {
+			t.Fatalf("Models = %+v, want %+v", stats.Models, want)
+		}
+		for model, w := range want {
+			got := stats.Models[model]
+			if got.Agents != w.Agents || got.Donations != w.Donations ||
+				math.Abs(got.AverageResources-w.AverageResources) > 1e-9 ||
+				math.Abs(got.AvgDonationFraction-w.AvgDonationFraction) > 1e-9 {
+				t.Errorf("Models[%s] = %+v, want %+v", model, got, w)
+			}
+		}
+
+		wantSummary := "gemini-1.5-flash:agents=2|avg_resources=15.00|avg_donation_fraction=0.0333;" +
+			"gpt-4o-mini:agents=2|avg_resources=10.00|avg_donation_fraction=0.4000"
+		if summary := stats.ModelSummary(2); summary != wantSummary {
+			t.Errorf("ModelSummary = %q, want %q", summary, wantSummary)
+		}
+	})
+
+	t.Run("test agents are recorded with their models", func(t *testing.T) {
+		e := newTestExperiment(t, &mockClient{response: "ANSWER: 1"}, 4, 1, 1)
+		for i, model := range []string{"gpt-4o-mini", "gemini-1.5-flash", "gpt-4o-mini", "gemini-1.5-flash"} {
+			a, err := agent.NewDonorGameAgent(context.Background(), fmt.Sprintf("1_%d", i), "to donate one unit.",
+				agent.WithProvider(&mockClient{response: "ANSWER: 1"}),
+				agent.WithModel(agent.ModelInfo{Id: model}))
+			if err != nil {
+				t.Fatalf("Failed to create agent: %v", err)
+			}
+			if err := e.env.AddAgent(a); err != nil {
+				t.Fatalf("Failed to add agent: %v", err)
+			}
+		}
+		if err := e.env.Step(context.Background()); err != nil {
+			t.Fatalf("Failed to run round: %v", err)
+		}
+
+		state := e.env.GetState()
+		stats := computeGenerationStats(1, state)
+		for model, m := range stats.Models {
+			var total float64
+			for id, resources := range state.AgentResources {
+				if state.AgentModels[id] == model {
+					total += resources
+				}
+			}
+			if m.Agents != 2 || math.Abs(m.AverageResources-total/2) > 1e-9 {
+				t.Errorf("Models[%s] = %+v, want 2 agents averaging %.2f", model, m, total/2)
+			}
+		}
+		if len(stats.Models) != 2 {
+			t.Errorf("Models = %+v, want gpt-4o-mini and gemini-1.5-flash", stats.Models)
+		}
+
+		for _, record := range e.strategyRecords(1) {
+			if record.Model != state.AgentModels[record.AgentID] || record.Model == "" {
+				t.Errorf("record %s has model %q, want %q", record.AgentID, record.Model, state.AgentModels[record.AgentID])
+			}
+		}
+	})
+}
+
+func TestGini(t *testing.T) {
+	t.Run("test equal resources have a coefficient of 0", func(t *testing.T) {
+		if g := Gini([]float64{5, 5, 5, 5}); g != 0 {
+			t.Errorf("Gini = %v, want 0", g)
+		}
+	})
+
+	t.Run("test one agent holding everything approaches 1", func(t *testing.T) {
+		values := make([]float64, 100)
+		values[42] = 10
+		if g := Gini(values); math.Abs(g-0.99) > 1e-9 {
+			t.Errorf("Gini = %v, want 0.99", g)
+		}
+	})
+
+	t.Run("test known distribution", func(t *testing.T) {
+		// Mean absolute difference 1.25 over twice the mean of 2.5
+		if g := Gini([]float64{4, 1, 3, 2}); math.Abs(g-0.25) > 1e-9 {
+			t.Errorf("Gini = %v, want 0.25", g)
+		}
+	})
+
+	t.Run("test no resources have a coefficient of 0", func(t *testing.T) {
+		for _, values := range [][]float64{nil, {0, 0}} {
+			if g := Gini(values); g != 0 {
+				t.Errorf("Gini(%v) = %v, want 0", values, g)
+			}
+		}
+	})
+}
@@ -0,0 +1,33 @@
+package experiment
+
+import "slices"
+
+// Stop asks the experiment to stop gracefully: the current round finishes, its generation's
+// stats are reported, and the experiment finishes as if it had run its last generation, closing
+// its stats file. It returns immediately and is safe to call from another goroutine.
+func (e *DonorGameExperiment) Stop() error {
+	e.stopRequested.Store(true)
+	return nil
+}
+
+// stopping reports whether Stop has been called
+func (e *DonorGameExperiment) stopping() bool {
+	return e.stopRequested.Load()
+}
+
+// GetStatus returns whether the experiment is running, when it started and ended, and the
+// errors that stopped it. It is safe to call while Run is in progress on another goroutine.
+func (e *DonorGameExperiment) GetStatus() Status {
+	e.progressMu.RLock()
+	defer e.progressMu.RUnlock()
+	status := e.status
+	status.Errors = slices.Clone(e.status.Errors)
+	return status
+}
+
+// updateStatus applies update to the experiment's status under its lock
+func (e *DonorGameExperiment) updateStatus(update func(s *Status)) {
+	e.progressMu.Lock()
+	defer e.progressMu.Unlock()
+	update(&e.status)
+}
@@ -0,0 +1,44 @@
+package experiment
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// StrategyRecord is a single agent's strategy as stored in a strategies file
+type StrategyRecord struct {
+	AgentID    string  `json:"agent_id"`
+	Generation int     `json:"generation"`
+	Resources  float64 `json:"resources"`
+	Strategy   string  `json:"strategy"`
+	Model      string  `json:"model,omitempty"` // ID of the model the agent ran on
+}
+
+// SaveStrategies writes strategy records to a JSON file at path
+func SaveStrategies(path string, records []StrategyRecord) error {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode strategies: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write strategies file: %v", err)
+	}
+	return nil
+}
+
+// LoadStrategies reads strategy records from a JSON file written by SaveStrategies
+func LoadStrategies(path string) ([]StrategyRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read strategies file: %v", err)
+	}
+	var records []StrategyRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse strategies file %s: %v", path, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("strategies file %s contains no strategies", path)
+	}
+	return records, nil
+}
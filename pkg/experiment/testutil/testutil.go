@@ -0,0 +1,150 @@
+// Package testutil runs whole donor game experiments deterministically, without a real provider,
+// so that the evolution loop can be covered by end-to-end regression tests.
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boristopalov/petri/pkg/agent"
+	"github.com/boristopalov/petri/pkg/environment"
+	"github.com/boristopalov/petri/pkg/experiment"
+)
+
+// Client is a deterministic agent.Client. Strategy prompts get a fixed strategy and donation
+// prompts get an amount derived from a hash of the prompt, so identical prompts always get
+// identical answers while agents in different situations still donate different amounts.
+type Client struct {
+	Strategy   string // strategy returned for strategy prompts, without the "My strategy will be" prefix
+	MaxAmount  int    // donations are between 0 and MaxAmount-1 units
+	mu         sync.Mutex
+	calls      int
+	strategies int
+}
+
+func (c *Client) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+
+	if strings.Contains(prompt, "My strategy will be") {
+		c.strategies++
+		return "My strategy will be " + c.Strategy, nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(prompt))
+	return fmt.Sprintf("ANSWER: %d", h.Sum32()%uint32(c.MaxAmount)), nil
+}
+
+// Calls returns the number of completions requested so far
+func (c *Client) Calls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+// Clock is a fake clock that only moves when advanced
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a clock stopped at now
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the clock's current time
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Config describes the experiment a Harness runs
+type Config struct {
+	Seed               int64
+	NumAgents          int
+	NumGenerations     int
+	RoundsPerGen       int
+	SurvivorRatio      float64
+	DonationMultiplier float64
+	InitialBalance     float64
+}
+
+// DefaultConfig is a small experiment that finishes in well under a second
+var DefaultConfig = Config{
+	Seed:               1,
+	NumAgents:          6,
+	NumGenerations:     3,
+	RoundsPerGen:       4,
+	SurvivorRatio:      0.5,
+	DonationMultiplier: 2.0,
+	InitialBalance:     10.0,
+}
+
+// Harness wires a donor game experiment to a deterministic client, a fixed seed, an in-memory
+// stats sink and a fake clock
+type Harness struct {
+	Client     *Client
+	Clock      *Clock
+	Stats      *bytes.Buffer
+	Env        *environment.DonorGameEnvironment
+	Experiment *experiment.DonorGameExperiment
+}
+
+// NewHarness builds an experiment from cfg. The manifest is written to a temporary directory
+// and opts are applied after the harness's own options.
+func NewHarness(tb testing.TB, cfg Config, opts ...experiment.ExperimentOption) *Harness {
+	tb.Helper()
+	// Agents construct a default OpenAI client before their provider is replaced
+	tb.Setenv("OPENAI_API_KEY", "test-key")
+
+	h := &Harness{
+		Client: &Client{Strategy: "to donate a little more to agents who donated before.", MaxAmount: 5},
+		Clock:  NewClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)),
+		Stats:  &bytes.Buffer{},
+	}
+	h.Env = environment.NewDonorGameEnvironment(cfg.RoundsPerGen, cfg.DonationMultiplier, cfg.InitialBalance,
+		environment.WithSeed(cfg.Seed))
+
+	factory := func(ctx context.Context, id string, strategy string) (*agent.DonorGameAgent, error) {
+		return agent.NewDonorGameAgent(ctx, id, strategy, agent.WithProvider(h.Client))
+	}
+	opts = append([]experiment.ExperimentOption{
+		experiment.WithStatsWriter(h.Stats),
+		experiment.WithOutputDir(tb.TempDir()),
+		experiment.WithClock(h.Clock.Now),
+	}, opts...)
+
+	e, err := experiment.NewDonorGameExperiment(h.Env, factory, cfg.SurvivorRatio, cfg.NumAgents,
+		cfg.NumGenerations, cfg.RoundsPerGen, opts...)
+	if err != nil {
+		tb.Fatalf("Failed to create experiment: %v", err)
+	}
+	h.Experiment = e
+	return h
+}
+
+// Run runs the experiment to completion
+func (h *Harness) Run(ctx context.Context) error {
+	return h.Experiment.Run(ctx)
+}
+
+// FinalResources returns the resources of every agent at the end of the run
+func (h *Harness) FinalResources() map[string]float64 {
+	return h.Env.GetState().AgentResources
+}
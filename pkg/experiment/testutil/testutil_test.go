@@ -0,0 +1,44 @@
+package testutil
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestHarness(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test repeated runs end with identical resources", func(t *testing.T) {
+		var runs []map[string]float64
+		var stats []string
+		for i := 0; i < 3; i++ {
+			h := NewHarness(t, DefaultConfig)
+			if err := h.Run(ctx); err != nil {
+				t.Fatalf("run %d failed: %v", i, err)
+			}
+			runs = append(runs, h.FinalResources())
+			stats = append(stats, h.Stats.String())
+		}
+
+		want := runs[0]
+		if len(want) != DefaultConfig.NumAgents {
+			t.Fatalf("Expected %d agents in the final generation, got %d", DefaultConfig.NumAgents, len(want))
+		}
+		for i, got := range runs[1:] {
+			for id, resources := range want {
+				if got[id] != resources {
+					t.Errorf("run %d: %s resources = %.2f, want %.2f", i+1, id, got[id], resources)
+				}
+			}
+		}
+		for i, s := range stats[1:] {
+			if s != stats[0] {
+				t.Errorf("run %d stats differ:\n%s\nwant:\n%s", i+1, s, stats[0])
+			}
+		}
+		if rows := strings.Count(stats[0], "\n"); rows != DefaultConfig.NumGenerations+1 {
+			t.Errorf("Expected a header and %d stats rows, got %d lines", DefaultConfig.NumGenerations, rows)
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package experiment
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/boristopalov/petri/pkg/agent"
+	"github.com/boristopalov/petri/pkg/environment"
+)
+
+// ultimatumGame adapts an ultimatum game environment to the Game a GameExperiment plays
+type ultimatumGame struct {
+	*environment.UltimatumEnvironment
+}
+
+func (g ultimatumGame) Scores() map[string]float64 {
+	return g.GetState().Resources
+}
+
+func (g ultimatumGame) Summary() string {
+	state := g.GetState()
+	return fmt.Sprintf("%d offers, %.0f%% accepted, average offer %.2f, %d failed",
+		state.Offers, 100*state.AcceptanceRate(), state.AverageOffer(), state.FailedOffers)
+}
+
+// NewUltimatumExperiment creates a generational ultimatum game experiment in env. Every generation
+// has numAgents agents created by agentFactory that play roundsPerGeneration rounds, and the
+// strategies of the best survivorRatio of them are passed on to the next generation.
+func NewUltimatumExperiment(
+	env *environment.UltimatumEnvironment,
+	agentFactory func(ctx context.Context, id string, strategy string) (*agent.UltimatumAgent, error),
+	survivorRatio float64,
+	numAgents int,
+	numGenerations int,
+	roundsPerGeneration int,
+	opts ...GameOption,
+) (*GameExperiment[*agent.UltimatumAgent], error) {
+	return newGameExperiment[*agent.UltimatumAgent]("ultimatum", ultimatumGame{env}, agentFactory,
+		survivorRatio, numAgents, numGenerations, roundsPerGeneration, opts...)
+}
@@ -0,0 +1,127 @@
+package experiment
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// StatsRow is one generation's statistics keyed by column name
+type StatsRow struct {
+	Columns []string          // column names in file order
+	Values  map[string]string // maps column name to its value
+}
+
+// StatsTailer follows a stats file, CSV or NDJSON, while an experiment appends to it
+type StatsTailer struct {
+	path    string
+	info    os.FileInfo // file last read, to detect it being replaced
+	offset  int64       // bytes of the file consumed so far
+	partial string      // trailing line that hasn't been terminated yet
+	header  []string    // CSV column names; nil until the header has been read
+}
+
+// NewStatsTailer creates a tailer for the stats file at path, which doesn't have to exist yet
+func NewStatsTailer(path string) *StatsTailer {
+	return &StatsTailer{path: path}
+}
+
+// Poll returns the rows appended since the last call. A file that doesn't exist yet has no rows.
+// If the file was replaced or truncated, it is read again from the start.
+func (t *StatsTailer) Poll() ([]StatsRow, error) {
+	f, err := os.Open(t.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if t.info != nil && (!os.SameFile(t.info, info) || info.Size() < t.offset) {
+		t.offset, t.partial, t.header = 0, "", nil
+	}
+	t.info = info
+
+	if _, err := f.Seek(t.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	t.offset += int64(len(data))
+
+	lines := strings.Split(t.partial+string(data), "\n")
+	t.partial = lines[len(lines)-1]
+
+	var rows []StatsRow
+	for _, line := range lines[:len(lines)-1] {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		row, ok, err := t.parseLine(line)
+		if err != nil {
+			return rows, err
+		}
+		if ok {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// parseLine parses a complete line, reporting false for a CSV header
+func (t *StatsTailer) parseLine(line string) (StatsRow, bool, error) {
+	if strings.HasPrefix(line, "{") {
+		var values map[string]any
+		if err := json.Unmarshal([]byte(line), &values); err != nil {
+			return StatsRow{}, false, fmt.Errorf("failed to parse stats line %q: %v", line, err)
+		}
+		// JSON objects are unordered, so columns are listed alphabetically
+		row := StatsRow{Values: make(map[string]string, len(values))}
+		for _, key := range slices.Sorted(maps.Keys(values)) {
+			row.Columns = append(row.Columns, key)
+			row.Values[key] = fmt.Sprint(values[key])
+		}
+		return row, true, nil
+	}
+
+	fields, err := csv.NewReader(strings.NewReader(line)).Read()
+	if err != nil {
+		return StatsRow{}, false, fmt.Errorf("failed to parse stats line %q: %v", line, err)
+	}
+	if t.header == nil {
+		t.header = fields
+		return StatsRow{}, false, nil
+	}
+	row := StatsRow{Columns: t.header, Values: make(map[string]string, len(fields))}
+	for i, column := range t.header {
+		if i < len(fields) {
+			row.Values[column] = fields[i]
+		}
+	}
+	return row, true, nil
+}
+
+// RenderStatsRow writes row as an aligned table of column names and values
+func RenderStatsRow(w io.Writer, row StatsRow) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, column := range row.Columns {
+		fmt.Fprintf(tw, "%s\t%s\n", column, row.Values[column])
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,88 @@
+package experiment
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStatsTailer(t *testing.T) {
+	appendTo := func(t *testing.T, path, data string) {
+		t.Helper()
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatalf("Failed to open stats file: %v", err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(data); err != nil {
+			t.Fatalf("Failed to write stats file: %v", err)
+		}
+	}
+	poll := func(t *testing.T, tailer *StatsTailer) []StatsRow {
+		t.Helper()
+		rows, err := tailer.Poll()
+		if err != nil {
+			t.Fatalf("Poll failed: %v", err)
+		}
+		return rows
+	}
+
+	t.Run("test appended csv rows are reported once", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "stats.csv")
+		tailer := NewStatsTailer(path)
+		if rows := poll(t, tailer); len(rows) != 0 {
+			t.Fatalf("got %d rows before the file exists, want 0", len(rows))
+		}
+
+		appendTo(t, path, "Generation,TotalResources\n1,60.00\n2,7")
+		rows := poll(t, tailer)
+		if len(rows) != 1 || rows[0].Values["Generation"] != "1" || rows[0].Values["TotalResources"] != "60.00" {
+			t.Fatalf("rows = %+v, want generation 1 only", rows)
+		}
+
+		// The partial row is reported once it is terminated
+		appendTo(t, path, "5.50\n3,80.25\n")
+		rows = poll(t, tailer)
+		if len(rows) != 2 || rows[0].Values["TotalResources"] != "75.50" || rows[1].Values["Generation"] != "3" {
+			t.Fatalf("rows = %+v, want generations 2 and 3", rows)
+		}
+		if rows := poll(t, tailer); len(rows) != 0 {
+			t.Errorf("got %d rows without new data, want 0", len(rows))
+		}
+
+		var table strings.Builder
+		if err := RenderStatsRow(&table, rows[1]); err != nil {
+			t.Fatalf("Failed to render row: %v", err)
+		}
+		if table.String() != "Generation      3\nTotalResources  80.25\n" {
+			t.Errorf("table = %q", table.String())
+		}
+	})
+
+	t.Run("test replaced file is read from the start", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "stats.csv")
+		tailer := NewStatsTailer(path)
+		appendTo(t, path, "Generation,TotalResources\n1,60.00\n2,75.50\n")
+		poll(t, tailer)
+
+		if err := os.Remove(path); err != nil {
+			t.Fatalf("Failed to remove stats file: %v", err)
+		}
+		appendTo(t, path, "Generation,AverageResources\n1,10.00\n")
+		rows := poll(t, tailer)
+		if len(rows) != 1 || rows[0].Values["AverageResources"] != "10.00" {
+			t.Errorf("rows = %+v, want the new file's first row", rows)
+		}
+	})
+
+	t.Run("test ndjson rows are parsed", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "stats.ndjson")
+		tailer := NewStatsTailer(path)
+		appendTo(t, path, `{"Generation": 1, "TotalResources": 60.5}`+"\n")
+		rows := poll(t, tailer)
+		if len(rows) != 1 || rows[0].Values["Generation"] != "1" || rows[0].Values["TotalResources"] != "60.5" {
+			t.Errorf("rows = %+v, want generation 1", rows)
+		}
+	})
+}
@@ -1,10 +1,22 @@
 package memory
 
-import "sync"
+import (
+	"context"
+	"sync"
+
+	"github.com/boristopalov/petri/pkg/providers"
+)
 
 type Memory struct {
+	pinned       []string // entries that are never evicted, returned before the memory stream
 	memoryStream []string
-	capacity     int
+	capacity     int // maximum number of entries in the memory stream; 0 means no limit
+	maxTokens    int // maximum estimated tokens in the memory stream; 0 means no limit
+	tokens       int // estimated tokens in the memory stream
+	embedder     providers.Embedder
+	embeddings   map[string][]float32 // embeddings of stored messages, computed by Retrieve
+	summarizer   Completer            // compresses entries that would be evicted; nil drops them
+	summaryModel string
 	mu           sync.RWMutex
 }
 
@@ -15,27 +27,100 @@ func NewMemory(capacity int) *Memory {
 	}
 }
 
-// GetAllMessages returns a copy of all messages in memory
+// NewMemoryWithTokenLimit creates a memory that evicts its oldest entries once the entries it
+// stores add up to more than maxTokens, as estimated by CountTokens, rather than by count.
+// The most recent entry is kept even if it alone exceeds the limit. Pinned entries don't count
+// towards the limit.
+func NewMemoryWithTokenLimit(maxTokens int) *Memory {
+	return &Memory{maxTokens: maxTokens}
+}
+
+// GetAllMessages returns a copy of all messages in memory, pinned entries first
 func (m *Memory) GetAllMessages() []string {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	// Return a copy to prevent external modifications
-	messages := make([]string, len(m.memoryStream))
-	copy(messages, m.memoryStream)
+	messages := make([]string, 0, len(m.pinned)+len(m.memoryStream))
+	messages = append(messages, m.pinned...)
+	messages = append(messages, m.memoryStream...)
 	return messages
 }
 
+// Pin adds an entry that is always returned at the top of GetAllMessages and is never evicted.
+// Pinned entries don't count towards the capacity.
+func (m *Memory) Pin(entry string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pinned = append(m.pinned, entry)
+}
+
+// Len returns the number of stored messages currently in memory, not counting pinned entries
+func (m *Memory) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.memoryStream)
+}
+
+// TokenCount returns the estimated number of tokens in the stored messages, not counting pinned entries
+func (m *Memory) TokenCount() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.tokens
+}
+
+// Capacity returns the maximum number of messages kept in memory, or 0 if the number isn't limited
+func (m *Memory) Capacity() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.capacity
+}
+
+// Clear removes all messages from memory, including pinned entries. The embedder is kept.
+func (m *Memory) Clear() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pinned = nil
+	m.memoryStream = m.memoryStream[:0]
+	m.tokens = 0
+	m.embeddings = nil
+	return nil
+}
+
 func (m *Memory) Store(data string) error {
+	return m.StoreContext(context.Background(), data)
+}
+
+// StoreContext stores data like Store. If the memory summarizes and is full, the summary is
+// generated with ctx, without holding the memory's lock.
+func (m *Memory) StoreContext(ctx context.Context, data string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	m.memoryStream = append(m.memoryStream, data)
+	m.tokens += CountTokens(data)
 
-	// TODO: come up with a better solution for handling capacity limitations
-	// It should likely be based on token counts
-	if len(m.memoryStream) > m.capacity {
-		m.memoryStream = m.memoryStream[1:]
+	if m.capacity > 0 && len(m.memoryStream) > m.capacity && m.summarizer != nil {
+		if oldest := m.summaryCandidates(); oldest != nil {
+			m.mu.Unlock()
+			summary, ok := m.summarize(ctx, oldest)
+			m.mu.Lock()
+			if ok {
+				m.replaceOldest(oldest, summary)
+			}
+		}
+	}
+	for m.capacity > 0 && len(m.memoryStream) > m.capacity {
+		m.evictOldest()
+	}
+	for m.maxTokens > 0 && m.tokens > m.maxTokens && len(m.memoryStream) > 1 {
+		m.evictOldest()
 	}
 	return nil
 }
+
+// evictOldest drops the oldest entry of the memory stream
+func (m *Memory) evictOldest() {
+	m.tokens -= CountTokens(m.memoryStream[0])
+	m.memoryStream = m.memoryStream[1:]
+}
@@ -0,0 +1,282 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	t.Run("test len and capacity", func(t *testing.T) {
+		m := NewMemory(3)
+		if got := m.Capacity(); got != 3 {
+			t.Errorf("Capacity() = %d, want 3", got)
+		}
+
+		for i := 1; i <= 5; i++ {
+			if err := m.Store(fmt.Sprintf("message %d", i)); err != nil {
+				t.Fatalf("Failed to store message: %v", err)
+			}
+			want := i
+			if want > 3 {
+				want = 3
+			}
+			if got := m.Len(); got != want {
+				t.Errorf("after %d stores Len() = %d, want %d", i, got, want)
+			}
+		}
+	})
+
+	t.Run("test clear empties memory for reuse", func(t *testing.T) {
+		m := NewMemory(3)
+		m.Pin("My strategy will be to donate half.")
+		for i := 1; i <= 2; i++ {
+			if err := m.Store(fmt.Sprintf("message %d", i)); err != nil {
+				t.Fatalf("Failed to store message: %v", err)
+			}
+		}
+
+		if err := m.Clear(); err != nil {
+			t.Fatalf("Failed to clear memory: %v", err)
+		}
+		if got := m.GetAllMessages(); m.Len() != 0 || len(got) != 0 {
+			t.Errorf("after Clear Len() = %d, GetAllMessages() = %v, want an empty memory", m.Len(), got)
+		}
+		if err := m.Store("message 3"); err != nil {
+			t.Fatalf("Failed to store message: %v", err)
+		}
+		if m.Len() != 1 || m.Capacity() != 3 {
+			t.Errorf("Len(), Capacity() = %d, %d, want 1, 3 after storing into a cleared memory", m.Len(), m.Capacity())
+		}
+	})
+
+	t.Run("test pinned entry survives eviction", func(t *testing.T) {
+		m := NewMemory(2)
+		m.Pin("My strategy will be to donate half.")
+		for i := 1; i <= 4; i++ {
+			if err := m.Store(fmt.Sprintf("message %d", i)); err != nil {
+				t.Fatalf("Failed to store message: %v", err)
+			}
+		}
+
+		want := []string{"My strategy will be to donate half.", "message 3", "message 4"}
+		got := m.GetAllMessages()
+		if len(got) != len(want) {
+			t.Fatalf("GetAllMessages() = %v, want %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("GetAllMessages()[%d] = %q, want %q", i, got[i], want[i])
+			}
+		}
+	})
+
+	t.Run("test truncating to tokens keeps the newest entries", func(t *testing.T) {
+		entries := []string{"first entry is here", "second entry", "third"}
+		if got := TruncateToTokens(entries, 0); len(got) != 3 {
+			t.Errorf("TruncateToTokens with no limit kept %v, want all entries", got)
+		}
+		limit := CountTokens("second entry") + 1 + CountTokens("third")
+		if got := TruncateToTokens(entries, limit); fmt.Sprint(got) != "[second entry third]" {
+			t.Errorf("TruncateToTokens(%d) = %v, want [second entry third]", limit, got)
+		}
+		if got := TruncateToTokens(entries, 1); fmt.Sprint(got) != "[third]" {
+			t.Errorf("TruncateToTokens(1) = %v, want the latest entry kept whole", got)
+		}
+	})
+
+	t.Run("test token limit evicts oldest entries", func(t *testing.T) {
+		limit := CountTokens("second entry") + CountTokens("third")
+		m := NewMemoryWithTokenLimit(limit)
+		for _, entry := range []string{"first entry is here", "second entry", "third"} {
+			if err := m.Store(entry); err != nil {
+				t.Fatalf("Failed to store message: %v", err)
+			}
+		}
+		if got := fmt.Sprint(m.GetAllMessages()); got != "[second entry third]" {
+			t.Errorf("GetAllMessages() = %v, want [second entry third]", got)
+		}
+		if m.TokenCount() != limit {
+			t.Errorf("TokenCount() = %d, want %d", m.TokenCount(), limit)
+		}
+		if m.Capacity() != 0 {
+			t.Errorf("Capacity() = %d, want 0 for a token-limited memory", m.Capacity())
+		}
+
+		if err := m.Store("an entry far too long to fit within the token limit on its own"); err != nil {
+			t.Fatalf("Failed to store message: %v", err)
+		}
+		if m.Len() != 1 {
+			t.Errorf("Len() = %d, want the oversized latest entry kept alone", m.Len())
+		}
+
+		if err := m.Clear(); err != nil {
+			t.Fatalf("Failed to clear memory: %v", err)
+		}
+		if m.TokenCount() != 0 {
+			t.Errorf("TokenCount() after Clear = %d, want 0", m.TokenCount())
+		}
+	})
+
+	t.Run("test retrieve returns the most similar messages", func(t *testing.T) {
+		m := NewMemory(10)
+		if _, err := m.Retrieve(context.Background(), "agent_1", 1); !errors.Is(err, ErrNoEmbedder) {
+			t.Errorf("Retrieve() without an embedder error = %v, want ErrNoEmbedder", err)
+		}
+
+		embedder := &keywordEmbedder{keywords: []string{"agent_1", "agent_2", "agent_3"}}
+		m.SetEmbedder(embedder)
+		for _, entry := range []string{
+			"Round 1: agent_1 donated 5 to agent_2",
+			"Round 2: agent_3 donated 2 to me",
+			"Round 3: I donated 4 to agent_1",
+		} {
+			if err := m.Store(entry); err != nil {
+				t.Fatalf("Failed to store message: %v", err)
+			}
+		}
+
+		got, err := m.Retrieve(context.Background(), "agent_1", 2)
+		if err != nil {
+			t.Fatalf("Retrieve() error = %v", err)
+		}
+		want := "[Round 3: I donated 4 to agent_1 Round 1: agent_1 donated 5 to agent_2]"
+		if fmt.Sprint(got) != want {
+			t.Errorf("Retrieve() = %v, want %v", got, want)
+		}
+
+		// Stored messages are only embedded once
+		if _, err := m.Retrieve(context.Background(), "agent_3", 1); err != nil {
+			t.Fatalf("Retrieve() error = %v", err)
+		}
+		if embedder.embedded != 5 {
+			t.Errorf("embedded %d texts, want 5 (3 messages and 2 queries)", embedder.embedded)
+		}
+	})
+
+	t.Run("test summarizing memory compresses the oldest entries", func(t *testing.T) {
+		client := &summaryClient{summary: "agent_1 and agent_2 donated."}
+		m := NewSummarizingMemory(4, client, "summary-model")
+		for i := 1; i <= 5; i++ {
+			if err := m.Store(fmt.Sprintf("Round %d: agent_1 donated %d units to agent_2, who had 10 units", i, i)); err != nil {
+				t.Fatalf("Failed to store message: %v", err)
+			}
+		}
+
+		got := m.GetAllMessages()
+		if len(got) != 4 || got[0] != SUMMARY_PREFIX+"agent_1 and agent_2 donated." {
+			t.Fatalf("GetAllMessages() = %q, want a summary followed by the 3 newest entries", got)
+		}
+		if !strings.HasPrefix(got[1], "Round 3:") {
+			t.Errorf("first entry after the summary = %q, want round 3", got[1])
+		}
+		if client.calls != 1 || !strings.Contains(client.prompt, "Round 1:") || !strings.Contains(client.prompt, "Round 2:") {
+			t.Errorf("summarized with %d calls and prompt %q, want one call covering rounds 1 and 2", client.calls, client.prompt)
+		}
+		want := 0
+		for _, entry := range got {
+			want += CountTokens(entry)
+		}
+		if m.TokenCount() != want {
+			t.Errorf("TokenCount() = %d, want %d", m.TokenCount(), want)
+		}
+	})
+
+	t.Run("test summary is generated with the store context and without the lock", func(t *testing.T) {
+		client := &summaryClient{summary: "agent_1 donated."}
+		m := NewSummarizingMemory(2, client, "summary-model")
+		type key struct{}
+		ctx := context.WithValue(context.Background(), key{}, "store")
+		client.during = func(ctx context.Context) {
+			if ctx.Value(key{}) != "store" {
+				t.Error("Expected the summary to be generated with the context passed to StoreContext")
+			}
+			read := make(chan int)
+			go func() { read <- m.Len() }()
+			select {
+			case <-read:
+			case <-time.After(time.Second):
+				t.Error("Expected the memory to be readable while the summary is generated")
+			}
+		}
+		for i := 1; i <= 3; i++ {
+			if err := m.StoreContext(ctx, fmt.Sprintf("Round %d: agent_1 donated %d units to agent_2, who had 10 units", i, i)); err != nil {
+				t.Fatalf("Failed to store message: %v", err)
+			}
+		}
+		if client.calls != 1 {
+			t.Errorf("summarized %d times, want 1", client.calls)
+		}
+	})
+
+	t.Run("test cancelled summary falls back to eviction", func(t *testing.T) {
+		client := &summaryClient{summary: "summary"}
+		m := NewSummarizingMemory(2, client, "summary-model")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		for i := 1; i <= 3; i++ {
+			if err := m.StoreContext(ctx, fmt.Sprintf("message %d", i)); err != nil {
+				t.Fatalf("Failed to store message: %v", err)
+			}
+		}
+		if got := fmt.Sprint(m.GetAllMessages()); got != "[message 2 message 3]" {
+			t.Errorf("GetAllMessages() = %v, want the oldest entry evicted", got)
+		}
+	})
+
+	t.Run("test summary that isn't shorter falls back to eviction", func(t *testing.T) {
+		client := &summaryClient{summary: strings.Repeat("a very long summary ", 20)}
+		m := NewSummarizingMemory(2, client, "summary-model")
+		for i := 1; i <= 3; i++ {
+			if err := m.Store(fmt.Sprintf("message %d", i)); err != nil {
+				t.Fatalf("Failed to store message: %v", err)
+			}
+		}
+		if got := fmt.Sprint(m.GetAllMessages()); got != "[message 2 message 3]" {
+			t.Errorf("GetAllMessages() = %v, want the oldest entry evicted", got)
+		}
+	})
+}
+
+// keywordEmbedder embeds a text as a vector with one dimension per keyword, set to 1 if the text mentions it
+type keywordEmbedder struct {
+	keywords []string
+	embedded int
+}
+
+func (e *keywordEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = make([]float32, len(e.keywords))
+		for j, keyword := range e.keywords {
+			if strings.Contains(text, keyword) {
+				vectors[i][j] = 1
+			}
+		}
+	}
+	e.embedded += len(texts)
+	return vectors, nil
+}
+
+// summaryClient answers every summary request with the same summary
+type summaryClient struct {
+	summary string
+	prompt  string // prompt of the last call
+	calls   int
+	during  func(ctx context.Context) // called while the summary is generated, if set
+}
+
+func (c *summaryClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	c.prompt = prompt
+	c.calls++
+	if c.during != nil {
+		c.during(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return c.summary, nil
+}
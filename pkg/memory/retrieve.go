@@ -0,0 +1,114 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+
+	"github.com/boristopalov/petri/pkg/providers"
+)
+
+// ErrNoEmbedder is returned by Retrieve when the memory has no embedder
+var ErrNoEmbedder = errors.New("memory has no embedder")
+
+// SetEmbedder makes the memory keep an embedding for each stored message so it can be searched
+// with Retrieve. Embeddings are computed lazily, the first time Retrieve sees a message.
+func (m *Memory) SetEmbedder(embedder providers.Embedder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.embedder = embedder
+}
+
+// Retrieve returns the k stored messages most similar to query by cosine similarity of their
+// embeddings, most similar first. Pinned entries aren't searched.
+func (m *Memory) Retrieve(ctx context.Context, query string, k int) ([]string, error) {
+	m.mu.RLock()
+	embedder := m.embedder
+	entries := slices.Clone(m.memoryStream)
+	var missing []string
+	for _, entry := range entries {
+		if _, ok := m.embeddings[entry]; !ok && !slices.Contains(missing, entry) {
+			missing = append(missing, entry)
+		}
+	}
+	m.mu.RUnlock()
+
+	if embedder == nil {
+		return nil, ErrNoEmbedder
+	}
+	if k <= 0 || len(entries) == 0 {
+		return nil, nil
+	}
+
+	// Embed the new entries and the query in one call, without holding the lock
+	vectors, err := embedder.Embed(ctx, append(missing, query))
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed memories: %w", err)
+	}
+	if len(vectors) != len(missing)+1 {
+		return nil, fmt.Errorf("embedder returned %d embeddings for %d texts", len(vectors), len(missing)+1)
+	}
+	queryVector := vectors[len(missing)]
+
+	scores := make([]float64, len(entries))
+	m.mu.Lock()
+	if m.embeddings == nil {
+		m.embeddings = make(map[string][]float32)
+	}
+	for i, entry := range missing {
+		m.embeddings[entry] = vectors[i]
+	}
+	for i, entry := range entries {
+		scores[i] = cosineSimilarity(m.embeddings[entry], queryVector)
+	}
+	// Forget the embeddings of messages that have been evicted
+	current := make(map[string]bool, len(m.memoryStream))
+	for _, entry := range m.memoryStream {
+		current[entry] = true
+	}
+	for entry := range m.embeddings {
+		if !current[entry] {
+			delete(m.embeddings, entry)
+		}
+	}
+	m.mu.Unlock()
+
+	order := make([]int, len(entries))
+	for i := range order {
+		order[i] = i
+	}
+	// Ties keep the newest message first
+	sort.SliceStable(order, func(a, b int) bool {
+		if scores[order[a]] != scores[order[b]] {
+			return scores[order[a]] > scores[order[b]]
+		}
+		return order[a] > order[b]
+	})
+
+	retrieved := make([]string, 0, min(k, len(entries)))
+	for _, i := range order[:min(k, len(entries))] {
+		retrieved = append(retrieved, entries[i])
+	}
+	return retrieved, nil
+}
+
+// cosineSimilarity returns the cosine of the angle between a and b, or 0 if either is a zero
+// vector or their lengths differ
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,81 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+const (
+	SUMMARY_PROMPT_TEMPLATE = `Summarize the following memories in one or two sentences. Keep who did what to whom and the amounts involved, and leave out anything else.
+
+%s`
+
+	// SUMMARY_PREFIX starts the entry that replaces summarized memories
+	SUMMARY_PREFIX = "Summary of earlier memories: "
+)
+
+// Completer generates text from a prompt. It has the same method set as agent.Client.
+type Completer interface {
+	Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error)
+}
+
+// NewSummarizingMemory creates a memory that, instead of dropping its oldest entry when Store
+// would exceed capacity, asks model to compress the oldest half of the entries into a single
+// summary entry. If the summary can't be generated, the oldest entry is evicted instead.
+func NewSummarizingMemory(capacity int, client Completer, model string) *Memory {
+	m := NewMemory(capacity)
+	m.summarizer = client
+	m.summaryModel = model
+	return m
+}
+
+// summaryCandidates returns the oldest half of the memory stream, which a summary replaces, or
+// nil if there are too few entries to be worth summarizing. m.mu must be held.
+func (m *Memory) summaryCandidates() []string {
+	n := min(max(m.capacity/2, 2), len(m.memoryStream)-1)
+	if n < 2 {
+		return nil
+	}
+	return slices.Clone(m.memoryStream[:n])
+}
+
+// summarize asks the model to compress oldest into a single summary entry. It is called without
+// m.mu held, so the memory can be read while the model runs. A summary that is empty or isn't
+// shorter than the entries it replaces is discarded, so summarizing never grows the memory.
+func (m *Memory) summarize(ctx context.Context, oldest []string) (string, bool) {
+	prompt := fmt.Sprintf(SUMMARY_PROMPT_TEMPLATE, strings.Join(oldest, "\n"))
+	response, err := m.summarizer.Complete(ctx, m.summaryModel, prompt, "", nil)
+	if err != nil {
+		log.Printf("Failed to summarize memories, evicting the oldest instead: %v", err)
+		return "", false
+	}
+	summary := SUMMARY_PREFIX + strings.Join(strings.Fields(response), " ")
+
+	replacedTokens := 0
+	for _, entry := range oldest {
+		replacedTokens += CountTokens(entry)
+	}
+	if strings.TrimSpace(response) == "" || CountTokens(summary) >= replacedTokens {
+		return "", false
+	}
+	return summary, true
+}
+
+// replaceOldest replaces the oldest entries with their summary, unless they changed while the
+// summary was generated, e.g. because a concurrent Store evicted them. The summary is stored
+// directly rather than through Store, so summarizing never recurses. m.mu must be held.
+func (m *Memory) replaceOldest(oldest []string, summary string) {
+	n := len(oldest)
+	if n > len(m.memoryStream) || !slices.Equal(m.memoryStream[:n], oldest) {
+		return
+	}
+	replacedTokens := 0
+	for _, entry := range oldest {
+		replacedTokens += CountTokens(entry)
+	}
+	m.memoryStream = append([]string{summary}, m.memoryStream[n:]...)
+	m.tokens += CountTokens(summary) - replacedTokens
+}
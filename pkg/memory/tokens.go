@@ -0,0 +1,46 @@
+package memory
+
+import (
+	"strings"
+	"unicode"
+)
+
+// CountTokens estimates how many tokens text takes up in a prompt. Models tokenize differently,
+// so this approximates BPE tokenizers: roughly one token per four characters of a word, and one
+// per punctuation mark.
+func CountTokens(text string) int {
+	tokens := 0
+	for _, word := range strings.Fields(text) {
+		letters := 0
+		for _, r := range word {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				letters++
+				continue
+			}
+			tokens++
+		}
+		tokens += (letters + 3) / 4
+	}
+	return tokens
+}
+
+// TruncateToTokens drops the oldest entries until the rest, joined with newlines, fit within
+// limit tokens as counted by CountTokens. The most recent entry is always kept whole, even if
+// it alone exceeds the limit. A limit of 0 or less keeps every entry.
+func TruncateToTokens(entries []string, limit int) []string {
+	if limit <= 0 || len(entries) == 0 {
+		return entries
+	}
+	start := len(entries) - 1
+	total := CountTokens(entries[start])
+	for start > 0 {
+		// Each newline joining entries counts as a token
+		next := total + CountTokens(entries[start-1]) + 1
+		if next > limit {
+			break
+		}
+		total = next
+		start--
+	}
+	return entries[start:]
+}
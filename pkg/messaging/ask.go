@@ -0,0 +1,74 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// askInboxSize is the buffer of the temporary inbox Ask waits on, which broadcasts also reach
+const askInboxSize = 16
+
+// askInboxInfix separates the asking agent's ID from the correlation ID in a temporary inbox
+const askInboxInfix = "/ask/"
+
+// askInboxOwner returns the agent that subscribed recipient if it is a temporary inbox made by Ask
+func askInboxOwner(recipient string) (string, bool) {
+	owner, _, ok := strings.Cut(recipient, askInboxInfix)
+	return owner, ok
+}
+
+// Ask sends content from one agent to another and waits for the reply. The request carries a new
+// correlation ID and asks for replies at a temporary inbox, so the reply doesn't reach the asking
+// agent's own message handler. Broadcasts that reach the inbox while it waits are discarded. It
+// returns an error if ctx is done before the reply arrives.
+func Ask(ctx context.Context, broker Broker, from string, to string, content any) (Message, error) {
+	correlationID := uuid.NewString()
+	inbox := from + askInboxInfix + correlationID
+	replies := make(chan Message, askInboxSize)
+	if err := broker.Subscribe(inbox, replies); err != nil {
+		return Message{}, fmt.Errorf("failed to subscribe for the reply: %w", err)
+	}
+	defer broker.Unsubscribe(inbox)
+
+	request := Message{
+		From:          from,
+		To:            []string{to},
+		Content:       content,
+		Timestamp:     time.Now(),
+		CorrelationID: correlationID,
+		ReplyTo:       inbox,
+	}
+	if err := broker.Publish(request); err != nil {
+		return Message{}, fmt.Errorf("failed to send request to %s: %w", to, err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return Message{}, fmt.Errorf("no reply from %s: %w", to, ctx.Err())
+		case reply := <-replies:
+			if reply.CorrelationID == correlationID {
+				return reply, nil
+			}
+		}
+	}
+}
+
+// Reply answers request with content from the agent that received it
+func Reply(broker Broker, request Message, from string, content any) error {
+	to := request.ReplyTo
+	if to == "" {
+		to = request.From
+	}
+	return broker.Publish(Message{
+		From:          from,
+		To:            []string{to},
+		Content:       content,
+		Timestamp:     time.Now(),
+		CorrelationID: request.CorrelationID,
+	})
+}
@@ -0,0 +1,70 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAsk(t *testing.T) {
+	t.Run("test ask returns the reply to its request", func(t *testing.T) {
+		broker := NewBroker()
+		asker, responder := make(chan Message, 10), make(chan Message, 10)
+		if err := broker.Subscribe("asker", asker); err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+		if err := broker.Subscribe("responder", responder); err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+		go func() {
+			request := <-responder
+			// Unrelated traffic doesn't satisfy the request
+			broker.Publish(Message{From: "responder", Content: "broadcast"})
+			broker.Publish(Message{From: "responder", To: []string{request.ReplyTo}, Content: "stale", CorrelationID: "other"})
+			Reply(broker, request, "responder", "offer accepted")
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		reply, err := Ask(ctx, broker, "asker", "responder", "will you accept 5?")
+		if err != nil {
+			t.Fatalf("Ask failed: %v", err)
+		}
+		if reply.Content != "offer accepted" || reply.From != "responder" || reply.CorrelationID == "" {
+			t.Errorf("reply = %+v, want the responder's answer", reply)
+		}
+		if len(asker) != 1 {
+			t.Errorf("asker's own channel got %d messages, want only the broadcast", len(asker))
+		}
+		if n := broker.SubscriberCount(); n != 2 {
+			t.Errorf("%d subscribers after Ask, want its inbox to be removed", n)
+		}
+	})
+
+	t.Run("test ask gives up when the context expires", func(t *testing.T) {
+		broker := NewBroker()
+		if err := broker.Subscribe("responder", make(chan Message, 1)); err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if _, err := Ask(ctx, broker, "asker", "responder", "hello?"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("err = %v, want the context's deadline", err)
+		}
+	})
+
+	t.Run("test reply without a reply-to address goes to the sender", func(t *testing.T) {
+		broker := NewBroker()
+		asker := make(chan Message, 1)
+		if err := broker.Subscribe("asker", asker); err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+		if err := Reply(broker, Message{From: "asker", CorrelationID: "id"}, "responder", "yes"); err != nil {
+			t.Fatalf("Reply failed: %v", err)
+		}
+		if reply := <-asker; reply.CorrelationID != "id" || reply.Content != "yes" {
+			t.Errorf("reply = %+v, want correlation ID id", reply)
+		}
+	})
+}
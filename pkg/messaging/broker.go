@@ -1,87 +1,386 @@
 package messaging
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 )
 
+// defaultShards is the number of subscriber shards used when WithShards isn't given
+const defaultShards = 16
+
 // SimpleBroker implements the Broker interface
-// subscribers is a map where keys are agent IDs and values are channels for receiving messages
+// Subscribers are split into shards keyed by a hash of the agent ID, each with its own lock,
+// so that publishes and subscriptions for agents in different shards don't contend
 type SimpleBroker struct {
+	shards []*brokerShard
+	// priority orders broadcast delivery when set; nil delivers in map iteration order
+	priority func(agentID string) int
+	// deliveryTimeout is how long a send waits for space in a full channel; 0 drops the message immediately
+	deliveryTimeout time.Duration
+	// groups maps a group name to the IDs of its members
+	groups   map[string]map[string]struct{}
+	groupsMu sync.RWMutex
+	// deadLetters are the most recent messages that couldn't be delivered
+	deadLetters   []DeadLetter
+	deadLettersMu sync.Mutex
+	// stats counts the messages published and delivered
+	stats brokerStats
+}
+
+// brokerShard is a map where keys are agent IDs and values are channels for receiving messages
+type brokerShard struct {
 	subscribers map[string]chan<- Message
 	mu          sync.RWMutex
 }
 
+// BrokerOption configures a SimpleBroker
+type BrokerOption func(*SimpleBroker)
+
+// WithShards sets the number of subscriber shards. One shard behaves like a single-lock broker.
+func WithShards(n int) BrokerOption {
+	return func(b *SimpleBroker) {
+		if n < 1 {
+			n = 1
+		}
+		b.shards = newShards(n)
+	}
+}
+
+// WithOrderedDelivery delivers broadcasts to subscribers in agent ID order, so that
+// turn-taking driven by broadcasts is reproducible
+func WithOrderedDelivery() BrokerOption {
+	return WithDeliveryPriority(func(string) int { return 0 })
+}
+
+// WithDeliveryPriority delivers broadcasts to subscribers in descending priority order,
+// breaking ties by agent ID. Ordered delivery collects every subscriber before sending,
+// so it is slower than the default unordered broadcast.
+func WithDeliveryPriority(priority func(agentID string) int) BrokerOption {
+	return func(b *SimpleBroker) {
+		b.priority = priority
+	}
+}
+
+// WithBlockingDelivery makes a send to a full channel wait up to timeout for space before
+// giving up, instead of failing immediately. PublishContext also gives up when its context is
+// done. A subscriber can't be unsubscribed while a send to it is waiting.
+func WithBlockingDelivery(timeout time.Duration) BrokerOption {
+	return func(b *SimpleBroker) {
+		b.deliveryTimeout = timeout
+	}
+}
+
 // NewBroker creates a new message broker
-func NewBroker() *SimpleBroker {
-	return &SimpleBroker{
-		subscribers: make(map[string]chan<- Message),
+func NewBroker(opts ...BrokerOption) *SimpleBroker {
+	b := &SimpleBroker{
+		shards: newShards(defaultShards),
+		groups: make(map[string]map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
 	}
+	return b
+}
+
+func newShards(n int) []*brokerShard {
+	shards := make([]*brokerShard, n)
+	for i := range shards {
+		shards[i] = &brokerShard{subscribers: make(map[string]chan<- Message)}
+	}
+	return shards
+}
+
+// shard returns the shard that holds agentID's subscription
+func (b *SimpleBroker) shard(agentID string) *brokerShard {
+	h := fnv.New32a()
+	h.Write([]byte(agentID))
+	return b.shards[h.Sum32()%uint32(len(b.shards))]
 }
 
 // Publish sends a message to specified recipients
 func (b *SimpleBroker) Publish(msg Message) error {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
+	return b.PublishContext(context.Background(), msg)
+}
+
+// PublishContext sends a message to specified recipients like Publish. With blocking delivery,
+// sends to full channels stop waiting when ctx is done. Every recipient is attempted even if
+// delivery to another one fails; each failure is dead-lettered and they are returned joined.
+func (b *SimpleBroker) PublishContext(ctx context.Context, msg Message) error {
+	b.stats.published.Add(1)
 
 	// If no recipients specified, broadcast to all subscribers
-	recipients := msg.To
-	if len(recipients) == 0 {
-		for id := range b.subscribers {
-			if id != msg.From { // Don't send to self
-				recipients = append(recipients, id)
+	if len(msg.To) == 0 {
+		if b.priority != nil {
+			return b.orderedBroadcast(ctx, msg)
+		}
+		var errs []error
+		for _, s := range b.shards {
+			errs = append(errs, s.broadcast(msg.From, b.deliverer(ctx, msg)))
+		}
+		return errors.Join(errs...)
+	}
+
+	// Send to each recipient, expanding groups to their current members
+	var errs []error
+	for _, recipientID := range b.recipients(msg) {
+		subscribed, err := b.shard(recipientID).send(recipientID, b.deliverer(ctx, msg))
+		errs = append(errs, err)
+		if !subscribed {
+			b.deadLetter(msg, recipientID, UnknownRecipient)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// recipients returns the agent IDs msg is addressed to, with each group replaced by its members
+// other than the sender. When msg is addressed to a group, an agent addressed more than once only
+// receives it once; messages addressed only to agent IDs are delivered exactly as addressed.
+func (b *SimpleBroker) recipients(msg Message) []string {
+	if !slices.ContainsFunc(msg.To, isGroupAddress) {
+		return msg.To
+	}
+
+	b.groupsMu.RLock()
+	defer b.groupsMu.RUnlock()
+	seen := make(map[string]bool)
+	var recipients []string
+	add := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			recipients = append(recipients, id)
+		}
+	}
+	for _, to := range msg.To {
+		if !isGroupAddress(to) {
+			add(to)
+			continue
+		}
+		for _, member := range slices.Sorted(maps.Keys(b.groups[strings.TrimPrefix(to, GroupPrefix)])) {
+			if member != msg.From {
+				add(member)
 			}
 		}
 	}
+	return recipients
+}
 
-	// Send to each recipient
-	for _, recipientID := range recipients {
-		ch, ok := b.subscribers[recipientID]
-		if !ok {
-			continue // Skip if recipient not found
+// isGroupAddress reports whether recipient addresses a group rather than an agent
+func isGroupAddress(recipient string) bool {
+	return strings.HasPrefix(recipient, GroupPrefix)
+}
+
+// subscription is a subscriber's agent ID and channel
+type subscription struct {
+	id string
+	ch chan<- Message
+}
+
+// orderedBroadcast sends msg to every subscriber except its sender in priority order
+func (b *SimpleBroker) orderedBroadcast(ctx context.Context, msg Message) error {
+	var subs []subscription
+	for _, s := range b.shards {
+		s.mu.RLock()
+		for id, ch := range s.subscribers {
+			if id != msg.From { // Don't send to self
+				subs = append(subs, subscription{id: id, ch: ch})
+			}
 		}
+		s.mu.RUnlock()
+	}
+
+	slices.SortFunc(subs, func(a, c subscription) int {
+		return cmp.Or(
+			cmp.Compare(b.priority(c.id), b.priority(a.id)),
+			cmp.Compare(a.id, c.id),
+		)
+	})
+	var errs []error
+	for _, sub := range subs {
+		errs = append(errs, b.deliver(ctx, sub.id, sub.ch, msg))
+	}
+	return errors.Join(errs...)
+}
+
+// broadcast delivers a message to every subscriber in the shard except its sender, returning
+// the failed deliveries joined
+func (s *brokerShard) broadcast(from string, deliver func(id string, ch chan<- Message) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-		// Non-blocking send
-		select {
-		case ch <- msg:
-			// Message sent successfully
-		default:
-			// Channel is full, skip this message
-			return fmt.Errorf("recipient %s's channel is full", recipientID)
+	var errs []error
+	for id, ch := range s.subscribers {
+		if id == from { // Don't send to self
+			continue
 		}
+		errs = append(errs, deliver(id, ch))
 	}
+	return errors.Join(errs...)
+}
 
-	return nil
+// send delivers a message to recipientID if it is subscribed to this shard, reporting whether it is
+func (s *brokerShard) send(recipientID string, deliver func(id string, ch chan<- Message) error) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ch, ok := s.subscribers[recipientID]
+	if !ok {
+		return false, nil // Skip if recipient not found
+	}
+	return true, deliver(recipientID, ch)
+}
+
+// deliverer returns a function that delivers msg to a subscriber
+func (b *SimpleBroker) deliverer(ctx context.Context, msg Message) func(id string, ch chan<- Message) error {
+	return func(id string, ch chan<- Message) error {
+		return b.deliver(ctx, id, ch, msg)
+	}
+}
+
+// deliver sends msg on ch, counts the outcome and dead-letters msg if it couldn't be sent.
+// Without a delivery timeout the send doesn't block; otherwise it waits up to the timeout for
+// space in ch, giving up early if ctx is done.
+func (b *SimpleBroker) deliver(ctx context.Context, recipientID string, ch chan<- Message, msg Message) error {
+	err := b.send(ctx, recipientID, ch, msg)
+	b.stats.record(recipientID, err)
+	return b.failed(msg, err)
+}
+
+// send sends msg on ch, waiting up to the delivery timeout for space
+func (b *SimpleBroker) send(ctx context.Context, recipientID string, ch chan<- Message, msg Message) error {
+	if b.deliveryTimeout <= 0 {
+		return trySend(recipientID, ch, msg)
+	}
+	timer := time.NewTimer(b.deliveryTimeout)
+	defer timer.Stop()
+	select {
+	case ch <- msg:
+		return nil
+	case <-ctx.Done():
+		return &deliveryError{recipientID, DeliveryCancelled, fmt.Errorf("delivery to %s cancelled: %w", recipientID, ctx.Err())}
+	case <-timer.C:
+		return &deliveryError{recipientID, ChannelFull, fmt.Errorf("recipient %s's channel is still full after %v", recipientID, b.deliveryTimeout)}
+	}
+}
+
+// trySend does a non-blocking send of msg on ch
+func trySend(recipientID string, ch chan<- Message, msg Message) error {
+	select {
+	case ch <- msg:
+		// Message sent successfully
+		return nil
+	default:
+		// Channel is full, skip this message
+		return &deliveryError{recipientID, ChannelFull, fmt.Errorf("recipient %s's channel is full", recipientID)}
+	}
 }
 
 // Subscribe registers an agent to receive messages
 func (b *SimpleBroker) Subscribe(agentID string, ch chan<- Message) error {
-	b.mu.Lock()
-	defer b.mu.Unlock()
+	s := b.shard(agentID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	if _, exists := b.subscribers[agentID]; exists {
-		return fmt.Errorf("agent %s is already subscribed", agentID)
+	if _, exists := s.subscribers[agentID]; exists {
+		return fmt.Errorf("agent %s is %w", agentID, ErrAlreadySubscribed)
 	}
 
-	b.subscribers[agentID] = ch
+	s.subscribers[agentID] = ch
 	return nil
 }
 
 // Unsubscribe removes an agent's subscription
 func (b *SimpleBroker) Unsubscribe(agentID string) error {
-	b.mu.Lock()
-	defer b.mu.Unlock()
+	s := b.shard(agentID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	if _, exists := b.subscribers[agentID]; !exists {
+	if _, exists := s.subscribers[agentID]; !exists {
 		return fmt.Errorf("agent %s is not subscribed", agentID)
 	}
 
-	delete(b.subscribers, agentID)
+	delete(s.subscribers, agentID)
+	b.leaveAllGroups(agentID)
 	return nil
 }
 
+// JoinGroup adds an agent to group, so messages addressed to GroupAddress(group) reach it.
+// Agents don't have to be subscribed to join a group, but only subscribers receive messages.
+func (b *SimpleBroker) JoinGroup(agentID string, group string) error {
+	if group == "" {
+		return fmt.Errorf("group name is required")
+	}
+	b.groupsMu.Lock()
+	defer b.groupsMu.Unlock()
+
+	members, ok := b.groups[group]
+	if !ok {
+		members = make(map[string]struct{})
+		b.groups[group] = members
+	}
+	if _, exists := members[agentID]; exists {
+		return fmt.Errorf("agent %s is already in group %s", agentID, group)
+	}
+	members[agentID] = struct{}{}
+	return nil
+}
+
+// LeaveGroup removes an agent from group
+func (b *SimpleBroker) LeaveGroup(agentID string, group string) error {
+	b.groupsMu.Lock()
+	defer b.groupsMu.Unlock()
+
+	if _, exists := b.groups[group][agentID]; !exists {
+		return fmt.Errorf("agent %s is not in group %s", agentID, group)
+	}
+	delete(b.groups[group], agentID)
+	if len(b.groups[group]) == 0 {
+		delete(b.groups, group)
+	}
+	return nil
+}
+
+// leaveAllGroups removes an agent from every group it is in
+func (b *SimpleBroker) leaveAllGroups(agentID string) {
+	b.groupsMu.Lock()
+	defer b.groupsMu.Unlock()
+	for group, members := range b.groups {
+		delete(members, agentID)
+		if len(members) == 0 {
+			delete(b.groups, group)
+		}
+	}
+}
+
+// SubscriberCount returns the number of subscribed agents
+func (b *SimpleBroker) SubscriberCount() int {
+	count := 0
+	for _, s := range b.shards {
+		s.mu.RLock()
+		count += len(s.subscribers)
+		s.mu.RUnlock()
+	}
+	return count
+}
+
 func (b *SimpleBroker) Reset() {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	b.subscribers = make(map[string]chan<- Message)
+	for _, s := range b.shards {
+		s.mu.Lock()
+		s.subscribers = make(map[string]chan<- Message)
+		s.mu.Unlock()
+	}
+	b.groupsMu.Lock()
+	b.groups = make(map[string]map[string]struct{})
+	b.groupsMu.Unlock()
+	b.deadLettersMu.Lock()
+	b.deadLetters = nil
+	b.deadLettersMu.Unlock()
+	b.stats.reset()
 }
@@ -1,6 +1,12 @@
 package messaging
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -119,8 +125,8 @@ func TestBroker(t *testing.T) {
 		}
 
 		// Test duplicate subscription
-		if err := broker.Subscribe("agent1", ch); err == nil {
-			t.Error("Expected error for duplicate subscription, got nil")
+		if err := broker.Subscribe("agent1", ch); !errors.Is(err, ErrAlreadySubscribed) {
+			t.Errorf("Expected ErrAlreadySubscribed for duplicate subscription, got %v", err)
 		}
 
 		// Test unsubscribe
@@ -164,3 +170,450 @@ func TestBroker(t *testing.T) {
 		}
 	})
 }
+
+func TestShardedBroker(t *testing.T) {
+	t.Run("test concurrent publishers and subscribers", func(t *testing.T) {
+		broker := NewBroker(WithShards(8))
+		const agents = 64
+		channels := make([]chan Message, agents)
+		for i := range channels {
+			channels[i] = make(chan Message, agents*4)
+		}
+
+		// Half the agents subscribe up front, the other half while messages are being published
+		for i := 0; i < agents/2; i++ {
+			if err := broker.Subscribe(fmt.Sprintf("agent%d", i), channels[i]); err != nil {
+				t.Fatalf("Failed to subscribe agent%d: %v", i, err)
+			}
+		}
+
+		var wg sync.WaitGroup
+		for i := agents / 2; i < agents; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if err := broker.Subscribe(fmt.Sprintf("agent%d", i), channels[i]); err != nil {
+					t.Errorf("Failed to subscribe agent%d: %v", i, err)
+				}
+			}(i)
+		}
+		for i := 0; i < agents/2; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				from := fmt.Sprintf("agent%d", i)
+				to := fmt.Sprintf("agent%d", (i+1)%(agents/2))
+				if err := broker.Publish(Message{From: from, To: []string{to}, Content: "direct"}); err != nil {
+					t.Errorf("Failed to publish from %s: %v", from, err)
+				}
+				if err := broker.Publish(Message{From: from, Content: "broadcast"}); err != nil {
+					t.Errorf("Failed to broadcast from %s: %v", from, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		// Every early subscriber gets one direct message and a broadcast from every other early subscriber
+		for i := 0; i < agents/2; i++ {
+			if got, want := len(channels[i]), agents/2; got != want {
+				t.Errorf("agent%d received %d messages, want %d", i, got, want)
+			}
+		}
+
+		// A broadcast after everyone subscribed reaches all shards
+		if err := broker.Publish(Message{From: "agent0", Content: "final"}); err != nil {
+			t.Fatalf("Failed to broadcast: %v", err)
+		}
+		for i := agents / 2; i < agents; i++ {
+			if len(channels[i]) == 0 {
+				t.Errorf("agent%d did not receive the final broadcast", i)
+			}
+		}
+	})
+}
+
+// subscribeDrained subscribes n agents with the given buffer size and drains their channels
+// in the background so publishes don't fail on full channels. It returns the agent IDs.
+func subscribeDrained(b *testing.B, broker *SimpleBroker, n, buffer int) []string {
+	b.Helper()
+	ids := make([]string, n)
+	done := make(chan struct{})
+	for i := range ids {
+		ids[i] = fmt.Sprintf("agent%d", i)
+		ch := make(chan Message, buffer)
+		if err := broker.Subscribe(ids[i], ch); err != nil {
+			b.Fatalf("Failed to subscribe %s: %v", ids[i], err)
+		}
+		go func() {
+			for {
+				select {
+				case <-ch:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	b.Cleanup(func() {
+		close(done)
+		broker.Reset()
+	})
+	return ids
+}
+
+func BenchmarkBrokerBroadcast(b *testing.B) {
+	for _, subscribers := range []int{10, 100, 1000} {
+		for _, buffer := range []int{1, 64} {
+			b.Run(fmt.Sprintf("subscribers=%d/buffer=%d", subscribers, buffer), func(b *testing.B) {
+				broker := NewBroker()
+				ids := subscribeDrained(b, broker, subscribers, buffer)
+				msg := Message{From: ids[0], Content: "hello", Timestamp: time.Now()}
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					// Full channels are expected with small buffers and only end the broadcast early
+					broker.Publish(msg)
+				}
+				b.ReportMetric(float64(subscribers-1), "recipients/op")
+			})
+		}
+	}
+}
+
+func BenchmarkBrokerDirect(b *testing.B) {
+	for _, subscribers := range []int{10, 100, 1000} {
+		for _, buffer := range []int{1, 64} {
+			b.Run(fmt.Sprintf("subscribers=%d/buffer=%d", subscribers, buffer), func(b *testing.B) {
+				broker := NewBroker()
+				ids := subscribeDrained(b, broker, subscribers, buffer)
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					broker.Publish(Message{
+						From: ids[i%subscribers],
+						To:   []string{ids[(i+1)%subscribers]},
+					})
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkBrokerPublishSubscribe mixes direct publishes with agents subscribing and
+// unsubscribing from parallel goroutines. shards=1 is equivalent to a single-lock broker.
+func BenchmarkBrokerPublishSubscribe(b *testing.B) {
+	for _, shards := range []int{1, defaultShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			broker := NewBroker(WithShards(shards))
+			ids := subscribeDrained(b, broker, 100, 64)
+			var next atomic.Int64
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				worker := next.Add(1)
+				i := 0
+				for pb.Next() {
+					i++
+					if i%10 == 0 {
+						id := fmt.Sprintf("churn%d_%d", worker, i)
+						broker.Subscribe(id, make(chan Message, 1))
+						broker.Unsubscribe(id)
+						continue
+					}
+					broker.Publish(Message{
+						From: ids[i%len(ids)],
+						To:   []string{ids[(i+int(worker))%len(ids)]},
+					})
+				}
+			})
+		})
+	}
+}
+
+func TestOrderedDelivery(t *testing.T) {
+	// Every subscriber's channel is full, so each delivery is dead-lettered in the order it was
+	// attempted
+	deliveryOrder := func(t *testing.T, opt BrokerOption) []string {
+		broker := NewBroker(opt)
+		for i := 0; i < 6; i++ {
+			id := fmt.Sprintf("agent%d", i)
+			if err := broker.Subscribe(id, make(chan Message)); err != nil {
+				t.Fatalf("Failed to subscribe %s: %v", id, err)
+			}
+		}
+
+		if err := broker.Publish(Message{From: "host", Content: "your turn"}); err == nil {
+			t.Fatal("Expected error when broadcasting to full channels, got nil")
+		}
+		var order []string
+		for _, letter := range broker.DeadLetters() {
+			order = append(order, letter.Recipient)
+		}
+		return order
+	}
+
+	t.Run("test broadcasts are delivered in agent ID order", func(t *testing.T) {
+		// Repeat so that an unordered broadcast can't pass by chance
+		for i := 0; i < 20; i++ {
+			order := deliveryOrder(t, WithOrderedDelivery())
+			want := []string{"agent0", "agent1", "agent2", "agent3", "agent4", "agent5"}
+			if !slices.Equal(order, want) {
+				t.Fatalf("delivery order = %v, want %v", order, want)
+			}
+		}
+	})
+
+	t.Run("test broadcasts are delivered in priority order", func(t *testing.T) {
+		// agent5 has the highest priority, agent0 the lowest
+		priority := func(id string) int {
+			var n int
+			fmt.Sscanf(id, "agent%d", &n)
+			return n
+		}
+		for i := 0; i < 20; i++ {
+			order := deliveryOrder(t, WithDeliveryPriority(priority))
+			want := []string{"agent5", "agent4", "agent3", "agent2", "agent1", "agent0"}
+			if !slices.Equal(order, want) {
+				t.Fatalf("delivery order = %v, want %v", order, want)
+			}
+		}
+	})
+}
+
+func TestBlockingDelivery(t *testing.T) {
+	// newFullBroker returns a blocking broker whose only subscriber, agent1, has a full channel
+	newFullBroker := func(t *testing.T, timeout time.Duration) (*SimpleBroker, chan Message) {
+		t.Helper()
+		broker := NewBroker(WithBlockingDelivery(timeout))
+		ch := make(chan Message, 1)
+		if err := broker.Subscribe("agent1", ch); err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+		if err := broker.Publish(Message{From: "agent2", To: []string{"agent1"}, Content: "Message 1"}); err != nil {
+			t.Fatalf("Failed to publish first message: %v", err)
+		}
+		return broker, ch
+	}
+
+	t.Run("test send waits for space in a full channel", func(t *testing.T) {
+		broker, ch := newFullBroker(t, time.Second)
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			<-ch
+		}()
+
+		// A broadcast reaches the subscriber once it has drained its channel
+		if err := broker.Publish(Message{From: "agent2", Content: "Message 2"}); err != nil {
+			t.Fatalf("Expected the publish to wait for space, got %v", err)
+		}
+		if msg := <-ch; msg.Content != "Message 2" {
+			t.Errorf("received %v, want Message 2", msg.Content)
+		}
+	})
+
+	t.Run("test send gives up after the timeout", func(t *testing.T) {
+		broker, _ := newFullBroker(t, 20*time.Millisecond)
+		start := time.Now()
+		if err := broker.Publish(Message{From: "agent2", To: []string{"agent1"}, Content: "Message 2"}); err == nil {
+			t.Error("Expected an error once the timeout passed, got nil")
+		}
+		if waited := time.Since(start); waited < 20*time.Millisecond {
+			t.Errorf("gave up after %v, want at least the 20ms timeout", waited)
+		}
+	})
+
+	t.Run("test send stops waiting when the context is done", func(t *testing.T) {
+		broker, _ := newFullBroker(t, time.Minute)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		err := broker.PublishContext(ctx, Message{From: "agent2", To: []string{"agent1"}, Content: "Message 2"})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("err = %v, want the context's deadline", err)
+		}
+	})
+}
+
+func TestGroups(t *testing.T) {
+	// subscribe subscribes each agent to a new channel and returns the channels by agent ID
+	subscribe := func(t *testing.T, broker *SimpleBroker, ids ...string) map[string]chan Message {
+		t.Helper()
+		channels := make(map[string]chan Message, len(ids))
+		for _, id := range ids {
+			channels[id] = make(chan Message, 10)
+			if err := broker.Subscribe(id, channels[id]); err != nil {
+				t.Fatalf("Failed to subscribe %s: %v", id, err)
+			}
+		}
+		return channels
+	}
+
+	t.Run("test group messages reach current members except the sender", func(t *testing.T) {
+		broker := NewBroker()
+		channels := subscribe(t, broker, "red1", "red2", "blue1")
+		for _, id := range []string{"red1", "red2"} {
+			if err := broker.JoinGroup(id, "team-red"); err != nil {
+				t.Fatalf("Failed to join group: %v", err)
+			}
+		}
+
+		if err := broker.Publish(Message{From: "red1", To: []string{GroupAddress("team-red")}, Content: "attack"}); err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+		for id, want := range map[string]int{"red1": 0, "red2": 1, "blue1": 0} {
+			if got := len(channels[id]); got != want {
+				t.Errorf("%s received %d messages, want %d", id, got, want)
+			}
+		}
+
+		// Membership is read when the message is published
+		if err := broker.LeaveGroup("red2", "team-red"); err != nil {
+			t.Fatalf("Failed to leave group: %v", err)
+		}
+		if err := broker.JoinGroup("blue1", "team-red"); err != nil {
+			t.Fatalf("Failed to join group: %v", err)
+		}
+		if err := broker.Publish(Message{From: "red1", To: []string{"@team-red"}, Content: "retreat"}); err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+		if len(channels["red2"]) != 1 || len(channels["blue1"]) != 1 {
+			t.Errorf("got red2 %d and blue1 %d messages, want 1 each", len(channels["red2"]), len(channels["blue1"]))
+		}
+	})
+
+	t.Run("test agents addressed directly and through a group receive one copy", func(t *testing.T) {
+		broker := NewBroker()
+		channels := subscribe(t, broker, "a", "b")
+		if err := broker.JoinGroup("b", "g"); err != nil {
+			t.Fatalf("Failed to join group: %v", err)
+		}
+		if err := broker.Publish(Message{From: "a", To: []string{"b", "@g"}, Content: "once"}); err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+		if got := len(channels["b"]); got != 1 {
+			t.Errorf("b received %d messages, want 1", got)
+		}
+	})
+
+	t.Run("test an empty group is not a broadcast", func(t *testing.T) {
+		broker := NewBroker()
+		channels := subscribe(t, broker, "a", "b")
+		if err := broker.Publish(Message{From: "a", To: []string{"@nobody"}, Content: "hello?"}); err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+		if got := len(channels["b"]); got != 0 {
+			t.Errorf("b received %d messages, want 0", got)
+		}
+	})
+
+	t.Run("test membership errors and unsubscribing leaves groups", func(t *testing.T) {
+		broker := NewBroker()
+		subscribe(t, broker, "a")
+		if err := broker.JoinGroup("a", ""); err == nil {
+			t.Error("Expected an error joining an unnamed group")
+		}
+		if err := broker.JoinGroup("a", "g"); err != nil {
+			t.Fatalf("Failed to join group: %v", err)
+		}
+		if err := broker.JoinGroup("a", "g"); err == nil {
+			t.Error("Expected an error joining a group twice")
+		}
+		if err := broker.Unsubscribe("a"); err != nil {
+			t.Fatalf("Failed to unsubscribe: %v", err)
+		}
+		if err := broker.LeaveGroup("a", "g"); err == nil {
+			t.Error("Expected unsubscribing to have removed the agent from its groups")
+		}
+	})
+}
+
+func TestDeadLetters(t *testing.T) {
+	t.Run("test undeliverable messages are kept with their reason", func(t *testing.T) {
+		broker := NewBroker()
+		if err := broker.Subscribe("full", make(chan Message)); err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+		delivered := make(chan Message, 1)
+		if err := broker.Subscribe("ok", delivered); err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+
+		if err := broker.Publish(Message{From: "a", To: []string{"ghost", "ok"}, Content: "one"}); err != nil {
+			t.Fatalf("Expected unknown recipients to be skipped, got %v", err)
+		}
+		if err := broker.Publish(Message{From: "a", To: []string{"full"}, Content: "two"}); err == nil {
+			t.Error("Expected an error publishing to a full channel")
+		}
+
+		want := []DeadLetter{
+			{Message: Message{From: "a", To: []string{"ghost", "ok"}, Content: "one"}, Recipient: "ghost", Reason: UnknownRecipient},
+			{Message: Message{From: "a", To: []string{"full"}, Content: "two"}, Recipient: "full", Reason: ChannelFull},
+		}
+		got := broker.DeadLetters()
+		if len(got) != len(want) {
+			t.Fatalf("got %d dead letters, want %d: %+v", len(got), len(want), got)
+		}
+		for i := range want {
+			if got[i].Recipient != want[i].Recipient || got[i].Reason != want[i].Reason || got[i].Message.Content != want[i].Message.Content {
+				t.Errorf("dead letter %d = %+v, want %+v", i, got[i], want[i])
+			}
+		}
+		if len(delivered) != 1 {
+			t.Error("Expected the subscribed recipient to still get the message")
+		}
+	})
+
+	t.Run("test broadcast and cancelled deliveries are dead-lettered", func(t *testing.T) {
+		broker := NewBroker(WithBlockingDelivery(time.Minute))
+		if err := broker.Subscribe("full", make(chan Message)); err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := broker.PublishContext(ctx, Message{From: "a", Content: "hello"}); err == nil {
+			t.Error("Expected an error once the context was cancelled")
+		}
+		if got := broker.DeadLetters(); len(got) != 1 || got[0].Recipient != "full" || got[0].Reason != DeliveryCancelled {
+			t.Errorf("dead letters = %+v, want the cancelled delivery to full", got)
+		}
+	})
+
+	t.Run("test a failed delivery doesn't stop delivery to the other recipients", func(t *testing.T) {
+		for _, opt := range []BrokerOption{WithShards(1), WithOrderedDelivery()} {
+			broker := NewBroker(opt)
+			healthy := make(chan Message, 2)
+			if err := broker.Subscribe("a_full", make(chan Message)); err != nil {
+				t.Fatalf("Failed to subscribe: %v", err)
+			}
+			if err := broker.Subscribe("b_healthy", healthy); err != nil {
+				t.Fatalf("Failed to subscribe: %v", err)
+			}
+
+			if err := broker.Publish(Message{From: "host", To: []string{"a_full", "b_healthy"}, Content: "direct"}); err == nil {
+				t.Error("Expected an error for the full recipient of a direct message")
+			}
+			err := broker.Publish(Message{From: "host", Content: "broadcast"})
+			var de *deliveryError
+			if !errors.As(err, &de) || de.recipientID != "a_full" {
+				t.Errorf("broadcast err = %v, want the full recipient's delivery error", err)
+			}
+
+			if len(healthy) != 2 {
+				t.Errorf("healthy recipient got %d messages, want the direct message and the broadcast", len(healthy))
+			}
+			if got := broker.DeadLetters(); len(got) != 2 || got[0].Recipient != "a_full" || got[1].Recipient != "a_full" {
+				t.Errorf("dead letters = %+v, want both messages to the full recipient", got)
+			}
+		}
+	})
+
+	t.Run("test only the most recent dead letters are kept", func(t *testing.T) {
+		broker := NewBroker()
+		for i := range maxDeadLetters + 5 {
+			broker.Publish(Message{From: "a", To: []string{"ghost"}, Content: i})
+		}
+		got := broker.DeadLetters()
+		if len(got) != maxDeadLetters || got[0].Message.Content != 5 {
+			t.Errorf("got %d dead letters starting at %v, want %d starting at 5", len(got), got[0].Message.Content, maxDeadLetters)
+		}
+	})
+}
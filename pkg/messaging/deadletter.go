@@ -0,0 +1,66 @@
+package messaging
+
+import (
+	"errors"
+	"slices"
+)
+
+// DeadLetterReason is why a message couldn't be delivered to a recipient
+type DeadLetterReason string
+
+const (
+	UnknownRecipient  DeadLetterReason = "unknown recipient"  // the recipient isn't subscribed
+	ChannelFull       DeadLetterReason = "channel full"       // the recipient's channel had no space
+	DeliveryCancelled DeadLetterReason = "delivery cancelled" // the publisher's context ended while waiting for space
+)
+
+// DeadLetter is a message that couldn't be delivered to one of its recipients
+type DeadLetter struct {
+	Message   Message
+	Recipient string
+	Reason    DeadLetterReason
+}
+
+// maxDeadLetters is how many dead letters a broker keeps; older ones are discarded first
+const maxDeadLetters = 1000
+
+// deliveryError is returned when a message couldn't be delivered to a subscriber
+type deliveryError struct {
+	recipientID string
+	reason      DeadLetterReason
+	err         error
+}
+
+func (e *deliveryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *deliveryError) Unwrap() error {
+	return e.err
+}
+
+// DeadLetters returns the most recent messages that couldn't be delivered, oldest first
+func (b *SimpleBroker) DeadLetters() []DeadLetter {
+	b.deadLettersMu.Lock()
+	defer b.deadLettersMu.Unlock()
+	return slices.Clone(b.deadLetters)
+}
+
+// deadLetter records that msg couldn't be delivered to recipientID
+func (b *SimpleBroker) deadLetter(msg Message, recipientID string, reason DeadLetterReason) {
+	b.deadLettersMu.Lock()
+	defer b.deadLettersMu.Unlock()
+	if len(b.deadLetters) >= maxDeadLetters {
+		b.deadLetters = slices.Delete(b.deadLetters, 0, len(b.deadLetters)-maxDeadLetters+1)
+	}
+	b.deadLetters = append(b.deadLetters, DeadLetter{Message: msg, Recipient: recipientID, Reason: reason})
+}
+
+// failed records a dead letter if err is a failed delivery of msg, and returns err
+func (b *SimpleBroker) failed(msg Message, err error) error {
+	var de *deliveryError
+	if errors.As(err, &de) {
+		b.deadLetter(msg, de.recipientID, de.reason)
+	}
+	return err
+}
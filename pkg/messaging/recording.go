@@ -0,0 +1,88 @@
+package messaging
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MessageRecord is a published message as written to a transcript
+type MessageRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	From      string    `json:"from"`
+	To        []string  `json:"to,omitempty"` // empty for a broadcast
+	Content   any       `json:"content"`
+	// CorrelationID and ReplyTo are set for requests made with Ask and their replies
+	CorrelationID string `json:"correlation_id,omitempty"`
+	ReplyTo       string `json:"reply_to,omitempty"`
+}
+
+// RecordingBroker wraps a Broker and keeps every message published through it, so a conversation
+// can be reconstructed after a run. Delivery is left to the wrapped broker.
+type RecordingBroker struct {
+	Broker
+	history []Message
+	enc     *json.Encoder // writes each message to the transcript; nil if there isn't one
+	mu      sync.Mutex
+}
+
+// RecordingOption configures a RecordingBroker
+type RecordingOption func(*RecordingBroker)
+
+// WithTranscript also writes every published message to w as a JSON line
+func WithTranscript(w io.Writer) RecordingOption {
+	return func(b *RecordingBroker) {
+		b.enc = json.NewEncoder(w)
+	}
+}
+
+// NewRecordingBroker returns a broker that records every message published through broker
+func NewRecordingBroker(broker Broker, opts ...RecordingOption) *RecordingBroker {
+	b := &RecordingBroker{Broker: broker}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Publish records msg and passes it to the wrapped broker. Messages are recorded whether or not
+// they could be delivered; messages without a timestamp are recorded with the time they were published.
+func (b *RecordingBroker) Publish(msg Message) error {
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+	b.record(msg)
+	return b.Broker.Publish(msg)
+}
+
+// record appends msg to the history and the transcript
+func (b *RecordingBroker) record(msg Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	msg.To = slices.Clone(msg.To)
+	b.history = append(b.history, msg)
+	if b.enc == nil {
+		return
+	}
+	record := MessageRecord{
+		Timestamp:     msg.Timestamp,
+		From:          msg.From,
+		To:            msg.To,
+		Content:       msg.Content,
+		CorrelationID: msg.CorrelationID,
+		ReplyTo:       msg.ReplyTo,
+	}
+	if err := b.enc.Encode(record); err != nil {
+		log.Printf("Warning: Failed to record message from %s: %v", msg.From, err)
+	}
+}
+
+// History returns every message published so far, oldest first
+func (b *RecordingBroker) History() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.history)
+}
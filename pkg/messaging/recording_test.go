@@ -0,0 +1,69 @@
+package messaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestRecordingBroker(t *testing.T) {
+	t.Run("test published messages are recorded and delivered", func(t *testing.T) {
+		var transcript bytes.Buffer
+		broker := NewRecordingBroker(NewBroker(), WithTranscript(&transcript))
+		ch1, ch2 := make(chan Message, 1), make(chan Message, 1)
+		if err := broker.Subscribe("agent1", ch1); err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+		if err := broker.Subscribe("agent2", ch2); err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+
+		if err := broker.Publish(Message{From: "agent1", Content: "hello"}); err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+		if err := broker.Publish(Message{From: "agent2", To: []string{"agent1"}, Content: "hi back"}); err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+		if msg := <-ch2; msg.Content != "hello" {
+			t.Errorf("agent2 received %v, want hello", msg.Content)
+		}
+		if msg := <-ch1; msg.Content != "hi back" {
+			t.Errorf("agent1 received %v, want hi back", msg.Content)
+		}
+
+		history := broker.History()
+		if len(history) != 2 || history[0].Content != "hello" || history[1].To[0] != "agent1" {
+			t.Fatalf("history = %+v, want both messages in order", history)
+		}
+		if history[0].Timestamp.IsZero() {
+			t.Error("Expected an unstamped message to be recorded with the time it was published")
+		}
+
+		var records []MessageRecord
+		scanner := bufio.NewScanner(&transcript)
+		for scanner.Scan() {
+			var r MessageRecord
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				t.Fatalf("Failed to parse transcript line %q: %v", scanner.Text(), err)
+			}
+			records = append(records, r)
+		}
+		if len(records) != 2 || records[0].From != "agent1" || records[1].Content != "hi back" {
+			t.Errorf("transcript = %+v, want both messages in order", records)
+		}
+	})
+
+	t.Run("test undeliverable messages are still recorded", func(t *testing.T) {
+		broker := NewRecordingBroker(NewBroker())
+		if err := broker.Subscribe("agent1", make(chan Message)); err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+		if err := broker.Publish(Message{From: "agent2", To: []string{"agent1"}, Content: "dropped"}); err == nil {
+			t.Error("Expected the unbuffered channel to reject the message")
+		}
+		if history := broker.History(); len(history) != 1 {
+			t.Errorf("got %d recorded messages, want 1", len(history))
+		}
+	})
+}
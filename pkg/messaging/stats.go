@@ -0,0 +1,69 @@
+package messaging
+
+import (
+	"errors"
+	"maps"
+	"sync"
+	"sync/atomic"
+)
+
+// BrokerStats counts the messages that went through a broker
+type BrokerStats struct {
+	Published       uint64            // messages published, each counted once however many recipients it had
+	Delivered       uint64            // messages delivered to a recipient's channel
+	Dropped         uint64            // deliveries given up on because the recipient's channel was full
+	AgentDeliveries map[string]uint64 // maps agent ID to the messages delivered to it
+}
+
+// brokerStats is the broker's live counters
+type brokerStats struct {
+	published atomic.Uint64
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+	agents    map[string]uint64
+	mu        sync.Mutex // guards agents
+}
+
+// record counts a delivery to recipientID that failed with err, or succeeded if err is nil
+func (s *brokerStats) record(recipientID string, err error) {
+	if err != nil {
+		var de *deliveryError
+		if errors.As(err, &de) && de.reason == ChannelFull {
+			s.dropped.Add(1)
+		}
+		return
+	}
+	s.delivered.Add(1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.agents == nil {
+		s.agents = make(map[string]uint64)
+	}
+	s.agents[recipientID]++
+}
+
+// reset sets every counter back to zero
+func (s *brokerStats) reset() {
+	s.published.Store(0)
+	s.delivered.Store(0)
+	s.dropped.Store(0)
+	s.mu.Lock()
+	s.agents = nil
+	s.mu.Unlock()
+}
+
+// Stats returns the broker's message counts since it was created or last reset
+func (b *SimpleBroker) Stats() BrokerStats {
+	b.stats.mu.Lock()
+	agents := maps.Clone(b.stats.agents)
+	b.stats.mu.Unlock()
+	if agents == nil {
+		agents = make(map[string]uint64)
+	}
+	return BrokerStats{
+		Published:       b.stats.published.Load(),
+		Delivered:       b.stats.delivered.Load(),
+		Dropped:         b.stats.dropped.Load(),
+		AgentDeliveries: agents,
+	}
+}
@@ -0,0 +1,65 @@
+package messaging
+
+import (
+	"testing"
+)
+
+func TestBrokerStats(t *testing.T) {
+	t.Run("test publishes, deliveries and drops are counted", func(t *testing.T) {
+		broker := NewBroker()
+		a := make(chan Message, 10)
+		b := make(chan Message, 10)
+		if err := broker.Subscribe("a", a); err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+		if err := broker.Subscribe("b", b); err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+		if err := broker.Subscribe("full", make(chan Message)); err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+
+		for _, msg := range []Message{
+			{From: "a", To: []string{"b"}},
+			{From: "b", To: []string{"a", "b"}},
+			{From: "a", To: []string{"ghost"}},
+		} {
+			if err := broker.Publish(msg); err != nil {
+				t.Fatalf("Failed to publish: %v", err)
+			}
+		}
+		if err := broker.Publish(Message{From: "a", To: []string{"full"}}); err == nil {
+			t.Error("Expected an error publishing to a full channel")
+		}
+
+		stats := broker.Stats()
+		if stats.Published != 4 || stats.Delivered != 3 || stats.Dropped != 1 {
+			t.Errorf("stats = %+v, want 4 published, 3 delivered and 1 dropped", stats)
+		}
+		if stats.AgentDeliveries["a"] != 1 || stats.AgentDeliveries["b"] != 2 || len(stats.AgentDeliveries) != 2 {
+			t.Errorf("agent deliveries = %v, want a:1 b:2", stats.AgentDeliveries)
+		}
+	})
+
+	t.Run("test stats are a snapshot and reset clears them", func(t *testing.T) {
+		broker := NewBroker()
+		if err := broker.Subscribe("a", make(chan Message, 1)); err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+		if err := broker.Publish(Message{From: "b", To: []string{"a"}}); err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+
+		stats := broker.Stats()
+		stats.AgentDeliveries["a"] = 100
+		if got := broker.Stats().AgentDeliveries["a"]; got != 1 {
+			t.Errorf("agent deliveries for a = %d after changing a snapshot, want 1", got)
+		}
+
+		broker.Reset()
+		stats = broker.Stats()
+		if stats.Published != 0 || stats.Delivered != 0 || stats.Dropped != 0 || len(stats.AgentDeliveries) != 0 {
+			t.Errorf("stats after reset = %+v, want all zero", stats)
+		}
+	})
+}
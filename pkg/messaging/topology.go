@@ -0,0 +1,82 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNotNeighbor is returned when a message is addressed to an agent that isn't one of the
+// sender's neighbors
+var ErrNotNeighbor = errors.New("not a neighbor")
+
+// Topology is who each agent is connected to
+type Topology interface {
+	// Neighbors returns the IDs of the agents connected to the agent with the given ID
+	Neighbors(agentID string) []string
+}
+
+// TopologyBroker wraps a Broker so agents can only reach their neighbors in a topology. A broadcast
+// goes to the sender's neighbors alone, and a message addressed to anyone else is rejected.
+// Groups can't be checked against the topology, so messages addressed to a group are rejected too.
+// A reply to an Ask goes to the asking agent's temporary inbox and is checked as if it were
+// addressed to the asking agent.
+type TopologyBroker struct {
+	Broker
+	topology Topology
+}
+
+// contextPublisher is implemented by brokers whose sends can be cancelled, like SimpleBroker
+type contextPublisher interface {
+	PublishContext(ctx context.Context, msg Message) error
+}
+
+// NewTopologyBroker returns a broker that delivers messages through broker along topology's edges
+func NewTopologyBroker(broker Broker, topology Topology) *TopologyBroker {
+	return &TopologyBroker{Broker: broker, topology: topology}
+}
+
+// Publish passes msg to the wrapped broker addressed to the sender's neighbors if it is a
+// broadcast. A broadcast from an agent without neighbors isn't delivered to anyone.
+func (b *TopologyBroker) Publish(msg Message) error {
+	return b.PublishContext(context.Background(), msg)
+}
+
+// PublishContext checks msg against the topology like Publish and passes it to the wrapped
+// broker's PublishContext, if it has one, so its sends stop waiting when ctx is done
+func (b *TopologyBroker) PublishContext(ctx context.Context, msg Message) error {
+	neighbors := b.topology.Neighbors(msg.From)
+	if len(msg.To) == 0 {
+		if len(neighbors) == 0 {
+			return nil
+		}
+		msg.To = neighbors
+		return b.publish(ctx, msg)
+	}
+
+	isNeighbor := make(map[string]bool, len(neighbors))
+	for _, id := range neighbors {
+		isNeighbor[id] = true
+	}
+	for _, to := range msg.To {
+		if isGroupAddress(to) {
+			return fmt.Errorf("can't send to group %s: groups aren't part of the topology", to)
+		}
+		agentID := to
+		if owner, ok := askInboxOwner(to); ok {
+			agentID = owner
+		}
+		if !isNeighbor[agentID] {
+			return fmt.Errorf("%w: %s can't send to %s", ErrNotNeighbor, msg.From, to)
+		}
+	}
+	return b.publish(ctx, msg)
+}
+
+// publish passes msg to the wrapped broker
+func (b *TopologyBroker) publish(ctx context.Context, msg Message) error {
+	if publisher, ok := b.Broker.(contextPublisher); ok {
+		return publisher.PublishContext(ctx, msg)
+	}
+	return b.Broker.Publish(msg)
+}
@@ -0,0 +1,100 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// mapTopology maps each agent ID to its neighbors
+type mapTopology map[string][]string
+
+func (m mapTopology) Neighbors(agentID string) []string {
+	return m[agentID]
+}
+
+func TestTopologyBroker(t *testing.T) {
+	t.Run("test messages only travel along edges", func(t *testing.T) {
+		broker := NewTopologyBroker(NewBroker(), mapTopology{
+			"agent1": {"agent2"},
+			"agent2": {"agent1", "agent3"},
+			"agent3": {"agent2"},
+		})
+		chans := make(map[string]chan Message)
+		for _, id := range []string{"agent1", "agent2", "agent3"} {
+			chans[id] = make(chan Message, 10)
+			if err := broker.Subscribe(id, chans[id]); err != nil {
+				t.Fatalf("Failed to subscribe %s: %v", id, err)
+			}
+		}
+
+		if err := broker.Publish(Message{From: "agent1", Content: "hello"}); err != nil {
+			t.Fatalf("Failed to broadcast: %v", err)
+		}
+		if len(chans["agent2"]) != 1 || len(chans["agent3"]) != 0 {
+			t.Errorf("agent2 got %d messages and agent3 got %d, want the broadcast to reach agent2 alone",
+				len(chans["agent2"]), len(chans["agent3"]))
+		}
+
+		err := broker.Publish(Message{From: "agent1", To: []string{"agent3"}, Content: "psst"})
+		if !errors.Is(err, ErrNotNeighbor) {
+			t.Errorf("Expected ErrNotNeighbor sending to a non-neighbor, got %v", err)
+		}
+		if err := broker.Publish(Message{From: "agent1", To: []string{GroupAddress("team")}, Content: "hi"}); err == nil {
+			t.Error("Expected an error sending to a group")
+		}
+		if err := broker.Publish(Message{From: "agent3", To: []string{"agent2"}, Content: "hi"}); err != nil {
+			t.Errorf("Failed to send to a neighbor: %v", err)
+		}
+		if len(chans["agent2"]) != 2 || len(chans["agent1"]) != 0 {
+			t.Errorf("agent2 got %d messages and agent1 got %d, want only the allowed messages delivered",
+				len(chans["agent2"]), len(chans["agent1"]))
+		}
+	})
+	t.Run("test replies to an ask reach the neighbor's temporary inbox", func(t *testing.T) {
+		broker := NewTopologyBroker(NewBroker(), mapTopology{
+			"agent1": {"agent2"},
+			"agent2": {"agent1"},
+		})
+		requests := make(chan Message, 1)
+		if err := broker.Subscribe("agent2", requests); err != nil {
+			t.Fatalf("Failed to subscribe agent2: %v", err)
+		}
+		go func() {
+			request := <-requests
+			if err := Reply(broker, request, "agent2", "pong"); err != nil {
+				t.Errorf("Failed to reply: %v", err)
+			}
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		reply, err := Ask(ctx, broker, "agent1", "agent2", "ping")
+		if err != nil {
+			t.Fatalf("Ask failed: %v", err)
+		}
+		if reply.Content != "pong" {
+			t.Errorf("reply = %v, want pong", reply.Content)
+		}
+
+		err = broker.Publish(Message{From: "agent3", To: []string{"agent1" + askInboxInfix + "x"}, Content: "spoof"})
+		if !errors.Is(err, ErrNotNeighbor) {
+			t.Errorf("Expected ErrNotNeighbor sending to a non-neighbor's inbox, got %v", err)
+		}
+	})
+
+	t.Run("test publish context is passed to the wrapped broker", func(t *testing.T) {
+		broker := NewTopologyBroker(NewBroker(WithBlockingDelivery(time.Hour)), mapTopology{
+			"agent1": {"agent2"},
+		})
+		if err := broker.Subscribe("agent2", make(chan Message)); err != nil {
+			t.Fatalf("Failed to subscribe agent2: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := broker.PublishContext(ctx, Message{From: "agent1", Content: "hello"}); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("PublishContext error = %v, want the context's error", err)
+		}
+	})
+}
@@ -1,15 +1,23 @@
 package messaging
 
 import (
+	"errors"
 	"time"
 )
 
+// ErrAlreadySubscribed is returned when subscribing an agent ID that is already subscribed
+var ErrAlreadySubscribed = errors.New("already subscribed")
+
 // Message represents a communication between agents
 type Message struct {
 	From      string    // Agent ID of sender
-	To        []string  // Agent IDs of recipients (empty means broadcast)
+	To        []string  // Agent IDs of recipients, or group addresses like "@team-red" (empty means broadcast)
 	Content   any       // The actual message content
 	Timestamp time.Time // When the message was sent
+	// CorrelationID ties a reply to the request it answers; empty for messages that aren't part of an exchange
+	CorrelationID string
+	// ReplyTo is the recipient replies should be sent to instead of From; empty means From
+	ReplyTo string
 }
 
 // Sender can send messages
@@ -30,4 +38,18 @@ type Broker interface {
 	Subscribe(agentID string, ch chan<- Message) error
 	// Unsubscribe removes an agent's subscription
 	Unsubscribe(agentID string) error
+	// JoinGroup adds an agent to a named group that messages can be addressed to
+	JoinGroup(agentID string, group string) error
+	// LeaveGroup removes an agent from a named group
+	LeaveGroup(agentID string, group string) error
+	// DeadLetters returns messages that couldn't be delivered and why
+	DeadLetters() []DeadLetter
+}
+
+// GroupPrefix marks a recipient in Message.To as a group rather than an agent ID
+const GroupPrefix = "@"
+
+// GroupAddress returns the recipient that addresses a message to every member of group
+func GroupAddress(group string) string {
+	return GroupPrefix + group
 }
@@ -0,0 +1,87 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrCallBudgetExhausted is returned for calls made after a CallBudget has been used up
+var ErrCallBudgetExhausted = errors.New("call budget exhausted")
+
+// CallBudget caps the total number of provider calls shared by every client it wraps
+type CallBudget struct {
+	max  int64
+	used atomic.Int64
+}
+
+// NewCallBudget creates a budget that allows max calls
+func NewCallBudget(max int) *CallBudget {
+	return &CallBudget{max: int64(max)}
+}
+
+// take reserves a call, reporting false if the budget is already used up
+func (b *CallBudget) take() bool {
+	return b.used.Add(1) <= b.max
+}
+
+// Used returns the number of calls made against the budget, not counting rejected ones
+func (b *CallBudget) Used() int {
+	return int(min(b.used.Load(), b.max))
+}
+
+// Remaining returns the number of calls left
+func (b *CallBudget) Remaining() int {
+	return int(max(b.max-b.used.Load(), 0))
+}
+
+// Exhausted reports whether no calls are left
+func (b *CallBudget) Exhausted() bool {
+	return b.used.Load() >= b.max
+}
+
+// Wrap returns a client that counts its calls against the budget and fails them with
+// ErrCallBudgetExhausted once it is used up
+func (b *CallBudget) Wrap(client Client) Client {
+	return &budgetClient{client: client, budget: b}
+}
+
+type budgetClient struct {
+	client Client
+	budget *CallBudget
+}
+
+func (c *budgetClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	completion, err := c.CompleteDetailed(ctx, model, prompt, systemPrompt, history)
+	return completion.Text, err
+}
+
+func (c *budgetClient) CompleteDetailed(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (Completion, error) {
+	if !c.budget.take() {
+		return Completion{}, ErrCallBudgetExhausted
+	}
+	return CompleteDetailed(ctx, c.client, model, prompt, systemPrompt, history)
+}
+
+// CompleteWithTools counts as a single call, however many responses the model needs
+func (c *budgetClient) CompleteWithTools(ctx context.Context, model string, prompt string, systemPrompt string, history []string, tools []Tool) (Completion, error) {
+	if !c.budget.take() {
+		return Completion{}, ErrCallBudgetExhausted
+	}
+	return CompleteWithTools(ctx, c.client, model, prompt, systemPrompt, history, tools)
+}
+
+// CompleteStream counts as a call when the stream starts
+func (c *budgetClient) CompleteStream(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (*Stream, error) {
+	if !c.budget.take() {
+		return nil, ErrCallBudgetExhausted
+	}
+	return CompleteStream(ctx, c.client, model, prompt, systemPrompt, history)
+}
+
+func (c *budgetClient) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if !c.budget.take() {
+		return nil, ErrCallBudgetExhausted
+	}
+	return Embed(ctx, c.client, texts)
+}
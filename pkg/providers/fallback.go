@@ -0,0 +1,178 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/openai/openai-go"
+	"google.golang.org/genai"
+)
+
+// FallbackClient tries each of its providers in order until one succeeds
+type FallbackClient struct {
+	providers []FallbackProvider
+}
+
+// FallbackProvider is a client in a fallback chain and the model ID to send it, since providers
+// don't share model names
+type FallbackProvider struct {
+	Client Client
+	Model  string // empty sends the model the call was made with
+}
+
+// NewFallbackClient returns a client that sends each call to the first of providers and, if it
+// fails with an error another provider could succeed on, to each of the rest in turn. The first
+// success is returned, and the provider that served it is logged if it was a fallback.
+func NewFallbackClient(providers ...FallbackProvider) *FallbackClient {
+	return &FallbackClient{
+		providers: providers,
+	}
+}
+
+// WithFallback returns a FallbackClient that tries primary before the fallbacks, sending each of
+// them the model the call was made with
+func WithFallback(primary Client, fallbacks ...Client) Client {
+	providers := []FallbackProvider{{Client: primary}}
+	for _, fallback := range fallbacks {
+		providers = append(providers, FallbackProvider{Client: fallback})
+	}
+	return NewFallbackClient(providers...)
+}
+
+func (c *FallbackClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	completion, err := c.CompleteDetailed(ctx, model, prompt, systemPrompt, history)
+	return completion.Text, err
+}
+
+func (c *FallbackClient) CompleteDetailed(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (Completion, error) {
+	if len(c.providers) == 0 {
+		return Completion{}, fmt.Errorf("no providers to complete with")
+	}
+	var errs []error
+	for i, p := range c.providers {
+		providerModel := p.Model
+		if providerModel == "" {
+			providerModel = model
+		}
+		completion, err := CompleteDetailed(ctx, p.Client, providerModel, prompt, systemPrompt, history)
+		if err == nil {
+			if i > 0 {
+				log.Printf("Completion served by fallback provider %d of %d (%T, %s)", i+1, len(c.providers), p.Client, providerModel)
+			}
+			return completion, nil
+		}
+		errs = append(errs, fmt.Errorf("provider %d: %w", i+1, err))
+		if !shouldFallback(ctx, err) {
+			break
+		}
+		if i+1 < len(c.providers) {
+			log.Printf("Provider %d failed, falling back to provider %d: %v", i+1, i+2, err)
+		}
+	}
+	return Completion{}, errors.Join(errs...)
+}
+
+// Embed embeds texts with the first provider that can, falling back like CompleteDetailed.
+// Providers that don't support embeddings are skipped.
+func (c *FallbackClient) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	var errs []error
+	for i, p := range c.providers {
+		vectors, err := Embed(ctx, p.Client, texts)
+		if err == nil {
+			return vectors, nil
+		}
+		errs = append(errs, fmt.Errorf("provider %d: %w", i+1, err))
+		if !errors.Is(err, ErrEmbeddingsUnsupported) && !shouldFallback(ctx, err) {
+			break
+		}
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no providers to embed with")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// CompleteWithTools completes with the first provider whose models can call tools, falling back
+// like CompleteDetailed, but only while none of the tool calls has run, so a tool's side effects
+// never happen twice. Providers that don't support tools are skipped.
+func (c *FallbackClient) CompleteWithTools(ctx context.Context, model string, prompt string, systemPrompt string, history []string, tools []Tool) (Completion, error) {
+	tracked, ran := trackToolRuns(tools)
+	var errs []error
+	for i, p := range c.providers {
+		providerModel := p.Model
+		if providerModel == "" {
+			providerModel = model
+		}
+		completion, err := CompleteWithTools(ctx, p.Client, providerModel, prompt, systemPrompt, history, tracked)
+		if err == nil {
+			if i > 0 {
+				log.Printf("Completion served by fallback provider %d of %d (%T, %s)", i+1, len(c.providers), p.Client, providerModel)
+			}
+			return completion, nil
+		}
+		errs = append(errs, fmt.Errorf("provider %d: %w", i+1, err))
+		if ran.Load() || (!errors.Is(err, ErrToolsUnsupported) && !shouldFallback(ctx, err)) {
+			break
+		}
+	}
+	if len(errs) == 0 {
+		return Completion{}, fmt.Errorf("no providers to complete with")
+	}
+	return Completion{}, errors.Join(errs...)
+}
+
+// CompleteStream starts the stream with the first provider that can, falling back like
+// CompleteDetailed. Once a stream has started it isn't retried with another provider, since its
+// deltas have already been passed on.
+func (c *FallbackClient) CompleteStream(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (*Stream, error) {
+	if len(c.providers) == 0 {
+		return nil, fmt.Errorf("no providers to complete with")
+	}
+	var errs []error
+	for i, p := range c.providers {
+		providerModel := p.Model
+		if providerModel == "" {
+			providerModel = model
+		}
+		stream, err := CompleteStream(ctx, p.Client, providerModel, prompt, systemPrompt, history)
+		if err == nil {
+			if i > 0 {
+				log.Printf("Stream served by fallback provider %d of %d (%T, %s)", i+1, len(c.providers), p.Client, providerModel)
+			}
+			return stream, nil
+		}
+		errs = append(errs, fmt.Errorf("provider %d: %w", i+1, err))
+		if !shouldFallback(ctx, err) {
+			break
+		}
+		if i+1 < len(c.providers) {
+			log.Printf("Provider %d failed, falling back to provider %d: %v", i+1, i+2, err)
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// shouldFallback reports whether another provider might succeed where err failed. Outages,
+// rate limits and auth problems are provider-specific; a cancelled call or a malformed
+// request would fail the same way everywhere.
+func shouldFallback(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var openaiErr *openai.Error
+	if errors.As(err, &openaiErr) && openaiErr.StatusCode == http.StatusBadRequest {
+		return false
+	}
+	var geminiErr genai.ClientError
+	if errors.As(err, &geminiErr) && geminiErr.Code == http.StatusBadRequest {
+		return false
+	}
+	var ollamaErr *OllamaError
+	if errors.As(err, &ollamaErr) && ollamaErr.StatusCode == http.StatusBadRequest {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+// stubClient implements Client, recording the order it was called in and the model it was sent
+type stubClient struct {
+	name     string
+	response string
+	err      error
+	calls    *[]string
+	model    string
+}
+
+func (s *stubClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	*s.calls = append(*s.calls, s.name)
+	s.model = model
+	return s.response, s.err
+}
+
+func TestWithFallback(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test fallback response is returned when the primary fails", func(t *testing.T) {
+		var calls []string
+		primary := &stubClient{name: "primary", err: errors.New("service unavailable"), calls: &calls}
+		fallback := &stubClient{name: "fallback", response: "ANSWER: 3", calls: &calls}
+
+		response, err := WithFallback(primary, fallback).Complete(ctx, "gpt-4o-mini", "prompt", "", nil)
+		if err != nil {
+			t.Fatalf("Expected the fallback to succeed, got %v", err)
+		}
+		if response != "ANSWER: 3" {
+			t.Errorf("response = %q, want %q", response, "ANSWER: 3")
+		}
+		if len(calls) != 2 || calls[0] != "primary" || calls[1] != "fallback" {
+			t.Errorf("calls = %v, want [primary fallback]", calls)
+		}
+	})
+
+	t.Run("test cancelled call does not fall back", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		var calls []string
+		primary := &stubClient{name: "primary", err: context.Canceled, calls: &calls}
+		fallback := &stubClient{name: "fallback", response: "ANSWER: 3", calls: &calls}
+
+		_, err := WithFallback(primary, fallback).Complete(ctx, "gpt-4o-mini", "prompt", "", nil)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+		if len(calls) != 1 {
+			t.Errorf("calls = %v, want only the primary", calls)
+		}
+	})
+
+	t.Run("test chain logs the client that served the completion", func(t *testing.T) {
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		t.Cleanup(func() {
+			log.SetOutput(os.Stderr)
+		})
+
+		var calls []string
+		client := NewFallbackClient(
+			FallbackProvider{Client: &stubClient{name: "openai", err: errors.New("service unavailable"), calls: &calls}},
+			FallbackProvider{Client: &stubClient{name: "gemini", err: errors.New("quota exceeded"), calls: &calls}},
+			FallbackProvider{Client: &stubClient{name: "ollama", response: "ANSWER: 2", calls: &calls}},
+		)
+		response, err := client.Complete(ctx, "gpt-4o-mini", "prompt", "", nil)
+		if err != nil {
+			t.Fatalf("Expected the last client to succeed, got %v", err)
+		}
+		if response != "ANSWER: 2" {
+			t.Errorf("response = %q, want %q", response, "ANSWER: 2")
+		}
+		if strings.Join(calls, " ") != "openai gemini ollama" {
+			t.Errorf("calls = %v, want [openai gemini ollama]", calls)
+		}
+		if !strings.Contains(buf.String(), "Completion served by fallback provider 3 of 3") {
+			t.Errorf("Expected the serving provider to be logged, got logs:\n%s", buf.String())
+		}
+
+		buf.Reset()
+		primary := NewFallbackClient(FallbackProvider{Client: &stubClient{name: "openai", response: "ANSWER: 1", calls: &calls}})
+		if _, err := primary.Complete(ctx, "gpt-4o-mini", "prompt", "", nil); err != nil {
+			t.Fatalf("Expected the primary to succeed, got %v", err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("Expected nothing to be logged when the primary succeeds, got logs:\n%s", buf.String())
+		}
+	})
+
+	t.Run("test every failure is reported when the chain is exhausted", func(t *testing.T) {
+		var calls []string
+		primaryErr := errors.New("service unavailable")
+		backupErr := errors.New("quota exceeded")
+		client := NewFallbackClient(
+			FallbackProvider{Client: &stubClient{name: "openai", err: primaryErr, calls: &calls}},
+			FallbackProvider{Client: &stubClient{name: "gemini", err: backupErr, calls: &calls}},
+		)
+		_, err := client.Complete(ctx, "gpt-4o-mini", "prompt", "", nil)
+		if !errors.Is(err, primaryErr) || !errors.Is(err, backupErr) {
+			t.Errorf("err = %v, want both providers' errors", err)
+		}
+	})
+
+	t.Run("test each provider is sent its own model", func(t *testing.T) {
+		var calls []string
+		primary := &stubClient{name: "openai", err: errors.New("service unavailable"), calls: &calls}
+		fallback := &stubClient{name: "gemini", response: "ANSWER: 3", calls: &calls}
+		client := NewFallbackClient(
+			FallbackProvider{Client: primary, Model: "gpt-4o"},
+			FallbackProvider{Client: fallback, Model: "gemini-2.0-flash"},
+		)
+
+		if _, err := client.Complete(ctx, "gpt-4o", "prompt", "", nil); err != nil {
+			t.Fatalf("Expected the fallback to succeed, got %v", err)
+		}
+		if primary.model != "gpt-4o" || fallback.model != "gemini-2.0-flash" {
+			t.Errorf("models = %q and %q, want gpt-4o and gemini-2.0-flash", primary.model, fallback.model)
+		}
+	})
+
+	t.Run("test gemini bad requests do not fall back", func(t *testing.T) {
+		transport := &fakeTransport{status: http.StatusBadRequest, body: `{"error": {"code": 400, "message": "invalid argument"}}`}
+		gemini, err := Gemini(ctx, WithAPIKey("test-key"), WithHTTPClient(&http.Client{Transport: transport}))
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		var calls []string
+		fallback := &stubClient{name: "fallback", response: "ANSWER: 3", calls: &calls}
+
+		_, err = NewFallbackClient(
+			FallbackProvider{Client: gemini, Model: "gemini-2.0-flash"},
+			FallbackProvider{Client: fallback, Model: "gpt-4o"},
+		).Complete(ctx, "gemini-2.0-flash", "prompt", "", nil)
+		if err == nil {
+			t.Fatal("Expected the bad request to fail")
+		}
+		if len(calls) != 0 {
+			t.Errorf("calls = %v, want no fallback after a bad request", calls)
+		}
+	})
+}
@@ -4,12 +4,16 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"google.golang.org/genai"
 )
 
 type GeminiClient struct {
-	client *genai.Client
+	client  *genai.Client
+	limiter *rateLimiter
+	timeout time.Duration
 }
 
 func Gemini(ctx context.Context, opts ...ProviderOption) (*GeminiClient, error) {
@@ -28,24 +32,110 @@ func Gemini(ctx context.Context, opts ...ProviderOption) (*GeminiClient, error)
 		return nil, fmt.Errorf("error retrieving GEMINI_API_KEY")
 	}
 	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  apiKey,
-		Backend: genai.BackendGoogleAI,
+		APIKey:     apiKey,
+		Backend:    genai.BackendGoogleAI,
+		HTTPClient: params.HTTPClient,
 	})
 	if err != nil {
 		return nil, err
 	}
 	return &GeminiClient{
-		client: client,
+		client:  client,
+		limiter: newRateLimiter(params.RateLimit),
+		timeout: params.Timeout,
 	}, nil
 }
 
 func (c *GeminiClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
-	parts := []*genai.Part{
-		{Text: prompt},
+	completion, err := c.CompleteDetailed(ctx, model, prompt, systemPrompt, history)
+	return completion.Text, err
+}
+
+func (c *GeminiClient) CompleteDetailed(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (Completion, error) {
+	if err := c.limiter.wait(ctx); err != nil {
+		return Completion{}, err
+	}
+	sampling := SamplingFromContext(ctx)
+	config := &genai.GenerateContentConfig{
+		Temperature: sampling.Temperature,
+		TopP:        sampling.TopP,
+	}
+	if ResponseFormatFromContext(ctx) == ResponseFormatJSON {
+		config.ResponseMIMEType = "application/json"
+	}
+	var contents []*genai.Content
+	for _, msg := range chatMessages(ctx, prompt, systemPrompt, history) {
+		switch msg.Role {
+		case "system":
+			if msg.Content != "" {
+				config.SystemInstruction = &genai.Content{Parts: []*genai.Part{{Text: msg.Content}}}
+			}
+		case "assistant":
+			contents = appendGeminiTurn(contents, "model", msg.Content)
+		default:
+			contents = appendGeminiTurn(contents, "user", msg.Content)
+		}
 	}
-	result, err := c.client.Models.GenerateContent(ctx, "gemini-2.0-flash-exp", []*genai.Content{{Parts: parts}}, nil)
+
+	callCtx, cancel := timeoutContext(ctx, c.timeout)
+	defer cancel()
+	result, err := c.client.Models.GenerateContent(callCtx, model, contents, config)
 	if err != nil {
-		return "", err
+		return Completion{}, timeoutError(ctx, callCtx, err, c.timeout)
+	}
+	return geminiCompletion(result)
+}
+
+// appendGeminiTurn adds text to contents as a turn by role. Gemini expects user and model turns
+// to alternate, so text from the same role as the last turn is added to it as another part.
+func appendGeminiTurn(contents []*genai.Content, role, text string) []*genai.Content {
+	if n := len(contents); n > 0 && contents[n-1].Role == role {
+		contents[n-1].Parts = append(contents[n-1].Parts, &genai.Part{Text: text})
+		return contents
+	}
+	return append(contents, &genai.Content{Role: role, Parts: []*genai.Part{{Text: text}}})
+}
+
+// geminiFinishReasons maps Gemini finish reasons to the normalized ones. Others are lowercased.
+var geminiFinishReasons = map[genai.FinishReason]string{
+	genai.FinishReasonStop:              FinishStop,
+	genai.FinishReasonMaxTokens:         FinishLength,
+	genai.FinishReasonSafety:            FinishContentFilter,
+	genai.FinishReasonBlocklist:         FinishContentFilter,
+	genai.FinishReasonProhibitedContent: FinishContentFilter,
+}
+
+// geminiCompletion joins the text parts of the first candidate. A blocked prompt is reported
+// as an error rather than as an empty response.
+func geminiCompletion(result *genai.GenerateContentResponse) (Completion, error) {
+	if fb := result.PromptFeedback; fb != nil && fb.BlockReason != "" {
+		if fb.BlockReasonMessage != "" {
+			return Completion{}, fmt.Errorf("gemini blocked the prompt (%s): %s", fb.BlockReason, fb.BlockReasonMessage)
+		}
+		return Completion{}, fmt.Errorf("gemini blocked the prompt (%s)", fb.BlockReason)
+	}
+	if len(result.Candidates) == 0 || result.Candidates[0].Content == nil {
+		return Completion{}, fmt.Errorf("gemini returned no candidates")
+	}
+
+	candidate := result.Candidates[0]
+	var text strings.Builder
+	for _, part := range candidate.Content.Parts {
+		if part != nil {
+			text.WriteString(part.Text)
+		}
+	}
+	finishReason, ok := geminiFinishReasons[candidate.FinishReason]
+	if !ok {
+		finishReason = strings.ToLower(string(candidate.FinishReason))
+	}
+	completion := Completion{Text: text.String(), FinishReason: finishReason}
+	if usage := result.UsageMetadata; usage != nil {
+		completion.Usage = Usage{
+			PromptTokens:     int(usage.PromptTokenCount),
+			CompletionTokens: int(usage.CandidatesTokenCount),
+			TotalTokens:      int(usage.TotalTokenCount),
+		}
 	}
-	return result.PromptFeedback.BlockReasonMessage, nil
+	return completion, nil
 }
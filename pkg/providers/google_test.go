@@ -0,0 +1,161 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+const geminiResponse = `{
+	"candidates": [{
+		"content": {"role": "model", "parts": [{"text": "ANSWER: 5"}]},
+		"finishReason": "STOP"
+	}]
+}`
+
+// fakeTransport answers every request with body, counts the requests and records the last one and its body
+type fakeTransport struct {
+	status      int // 0 means 200 OK
+	body        string
+	request     *http.Request
+	requestBody []byte
+	requests    int
+}
+
+func (f *fakeTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.requests++
+	f.request = r
+	if r.Body != nil {
+		f.requestBody, _ = io.ReadAll(r.Body)
+	}
+	status := f.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(f.body)),
+		Request:    r,
+	}, nil
+}
+
+func TestGemini(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test model, system instruction and history are forwarded", func(t *testing.T) {
+		transport := &fakeTransport{body: geminiResponse}
+		client, err := Gemini(ctx, WithAPIKey("test-key"), WithHTTPClient(&http.Client{Transport: transport}))
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+
+		response, err := client.Complete(ctx, "gemini-1.5-pro", "How many units do you give up?", "You are playing the donor game.",
+			[]string{"Round: I donated 50% to agent2"})
+		if err != nil {
+			t.Fatalf("Failed to complete request: %v", err)
+		}
+		if response != "ANSWER: 5" {
+			t.Errorf("response = %q, want %q", response, "ANSWER: 5")
+		}
+
+		if !strings.Contains(transport.request.URL.Path, "models/gemini-1.5-pro:generateContent") {
+			t.Errorf("request path = %s, want the gemini-1.5-pro model", transport.request.URL.Path)
+		}
+		var body struct {
+			Contents []struct {
+				Role  string `json:"role"`
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"contents"`
+			SystemInstruction struct {
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"systemInstruction"`
+		}
+		if err := json.Unmarshal(transport.requestBody, &body); err != nil {
+			t.Fatalf("Failed to decode request body: %v\n%s", err, transport.requestBody)
+		}
+		if len(body.SystemInstruction.Parts) != 1 || body.SystemInstruction.Parts[0].Text != "You are playing the donor game." {
+			t.Errorf("system instruction not forwarded: %s", transport.requestBody)
+		}
+		if len(body.Contents) != 2 || body.Contents[0].Role != "model" || body.Contents[1].Role != "user" {
+			t.Fatalf("Expected a model history turn then the user prompt, got: %s", transport.requestBody)
+		}
+		if body.Contents[1].Parts[0].Text != "How many units do you give up?" {
+			t.Errorf("prompt = %q", body.Contents[1].Parts[0].Text)
+		}
+	})
+
+	t.Run("test consecutive history entries are sent as one turn", func(t *testing.T) {
+		transport := &fakeTransport{body: geminiResponse}
+		client, err := Gemini(ctx, WithAPIKey("test-key"), WithHTTPClient(&http.Client{Transport: transport}))
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+
+		history := []string{"Round: I donated 50% to agent2", "Round: I received 4 from agent3"}
+		if _, err := client.Complete(ctx, "gemini-1.5-pro", "How many units do you give up?", "", history); err != nil {
+			t.Fatalf("Failed to complete request: %v", err)
+		}
+
+		var body struct {
+			Contents []struct {
+				Role  string `json:"role"`
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"contents"`
+		}
+		if err := json.Unmarshal(transport.requestBody, &body); err != nil {
+			t.Fatalf("Failed to decode request body: %v\n%s", err, transport.requestBody)
+		}
+		if len(body.Contents) != 2 || body.Contents[0].Role != "model" || body.Contents[1].Role != "user" {
+			t.Fatalf("Expected alternating model and user turns, got: %s", transport.requestBody)
+		}
+		if parts := body.Contents[0].Parts; len(parts) != 2 || parts[0].Text != history[0] || parts[1].Text != history[1] {
+			t.Errorf("Expected the history as parts of one model turn, got: %s", transport.requestBody)
+		}
+	})
+}
+
+func TestGeminiCompletion(t *testing.T) {
+	t.Run("test text parts are concatenated", func(t *testing.T) {
+		result := &genai.GenerateContentResponse{
+			Candidates: []*genai.Candidate{{
+				Content:      &genai.Content{Parts: []*genai.Part{{Text: "I will give half. "}, {Text: "ANSWER: 5"}}},
+				FinishReason: genai.FinishReasonMaxTokens,
+			}},
+		}
+		completion, err := geminiCompletion(result)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if want := "I will give half. ANSWER: 5"; completion.Text != want {
+			t.Errorf("text = %q, want %q", completion.Text, want)
+		}
+		if completion.FinishReason != FinishLength {
+			t.Errorf("FinishReason = %q, want %q", completion.FinishReason, FinishLength)
+		}
+	})
+
+	t.Run("test blocked prompt is an error", func(t *testing.T) {
+		result := &genai.GenerateContentResponse{
+			PromptFeedback: &genai.GenerateContentResponsePromptFeedback{
+				BlockReason:        genai.BlockedReasonSafety,
+				BlockReasonMessage: "unsafe content",
+			},
+		}
+		_, err := geminiCompletion(result)
+		if err == nil || !strings.Contains(err.Error(), "SAFETY") {
+			t.Errorf("Expected a block error mentioning the reason, got %v", err)
+		}
+	})
+}
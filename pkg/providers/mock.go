@@ -0,0 +1,118 @@
+package providers
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMockResponse is answered by a MockClient with no other responses configured. It contains
+// both a donor game strategy and a donation so that a whole experiment can run against it.
+const DefaultMockResponse = "My strategy will be to donate half of my resources to every recipient.\nANSWER: 5"
+
+// MockClient is a deterministic Client for tests and dry runs that makes no network calls.
+// It answers with its scripted responses in order, then with its canned response.
+type MockClient struct {
+	response string
+	script   []string
+	latency  time.Duration
+	prompts  []string
+	mu       sync.Mutex
+}
+
+// MockOption configures a MockClient
+type MockOption func(*MockClient)
+
+// WithMockResponse sets the response returned once the script is used up
+func WithMockResponse(response string) MockOption {
+	return func(c *MockClient) {
+		c.response = response
+	}
+}
+
+// WithMockScript queues responses to be returned in order before the canned response
+func WithMockScript(responses ...string) MockOption {
+	return func(c *MockClient) {
+		c.script = append(c.script, responses...)
+	}
+}
+
+// WithMockLatency delays every call by d to simulate a real provider
+func WithMockLatency(d time.Duration) MockOption {
+	return func(c *MockClient) {
+		c.latency = d
+	}
+}
+
+// NewMockClient creates a mock client answering DefaultMockResponse unless configured otherwise
+func NewMockClient(opts ...MockOption) *MockClient {
+	c := &MockClient{response: DefaultMockResponse}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *MockClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	completion, err := c.CompleteDetailed(ctx, model, prompt, systemPrompt, history)
+	return completion.Text, err
+}
+
+func (c *MockClient) CompleteDetailed(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (Completion, error) {
+	if c.latency > 0 {
+		timer := time.NewTimer(c.latency)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return Completion{}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prompts = append(c.prompts, prompt)
+	response := c.response
+	if len(c.script) > 0 {
+		response = c.script[0]
+		c.script = c.script[1:]
+	}
+	return Completion{Text: response, FinishReason: FinishStop}, nil
+}
+
+// CompleteStream streams the same response CompleteDetailed would return, one word at a time
+func (c *MockClient) CompleteStream(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (*Stream, error) {
+	completion, err := c.CompleteDetailed(ctx, model, prompt, systemPrompt, history)
+	if err != nil {
+		return nil, err
+	}
+	stream, deltas := newStream()
+	stream.usage = completion.Usage
+	go func() {
+		defer close(deltas)
+		for _, word := range strings.SplitAfter(completion.Text, " ") {
+			select {
+			case deltas <- word:
+			case <-ctx.Done():
+				stream.err = ctx.Err()
+				return
+			}
+		}
+	}()
+	return stream, nil
+}
+
+// Calls returns the number of calls answered so far
+func (c *MockClient) Calls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.prompts)
+}
+
+// Prompts returns the prompts of the calls answered so far, in order
+func (c *MockClient) Prompts() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.prompts...)
+}
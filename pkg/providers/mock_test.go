@@ -0,0 +1,44 @@
+package providers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMockClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test scripted responses are returned before the canned one", func(t *testing.T) {
+		client := NewMockClient(WithMockResponse("ANSWER: 5"), WithMockScript("first", "second"))
+		var got []string
+		for i := 0; i < 4; i++ {
+			response, err := client.Complete(ctx, "mock", "prompt", "", nil)
+			if err != nil {
+				t.Fatalf("Failed to complete call %d: %v", i, err)
+			}
+			got = append(got, response)
+		}
+		want := []string{"first", "second", "ANSWER: 5", "ANSWER: 5"}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("response %d = %q, want %q", i, got[i], want[i])
+			}
+		}
+		if client.Calls() != 4 {
+			t.Errorf("calls = %d, want 4", client.Calls())
+		}
+	})
+
+	t.Run("test latency is cut short by the context", func(t *testing.T) {
+		client := NewMockClient(WithMockLatency(time.Hour))
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		if _, err := client.Complete(ctx, "mock", "prompt", "", nil); err != context.DeadlineExceeded {
+			t.Errorf("err = %v, want context.DeadlineExceeded", err)
+		}
+		if client.Calls() != 0 {
+			t.Errorf("calls = %d, want 0", client.Calls())
+		}
+	})
+}
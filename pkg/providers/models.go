@@ -0,0 +1,146 @@
+package providers
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Providers a model name can resolve to
+const (
+	ProviderOpenAI = "openai"
+	ProviderGemini = "gemini"
+	ProviderOllama = "ollama"
+	ProviderMock   = "mock"
+)
+
+// ModelSpec is a canonical model ID and the provider that serves it
+type ModelSpec struct {
+	Provider string
+	Model    string
+}
+
+// modelAliases maps normalized model names to the model they stand for. The bare provider
+// names pick that provider's default model.
+var modelAliases = map[string]ModelSpec{
+	"gpt-4":                {ProviderOpenAI, "gpt-4o-mini"},
+	"gpt4":                 {ProviderOpenAI, "gpt-4o-mini"},
+	"openai":               {ProviderOpenAI, "gpt-4o-mini"},
+	"gpt-4o":               {ProviderOpenAI, "gpt-4o"},
+	"gpt4o":                {ProviderOpenAI, "gpt-4o"},
+	"gpt-4o-mini":          {ProviderOpenAI, "gpt-4o-mini"},
+	"gpt4o-mini":           {ProviderOpenAI, "gpt-4o-mini"},
+	"gemini":               {ProviderGemini, "gemini-2.0-flash-exp"},
+	"gemini-flash":         {ProviderGemini, "gemini-2.0-flash"},
+	"gemini-2.0-flash":     {ProviderGemini, "gemini-2.0-flash"},
+	"gemini-2-flash":       {ProviderGemini, "gemini-2.0-flash"},
+	"gemini-2.0-flash-exp": {ProviderGemini, "gemini-2.0-flash-exp"},
+	"gemini-1.5-flash":     {ProviderGemini, "gemini-1.5-flash"},
+	"gemini-1.5-pro":       {ProviderGemini, "gemini-1.5-pro"},
+	"gemini-pro":           {ProviderGemini, "gemini-1.5-pro"},
+	"ollama":               {ProviderOllama, "llama3.2"},
+	"llama":                {ProviderOllama, "llama3.2"},
+	"llama3":               {ProviderOllama, "llama3"},
+	"llama-3":              {ProviderOllama, "llama3"},
+	"llama3.2":             {ProviderOllama, "llama3.2"},
+	"llama-3.2":            {ProviderOllama, "llama3.2"},
+	"mock":                 {ProviderMock, "mock"},
+}
+
+// modelPrefixes picks the provider for model IDs that aren't aliases, so new models work
+// without being added to modelAliases
+var modelPrefixes = []struct {
+	prefix   string
+	provider string
+}{
+	{"gpt-", ProviderOpenAI},
+	{"o1", ProviderOpenAI},
+	{"o3", ProviderOpenAI},
+	{"gemini-", ProviderGemini},
+}
+
+// ResolveModel normalizes a user-supplied model name such as "GPT4o" or "gemini 2.0 flash" and
+// returns the canonical model ID and its provider. Names that aren't aliases are matched by
+// prefix, and "ollama:<model>" runs any model on Ollama. An unknown name is an error that
+// suggests the closest known names.
+func ResolveModel(name string) (ModelSpec, error) {
+	if model, ok := strings.CutPrefix(strings.TrimSpace(name), "ollama:"); ok && model != "" {
+		return ModelSpec{Provider: ProviderOllama, Model: model}, nil
+	}
+	normalized := normalizeModelName(name)
+	if spec, ok := modelAliases[normalized]; ok {
+		return spec, nil
+	}
+	for _, p := range modelPrefixes {
+		if strings.HasPrefix(normalized, p.prefix) {
+			return ModelSpec{Provider: p.provider, Model: normalized}, nil
+		}
+	}
+
+	if suggestions := suggestModels(normalized); len(suggestions) > 0 {
+		return ModelSpec{}, fmt.Errorf("unknown model %q, did you mean %s?", name, strings.Join(suggestions, ", "))
+	}
+	return ModelSpec{}, fmt.Errorf("unknown model %q, expected one of %s or ollama:<model>", name, strings.Join(KnownModels(), ", "))
+}
+
+// KnownModels returns the model aliases ResolveModel accepts, sorted
+func KnownModels() []string {
+	names := make([]string, 0, len(modelAliases))
+	for name := range modelAliases {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// normalizeModelName lowercases name and joins its words with dashes
+func normalizeModelName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return r == ' ' || r == '_' || r == '-'
+	}), "-")
+}
+
+// suggestModels returns up to three known names within a few edits of name, closest first
+func suggestModels(name string) []string {
+	const maxDistance = 3
+	type candidate struct {
+		name     string
+		distance int
+	}
+	var candidates []candidate
+	for _, known := range KnownModels() {
+		if d := editDistance(name, known); d <= maxDistance {
+			candidates = append(candidates, candidate{known, d})
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		return a.distance - b.distance
+	})
+	var names []string
+	for _, c := range candidates[:min(3, len(candidates))] {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,56 @@
+package providers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveModel(t *testing.T) {
+	t.Run("test aliases resolve to canonical models", func(t *testing.T) {
+		for name, want := range map[string]ModelSpec{
+			"gpt-4":            {ProviderOpenAI, "gpt-4o-mini"},
+			"gpt4":             {ProviderOpenAI, "gpt-4o-mini"},
+			"GPT-4o":           {ProviderOpenAI, "gpt-4o"},
+			"gpt4o":            {ProviderOpenAI, "gpt-4o"},
+			"gemini":           {ProviderGemini, "gemini-2.0-flash-exp"},
+			"gemini-2.0-flash": {ProviderGemini, "gemini-2.0-flash"},
+			"Gemini 2.0 Flash": {ProviderGemini, "gemini-2.0-flash"},
+			"llama_3.2":        {ProviderOllama, "llama3.2"},
+			"ollama:mistral":   {ProviderOllama, "mistral"},
+			"mock":             {ProviderMock, "mock"},
+		} {
+			spec, err := ResolveModel(name)
+			if err != nil {
+				t.Errorf("ResolveModel(%q) failed: %v", name, err)
+				continue
+			}
+			if spec != want {
+				t.Errorf("ResolveModel(%q) = %+v, want %+v", name, spec, want)
+			}
+		}
+	})
+
+	t.Run("test unlisted models are matched by prefix", func(t *testing.T) {
+		for name, want := range map[string]ModelSpec{
+			"gpt-4-turbo":        {ProviderOpenAI, "gpt-4-turbo"},
+			"o1-mini":            {ProviderOpenAI, "o1-mini"},
+			"gemini-2.5-pro-exp": {ProviderGemini, "gemini-2.5-pro-exp"},
+		} {
+			if spec, err := ResolveModel(name); err != nil || spec != want {
+				t.Errorf("ResolveModel(%q) = %+v, %v, want %+v", name, spec, err, want)
+			}
+		}
+	})
+
+	t.Run("test unknown names suggest close matches", func(t *testing.T) {
+		_, err := ResolveModel("gemni")
+		if err == nil || !strings.Contains(err.Error(), `unknown model "gemni", did you mean gemini`) {
+			t.Errorf("err = %v, want a suggestion of gemini", err)
+		}
+
+		_, err = ResolveModel("claude-3")
+		if err == nil || !strings.Contains(err.Error(), "expected one of") {
+			t.Errorf("err = %v, want the list of known models", err)
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type ollamaClient struct {
+	baseURL    string
+	httpClient *http.Client
+	limiter    *rateLimiter
+	timeout    time.Duration
+}
+
+type ollamaChatRequest struct {
+	Model    string         `json:"model"`
+	Messages []ChatMessage  `json:"messages"`
+	Stream   bool           `json:"stream"`
+	Options  *ollamaOptions `json:"options,omitempty"`
+	Format   string         `json:"format,omitempty"`
+}
+
+// ollamaOptions are the model parameters of a chat request
+type ollamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+}
+
+type ollamaChatResponse struct {
+	Message         ChatMessage `json:"message"`
+	DoneReason      string      `json:"done_reason,omitempty"`
+	PromptEvalCount int         `json:"prompt_eval_count,omitempty"`
+	EvalCount       int         `json:"eval_count,omitempty"`
+	Error           string      `json:"error,omitempty"`
+}
+
+// OllamaError is an error response from an Ollama server
+type OllamaError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *OllamaError) Error() string {
+	return fmt.Sprintf("ollama returned status %d: %s", e.StatusCode, e.Message)
+}
+
+// Ollama creates a client for a local or remote Ollama server. The server defaults to
+// OLLAMA_HOST or http://localhost:11434 and can be overridden with WithBaseURL.
+func Ollama(ctx context.Context, opts ...ProviderOption) (*ollamaClient, error) {
+	params := &ProviderParams{}
+
+	// Apply all options
+	for _, opt := range opts {
+		opt(params)
+	}
+
+	baseURL := params.BaseURL
+	if baseURL == "" {
+		baseURL = os.Getenv("OLLAMA_HOST")
+		if baseURL == "" {
+			baseURL = "http://localhost:11434"
+		}
+	}
+	httpClient := params.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &ollamaClient{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: httpClient,
+		limiter:    newRateLimiter(params.RateLimit),
+		timeout:    params.Timeout,
+	}, nil
+}
+
+func (c *ollamaClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	completion, err := c.CompleteDetailed(ctx, model, prompt, systemPrompt, history)
+	return completion.Text, err
+}
+
+func (c *ollamaClient) CompleteDetailed(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (Completion, error) {
+	if err := c.limiter.wait(ctx); err != nil {
+		return Completion{}, err
+	}
+	log.Printf("Making Ollama API call with model: %s", model)
+
+	request := ollamaChatRequest{
+		Model:    model,
+		Messages: chatMessages(ctx, prompt, systemPrompt, history),
+		Stream:   false,
+	}
+	if sampling := SamplingFromContext(ctx); sampling.Temperature != nil || sampling.TopP != nil {
+		request.Options = &ollamaOptions{Temperature: sampling.Temperature, TopP: sampling.TopP}
+	}
+	if ResponseFormatFromContext(ctx) == ResponseFormatJSON {
+		request.Format = "json"
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return Completion{}, fmt.Errorf("failed to encode ollama request: %v", err)
+	}
+	callCtx, cancel := timeoutContext(ctx, c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(callCtx, http.MethodPost, c.baseURL+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return Completion{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		err = timeoutError(ctx, callCtx, err, c.timeout)
+		log.Printf("Ollama API error: %v", err)
+		return Completion{}, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if timeoutErr := timeoutError(ctx, callCtx, err, c.timeout); timeoutErr != err {
+			return Completion{}, timeoutErr
+		}
+		return Completion{}, fmt.Errorf("failed to read ollama response: %v", err)
+	}
+	var chat ollamaChatResponse
+	if err := json.Unmarshal(data, &chat); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			// e.g. a proxy in front of the server answering with a plain text error
+			return Completion{}, &OllamaError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+		}
+		return Completion{}, fmt.Errorf("failed to decode ollama response (status %d): %v", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || chat.Error != "" {
+		return Completion{}, &OllamaError{StatusCode: resp.StatusCode, Message: chat.Error}
+	}
+	return Completion{
+		Text:         chat.Message.Content,
+		FinishReason: chat.DoneReason,
+		Usage: Usage{
+			PromptTokens:     chat.PromptEvalCount,
+			CompletionTokens: chat.EvalCount,
+			TotalTokens:      chat.PromptEvalCount + chat.EvalCount,
+		},
+	}, nil
+}
@@ -0,0 +1,53 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestOllama(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test chat request and response", func(t *testing.T) {
+		var path string
+		var request ollamaChatRequest
+		server := newStubServer(t, `{"model":"llama3.2","message":{"role":"assistant","content":"ANSWER: 4"},"done":true}`,
+			func(r *http.Request) {
+				path = r.URL.Path
+				body, _ := io.ReadAll(r.Body)
+				json.Unmarshal(body, &request)
+			})
+
+		client, err := Ollama(ctx, WithBaseURL(server.URL+"/"))
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		response, err := client.Complete(ctx, "llama3.2", "How many units do you give up?", "You are playing the donor game.",
+			[]string{"Round: I donated 50% to agent2"})
+		if err != nil {
+			t.Fatalf("Failed to complete request: %v", err)
+		}
+		if response != "ANSWER: 4" {
+			t.Errorf("response = %q, want %q", response, "ANSWER: 4")
+		}
+
+		if path != "/api/chat" {
+			t.Errorf("path = %s, want /api/chat", path)
+		}
+		if request.Model != "llama3.2" || request.Stream {
+			t.Errorf("request = %+v, want model llama3.2 without streaming", request)
+		}
+		want := chatMessages(ctx, "How many units do you give up?", "You are playing the donor game.", []string{"Round: I donated 50% to agent2"})
+		if len(request.Messages) != len(want) {
+			t.Fatalf("messages = %+v, want %+v", request.Messages, want)
+		}
+		for i := range want {
+			if request.Messages[i] != want[i] {
+				t.Errorf("message %d = %+v, want %+v", i, request.Messages[i], want[i])
+			}
+		}
+	})
+}
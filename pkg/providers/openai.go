@@ -5,15 +5,30 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
 )
 
+// openAIClient is the only OpenAI implementation; every caller gets one from OpenAi
 type openAIClient struct {
-	client *openai.Client
+	client         *openai.Client
+	limiter        *rateLimiter
+	timeout        time.Duration
+	embeddingModel string
 }
 
+var (
+	_ DetailedClient  = (*openAIClient)(nil)
+	_ StreamingClient = (*openAIClient)(nil)
+	_ ToolClient      = (*openAIClient)(nil)
+	_ Embedder        = (*openAIClient)(nil)
+)
+
+// DefaultOpenAIEmbeddingModel is used by Embed unless WithEmbeddingModel sets another model
+const DefaultOpenAIEmbeddingModel = openai.EmbeddingModelTextEmbedding3Small
+
 func OpenAi(ctx context.Context, opts ...ProviderOption) (*openAIClient, error) {
 	params := &ProviderParams{}
 
@@ -37,37 +52,260 @@ func OpenAi(ctx context.Context, opts ...ProviderOption) (*openAIClient, error)
 	if apiKey == "" {
 		return nil, fmt.Errorf("error retrieving OPENAI_API_KEY")
 	}
-	client := openai.NewClient(
+	organization := params.Organization
+	if organization == "" {
+		organization = os.Getenv("OPENAI_ORG_ID")
+	}
+	project := params.Project
+	if project == "" {
+		project = os.Getenv("OPENAI_PROJECT_ID")
+	}
+
+	requestOpts := []option.RequestOption{
 		option.WithAPIKey(apiKey),
 		option.WithBaseURL(baseUrl),
-	)
+	}
+	if organization != "" {
+		requestOpts = append(requestOpts, option.WithOrganization(organization))
+	}
+	if project != "" {
+		requestOpts = append(requestOpts, option.WithProject(project))
+	}
+	if params.HTTPClient != nil {
+		requestOpts = append(requestOpts, option.WithHTTPClient(params.HTTPClient))
+	}
+	embeddingModel := params.EmbeddingModel
+	if embeddingModel == "" {
+		embeddingModel = DefaultOpenAIEmbeddingModel
+	}
+	client := openai.NewClient(requestOpts...)
 	return &openAIClient{
-		client: client,
+		client:         client,
+		limiter:        newRateLimiter(params.RateLimit),
+		timeout:        params.Timeout,
+		embeddingModel: embeddingModel,
 	}, nil
 }
 
 func (c *openAIClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
-	log.Printf("Making OpenAI API call with model: %s", model)
+	completion, err := c.CompleteDetailed(ctx, model, prompt, systemPrompt, history)
+	return completion.Text, err
+}
+
+func (c *openAIClient) CompleteDetailed(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (Completion, error) {
+	chatCompletion, err := c.newChatCompletion(ctx, chatParams(ctx, model, prompt, systemPrompt, history))
+	if err != nil {
+		return Completion{}, err
+	}
+	choice := chatCompletion.Choices[0]
+	return Completion{
+		Text:         choice.Message.Content,
+		FinishReason: string(choice.FinishReason),
+		Usage:        chatUsage(chatCompletion),
+	}, nil
+}
 
-	messages := []openai.ChatCompletionMessageParamUnion{
-		openai.SystemMessage(systemPrompt),
+// CompleteWithTools completes with OpenAI function calling. Every tool call in a response is run
+// and its result sent back, until the model answers without calling a tool.
+func (c *openAIClient) CompleteWithTools(ctx context.Context, model string, prompt string, systemPrompt string, history []string, tools []Tool) (Completion, error) {
+	params := chatParams(ctx, model, prompt, systemPrompt, history)
+	if len(tools) > 0 {
+		params.Tools = openai.F(toolParams(tools))
 	}
+	messages := params.Messages.Value
+
+	var completion Completion
+	for range maxToolRounds {
+		params.Messages = openai.F(messages)
+		chatCompletion, err := c.newChatCompletion(ctx, params)
+		if err != nil {
+			return Completion{}, err
+		}
+		choice := chatCompletion.Choices[0]
+		completion.Text = choice.Message.Content
+		completion.FinishReason = string(choice.FinishReason)
+		completion.Usage = completion.Usage.Add(chatUsage(chatCompletion))
+		if len(choice.Message.ToolCalls) == 0 {
+			return completion, nil
+		}
 
-	// Add history as assistant messages
-	for _, msg := range history {
-		messages = append(messages, openai.AssistantMessage(msg))
+		messages = append(messages, choice.Message)
+		for _, call := range choice.Message.ToolCalls {
+			result := runTool(ctx, tools, call.Function.Name, call.Function.Arguments)
+			completion.ToolCalls = append(completion.ToolCalls, ToolCall{
+				ID:        call.ID,
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+				Result:    result,
+			})
+			messages = append(messages, openai.ToolMessage(call.ID, result))
+		}
 	}
+	return Completion{}, fmt.Errorf("model was still calling tools after %d responses", maxToolRounds)
+}
 
-	// Add current prompt as the final user message
-	messages = append(messages, openai.UserMessage(prompt))
+// newChatCompletion sends a chat completion request, waiting for the rate limiter first
+func (c *openAIClient) newChatCompletion(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	if err := c.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	log.Printf("Making OpenAI API call with model: %s", params.Model.Value)
 
-	chatCompletion, err := c.client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Messages: openai.F(messages),
-		Model:    openai.F(model),
+	callCtx, cancel := timeoutContext(ctx, c.timeout)
+	defer cancel()
+	chatCompletion, err := c.client.Chat.Completions.New(callCtx, params)
+	if err != nil {
+		err = timeoutError(ctx, callCtx, err, c.timeout)
+		log.Printf("OpenAI API error: %v", err)
+		return nil, err
+	}
+	if len(chatCompletion.Choices) == 0 {
+		return nil, fmt.Errorf("openai returned no choices")
+	}
+	return chatCompletion, nil
+}
+
+// chatUsage returns the tokens a chat completion consumed
+func chatUsage(chatCompletion *openai.ChatCompletion) Usage {
+	return Usage{
+		PromptTokens:     int(chatCompletion.Usage.PromptTokens),
+		CompletionTokens: int(chatCompletion.Usage.CompletionTokens),
+		TotalTokens:      int(chatCompletion.Usage.TotalTokens),
+	}
+}
+
+// toolParams describes tools as OpenAI functions
+func toolParams(tools []Tool) []openai.ChatCompletionToolParam {
+	params := make([]openai.ChatCompletionToolParam, 0, len(tools))
+	for _, tool := range tools {
+		function := openai.FunctionDefinitionParam{
+			Name:        openai.F(tool.Name),
+			Description: openai.F(tool.Description),
+		}
+		if tool.Parameters != nil {
+			function.Parameters = openai.F(openai.FunctionParameters(tool.Parameters))
+		}
+		params = append(params, openai.ChatCompletionToolParam{
+			Type:     openai.F(openai.ChatCompletionToolTypeFunction),
+			Function: openai.F(function),
+		})
+	}
+	return params
+}
+
+// Embed computes an embedding for each of texts with the client's embedding model in a single request
+func (c *openAIClient) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	if err := c.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	log.Printf("Making OpenAI embeddings call with model: %s", c.embeddingModel)
+
+	callCtx, cancel := timeoutContext(ctx, c.timeout)
+	defer cancel()
+	response, err := c.client.Embeddings.New(callCtx, openai.EmbeddingNewParams{
+		Input: openai.F[openai.EmbeddingNewParamsInputUnion](openai.EmbeddingNewParamsInputArrayOfStrings(texts)),
+		Model: openai.F(c.embeddingModel),
 	})
 	if err != nil {
+		err = timeoutError(ctx, callCtx, err, c.timeout)
 		log.Printf("OpenAI API error: %v", err)
-		return "", err
+		return nil, err
+	}
+	if len(response.Data) != len(texts) {
+		return nil, fmt.Errorf("openai returned %d embeddings for %d texts", len(response.Data), len(texts))
+	}
+
+	embeddings := make([][]float32, len(texts))
+	for _, data := range response.Data {
+		if data.Index < 0 || int(data.Index) >= len(texts) {
+			return nil, fmt.Errorf("openai returned an embedding for unknown input %d", data.Index)
+		}
+		vector := make([]float32, len(data.Embedding))
+		for i, v := range data.Embedding {
+			vector[i] = float32(v)
+		}
+		embeddings[data.Index] = vector
+	}
+	return embeddings, nil
+}
+
+// CompleteStream streams the completion's content deltas from the streaming API. An error
+// in the middle of the stream is logged and ends the stream early.
+func (c *openAIClient) CompleteStream(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (*Stream, error) {
+	if err := c.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	log.Printf("Making streaming OpenAI API call with model: %s", model)
+
+	callCtx, cancel := timeoutContext(ctx, c.timeout)
+	params := chatParams(ctx, model, prompt, systemPrompt, history)
+	params.StreamOptions = openai.F(openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.F(true)})
+	chunks := c.client.Chat.Completions.NewStreaming(callCtx, params)
+	stream, deltas := newStream()
+	go func() {
+		defer close(deltas)
+		defer cancel()
+		defer chunks.Close()
+		for chunks.Next() {
+			chunk := chunks.Current()
+			if chunk.Usage.TotalTokens > 0 {
+				stream.usage = Usage{
+					PromptTokens:     int(chunk.Usage.PromptTokens),
+					CompletionTokens: int(chunk.Usage.CompletionTokens),
+					TotalTokens:      int(chunk.Usage.TotalTokens),
+				}
+			}
+			if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+				continue
+			}
+			select {
+			case deltas <- chunk.Choices[0].Delta.Content:
+			case <-callCtx.Done():
+				stream.err = timeoutError(ctx, callCtx, callCtx.Err(), c.timeout)
+				return
+			}
+		}
+		if err := chunks.Err(); err != nil {
+			stream.err = timeoutError(ctx, callCtx, err, c.timeout)
+			log.Printf("OpenAI API stream error: %v", stream.err)
+		}
+	}()
+	return stream, nil
+}
+
+// chatParams builds the chat completion request for a call, applying the history roles, sampling
+// parameters and response format set in ctx
+func chatParams(ctx context.Context, model string, prompt string, systemPrompt string, history []string) openai.ChatCompletionNewParams {
+	var messages []openai.ChatCompletionMessageParamUnion
+	for _, msg := range chatMessages(ctx, prompt, systemPrompt, history) {
+		switch msg.Role {
+		case "system":
+			messages = append(messages, openai.SystemMessage(msg.Content))
+		case "assistant":
+			messages = append(messages, openai.AssistantMessage(msg.Content))
+		default:
+			messages = append(messages, openai.UserMessage(msg.Content))
+		}
+	}
+
+	params := openai.ChatCompletionNewParams{
+		Messages: openai.F(messages),
+		Model:    openai.F(model),
+	}
+	sampling := SamplingFromContext(ctx)
+	if sampling.Temperature != nil {
+		params.Temperature = openai.F(*sampling.Temperature)
+	}
+	if sampling.TopP != nil {
+		params.TopP = openai.F(*sampling.TopP)
+	}
+	if ResponseFormatFromContext(ctx) == ResponseFormatJSON {
+		params.ResponseFormat = openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](openai.ResponseFormatJSONObjectParam{
+			Type: openai.F(openai.ResponseFormatJSONObjectTypeJSONObject),
+		})
 	}
-	return chatCompletion.Choices[0].Message.Content, nil
+	return params
 }
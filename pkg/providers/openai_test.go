@@ -0,0 +1,309 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const chatCompletionResponse = `{
+	"id": "chatcmpl-test",
+	"object": "chat.completion",
+	"created": 0,
+	"model": "gpt-4o-mini",
+	"choices": [{
+		"index": 0,
+		"finish_reason": "stop",
+		"message": {"role": "assistant", "content": "hello"}
+	}],
+	"usage": {"prompt_tokens": 3, "completion_tokens": 1, "total_tokens": 4}
+}`
+
+// newStubServer starts a server that answers every request with body and passes the request to inspect
+func newStubServer(t *testing.T, body string, inspect func(r *http.Request)) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if inspect != nil {
+			inspect(r)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOpenAI(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test organization and project headers", func(t *testing.T) {
+		var org, project string
+		server := newStubServer(t, chatCompletionResponse, func(r *http.Request) {
+			org = r.Header.Get("OpenAI-Organization")
+			project = r.Header.Get("OpenAI-Project")
+		})
+
+		client, err := OpenAi(ctx,
+			WithAPIKey("test-key"),
+			WithBaseURL(server.URL+"/"),
+			WithOrganization("org-123"),
+			WithProject("proj-456"),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+
+		response, err := client.Complete(ctx, "gpt-4o-mini", "Say hello!", "", nil)
+		if err != nil {
+			t.Fatalf("Failed to complete request: %v", err)
+		}
+		if response != "hello" {
+			t.Errorf("response = %q, want %q", response, "hello")
+		}
+		if org != "org-123" {
+			t.Errorf("OpenAI-Organization = %q, want %q", org, "org-123")
+		}
+		if project != "proj-456" {
+			t.Errorf("OpenAI-Project = %q, want %q", project, "proj-456")
+		}
+	})
+
+	t.Run("test sampling parameters from the context are sent", func(t *testing.T) {
+		var body map[string]any
+		server := newStubServer(t, chatCompletionResponse, func(r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&body)
+		})
+
+		client, err := OpenAi(ctx, WithAPIKey("test-key"), WithBaseURL(server.URL+"/"))
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		if _, err := client.Complete(ctx, "gpt-4o-mini", "Say hello!", "", nil); err != nil {
+			t.Fatalf("Failed to complete request: %v", err)
+		}
+		if _, ok := body["temperature"]; ok {
+			t.Errorf("temperature = %v, want it omitted by default", body["temperature"])
+		}
+
+		temperature, topP := 1.0, 0.9
+		samplingCtx := WithSampling(ctx, Sampling{Temperature: &temperature, TopP: &topP})
+		if _, err := client.Complete(samplingCtx, "gpt-4o-mini", "Say hello!", "", nil); err != nil {
+			t.Fatalf("Failed to complete request: %v", err)
+		}
+		if body["temperature"] != 1.0 || body["top_p"] != 0.9 {
+			t.Errorf("temperature, top_p = %v, %v, want 1, 0.9", body["temperature"], body["top_p"])
+		}
+	})
+
+	t.Run("test json response format is requested", func(t *testing.T) {
+		var body map[string]any
+		server := newStubServer(t, chatCompletionResponse, func(r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&body)
+		})
+
+		client, err := OpenAi(ctx, WithAPIKey("test-key"), WithBaseURL(server.URL+"/"))
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		jsonCtx := WithResponseFormat(ctx, ResponseFormatJSON)
+		if _, err := client.Complete(jsonCtx, "gpt-4o-mini", "Answer in JSON.", "", nil); err != nil {
+			t.Fatalf("Failed to complete request: %v", err)
+		}
+		format, _ := body["response_format"].(map[string]any)
+		if format["type"] != "json_object" {
+			t.Errorf("response_format = %v, want type json_object", body["response_format"])
+		}
+	})
+
+	t.Run("test organization and project fall back to environment", func(t *testing.T) {
+		t.Setenv("OPENAI_ORG_ID", "org-env")
+		t.Setenv("OPENAI_PROJECT_ID", "proj-env")
+		var org, project string
+		server := newStubServer(t, chatCompletionResponse, func(r *http.Request) {
+			org = r.Header.Get("OpenAI-Organization")
+			project = r.Header.Get("OpenAI-Project")
+		})
+
+		client, err := OpenAi(ctx, WithAPIKey("test-key"), WithBaseURL(server.URL+"/"))
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		if _, err := client.Complete(ctx, "gpt-4o-mini", "Say hello!", "", nil); err != nil {
+			t.Fatalf("Failed to complete request: %v", err)
+		}
+		if org != "org-env" || project != "proj-env" {
+			t.Errorf("headers = (%q, %q), want (%q, %q)", org, project, "org-env", "proj-env")
+		}
+	})
+
+	t.Run("test streamed deltas are yielded in order", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, delta := range []string{"Hel", "lo", "!"} {
+				fmt.Fprintf(w, "data: {\"id\":\"chatcmpl-test\",\"object\":\"chat.completion.chunk\",\"created\":0,\"model\":\"gpt-4o-mini\",\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", delta)
+			}
+			fmt.Fprint(w, "data: {\"id\":\"chatcmpl-test\",\"object\":\"chat.completion.chunk\",\"created\":0,\"model\":\"gpt-4o-mini\",\"choices\":[],\"usage\":{\"prompt_tokens\":4,\"completion_tokens\":3,\"total_tokens\":7}}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+		}))
+		t.Cleanup(server.Close)
+
+		client, err := OpenAi(ctx, WithAPIKey("test-key"), WithBaseURL(server.URL+"/"))
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		stream, err := client.CompleteStream(ctx, "gpt-4o-mini", "Say hello!", "", nil)
+		if err != nil {
+			t.Fatalf("Failed to start stream: %v", err)
+		}
+		var got []string
+		for delta := range stream.Deltas {
+			got = append(got, delta)
+		}
+		if fmt.Sprint(got) != "[Hel lo !]" {
+			t.Errorf("deltas = %q, want [Hel lo !]", got)
+		}
+		if err := stream.Err(); err != nil {
+			t.Errorf("Expected the stream to complete, got %v", err)
+		}
+		if usage := stream.Usage(); usage.TotalTokens != 7 {
+			t.Errorf("usage = %+v, want the 7 tokens of the final chunk", usage)
+		}
+	})
+
+	t.Run("test stream errors are reported after the deltas", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"id\":\"chatcmpl-test\",\"object\":\"chat.completion.chunk\",\"created\":0,\"model\":\"gpt-4o-mini\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+			fmt.Fprint(w, "data: {\"error\":{\"message\":\"server overloaded\"}}\n\n")
+		}))
+		t.Cleanup(server.Close)
+
+		client, err := OpenAi(ctx, WithAPIKey("test-key"), WithBaseURL(server.URL+"/"))
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		stream, err := client.CompleteStream(ctx, "gpt-4o-mini", "Say hello!", "", nil)
+		if err != nil {
+			t.Fatalf("Failed to start stream: %v", err)
+		}
+		for range stream.Deltas {
+		}
+		if err := stream.Err(); err == nil || !strings.Contains(err.Error(), "server overloaded") {
+			t.Errorf("Expected the stream error to be reported, got %v", err)
+		}
+	})
+
+	t.Run("test embeddings are returned in input order", func(t *testing.T) {
+		var body map[string]any
+		response := `{
+			"object": "list",
+			"model": "text-embedding-3-small",
+			"data": [
+				{"object": "embedding", "index": 1, "embedding": [0, 1]},
+				{"object": "embedding", "index": 0, "embedding": [1, 0.5]}
+			],
+			"usage": {"prompt_tokens": 4, "total_tokens": 4}
+		}`
+		server := newStubServer(t, response, func(r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&body)
+		})
+
+		client, err := OpenAi(ctx, WithAPIKey("test-key"), WithBaseURL(server.URL+"/"))
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		embeddings, err := client.Embed(ctx, []string{"first", "second"})
+		if err != nil {
+			t.Fatalf("Failed to embed: %v", err)
+		}
+		if fmt.Sprint(embeddings) != "[[1 0.5] [0 1]]" {
+			t.Errorf("embeddings = %v, want [[1 0.5] [0 1]]", embeddings)
+		}
+		if body["model"] != DefaultOpenAIEmbeddingModel {
+			t.Errorf("model = %v, want %v", body["model"], DefaultOpenAIEmbeddingModel)
+		}
+
+		if _, err := client.Embed(ctx, []string{"only one"}); err == nil {
+			t.Error("Embed() error = nil, want an error when the number of embeddings doesn't match")
+		}
+	})
+
+	t.Run("test tool calls are run and their results sent back", func(t *testing.T) {
+		toolCallResponse := `{
+			"id": "chatcmpl-tool",
+			"object": "chat.completion",
+			"created": 0,
+			"model": "gpt-4o-mini",
+			"choices": [{
+				"index": 0,
+				"finish_reason": "tool_calls",
+				"message": {"role": "assistant", "content": null, "tool_calls": [
+					{"id": "call_1", "type": "function", "function": {"name": "take", "arguments": "{\"amount\": 3}"}}
+				]}
+			}],
+			"usage": {"prompt_tokens": 5, "completion_tokens": 2, "total_tokens": 7}
+		}`
+		var requests []map[string]any
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body map[string]any
+			json.NewDecoder(r.Body).Decode(&body)
+			requests = append(requests, body)
+			w.Header().Set("Content-Type", "application/json")
+			if len(requests) == 1 {
+				w.Write([]byte(toolCallResponse))
+			} else {
+				w.Write([]byte(chatCompletionResponse))
+			}
+		}))
+		t.Cleanup(server.Close)
+
+		client, err := OpenAi(ctx, WithAPIKey("test-key"), WithBaseURL(server.URL+"/"))
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		var arguments string
+		take := Tool{
+			Name:        "take",
+			Description: "Take resources from the shared pool",
+			Parameters: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"amount": map[string]any{"type": "number"}},
+			},
+			Handler: func(ctx context.Context, args string) (string, error) {
+				arguments = args
+				return "took 3, 7 left", nil
+			},
+		}
+
+		completion, err := client.CompleteWithTools(ctx, "gpt-4o-mini", "Take some resources", "", nil, []Tool{take})
+		if err != nil {
+			t.Fatalf("Failed to complete with tools: %v", err)
+		}
+		if completion.Text != "hello" || completion.Usage.TotalTokens != 11 {
+			t.Errorf("completion = %+v, want the final answer and the usage of both calls", completion)
+		}
+		if arguments != `{"amount": 3}` {
+			t.Errorf("tool arguments = %q", arguments)
+		}
+		if len(completion.ToolCalls) != 1 || completion.ToolCalls[0].Result != "took 3, 7 left" {
+			t.Errorf("tool calls = %+v, want the take call and its result", completion.ToolCalls)
+		}
+
+		if len(requests) != 2 {
+			t.Fatalf("got %d requests, want 2", len(requests))
+		}
+		tools, _ := requests[0]["tools"].([]any)
+		if len(tools) != 1 || fmt.Sprint(tools[0].(map[string]any)["function"].(map[string]any)["name"]) != "take" {
+			t.Errorf("tools = %v, want the take function", requests[0]["tools"])
+		}
+		messages, _ := requests[1]["messages"].([]any)
+		last, _ := messages[len(messages)-1].(map[string]any)
+		if last["role"] != "tool" || last["tool_call_id"] != "call_1" || !strings.Contains(fmt.Sprint(last["content"]), "took 3, 7 left") {
+			t.Errorf("last message = %v, want the tool's result", last)
+		}
+	})
+}
@@ -1,8 +1,259 @@
 package providers
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client is implemented by every provider and by the decorators that wrap them.
+// It has the same method set as agent.Client.
+type Client interface {
+	Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error)
+}
+
+// Finish reasons reported in a Completion, normalized across providers
+const (
+	FinishStop          = "stop"           // the model finished its answer
+	FinishLength        = "length"         // the answer was truncated at the token limit
+	FinishContentFilter = "content_filter" // the answer was cut off by a safety filter
+)
+
+// Completion is a provider's response along with why the model stopped generating
+type Completion struct {
+	Text         string
+	FinishReason string // one of the Finish constants, another provider-specific reason, or "" if unknown
+	Usage        Usage  // tokens consumed by the call; zero if the provider doesn't report them
+	// ToolCalls are the tools the model called before answering, in order
+	ToolCalls []ToolCall
+}
+
+// Usage is the number of tokens consumed by one or more completions
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Add returns the sum of u and other
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		TotalTokens:      u.TotalTokens + other.TotalTokens,
+	}
+}
+
+// Sub returns the usage in u that isn't in other, e.g. the usage since an earlier snapshot
+func (u Usage) Sub(other Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens - other.PromptTokens,
+		CompletionTokens: u.CompletionTokens - other.CompletionTokens,
+		TotalTokens:      u.TotalTokens - other.TotalTokens,
+	}
+}
+
+// DetailedClient is a Client that can also report how each completion finished
+type DetailedClient interface {
+	Client
+	CompleteDetailed(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (Completion, error)
+}
+
+// StreamingClient is a Client that can also stream a completion as it is generated
+type StreamingClient interface {
+	Client
+	CompleteStream(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (*Stream, error)
+}
+
+// Stream is a completion being streamed. Deltas yields text deltas and is closed when the
+// completion ends or ctx is done, after which Err reports why the stream ended early, if it did.
+type Stream struct {
+	Deltas <-chan string
+	err    error // set by the sender before Deltas is closed
+	usage  Usage // set by the sender before Deltas is closed, if the provider reports it
+}
+
+// newStream returns a stream and the channel its sender writes deltas to
+func newStream() (*Stream, chan string) {
+	deltas := make(chan string)
+	return &Stream{Deltas: deltas}, deltas
+}
+
+// Err returns the error that ended the stream, or nil if it completed. It is only valid once
+// Deltas is closed.
+func (s *Stream) Err() error {
+	return s.err
+}
+
+// Usage returns the tokens the streamed completion consumed, if the provider reported them. Like
+// Err it is only valid once Deltas is closed.
+func (s *Stream) Usage() Usage {
+	return s.usage
+}
+
+// relay returns a stream that passes on s's deltas. When s ends, end is called with the streamed
+// completion and the error that ended it, if any, and the relayed stream ends with the error end
+// returns, so client decorators can account for a stream once it has finished.
+func (s *Stream) relay(ctx context.Context, end func(completion Completion, err error) error) *Stream {
+	out, deltas := newStream()
+	go func() {
+		defer close(deltas)
+		var text strings.Builder
+		for delta := range s.Deltas {
+			text.WriteString(delta)
+			select {
+			case deltas <- delta:
+			case <-ctx.Done():
+				out.err = end(Completion{Text: text.String()}, ctx.Err())
+				return
+			}
+		}
+		out.usage = s.usage
+		out.err = end(Completion{Text: text.String(), Usage: s.usage}, s.err)
+	}()
+	return out
+}
+
+// Embedder turns texts into embedding vectors, returning one vector per text in the same order
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// ErrEmbeddingsUnsupported is returned by Embed for a client that can't embed texts
+var ErrEmbeddingsUnsupported = errors.New("client doesn't support embeddings")
+
+// Embed calls client's Embed if it has one, otherwise it fails with ErrEmbeddingsUnsupported.
+// The client decorators in this package implement Embed with it, so wrapping a client doesn't
+// hide its embeddings.
+func Embed(ctx context.Context, client Client, texts []string) ([][]float32, error) {
+	if embedder, ok := client.(Embedder); ok {
+		return embedder.Embed(ctx, texts)
+	}
+	return nil, fmt.Errorf("%w: %T", ErrEmbeddingsUnsupported, client)
+}
+
+// CompleteDetailed calls client's CompleteDetailed if it has one, otherwise its Complete
+// with an unknown finish reason
+func CompleteDetailed(ctx context.Context, client Client, model string, prompt string, systemPrompt string, history []string) (Completion, error) {
+	if detailed, ok := client.(DetailedClient); ok {
+		return detailed.CompleteDetailed(ctx, model, prompt, systemPrompt, history)
+	}
+	text, err := client.Complete(ctx, model, prompt, systemPrompt, history)
+	return Completion{Text: text}, err
+}
+
+// CompleteStream calls client's CompleteStream if it has one, otherwise it streams the response
+// of its CompleteDetailed as a single delta. The client decorators in this package implement
+// CompleteStream with it, so wrapping a client doesn't stop it streaming.
+func CompleteStream(ctx context.Context, client Client, model string, prompt string, systemPrompt string, history []string) (*Stream, error) {
+	if streaming, ok := client.(StreamingClient); ok {
+		return streaming.CompleteStream(ctx, model, prompt, systemPrompt, history)
+	}
+	completion, err := CompleteDetailed(ctx, client, model, prompt, systemPrompt, history)
+	if err != nil {
+		return nil, err
+	}
+	stream, deltas := newStream()
+	stream.usage = completion.Usage
+	go func() {
+		defer close(deltas)
+		select {
+		case deltas <- completion.Text:
+		case <-ctx.Done():
+			stream.err = ctx.Err()
+		}
+	}()
+	return stream, nil
+}
+
+// Sampling holds the sampling parameters for a completion. Nil fields use the provider's default.
+type Sampling struct {
+	Temperature *float64
+	TopP        *float64
+}
+
+type samplingKey struct{}
+
+// WithSampling returns a context that makes providers complete with the given sampling parameters.
+// Passing them through the context lets each agent choose its own without changing the Client interface.
+func WithSampling(ctx context.Context, sampling Sampling) context.Context {
+	return context.WithValue(ctx, samplingKey{}, sampling)
+}
+
+// SamplingFromContext returns the sampling parameters set with WithSampling, if any
+func SamplingFromContext(ctx context.Context) Sampling {
+	sampling, _ := ctx.Value(samplingKey{}).(Sampling)
+	return sampling
+}
+
+// ResponseFormatJSON asks a provider to respond with a single JSON object
+const ResponseFormatJSON = "json"
+
+type responseFormatKey struct{}
+
+// WithResponseFormat returns a context that asks providers to respond in format, e.g. ResponseFormatJSON.
+// The prompt should still describe the expected structure, as providers only guarantee valid syntax.
+func WithResponseFormat(ctx context.Context, format string) context.Context {
+	return context.WithValue(ctx, responseFormatKey{}, format)
+}
+
+// ResponseFormatFromContext returns the response format set with WithResponseFormat, or "" for plain text
+func ResponseFormatFromContext(ctx context.Context) string {
+	format, _ := ctx.Value(responseFormatKey{}).(string)
+	return format
+}
+
+type historyRolesKey struct{}
+
+// WithHistoryRoles returns a context that sends each history entry of a completion as a turn by the
+// role at the same index, "user" or "assistant", e.g. so messages from other agents are sent as the
+// user's turns. Entries without a role are sent as the model's own turns.
+func WithHistoryRoles(ctx context.Context, roles []string) context.Context {
+	return context.WithValue(ctx, historyRolesKey{}, roles)
+}
+
+// HistoryRolesFromContext returns the history roles set with WithHistoryRoles, if any
+func HistoryRolesFromContext(ctx context.Context) []string {
+	roles, _ := ctx.Value(historyRolesKey{}).([]string)
+	return roles
+}
+
+// ChatMessage is a single message of the conversation sent to a provider
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// chatMessages builds the conversation sent for a Complete call: the system prompt, the history
+// as turns by the roles set with WithHistoryRoles, assistant by default, and the prompt as the
+// final user turn
+func chatMessages(ctx context.Context, prompt string, systemPrompt string, history []string) []ChatMessage {
+	roles := HistoryRolesFromContext(ctx)
+	messages := make([]ChatMessage, 0, len(history)+2)
+	messages = append(messages, ChatMessage{Role: "system", Content: systemPrompt})
+	for i, msg := range history {
+		role := "assistant"
+		if i < len(roles) && roles[i] != "" {
+			role = roles[i]
+		}
+		messages = append(messages, ChatMessage{Role: role, Content: msg})
+	}
+	return append(messages, ChatMessage{Role: "user", Content: prompt})
+}
+
 type ProviderParams struct {
-	BaseURL string
-	APIKey  string
+	BaseURL      string
+	APIKey       string
+	Organization string
+	Project      string
+	HTTPClient   *http.Client
+	RateLimit    int           // maximum requests per minute; 0 means unlimited
+	Timeout      time.Duration // maximum duration of a single request; 0 means no limit
+	// EmbeddingModel is the model used by Embed; empty means the provider's default
+	EmbeddingModel string
 }
 
 type ProviderOption func(*ProviderParams)
@@ -18,3 +269,47 @@ func WithAPIKey(apiKey string) ProviderOption {
 		p.APIKey = apiKey
 	}
 }
+
+// WithOrganization sets the organization used for billing attribution (OpenAI-Organization header)
+func WithOrganization(id string) ProviderOption {
+	return func(p *ProviderParams) {
+		p.Organization = id
+	}
+}
+
+// WithProject sets the project used for billing attribution (OpenAI-Project header)
+func WithProject(id string) ProviderOption {
+	return func(p *ProviderParams) {
+		p.Project = id
+	}
+}
+
+// WithRateLimit limits the provider to rpm requests per minute, blocking calls until the
+// budget allows them instead of letting the provider reject them with 429s
+func WithRateLimit(rpm int) ProviderOption {
+	return func(p *ProviderParams) {
+		p.RateLimit = rpm
+	}
+}
+
+// WithRequestTimeout bounds each request to the provider to d. A request that runs longer
+// fails with a *TimeoutError.
+func WithRequestTimeout(d time.Duration) ProviderOption {
+	return func(p *ProviderParams) {
+		p.Timeout = d
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to reach the provider, e.g. to add a proxy or a custom transport
+func WithHTTPClient(client *http.Client) ProviderOption {
+	return func(p *ProviderParams) {
+		p.HTTPClient = client
+	}
+}
+
+// WithEmbeddingModel sets the model the provider uses to compute embeddings
+func WithEmbeddingModel(model string) ProviderOption {
+	return func(p *ProviderParams) {
+		p.EmbeddingModel = model
+	}
+}
@@ -0,0 +1,292 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// embedStub is a stubClient that also embeds each text as a vector holding its length
+type embedStub struct {
+	stubClient
+	embedded int
+}
+
+func (s *embedStub) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	s.embedded++
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = []float32{float32(len(text))}
+	}
+	return vectors, nil
+}
+
+// toolStub is a stubClient whose models call every tool once. Its first failures completions fail
+// with a server error, before calling the tools or, with failAfterTools, after calling them.
+type toolStub struct {
+	stubClient
+	failures       int
+	failAfterTools bool
+	completions    int
+}
+
+func (s *toolStub) CompleteWithTools(ctx context.Context, model string, prompt string, systemPrompt string, history []string, tools []Tool) (Completion, error) {
+	s.completions++
+	failing := s.completions <= s.failures
+	if failing && !s.failAfterTools {
+		return Completion{}, apiError(http.StatusServiceUnavailable)
+	}
+	completion := Completion{Text: "done", Usage: Usage{PromptTokens: 1}}
+	for _, tool := range tools {
+		result := runTool(ctx, tools, tool.Name, "{}")
+		completion.ToolCalls = append(completion.ToolCalls, ToolCall{Name: tool.Name, Arguments: "{}", Result: result})
+	}
+	if failing {
+		return Completion{}, apiError(http.StatusServiceUnavailable)
+	}
+	return completion, nil
+}
+
+// streamStub is a stubClient that streams its response one word at a time
+type streamStub struct {
+	stubClient
+	streams int
+}
+
+func (s *streamStub) CompleteStream(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (*Stream, error) {
+	s.streams++
+	stream, deltas := newStream()
+	stream.usage = Usage{PromptTokens: 2, CompletionTokens: 3, TotalTokens: 5}
+	go func() {
+		defer close(deltas)
+		for _, word := range strings.SplitAfter(s.response, " ") {
+			select {
+			case deltas <- word:
+			case <-ctx.Done():
+				stream.err = ctx.Err()
+				return
+			}
+		}
+	}()
+	return stream, nil
+}
+
+// cliStack wraps client in the decorators the CLI wraps its provider in, from the inside out
+func cliStack(client Client, tracker *UsageTracker, budget *CallBudget, record io.Writer) Client {
+	client = WithFallback(NewRateLimitedClient(NewTimeoutClient(client, time.Minute), 60))
+	client = budget.Wrap(tracker.Wrap(client))
+	return NewRecordingClient(NewRetryingClient(client), record)
+}
+
+// decorators wraps client in each of the package's client decorators, and in a stack of them as
+// the CLI builds
+func decorators(client Client) map[string]Client {
+	return map[string]Client{
+		"retrying":     NewRetryingClient(client),
+		"fallback":     WithFallback(client),
+		"usage":        NewUsageTracker().Wrap(client),
+		"budget":       NewCallBudget(10).Wrap(client),
+		"rate limited": NewRateLimitedClient(client, 60),
+		"timeout":      NewTimeoutClient(client, time.Minute),
+		"recording":    NewRecordingClient(client, io.Discard),
+		"stacked":      cliStack(client, NewUsageTracker(), NewCallBudget(10), io.Discard),
+	}
+}
+
+// echoTool returns a tool that counts its runs in runs
+func echoTool(runs *int) Tool {
+	return Tool{
+		Name: "echo",
+		Handler: func(ctx context.Context, arguments string) (string, error) {
+			*runs++
+			return arguments, nil
+		},
+	}
+}
+
+func TestDecorators(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test embed is forwarded through every decorator", func(t *testing.T) {
+		for name, decorated := range decorators(&embedStub{}) {
+			embedder, ok := decorated.(Embedder)
+			if !ok {
+				t.Errorf("%s client doesn't implement Embedder", name)
+				continue
+			}
+			vectors, err := embedder.Embed(ctx, []string{"a", "abc"})
+			if err != nil {
+				t.Errorf("%s client failed to embed: %v", name, err)
+				continue
+			}
+			if got := fmt.Sprint(vectors); got != "[[1] [3]]" {
+				t.Errorf("%s client embedded %s, want [[1] [3]]", name, got)
+			}
+		}
+	})
+
+	t.Run("test embed fails for a client without embeddings", func(t *testing.T) {
+		var calls []string
+		for name, decorated := range decorators(&stubClient{calls: &calls}) {
+			if _, err := Embed(ctx, decorated, []string{"a"}); !errors.Is(err, ErrEmbeddingsUnsupported) {
+				t.Errorf("%s client err = %v, want ErrEmbeddingsUnsupported", name, err)
+			}
+		}
+	})
+
+	t.Run("test tools are forwarded through every decorator", func(t *testing.T) {
+		for name, decorated := range decorators(&toolStub{}) {
+			var runs int
+			completion, err := CompleteWithTools(ctx, decorated, "model", "prompt", "", nil, []Tool{echoTool(&runs)})
+			if err != nil {
+				t.Errorf("%s client failed to complete with tools: %v", name, err)
+				continue
+			}
+			if completion.Text != "done" || len(completion.ToolCalls) != 1 || runs != 1 {
+				t.Errorf("%s client completed %+v with %d tool runs, want one run", name, completion, runs)
+			}
+		}
+	})
+
+	t.Run("test tools fail for a client without tools", func(t *testing.T) {
+		var calls []string
+		for name, decorated := range decorators(&stubClient{calls: &calls}) {
+			if _, err := CompleteWithTools(ctx, decorated, "model", "prompt", "", nil, nil); !errors.Is(err, ErrToolsUnsupported) {
+				t.Errorf("%s client err = %v, want ErrToolsUnsupported", name, err)
+			}
+		}
+	})
+
+	t.Run("test completions with tools are only retried before a tool runs", func(t *testing.T) {
+		var runs int
+		before := &toolStub{failures: 1}
+		client := NewRetryingClient(before, WithBaseDelay(time.Millisecond))
+		if _, err := client.CompleteWithTools(ctx, "model", "prompt", "", nil, []Tool{echoTool(&runs)}); err != nil {
+			t.Fatalf("Expected the completion to succeed after a retry, got %v", err)
+		}
+		if before.completions != 2 || runs != 1 {
+			t.Errorf("got %d completions and %d tool runs, want 2 and 1", before.completions, runs)
+		}
+
+		runs = 0
+		after := &toolStub{failures: 1, failAfterTools: true}
+		client = NewRetryingClient(after, WithBaseDelay(time.Millisecond))
+		if _, err := client.CompleteWithTools(ctx, "model", "prompt", "", nil, []Tool{echoTool(&runs)}); err == nil {
+			t.Error("Expected the failure to be returned once a tool ran")
+		}
+		if after.completions != 1 || runs != 1 {
+			t.Errorf("got %d completions and %d tool runs, want 1 and 1", after.completions, runs)
+		}
+	})
+
+	t.Run("test fallback completes with tools only before a tool runs", func(t *testing.T) {
+		var calls []string
+		var runs int
+		fallback := &toolStub{}
+		client := WithFallback(&stubClient{calls: &calls}, &toolStub{failures: 1, failAfterTools: true}, fallback).(ToolClient)
+		if _, err := client.CompleteWithTools(ctx, "model", "prompt", "", nil, []Tool{echoTool(&runs)}); err == nil {
+			t.Error("Expected the failure to be returned once a tool ran")
+		}
+		if fallback.completions != 0 || runs != 1 {
+			t.Errorf("fallback completed %d times with %d tool runs, want 0 and 1", fallback.completions, runs)
+		}
+	})
+
+	t.Run("test usage of completions with tools is tracked", func(t *testing.T) {
+		tracker := NewUsageTracker()
+		if _, err := CompleteWithTools(ctx, tracker.Wrap(&toolStub{}), "model", "prompt", "", nil, nil); err != nil {
+			t.Fatalf("Failed to complete with tools: %v", err)
+		}
+		if tracker.Calls() != 1 || tracker.Usage().PromptTokens != 1 {
+			t.Errorf("tracked %d calls using %+v, want 1 call", tracker.Calls(), tracker.Usage())
+		}
+	})
+
+	t.Run("test streams are forwarded through every decorator", func(t *testing.T) {
+		for name, decorated := range decorators(&streamStub{stubClient: stubClient{response: "Hello there, world!"}}) {
+			if _, ok := decorated.(StreamingClient); !ok {
+				t.Errorf("%s client doesn't implement StreamingClient", name)
+				continue
+			}
+			stream, err := CompleteStream(ctx, decorated, "model", "prompt", "", nil)
+			if err != nil {
+				t.Errorf("%s client failed to stream: %v", name, err)
+				continue
+			}
+			var deltas []string
+			for delta := range stream.Deltas {
+				deltas = append(deltas, delta)
+			}
+			if len(deltas) != 3 || stream.Err() != nil {
+				t.Errorf("%s client streamed %q with error %v, want 3 deltas", name, deltas, stream.Err())
+			}
+		}
+	})
+
+	t.Run("test streams through the CLI decorator stack are accounted for", func(t *testing.T) {
+		client := &streamStub{stubClient: stubClient{response: "Hello there, world!"}}
+		tracker := NewUsageTracker()
+		budget := NewCallBudget(1)
+		var record bytes.Buffer
+		stack := cliStack(client, tracker, budget, &record)
+
+		stream, err := CompleteStream(ctx, stack, "model", "prompt", "", nil)
+		if err != nil {
+			t.Fatalf("Failed to stream: %v", err)
+		}
+		if budget.Used() != 1 {
+			t.Errorf("budget used %d calls once the stream started, want 1", budget.Used())
+		}
+		var text strings.Builder
+		for delta := range stream.Deltas {
+			text.WriteString(delta)
+		}
+		if client.streams != 1 || text.String() != "Hello there, world!" {
+			t.Errorf("streamed %q in %d streams, want the response in 1", text.String(), client.streams)
+		}
+		if want := (Usage{PromptTokens: 2, CompletionTokens: 3, TotalTokens: 5}); tracker.Calls() != 1 || tracker.Usage() != want || stream.Usage() != want {
+			t.Errorf("tracked %d calls using %+v, stream used %+v, want 1 call using %+v", tracker.Calls(), tracker.Usage(), stream.Usage(), want)
+		}
+		var call CallRecord
+		if err := json.Unmarshal(record.Bytes(), &call); err != nil {
+			t.Fatalf("Failed to parse call record %q: %v", record.String(), err)
+		}
+		if call.Response != "Hello there, world!" || call.Error != "" {
+			t.Errorf("recorded response %q with error %q, want the streamed response", call.Response, call.Error)
+		}
+
+		if _, err := CompleteStream(ctx, stack, "model", "prompt", "", nil); !errors.Is(err, ErrCallBudgetExhausted) {
+			t.Errorf("err = %v, want ErrCallBudgetExhausted", err)
+		}
+	})
+
+	t.Run("test fallback embeds with the first provider that supports it", func(t *testing.T) {
+		var calls []string
+		embedder := &embedStub{}
+		client := WithFallback(&stubClient{calls: &calls}, embedder).(Embedder)
+		if _, err := client.Embed(ctx, []string{"a"}); err != nil {
+			t.Fatalf("Failed to embed: %v", err)
+		}
+		if embedder.embedded != 1 {
+			t.Errorf("fallback embedder was called %d times, want 1", embedder.embedded)
+		}
+	})
+
+	t.Run("test budget counts embeddings", func(t *testing.T) {
+		budget := NewCallBudget(1)
+		client := budget.Wrap(&embedStub{})
+		if _, err := Embed(ctx, client, []string{"a"}); err != nil {
+			t.Fatalf("Failed to embed: %v", err)
+		}
+		if _, err := Embed(ctx, client, []string{"a"}); !errors.Is(err, ErrCallBudgetExhausted) {
+			t.Errorf("err = %v, want ErrCallBudgetExhausted", err)
+		}
+	})
+}
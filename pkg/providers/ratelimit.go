@@ -0,0 +1,117 @@
+package providers
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket holding up to a minute's worth of requests, refilled continuously
+type rateLimiter struct {
+	capacity float64
+	interval time.Duration // time to refill one token
+	tokens   float64
+	last     time.Time
+	now      func() time.Time
+	mu       sync.Mutex
+}
+
+// newRateLimiter returns a limiter allowing rpm requests per minute, or nil if rpm isn't positive
+func newRateLimiter(rpm int) *rateLimiter {
+	if rpm <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		capacity: float64(rpm),
+		interval: time.Minute / time.Duration(rpm),
+		tokens:   float64(rpm),
+		last:     time.Now(),
+		now:      time.Now,
+	}
+}
+
+// wait blocks until a request may be made or ctx is done. A nil limiter never blocks.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		delay := l.reserve()
+		if delay == 0 {
+			return nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve takes a token if one is available and returns 0, otherwise it returns how long
+// until the next token is added
+func (l *rateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.tokens = min(l.capacity, l.tokens+float64(now.Sub(l.last))/float64(l.interval))
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) * float64(l.interval))
+}
+
+// RateLimitedClient wraps a Client and blocks calls that would exceed a requests-per-minute budget
+type RateLimitedClient struct {
+	client  Client
+	limiter *rateLimiter
+}
+
+// NewRateLimitedClient returns a client that makes at most rpm calls per minute through client.
+// Up to rpm calls can be made at once before calls start waiting for the bucket to refill.
+func NewRateLimitedClient(client Client, rpm int) *RateLimitedClient {
+	return &RateLimitedClient{
+		client:  client,
+		limiter: newRateLimiter(rpm),
+	}
+}
+
+func (c *RateLimitedClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	completion, err := c.CompleteDetailed(ctx, model, prompt, systemPrompt, history)
+	return completion.Text, err
+}
+
+func (c *RateLimitedClient) CompleteDetailed(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (Completion, error) {
+	if err := c.limiter.wait(ctx); err != nil {
+		return Completion{}, err
+	}
+	return CompleteDetailed(ctx, c.client, model, prompt, systemPrompt, history)
+}
+
+// CompleteWithTools waits for a single call, however many responses the model needs
+func (c *RateLimitedClient) CompleteWithTools(ctx context.Context, model string, prompt string, systemPrompt string, history []string, tools []Tool) (Completion, error) {
+	if err := c.limiter.wait(ctx); err != nil {
+		return Completion{}, err
+	}
+	return CompleteWithTools(ctx, c.client, model, prompt, systemPrompt, history, tools)
+}
+
+// CompleteStream waits before the stream starts
+func (c *RateLimitedClient) CompleteStream(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (*Stream, error) {
+	if err := c.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	return CompleteStream(ctx, c.client, model, prompt, systemPrompt, history)
+}
+
+func (c *RateLimitedClient) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if err := c.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	return Embed(ctx, c.client, texts)
+}
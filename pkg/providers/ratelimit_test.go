@@ -0,0 +1,59 @@
+package providers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// countingClient implements Client and counts its calls
+type countingClient struct {
+	calls int
+}
+
+func (c *countingClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	c.calls++
+	return "ANSWER: 1", nil
+}
+
+func TestRateLimitedClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test calls beyond the budget wait for a token", func(t *testing.T) {
+		inner := &countingClient{}
+		client := NewRateLimitedClient(inner, 600) // one token every 100ms
+		for i := 0; i < 600; i++ {
+			if _, err := client.Complete(ctx, "model", "prompt", "", nil); err != nil {
+				t.Fatalf("call %d failed: %v", i, err)
+			}
+		}
+
+		start := time.Now()
+		if _, err := client.Complete(ctx, "model", "prompt", "", nil); err != nil {
+			t.Fatalf("Failed to complete call: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Errorf("call after the bucket emptied returned after %v, expected it to wait for a token", elapsed)
+		}
+		if inner.calls != 601 {
+			t.Errorf("calls = %d, want 601", inner.calls)
+		}
+	})
+
+	t.Run("test waiting call is cancelled with its context", func(t *testing.T) {
+		inner := &countingClient{}
+		client := NewRateLimitedClient(inner, 1)
+		if _, err := client.Complete(ctx, "model", "prompt", "", nil); err != nil {
+			t.Fatalf("Failed to complete first call: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		if _, err := client.Complete(ctx, "model", "prompt", "", nil); err != context.DeadlineExceeded {
+			t.Errorf("err = %v, want context.DeadlineExceeded", err)
+		}
+		if inner.calls != 1 {
+			t.Errorf("calls = %d, want 1", inner.calls)
+		}
+	})
+}
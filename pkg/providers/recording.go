@@ -0,0 +1,105 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// CallRecord is the exact conversation sent for one Complete call and its outcome
+type CallRecord struct {
+	Timestamp    time.Time     `json:"timestamp"`
+	Model        string        `json:"model"`
+	Messages     []ChatMessage `json:"messages"`
+	Response     string        `json:"response,omitempty"`
+	FinishReason string        `json:"finish_reason,omitempty"`
+	Error        string        `json:"error,omitempty"`
+}
+
+// RecordingClient wraps a Client and writes a CallRecord as a JSON line for every Complete call
+type RecordingClient struct {
+	client Client
+	enc    *json.Encoder
+	mu     sync.Mutex
+}
+
+// NewRecordingClient returns a client that records every call made through client to w as JSONL
+func NewRecordingClient(client Client, w io.Writer) *RecordingClient {
+	return &RecordingClient{
+		client: client,
+		enc:    json.NewEncoder(w),
+	}
+}
+
+func (c *RecordingClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	completion, err := c.CompleteDetailed(ctx, model, prompt, systemPrompt, history)
+	return completion.Text, err
+}
+
+func (c *RecordingClient) CompleteDetailed(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (Completion, error) {
+	return c.record(ctx, model, prompt, systemPrompt, history, func() (Completion, error) {
+		return CompleteDetailed(ctx, c.client, model, prompt, systemPrompt, history)
+	})
+}
+
+// CompleteWithTools records the conversation and the model's final answer; the tool calls made
+// along the way are in the returned completion
+func (c *RecordingClient) CompleteWithTools(ctx context.Context, model string, prompt string, systemPrompt string, history []string, tools []Tool) (Completion, error) {
+	return c.record(ctx, model, prompt, systemPrompt, history, func() (Completion, error) {
+		return CompleteWithTools(ctx, c.client, model, prompt, systemPrompt, history, tools)
+	})
+}
+
+// CompleteStream records the conversation and the streamed response once the stream has ended
+func (c *RecordingClient) CompleteStream(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (*Stream, error) {
+	record := newCallRecord(ctx, model, prompt, systemPrompt, history)
+	stream, err := CompleteStream(ctx, c.client, model, prompt, systemPrompt, history)
+	if err != nil {
+		c.write(record, Completion{}, err)
+		return nil, err
+	}
+	return stream.relay(ctx, func(completion Completion, err error) error {
+		c.write(record, completion, err)
+		return err
+	}), nil
+}
+
+// record makes a completion with complete and writes its CallRecord
+func (c *RecordingClient) record(ctx context.Context, model string, prompt string, systemPrompt string, history []string, complete func() (Completion, error)) (Completion, error) {
+	record := newCallRecord(ctx, model, prompt, systemPrompt, history)
+	completion, err := complete()
+	c.write(record, completion, err)
+	return completion, err
+}
+
+// newCallRecord starts the CallRecord of a call made now
+func newCallRecord(ctx context.Context, model string, prompt string, systemPrompt string, history []string) CallRecord {
+	return CallRecord{
+		Timestamp: time.Now(),
+		Model:     model,
+		Messages:  chatMessages(ctx, prompt, systemPrompt, history),
+	}
+}
+
+// write completes record with the call's outcome and writes it
+func (c *RecordingClient) write(record CallRecord, completion Completion, err error) {
+	record.Response = completion.Text
+	record.FinishReason = completion.FinishReason
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if encErr := c.enc.Encode(record); encErr != nil {
+		log.Printf("Warning: Failed to record provider call: %v", encErr)
+	}
+}
+
+// Embed forwards to the wrapped client without recording, since only completions are recorded
+func (c *RecordingClient) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return Embed(ctx, c.client, texts)
+}
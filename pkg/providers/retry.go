@@ -0,0 +1,165 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/openai/openai-go"
+	"google.golang.org/genai"
+)
+
+// RetryingClient wraps a Client and retries calls that fail with a rate limit or server error,
+// waiting an exponentially growing, jittered delay between attempts
+type RetryingClient struct {
+	client     Client
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+}
+
+// RetryOption configures a RetryingClient
+type RetryOption func(*RetryingClient)
+
+// WithMaxRetries sets how many times a failed call is retried. The default is 3.
+func WithMaxRetries(n int) RetryOption {
+	return func(c *RetryingClient) {
+		c.maxRetries = n
+	}
+}
+
+// WithBaseDelay sets the delay before the first retry, which doubles on every further retry.
+// The default is 1 second.
+func WithBaseDelay(d time.Duration) RetryOption {
+	return func(c *RetryingClient) {
+		c.baseDelay = d
+	}
+}
+
+// WithMaxDelay caps the delay between retries. The default is 30 seconds.
+func WithMaxDelay(d time.Duration) RetryOption {
+	return func(c *RetryingClient) {
+		c.maxDelay = d
+	}
+}
+
+// NewRetryingClient returns a client that retries client's transient failures
+func NewRetryingClient(client Client, opts ...RetryOption) *RetryingClient {
+	c := &RetryingClient{
+		client:     client,
+		maxRetries: 3,
+		baseDelay:  time.Second,
+		maxDelay:   30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *RetryingClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	completion, err := c.CompleteDetailed(ctx, model, prompt, systemPrompt, history)
+	return completion.Text, err
+}
+
+func (c *RetryingClient) CompleteDetailed(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (Completion, error) {
+	return retry(ctx, c, func() (Completion, error) {
+		return CompleteDetailed(ctx, c.client, model, prompt, systemPrompt, history)
+	})
+}
+
+// CompleteStream retries starting the stream; once it has started, a failure ends the stream
+func (c *RetryingClient) CompleteStream(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (*Stream, error) {
+	return retry(ctx, c, func() (*Stream, error) {
+		return CompleteStream(ctx, c.client, model, prompt, systemPrompt, history)
+	})
+}
+
+// CompleteWithTools retries a failed completion only if none of its tool calls ran, so a tool's
+// side effects never happen twice
+func (c *RetryingClient) CompleteWithTools(ctx context.Context, model string, prompt string, systemPrompt string, history []string, tools []Tool) (Completion, error) {
+	tracked, ran := trackToolRuns(tools)
+	retryable := func(err error) bool {
+		return !ran.Load() && isRetryable(err)
+	}
+	return retryIf(ctx, c, retryable, func() (Completion, error) {
+		return CompleteWithTools(ctx, c.client, model, prompt, systemPrompt, history, tracked)
+	})
+}
+
+func (c *RetryingClient) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return retry(ctx, c, func() ([][]float32, error) {
+		return Embed(ctx, c.client, texts)
+	})
+}
+
+// retry calls call until it succeeds, fails with an error that isn't retryable or has been
+// retried c.maxRetries times, backing off between attempts
+func retry[T any](ctx context.Context, c *RetryingClient, call func() (T, error)) (T, error) {
+	return retryIf(ctx, c, isRetryable, call)
+}
+
+// retryIf is retry with retryable deciding which errors are retried
+func retryIf[T any](ctx context.Context, c *RetryingClient, retryable func(error) bool, call func() (T, error)) (T, error) {
+	for attempt := 0; ; attempt++ {
+		result, err := call()
+		if err == nil || attempt >= c.maxRetries || !retryable(err) {
+			return result, err
+		}
+
+		delay := c.backoff(attempt)
+		log.Printf("Provider call failed, retrying in %v (attempt %d/%d): %v", delay, attempt+1, c.maxRetries, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			var zero T
+			return zero, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the delay before retry attempt+1: the base delay doubled attempt times,
+// capped at maxDelay, with up to half of it replaced by jitter so agents don't retry in lockstep
+func (c *RetryingClient) backoff(attempt int) time.Duration {
+	delay := c.baseDelay << attempt
+	if delay <= 0 || delay > c.maxDelay {
+		delay = c.maxDelay
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// isRetryable reports whether err is a rate limit or server error that may succeed if retried,
+// from any of the providers in this package
+func isRetryable(err error) bool {
+	var openaiErr *openai.Error
+	if errors.As(err, &openaiErr) {
+		return retryableStatus(openaiErr.StatusCode)
+	}
+	var geminiClientErr genai.ClientError
+	if errors.As(err, &geminiClientErr) {
+		return retryableStatus(geminiClientErr.Code)
+	}
+	var geminiServerErr genai.ServerError
+	if errors.As(err, &geminiServerErr) {
+		return true
+	}
+	var ollamaErr *OllamaError
+	if errors.As(err, &ollamaErr) {
+		return retryableStatus(ollamaErr.StatusCode)
+	}
+	return false
+}
+
+// retryableStatus reports whether an HTTP status is a rate limit or server error
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
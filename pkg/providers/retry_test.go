@@ -0,0 +1,142 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// flakyClient implements Client, failing with an API error of the given status until it has been called failures times
+type flakyClient struct {
+	failures int
+	status   int
+	calls    int
+}
+
+func (f *flakyClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return "", apiError(f.status)
+	}
+	return "ANSWER: 3", nil
+}
+
+// apiError builds an OpenAI API error with the given status code
+func apiError(status int) *openai.Error {
+	return &openai.Error{
+		StatusCode: status,
+		Request:    httptest.NewRequest(http.MethodPost, "https://api.openai.com/v1/chat/completions", nil),
+		Response:   &http.Response{StatusCode: status},
+	}
+}
+
+func TestRetryingClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test flaky client eventually succeeds", func(t *testing.T) {
+		flaky := &flakyClient{failures: 2, status: http.StatusTooManyRequests}
+		client := NewRetryingClient(flaky, WithMaxRetries(3), WithBaseDelay(time.Millisecond))
+
+		response, err := client.Complete(ctx, "gpt-4o-mini", "prompt", "", nil)
+		if err != nil {
+			t.Fatalf("Expected the call to succeed after retries, got %v", err)
+		}
+		if response != "ANSWER: 3" {
+			t.Errorf("response = %q, want %q", response, "ANSWER: 3")
+		}
+		if flaky.calls != 3 {
+			t.Errorf("calls = %d, want 3", flaky.calls)
+		}
+	})
+
+	t.Run("test client errors are not retried", func(t *testing.T) {
+		flaky := &flakyClient{failures: 1, status: http.StatusBadRequest}
+		client := NewRetryingClient(flaky, WithBaseDelay(time.Millisecond))
+
+		if _, err := client.Complete(ctx, "gpt-4o-mini", "prompt", "", nil); err == nil {
+			t.Error("Expected the bad request error to be returned")
+		}
+		if flaky.calls != 1 {
+			t.Errorf("calls = %d, want 1", flaky.calls)
+		}
+	})
+
+	t.Run("test Gemini rate limit and server errors are retried", func(t *testing.T) {
+		for status, wantRequests := range map[int]int{
+			http.StatusTooManyRequests:     3,
+			http.StatusServiceUnavailable:  3,
+			http.StatusInternalServerError: 3,
+			http.StatusBadRequest:          1,
+		} {
+			transport := &fakeTransport{
+				status: status,
+				body:   fmt.Sprintf(`{"error": {"code": %d, "message": "failed", "status": %q}}`, status, http.StatusText(status)),
+			}
+			gemini, err := Gemini(ctx, WithAPIKey("test-key"), WithHTTPClient(&http.Client{Transport: transport}))
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+			client := NewRetryingClient(gemini, WithMaxRetries(2), WithBaseDelay(time.Millisecond))
+
+			if _, err := client.Complete(ctx, "gemini-1.5-pro", "prompt", "", nil); err == nil {
+				t.Errorf("status %d: expected the error to be returned", status)
+			}
+			if transport.requests != wantRequests {
+				t.Errorf("status %d: requests = %d, want %d", status, transport.requests, wantRequests)
+			}
+		}
+	})
+
+	t.Run("test Ollama rate limit and server errors are retried", func(t *testing.T) {
+		for _, tc := range []struct {
+			status       int
+			body         string
+			wantRequests int
+		}{
+			{http.StatusTooManyRequests, `{"error": "too many requests"}`, 3},
+			{http.StatusServiceUnavailable, `{"error": "server busy"}`, 3},
+			{http.StatusBadGateway, "Bad Gateway", 3},
+			{http.StatusNotFound, `{"error": "model 'llama3.2' not found"}`, 1},
+		} {
+			var requests int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.body)
+			}))
+			ollama, err := Ollama(ctx, WithBaseURL(server.URL+"/"))
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+			client := NewRetryingClient(ollama, WithMaxRetries(2), WithBaseDelay(time.Millisecond))
+
+			if _, err := client.Complete(ctx, "llama3.2", "prompt", "", nil); err == nil {
+				t.Errorf("status %d: expected the error to be returned", tc.status)
+			}
+			if requests != tc.wantRequests {
+				t.Errorf("status %d: requests = %d, want %d", tc.status, requests, tc.wantRequests)
+			}
+			server.Close()
+		}
+	})
+
+	t.Run("test cancellation stops retrying", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		flaky := &flakyClient{failures: 10, status: http.StatusServiceUnavailable}
+		client := NewRetryingClient(flaky, WithMaxRetries(10), WithBaseDelay(time.Second))
+
+		start := time.Now()
+		if _, err := client.Complete(ctx, "gpt-4o-mini", "prompt", "", nil); err != context.DeadlineExceeded {
+			t.Errorf("err = %v, want context.DeadlineExceeded", err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("retry wait ignored cancellation, took %v", elapsed)
+		}
+	})
+}
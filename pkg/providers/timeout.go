@@ -0,0 +1,95 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TimeoutError is returned when a provider call runs past the timeout set with WithRequestTimeout.
+// It unwraps to context.DeadlineExceeded.
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("provider request timed out after %v", e.Timeout)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// timeoutContext derives the context for a single call, bounded by timeout if it is positive
+func timeoutContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// timeoutError reports err as a *TimeoutError if the call's own deadline expired while the
+// caller's context was still live, and returns it unchanged otherwise
+func timeoutError(parent, callCtx context.Context, err error, timeout time.Duration) error {
+	if err == nil || timeout <= 0 || parent.Err() != nil || callCtx.Err() != context.DeadlineExceeded {
+		return err
+	}
+	return &TimeoutError{Timeout: timeout}
+}
+
+// TimeoutClient wraps a Client and fails calls that take longer than a fixed timeout
+type TimeoutClient struct {
+	client  Client
+	timeout time.Duration
+}
+
+// NewTimeoutClient returns a client that gives each call through client at most timeout to
+// complete. A timeout that isn't positive disables the limit.
+func NewTimeoutClient(client Client, timeout time.Duration) *TimeoutClient {
+	return &TimeoutClient{
+		client:  client,
+		timeout: timeout,
+	}
+}
+
+func (c *TimeoutClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	completion, err := c.CompleteDetailed(ctx, model, prompt, systemPrompt, history)
+	return completion.Text, err
+}
+
+func (c *TimeoutClient) CompleteDetailed(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (Completion, error) {
+	callCtx, cancel := timeoutContext(ctx, c.timeout)
+	defer cancel()
+	completion, err := CompleteDetailed(callCtx, c.client, model, prompt, systemPrompt, history)
+	return completion, timeoutError(ctx, callCtx, err, c.timeout)
+}
+
+// CompleteWithTools gives the whole completion, including its tool calls, the timeout
+func (c *TimeoutClient) CompleteWithTools(ctx context.Context, model string, prompt string, systemPrompt string, history []string, tools []Tool) (Completion, error) {
+	callCtx, cancel := timeoutContext(ctx, c.timeout)
+	defer cancel()
+	completion, err := CompleteWithTools(callCtx, c.client, model, prompt, systemPrompt, history, tools)
+	return completion, timeoutError(ctx, callCtx, err, c.timeout)
+}
+
+// CompleteStream gives the whole stream, from its start until its last delta, the timeout
+func (c *TimeoutClient) CompleteStream(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (*Stream, error) {
+	callCtx, cancel := timeoutContext(ctx, c.timeout)
+	stream, err := CompleteStream(callCtx, c.client, model, prompt, systemPrompt, history)
+	if err != nil {
+		cancel()
+		return nil, timeoutError(ctx, callCtx, err, c.timeout)
+	}
+	return stream.relay(ctx, func(completion Completion, err error) error {
+		err = timeoutError(ctx, callCtx, err, c.timeout)
+		cancel()
+		return err
+	}), nil
+}
+
+func (c *TimeoutClient) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	callCtx, cancel := timeoutContext(ctx, c.timeout)
+	defer cancel()
+	vectors, err := Embed(callCtx, c.client, texts)
+	return vectors, timeoutError(ctx, callCtx, err, c.timeout)
+}
@@ -0,0 +1,79 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test slow calls fail with a timeout error", func(t *testing.T) {
+		client := NewTimeoutClient(NewMockClient(WithMockLatency(time.Second)), 20*time.Millisecond)
+
+		start := time.Now()
+		_, err := client.Complete(ctx, "model", "prompt", "", nil)
+		var timeoutErr *TimeoutError
+		if !errors.As(err, &timeoutErr) {
+			t.Fatalf("err = %v, want a *TimeoutError", err)
+		}
+		if timeoutErr.Timeout != 20*time.Millisecond {
+			t.Errorf("timeout = %v, want 20ms", timeoutErr.Timeout)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("err = %v, want it to match context.DeadlineExceeded", err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("call returned after %v, expected it to stop at the timeout", elapsed)
+		}
+	})
+
+	t.Run("test fast calls are unaffected", func(t *testing.T) {
+		client := NewTimeoutClient(NewMockClient(), 20*time.Millisecond)
+		response, err := client.Complete(ctx, "model", "prompt", "", nil)
+		if err != nil {
+			t.Fatalf("Failed to complete call: %v", err)
+		}
+		if response != DefaultMockResponse {
+			t.Errorf("response = %q, want %q", response, DefaultMockResponse)
+		}
+	})
+
+	t.Run("test caller deadlines are not reported as request timeouts", func(t *testing.T) {
+		client := NewTimeoutClient(NewMockClient(WithMockLatency(time.Second)), time.Minute)
+		callerCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+
+		_, err := client.Complete(callerCtx, "model", "prompt", "", nil)
+		var timeoutErr *TimeoutError
+		if errors.As(err, &timeoutErr) {
+			t.Errorf("err = %v, want the caller's context error", err)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("err = %v, want context.DeadlineExceeded", err)
+		}
+	})
+
+	t.Run("test provider option bounds each request", func(t *testing.T) {
+		server := newStubServer(t, `{"message":{"role":"assistant","content":"ANSWER: 4"},"done":true}`,
+			func(r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(time.Second):
+				}
+			})
+		client, err := Ollama(ctx, WithBaseURL(server.URL), WithRequestTimeout(20*time.Millisecond))
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+
+		_, err = client.Complete(ctx, "llama3.2", "prompt", "", nil)
+		var timeoutErr *TimeoutError
+		if !errors.As(err, &timeoutErr) {
+			t.Fatalf("err = %v, want a *TimeoutError", err)
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// Tool is a function a model can call while completing
+type Tool struct {
+	Name        string
+	Description string
+	// Parameters is the JSON schema of the tool's arguments object; nil means it takes none
+	Parameters map[string]any
+	// Handler runs the tool with the arguments the model gave, a JSON object, and returns the
+	// result sent back to the model
+	Handler func(ctx context.Context, arguments string) (string, error)
+}
+
+// ToolCall is a call a model made to a tool and the result it was sent back
+type ToolCall struct {
+	ID        string
+	Name      string
+	Arguments string
+	Result    string
+}
+
+// ToolClient is a Client whose models can call tools. CompleteWithTools runs every tool call the
+// model makes and sends the results back until the model answers without calling one.
+type ToolClient interface {
+	Client
+	CompleteWithTools(ctx context.Context, model string, prompt string, systemPrompt string, history []string, tools []Tool) (Completion, error)
+}
+
+// maxToolRounds is how many responses in a row may call tools before a completion is given up on
+const maxToolRounds = 10
+
+// ErrToolsUnsupported is returned by CompleteWithTools for a client whose models can't call tools
+var ErrToolsUnsupported = errors.New("provider does not support tools")
+
+// CompleteWithTools calls client's CompleteWithTools if it has one, otherwise it fails with
+// ErrToolsUnsupported. The client decorators in this package implement CompleteWithTools with
+// it, so wrapping a client doesn't hide its tools.
+func CompleteWithTools(ctx context.Context, client Client, model string, prompt string, systemPrompt string, history []string, tools []Tool) (Completion, error) {
+	toolClient, ok := client.(ToolClient)
+	if !ok {
+		return Completion{}, fmt.Errorf("%w: %T", ErrToolsUnsupported, client)
+	}
+	return toolClient.CompleteWithTools(ctx, model, prompt, systemPrompt, history, tools)
+}
+
+// trackToolRuns returns tools with handlers that set ran when they are called. A completion that
+// fails before any tool ran can safely be sent again; one that ran a tool would run it twice.
+func trackToolRuns(tools []Tool) (tracked []Tool, ran *atomic.Bool) {
+	ran = new(atomic.Bool)
+	tracked = make([]Tool, len(tools))
+	for i, tool := range tools {
+		handler := tool.Handler
+		tool.Handler = func(ctx context.Context, arguments string) (string, error) {
+			ran.Store(true)
+			return handler(ctx, arguments)
+		}
+		tracked[i] = tool
+	}
+	return tracked, ran
+}
+
+// runTool runs the handler of the tool called name and returns its result. An unknown tool or a
+// failed handler is reported in the result, so the model can try something else.
+func runTool(ctx context.Context, tools []Tool, name string, arguments string) string {
+	for _, tool := range tools {
+		if tool.Name != name {
+			continue
+		}
+		result, err := tool.Handler(ctx, arguments)
+		if err != nil {
+			return fmt.Sprintf("error: %v", err)
+		}
+		return result
+	}
+	return fmt.Sprintf("error: unknown tool %q", name)
+}
@@ -0,0 +1,32 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTools(t *testing.T) {
+	ctx := context.Background()
+	tools := []Tool{{
+		Name: "fail",
+		Handler: func(ctx context.Context, arguments string) (string, error) {
+			return "", errors.New("pool is empty")
+		},
+	}}
+
+	t.Run("test tool errors are reported to the model", func(t *testing.T) {
+		if result := runTool(ctx, tools, "fail", "{}"); result != "error: pool is empty" {
+			t.Errorf("result = %q", result)
+		}
+		if result := runTool(ctx, tools, "missing", "{}"); result != `error: unknown tool "missing"` {
+			t.Errorf("result = %q", result)
+		}
+	})
+
+	t.Run("test providers without tools are rejected", func(t *testing.T) {
+		if _, err := CompleteWithTools(ctx, NewMockClient(), "mock", "prompt", "", nil, tools); err == nil {
+			t.Error("Expected an error from a provider that can't call tools")
+		}
+	})
+}
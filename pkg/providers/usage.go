@@ -0,0 +1,89 @@
+package providers
+
+import (
+	"context"
+	"sync"
+)
+
+// UsageTracker accumulates the token usage of every call made through the clients it wraps
+type UsageTracker struct {
+	usage Usage
+	calls int
+	mu    sync.Mutex
+}
+
+// NewUsageTracker creates a tracker with no usage recorded
+func NewUsageTracker() *UsageTracker {
+	return &UsageTracker{}
+}
+
+// Usage returns the total tokens used so far
+func (t *UsageTracker) Usage() Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.usage
+}
+
+// Calls returns the number of completed calls recorded so far
+func (t *UsageTracker) Calls() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.calls
+}
+
+func (t *UsageTracker) add(usage Usage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.usage = t.usage.Add(usage)
+	t.calls++
+}
+
+// Wrap returns a client that records the usage of its successful calls in the tracker
+func (t *UsageTracker) Wrap(client Client) Client {
+	return &usageClient{client: client, tracker: t}
+}
+
+type usageClient struct {
+	client  Client
+	tracker *UsageTracker
+}
+
+func (c *usageClient) Complete(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (string, error) {
+	completion, err := c.CompleteDetailed(ctx, model, prompt, systemPrompt, history)
+	return completion.Text, err
+}
+
+func (c *usageClient) CompleteDetailed(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (Completion, error) {
+	completion, err := CompleteDetailed(ctx, c.client, model, prompt, systemPrompt, history)
+	if err == nil {
+		c.tracker.add(completion.Usage)
+	}
+	return completion, err
+}
+
+func (c *usageClient) CompleteWithTools(ctx context.Context, model string, prompt string, systemPrompt string, history []string, tools []Tool) (Completion, error) {
+	completion, err := CompleteWithTools(ctx, c.client, model, prompt, systemPrompt, history, tools)
+	if err == nil {
+		c.tracker.add(completion.Usage)
+	}
+	return completion, err
+}
+
+// CompleteStream records the stream's usage once it has completed
+func (c *usageClient) CompleteStream(ctx context.Context, model string, prompt string, systemPrompt string, history []string) (*Stream, error) {
+	stream, err := CompleteStream(ctx, c.client, model, prompt, systemPrompt, history)
+	if err != nil {
+		return nil, err
+	}
+	return stream.relay(ctx, func(completion Completion, err error) error {
+		if err == nil {
+			c.tracker.add(completion.Usage)
+		}
+		return err
+	}), nil
+}
+
+// Embed forwards to the wrapped client; embeddings aren't counted as completion usage
+func (c *usageClient) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return Embed(ctx, c.client, texts)
+}
@@ -0,0 +1,43 @@
+package providers
+
+import (
+	"context"
+	"testing"
+)
+
+func TestUsageTracker(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test usage reported by openai is accumulated", func(t *testing.T) {
+		server := newStubServer(t, chatCompletionResponse, nil)
+		openai, err := OpenAi(ctx, WithAPIKey("test-key"), WithBaseURL(server.URL+"/"))
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		tracker := NewUsageTracker()
+		client := tracker.Wrap(openai)
+
+		completion, err := CompleteDetailed(ctx, client, "gpt-4o-mini", "Say hello!", "", nil)
+		if err != nil {
+			t.Fatalf("Failed to complete request: %v", err)
+		}
+		want := Usage{PromptTokens: 3, CompletionTokens: 1, TotalTokens: 4}
+		if completion.Usage != want {
+			t.Errorf("completion usage = %+v, want %+v", completion.Usage, want)
+		}
+		if _, err := client.Complete(ctx, "gpt-4o-mini", "Say hello again!", "", nil); err != nil {
+			t.Fatalf("Failed to complete request: %v", err)
+		}
+
+		want = Usage{PromptTokens: 6, CompletionTokens: 2, TotalTokens: 8}
+		if got := tracker.Usage(); got != want {
+			t.Errorf("tracked usage = %+v, want %+v", got, want)
+		}
+		if tracker.Calls() != 2 {
+			t.Errorf("calls = %d, want 2", tracker.Calls())
+		}
+		if since := tracker.Usage().Sub(completion.Usage); since.TotalTokens != 4 {
+			t.Errorf("usage since the first call = %+v, want 4 total tokens", since)
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package remote
+
+import (
+	"github.com/boristopalov/petri/pkg/environment"
+	"github.com/boristopalov/petri/pkg/experiment"
+	"github.com/boristopalov/petri/pkg/remote/remotepb"
+)
+
+// experimentState converts an environment state to the message sent to clients
+func experimentState(state environment.DonorGameState) *remotepb.ExperimentState {
+	cooperation := make(map[string]*remotepb.CooperationStats, len(state.Cooperation))
+	for id, c := range state.Cooperation {
+		cooperation[id] = &remotepb.CooperationStats{Donations: int32(c.Donations), TotalFraction: c.TotalFraction}
+	}
+	return &remotepb.ExperimentState{
+		Round:               int32(state.Round),
+		TotalRounds:         int32(state.TotalRounds),
+		AgentResources:      state.AgentResources,
+		Cooperation:         cooperation,
+		AgentModels:         state.AgentModels,
+		SuccessfulDonations: int32(state.SuccessfulDonations),
+		FailedDonations:     int32(state.FailedDonations),
+		FinishReasons:       counts(state.FinishReasons),
+	}
+}
+
+// generationStats converts a generation's statistics to the message sent to clients
+func generationStats(stats experiment.GenerationStats) *remotepb.GenerationStats {
+	models := make(map[string]*remotepb.ModelStats, len(stats.Models))
+	for model, m := range stats.Models {
+		models[model] = &remotepb.ModelStats{
+			Agents:              int32(m.Agents),
+			AverageResources:    m.AverageResources,
+			Donations:           int32(m.Donations),
+			AvgDonationFraction: m.AvgDonationFraction,
+		}
+	}
+	return &remotepb.GenerationStats{
+		Generation:          int32(stats.Generation),
+		Population:          int32(stats.Population),
+		TotalResources:      stats.TotalResources,
+		AverageResources:    stats.AverageResources,
+		StandardDeviation:   stats.StandardDeviation,
+		ResourceInequality:  stats.ResourceInequality,
+		Gini:                stats.Gini,
+		SuccessfulDonations: int32(stats.SuccessfulDonations),
+		FailedDonations:     int32(stats.FailedDonations),
+		SuccessRate:         stats.SuccessRate,
+		Donations:           int32(stats.Donations),
+		AvgDonationFraction: stats.AvgDonationFraction,
+		StrategyFallbacks:   int32(stats.StrategyFallbacks),
+		TotalPunishments:    int32(stats.TotalPunishments),
+		PunishmentSpent:     stats.PunishmentSpent,
+		FinishReasons:       counts(stats.FinishReasons),
+		Models:              models,
+		DonationMultiplier:  stats.DonationMultiplier,
+		RoundsPerGen:        int32(stats.RoundsPerGen),
+	}
+}
+
+// counts converts a map of counts to the integer type used in messages
+func counts(m map[string]int) map[string]int32 {
+	converted := make(map[string]int32, len(m))
+	for k, v := range m {
+		converted[k] = int32(v)
+	}
+	return converted
+}
@@ -0,0 +1,293 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: remotepb/experiments.proto
+
+package remotepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Experiments_Create_FullMethodName      = "/petri.remote.Experiments/Create"
+	Experiments_Step_FullMethodName        = "/petri.remote.Experiments/Step"
+	Experiments_Run_FullMethodName         = "/petri.remote.Experiments/Run"
+	Experiments_State_FullMethodName       = "/petri.remote.Experiments/State"
+	Experiments_StreamStats_FullMethodName = "/petri.remote.Experiments/StreamStats"
+)
+
+// ExperimentsClient is the client API for Experiments service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Experiments creates, steps and observes donor game experiments
+type ExperimentsClient interface {
+	// Create creates an experiment and returns its ID
+	Create(ctx context.Context, in *CreateRequest, opts ...grpc.CallOption) (*CreateResponse, error)
+	// Step runs the experiment's next generation
+	Step(ctx context.Context, in *ExperimentRequest, opts ...grpc.CallOption) (*StepResponse, error)
+	// Run runs the remaining generations in the background
+	Run(ctx context.Context, in *ExperimentRequest, opts ...grpc.CallOption) (*StateResponse, error)
+	// State returns the environment state
+	State(ctx context.Context, in *ExperimentRequest, opts ...grpc.CallOption) (*StateResponse, error)
+	// StreamStats streams the statistics of every generation after `after` as they are produced,
+	// and ends when the experiment is done
+	StreamStats(ctx context.Context, in *StreamStatsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[GenerationStats], error)
+}
+
+type experimentsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewExperimentsClient(cc grpc.ClientConnInterface) ExperimentsClient {
+	return &experimentsClient{cc}
+}
+
+func (c *experimentsClient) Create(ctx context.Context, in *CreateRequest, opts ...grpc.CallOption) (*CreateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CreateResponse)
+	err := c.cc.Invoke(ctx, Experiments_Create_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *experimentsClient) Step(ctx context.Context, in *ExperimentRequest, opts ...grpc.CallOption) (*StepResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StepResponse)
+	err := c.cc.Invoke(ctx, Experiments_Step_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *experimentsClient) Run(ctx context.Context, in *ExperimentRequest, opts ...grpc.CallOption) (*StateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StateResponse)
+	err := c.cc.Invoke(ctx, Experiments_Run_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *experimentsClient) State(ctx context.Context, in *ExperimentRequest, opts ...grpc.CallOption) (*StateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StateResponse)
+	err := c.cc.Invoke(ctx, Experiments_State_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *experimentsClient) StreamStats(ctx context.Context, in *StreamStatsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[GenerationStats], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Experiments_ServiceDesc.Streams[0], Experiments_StreamStats_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamStatsRequest, GenerationStats]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Experiments_StreamStatsClient = grpc.ServerStreamingClient[GenerationStats]
+
+// ExperimentsServer is the server API for Experiments service.
+// All implementations must embed UnimplementedExperimentsServer
+// for forward compatibility.
+//
+// Experiments creates, steps and observes donor game experiments
+type ExperimentsServer interface {
+	// Create creates an experiment and returns its ID
+	Create(context.Context, *CreateRequest) (*CreateResponse, error)
+	// Step runs the experiment's next generation
+	Step(context.Context, *ExperimentRequest) (*StepResponse, error)
+	// Run runs the remaining generations in the background
+	Run(context.Context, *ExperimentRequest) (*StateResponse, error)
+	// State returns the environment state
+	State(context.Context, *ExperimentRequest) (*StateResponse, error)
+	// StreamStats streams the statistics of every generation after `after` as they are produced,
+	// and ends when the experiment is done
+	StreamStats(*StreamStatsRequest, grpc.ServerStreamingServer[GenerationStats]) error
+	mustEmbedUnimplementedExperimentsServer()
+}
+
+// UnimplementedExperimentsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedExperimentsServer struct{}
+
+func (UnimplementedExperimentsServer) Create(context.Context, *CreateRequest) (*CreateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Create not implemented")
+}
+func (UnimplementedExperimentsServer) Step(context.Context, *ExperimentRequest) (*StepResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Step not implemented")
+}
+func (UnimplementedExperimentsServer) Run(context.Context, *ExperimentRequest) (*StateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Run not implemented")
+}
+func (UnimplementedExperimentsServer) State(context.Context, *ExperimentRequest) (*StateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method State not implemented")
+}
+func (UnimplementedExperimentsServer) StreamStats(*StreamStatsRequest, grpc.ServerStreamingServer[GenerationStats]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamStats not implemented")
+}
+func (UnimplementedExperimentsServer) mustEmbedUnimplementedExperimentsServer() {}
+func (UnimplementedExperimentsServer) testEmbeddedByValue()                     {}
+
+// UnsafeExperimentsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ExperimentsServer will
+// result in compilation errors.
+type UnsafeExperimentsServer interface {
+	mustEmbedUnimplementedExperimentsServer()
+}
+
+func RegisterExperimentsServer(s grpc.ServiceRegistrar, srv ExperimentsServer) {
+	// If the following call pancis, it indicates UnimplementedExperimentsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Experiments_ServiceDesc, srv)
+}
+
+func _Experiments_Create_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExperimentsServer).Create(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Experiments_Create_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExperimentsServer).Create(ctx, req.(*CreateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Experiments_Step_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExperimentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExperimentsServer).Step(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Experiments_Step_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExperimentsServer).Step(ctx, req.(*ExperimentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Experiments_Run_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExperimentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExperimentsServer).Run(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Experiments_Run_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExperimentsServer).Run(ctx, req.(*ExperimentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Experiments_State_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExperimentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ExperimentsServer).State(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Experiments_State_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ExperimentsServer).State(ctx, req.(*ExperimentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Experiments_StreamStats_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamStatsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ExperimentsServer).StreamStats(m, &grpc.GenericServerStream[StreamStatsRequest, GenerationStats]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Experiments_StreamStatsServer = grpc.ServerStreamingServer[GenerationStats]
+
+// Experiments_ServiceDesc is the grpc.ServiceDesc for Experiments service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Experiments_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "petri.remote.Experiments",
+	HandlerType: (*ExperimentsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Create",
+			Handler:    _Experiments_Create_Handler,
+		},
+		{
+			MethodName: "Step",
+			Handler:    _Experiments_Step_Handler,
+		},
+		{
+			MethodName: "Run",
+			Handler:    _Experiments_Run_Handler,
+		},
+		{
+			MethodName: "State",
+			Handler:    _Experiments_State_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamStats",
+			Handler:       _Experiments_StreamStats_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "remotepb/experiments.proto",
+}
@@ -0,0 +1,362 @@
+// Package remote exposes donor game experiments over gRPC so other services can create, step
+// and observe them. The service is defined in remotepb/experiments.proto:
+//
+//	Create(CreateRequest) CreateResponse              creates an experiment and returns its ID
+//	Step(ExperimentRequest) StepResponse              runs the experiment's next generation
+//	Run(ExperimentRequest) StateResponse              runs the remaining generations in the background
+//	State(ExperimentRequest) StateResponse            returns the environment state
+//	StreamStats(StreamStatsRequest) stream GenerationStats
+//	                                                  streams each generation's statistics as it is produced
+package remote
+
+//go:generate buf generate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/boristopalov/petri/pkg/agent"
+	"github.com/boristopalov/petri/pkg/environment"
+	"github.com/boristopalov/petri/pkg/experiment"
+	"github.com/boristopalov/petri/pkg/remote/remotepb"
+)
+
+// ErrUnknownExperiment is returned for an experiment ID the server didn't create
+var ErrUnknownExperiment = errors.New("unknown experiment")
+
+// ProviderFunc returns the client for a model name such as "gpt-4" or "mock", along with the
+// model ID that is sent to the provider
+type ProviderFunc func(ctx context.Context, model string) (client agent.Client, modelID string, err error)
+
+// DefaultSessionTTL is how long a finished experiment is kept for clients to read its results
+const DefaultSessionTTL = time.Hour
+
+// Server runs donor game experiments on behalf of remote clients
+type Server struct {
+	grpc    *grpc.Server
+	service *service
+}
+
+// ServerOption configures a Server
+type ServerOption func(*service)
+
+// WithOutputRoot sets the directory experiments write their output under. A client's OutputDir
+// must be a relative path inside it. Defaults to the server's working directory.
+func WithOutputRoot(dir string) ServerOption {
+	return func(s *service) {
+		s.outputRoot = dir
+	}
+}
+
+// WithSessionTTL sets how long a finished or failed experiment is kept before it is evicted.
+// Defaults to DefaultSessionTTL.
+func WithSessionTTL(ttl time.Duration) ServerOption {
+	return func(s *service) {
+		s.ttl = ttl
+	}
+}
+
+// NewServer creates a server whose experiments get their clients from providers. Experiments
+// run in the background are stopped when ctx is done.
+func NewServer(ctx context.Context, providers ProviderFunc, opts ...ServerOption) (*Server, error) {
+	s := &service{
+		ctx:         ctx,
+		providers:   providers,
+		experiments: make(map[string]*session),
+		outputRoot:  ".",
+		ttl:         DefaultSessionTTL,
+		now:         time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	srv := grpc.NewServer()
+	remotepb.RegisterExperimentsServer(srv, s)
+	return &Server{grpc: srv, service: s}, nil
+}
+
+// Serve accepts connections on l and serves each until Stop is called
+func (s *Server) Serve(l net.Listener) error {
+	return s.grpc.Serve(l)
+}
+
+// Stop closes every connection and stops the server
+func (s *Server) Stop() {
+	s.grpc.Stop()
+}
+
+// service implements the gRPC methods. It is separate from Server so that the server's own
+// methods aren't mixed up with the RPC ones.
+type service struct {
+	remotepb.UnimplementedExperimentsServer
+
+	ctx         context.Context
+	providers   ProviderFunc
+	outputRoot  string           // directory every experiment's output directory is inside
+	ttl         time.Duration    // how long finished experiments are kept
+	now         func() time.Time // clock used to expire finished experiments
+	mu          sync.Mutex
+	experiments map[string]*session
+}
+
+// session is a single experiment and the statistics it has produced
+type session struct {
+	env        *environment.DonorGameEnvironment
+	experiment *experiment.DonorGameExperiment
+	step       sync.Mutex // serializes generations
+
+	mu         sync.Mutex
+	stats      []experiment.GenerationStats
+	generation int // the experiment's generation after the last step
+	done       bool
+	running    bool
+	err        error         // error that stopped a background run
+	finished   time.Time     // when the experiment finished or failed; zero while it can still run
+	updated    chan struct{} // closed and replaced whenever the fields above change
+	now        func() time.Time
+}
+
+func (s *service) Create(ctx context.Context, req *remotepb.CreateRequest) (*remotepb.CreateResponse, error) {
+	if err := validateCreate(req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	outputDir, err := s.outputDir(req.OutputDir)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	client, modelID, err := s.providers(s.ctx, req.Model)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	sess := &session{updated: make(chan struct{}), now: s.now}
+	sess.env = environment.NewDonorGameEnvironment(int(req.Rounds), req.DonationMultiplier, req.InitialBalance,
+		environment.WithSeed(req.Seed))
+	factory := func(ctx context.Context, id string, strategy string) (*agent.DonorGameAgent, error) {
+		return agent.NewDonorGameAgent(ctx, id, strategy,
+			agent.WithProvider(client),
+			agent.WithModel(agent.ModelInfo{Id: modelID}),
+		)
+	}
+	sess.experiment, err = experiment.NewDonorGameExperiment(sess.env, factory,
+		req.SurvivorRatio, int(req.NumAgents), int(req.Generations), int(req.Rounds),
+		experiment.WithOutputDir(outputDir),
+		experiment.WithStatsHook(sess.addStats),
+	)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	id := uuid.NewString()
+	s.mu.Lock()
+	s.evictFinished()
+	s.experiments[id] = sess
+	s.mu.Unlock()
+	log.Printf("Created experiment %s with %d %s agents", id, req.NumAgents, req.Model)
+	return &remotepb.CreateResponse{Id: id}, nil
+}
+
+func (s *service) Step(ctx context.Context, req *remotepb.ExperimentRequest) (*remotepb.StepResponse, error) {
+	sess, err := s.session(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if !sess.step.TryLock() {
+		return nil, status.Errorf(codes.FailedPrecondition, "experiment %s is already running", req.Id)
+	}
+	defer sess.step.Unlock()
+
+	generation := max(sess.experiment.Generation(), 1)
+	err = sess.experiment.Step(s.ctx)
+	sess.update(nil)
+	if errors.Is(err, experiment.ErrExperimentDone) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &remotepb.StepResponse{Generation: int32(generation), Done: sess.experiment.Done()}, nil
+}
+
+func (s *service) Run(ctx context.Context, req *remotepb.ExperimentRequest) (*remotepb.StateResponse, error) {
+	sess, err := s.session(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if !sess.step.TryLock() {
+		return nil, status.Errorf(codes.FailedPrecondition, "experiment %s is already running", req.Id)
+	}
+	if sess.experiment.Done() {
+		sess.step.Unlock()
+		return nil, status.Error(codes.FailedPrecondition, experiment.ErrExperimentDone.Error())
+	}
+	sess.mu.Lock()
+	sess.running = true
+	sess.mu.Unlock()
+	go func() {
+		defer sess.step.Unlock()
+		for !sess.experiment.Done() {
+			if err := sess.experiment.Step(s.ctx); err != nil {
+				log.Printf("Experiment %s failed: %v", req.Id, err)
+				sess.update(err)
+				return
+			}
+			sess.update(nil)
+		}
+	}()
+	return s.State(ctx, req)
+}
+
+func (s *service) State(ctx context.Context, req *remotepb.ExperimentRequest) (*remotepb.StateResponse, error) {
+	sess, err := s.session(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	reply := &remotepb.StateResponse{}
+	sess.mu.Lock()
+	reply.Generation = int32(sess.generation)
+	reply.Done = sess.done
+	reply.Running = sess.running
+	sess.mu.Unlock()
+	reply.State = experimentState(sess.env.GetState())
+	return reply, nil
+}
+
+func (s *service) StreamStats(req *remotepb.StreamStatsRequest, stream grpc.ServerStreamingServer[remotepb.GenerationStats]) error {
+	sess, err := s.session(req.Id)
+	if err != nil {
+		return err
+	}
+	after := int(req.After)
+	for {
+		sess.mu.Lock()
+		updated := sess.updated
+		var pending []experiment.GenerationStats
+		for _, stats := range sess.stats {
+			if stats.Generation > after {
+				pending = append(pending, stats)
+			}
+		}
+		done, runErr := sess.done, sess.err
+		sess.mu.Unlock()
+
+		for _, stats := range pending {
+			if err := stream.Send(generationStats(stats)); err != nil {
+				return err
+			}
+			after = stats.Generation
+		}
+		if runErr != nil {
+			return status.Errorf(codes.Aborted, "experiment %s failed: %v", req.Id, runErr)
+		}
+		if done {
+			return nil
+		}
+
+		select {
+		case <-updated:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.ctx.Done():
+			return status.Error(codes.Unavailable, "server is shutting down")
+		}
+	}
+}
+
+// validateCreate checks that req describes an experiment that can run
+func validateCreate(req *remotepb.CreateRequest) error {
+	switch {
+	case req.NumAgents < 2:
+		return fmt.Errorf("num_agents must be at least 2, got %d", req.NumAgents)
+	case req.Generations < 1:
+		return fmt.Errorf("generations must be at least 1, got %d", req.Generations)
+	case req.Rounds < 1:
+		return fmt.Errorf("rounds must be at least 1, got %d", req.Rounds)
+	case !(req.SurvivorRatio >= 0 && req.SurvivorRatio <= 1):
+		return fmt.Errorf("survivor_ratio must be between 0 and 1, got %v", req.SurvivorRatio)
+	}
+	return nil
+}
+
+// outputDir returns the directory for an experiment's output: dir inside the output root, which
+// must be a relative path that doesn't leave the root
+func (s *service) outputDir(dir string) (string, error) {
+	if dir == "" {
+		return s.outputRoot, nil
+	}
+	if !filepath.IsLocal(dir) {
+		return "", fmt.Errorf("output_dir must be a relative path inside the server's output directory, got %q", dir)
+	}
+	return filepath.Join(s.outputRoot, dir), nil
+}
+
+// evictFinished removes the experiments that finished more than the TTL ago. s.mu must be held.
+func (s *service) evictFinished() {
+	now := s.now()
+	for id, sess := range s.experiments {
+		if finished := sess.finishedAt(); !finished.IsZero() && now.Sub(finished) >= s.ttl {
+			delete(s.experiments, id)
+			log.Printf("Evicted experiment %s, finished at %s", id, finished.Format(time.RFC3339))
+		}
+	}
+}
+
+// session returns the experiment with the given ID
+func (s *service) session(id string) (*session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evictFinished()
+	sess, ok := s.experiments[id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "%v: %s", ErrUnknownExperiment, id)
+	}
+	return sess, nil
+}
+
+// addStats records a generation's statistics and wakes up waiting StreamStats calls
+func (sess *session) addStats(stats experiment.GenerationStats) {
+	sess.mu.Lock()
+	sess.stats = append(sess.stats, stats)
+	sess.mu.Unlock()
+	sess.notify()
+}
+
+// update records the experiment's progress after a step, and err if it stopped a background run.
+// It must be called by the goroutine holding the step lock.
+func (sess *session) update(err error) {
+	sess.mu.Lock()
+	sess.generation = sess.experiment.Generation()
+	sess.done = sess.experiment.Done()
+	if err != nil || sess.done {
+		sess.running = false
+		sess.err = err
+		sess.finished = sess.now()
+	}
+	sess.mu.Unlock()
+	sess.notify()
+}
+
+// finishedAt returns when the experiment finished or failed, or the zero time if it hasn't
+func (sess *session) finishedAt() time.Time {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.finished
+}
+
+// notify wakes up every StreamStats call waiting on the session
+func (sess *session) notify() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	close(sess.updated)
+	sess.updated = make(chan struct{})
+}
@@ -0,0 +1,222 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/boristopalov/petri/pkg/agent"
+	"github.com/boristopalov/petri/pkg/providers"
+	"github.com/boristopalov/petri/pkg/remote/remotepb"
+)
+
+// newTestClient serves a server backed by mock providers over an in-process connection. The
+// server writes its output under a temporary directory.
+func newTestClient(t *testing.T, opts ...ServerOption) remotepb.ExperimentsClient {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	mock := func(ctx context.Context, model string) (agent.Client, string, error) {
+		if model != "mock" {
+			return nil, "", fmt.Errorf("unsupported model: %s", model)
+		}
+		return providers.NewMockClient(), "mock", nil
+	}
+	server, err := NewServer(ctx, mock, append([]ServerOption{WithOutputRoot(t.TempDir())}, opts...)...)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return remotepb.NewExperimentsClient(conn)
+}
+
+// createRequest returns a valid request for a mock-backed experiment
+func createRequest(generations int32) *remotepb.CreateRequest {
+	return &remotepb.CreateRequest{
+		Model:              "mock",
+		NumAgents:          4,
+		Generations:        generations,
+		Rounds:             2,
+		SurvivorRatio:      0.5,
+		DonationMultiplier: 2,
+		InitialBalance:     10,
+		Seed:               1,
+		OutputDir:          "run",
+	}
+}
+
+func createExperiment(t *testing.T, client remotepb.ExperimentsClient, generations int32) string {
+	t.Helper()
+	created, err := client.Create(context.Background(), createRequest(generations))
+	if err != nil {
+		t.Fatalf("Failed to create experiment: %v", err)
+	}
+	return created.Id
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test running experiment streams each generation's stats", func(t *testing.T) {
+		client := newTestClient(t)
+		id := createExperiment(t, client, 3)
+
+		if _, err := client.Run(ctx, &remotepb.ExperimentRequest{Id: id}); err != nil {
+			t.Fatalf("Failed to run experiment: %v", err)
+		}
+
+		stream, err := client.StreamStats(ctx, &remotepb.StreamStatsRequest{Id: id})
+		if err != nil {
+			t.Fatalf("Failed to stream stats: %v", err)
+		}
+		var generations []int32
+		for {
+			stats, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Failed to receive stats: %v", err)
+			}
+			generations = append(generations, stats.Generation)
+			if stats.Population != 4 {
+				t.Errorf("generation %d population = %d, want 4", stats.Generation, stats.Population)
+			}
+		}
+		if fmt.Sprint(generations) != "[1 2 3]" {
+			t.Errorf("streamed generations = %v, want [1 2 3]", generations)
+		}
+
+		state, err := client.State(ctx, &remotepb.ExperimentRequest{Id: id})
+		if err != nil {
+			t.Fatalf("Failed to get state: %v", err)
+		}
+		if !state.Done || state.Running || state.Generation != 3 {
+			t.Errorf("state = generation %d, done %t, running %t, want generation 3 done and not running",
+				state.Generation, state.Done, state.Running)
+		}
+		if len(state.State.AgentResources) != 4 {
+			t.Errorf("state has %d agents, want 4", len(state.State.AgentResources))
+		}
+	})
+
+	t.Run("test stepping runs one generation at a time", func(t *testing.T) {
+		client := newTestClient(t)
+		id := createExperiment(t, client, 2)
+
+		for gen := int32(1); gen <= 2; gen++ {
+			step, err := client.Step(ctx, &remotepb.ExperimentRequest{Id: id})
+			if err != nil {
+				t.Fatalf("Failed to step experiment: %v", err)
+			}
+			if step.Generation != gen || step.Done != (gen == 2) {
+				t.Errorf("step %d = %v, want generation %d done %t", gen, step, gen, gen == 2)
+			}
+		}
+
+		stream, err := client.StreamStats(ctx, &remotepb.StreamStatsRequest{Id: id, After: 1})
+		if err != nil {
+			t.Fatalf("Failed to stream stats: %v", err)
+		}
+		var generations []int32
+		for {
+			stats, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Failed to receive stats: %v", err)
+			}
+			generations = append(generations, stats.Generation)
+		}
+		if fmt.Sprint(generations) != "[2]" {
+			t.Errorf("stats after generation 1 = %v, want only generation 2", generations)
+		}
+
+		_, err = client.Step(ctx, &remotepb.ExperimentRequest{Id: id})
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("err = %v, want FailedPrecondition stepping a finished experiment", err)
+		}
+	})
+
+	t.Run("test unknown experiment is rejected", func(t *testing.T) {
+		client := newTestClient(t)
+		_, err := client.State(ctx, &remotepb.ExperimentRequest{Id: "missing"})
+		if status.Code(err) != codes.NotFound {
+			t.Fatalf("err = %v, want NotFound", err)
+		}
+	})
+
+	t.Run("test invalid experiments are rejected", func(t *testing.T) {
+		client := newTestClient(t)
+		for _, tc := range []struct {
+			name   string
+			modify func(*remotepb.CreateRequest)
+		}{
+			{"one agent", func(r *remotepb.CreateRequest) { r.NumAgents = 1 }},
+			{"no generations", func(r *remotepb.CreateRequest) { r.Generations = 0 }},
+			{"no rounds", func(r *remotepb.CreateRequest) { r.Rounds = 0 }},
+			{"negative survivor ratio", func(r *remotepb.CreateRequest) { r.SurvivorRatio = -0.5 }},
+			{"survivor ratio above 1", func(r *remotepb.CreateRequest) { r.SurvivorRatio = 1.5 }},
+			{"absolute output dir", func(r *remotepb.CreateRequest) { r.OutputDir = "/tmp/elsewhere" }},
+			{"output dir outside the root", func(r *remotepb.CreateRequest) { r.OutputDir = "../elsewhere" }},
+		} {
+			req := createRequest(1)
+			tc.modify(req)
+			if _, err := client.Create(ctx, req); status.Code(err) != codes.InvalidArgument {
+				t.Errorf("%s: err = %v, want InvalidArgument", tc.name, err)
+			}
+		}
+	})
+
+	t.Run("test finished experiments are evicted after the ttl", func(t *testing.T) {
+		now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		clock := func(s *service) {
+			s.now = func() time.Time { return now }
+		}
+		client := newTestClient(t, WithSessionTTL(time.Minute), clock)
+		finished := createExperiment(t, client, 1)
+		unfinished := createExperiment(t, client, 2)
+		for _, id := range []string{finished, unfinished} {
+			if _, err := client.Step(ctx, &remotepb.ExperimentRequest{Id: id}); err != nil {
+				t.Fatalf("Failed to step experiment: %v", err)
+			}
+		}
+
+		now = now.Add(30 * time.Second)
+		if _, err := client.State(ctx, &remotepb.ExperimentRequest{Id: finished}); err != nil {
+			t.Errorf("Expected the finished experiment to be kept within the ttl, got %v", err)
+		}
+
+		now = now.Add(time.Minute)
+		if _, err := client.State(ctx, &remotepb.ExperimentRequest{Id: finished}); status.Code(err) != codes.NotFound {
+			t.Errorf("err = %v, want NotFound after the ttl", err)
+		}
+		if _, err := client.State(ctx, &remotepb.ExperimentRequest{Id: unfinished}); err != nil {
+			t.Errorf("Expected the unfinished experiment to be kept, got %v", err)
+		}
+	})
+}